@@ -29,6 +29,10 @@ func (w *netConnection) ConnectionID() string {
 	return w.connectionID
 }
 
+func (w *netConnection) RemoteAddr() string {
+	return w.conn.RemoteAddr().String()
+}
+
 func (w *netConnection) Write(p []byte) (n int, err error) {
 	if w.timeout > 0 {
 		defer func() { _ = w.conn.SetWriteDeadline(time.Time{}) }()
@@ -0,0 +1,53 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type abortHub struct {
+	Hub
+}
+
+func (a *abortHub) Kick(connectionID string, reason string) string {
+	if err := a.AbortConnection(connectionID, reason, false); err != nil {
+		return err.Error()
+	}
+	return "kicked"
+}
+
+var _ = Describe("AbortConnection", func() {
+	Context("When a hub aborts another connection", func() {
+		It("should close it with the reason and without reconnect", func() {
+			server, err := NewServer(SimpleHubFactory(&abortHub{}))
+			Expect(err).To(BeNil())
+			admin := newTestingConnection()
+			admin.connectionID = "admin"
+			go server.Run(context.TODO(), admin)
+			banned := newTestingConnection()
+			banned.connectionID = "banned"
+			go server.Run(context.TODO(), banned)
+			Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(2))
+			admin.ClientSend(`{"type":1,"invocationId":"1","target":"kick","arguments":["banned","You have been banned"]}`)
+			Eventually(admin.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "kicked"})))
+			Eventually(banned.ReceiveChan()).Should(Receive(Equal(closeMessage{Type: 7, Error: "You have been banned", AllowReconnect: false})))
+			Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(1))
+			admin.ClientSend(`{"type":1,"invocationId":"2","target":"kick","arguments":["banned","again"]}`)
+			Eventually(admin.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Result: "connection banned is not connected"})))
+		})
+	})
+	Context("When the application aborts a connection and allows it to reconnect", func() {
+		It("should close it with the reason and allow reconnect", func() {
+			server, err := NewServer(SimpleHubFactory(&abortHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "expired"
+			go server.Run(context.TODO(), conn)
+			Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(1))
+			Expect(server.AbortConnection("expired", "Token expired", true)).To(BeNil())
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(closeMessage{Type: 7, Error: "Token expired", AllowReconnect: true})))
+			Expect(server.AbortConnection("unknown", "Token expired", true)).NotTo(BeNil())
+		})
+	})
+})
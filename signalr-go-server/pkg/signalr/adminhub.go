@@ -0,0 +1,128 @@
+package signalr
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// AdminAuthorizerFunc decides if the request of an operator may negotiate or open a connection to the admin hub,
+// e.g. by checking a token or a client certificate
+type AdminAuthorizerFunc func(req *http.Request) bool
+
+// AdminConnection describes a connection of the managed server
+type AdminConnection struct {
+	ConnectionID string
+	UserID       string
+	RemoteAddr   string
+	Groups       []string
+	Tags         []string
+	Stats        ConnectionStats
+}
+
+// MapAdminHub serves a hub at path with runtime operations for the connections of target, so operators
+// get management tooling without writing it per project. The admin hub is only served when it is mapped.
+// authorize is required, requests it rejects are answered with 401 Unauthorized.
+// The admin hub has the methods
+//
+//	ListConnections() []AdminConnection
+//	KickConnection(connectionID string, reason string) bool
+//	SendTestMessage(connectionID string, target string, args []interface{}) bool
+//	ListGroups() map[string][]string
+//
+// KickConnection closes the connection with reason as close error, the client is not allowed to reconnect.
+// KickConnection and SendTestMessage return false if the connection is not connected to target.
+// The options are applied to the server of the admin hub.
+func MapAdminHub(mux *http.ServeMux, path string, target *Server, authorize AdminAuthorizerFunc, options ...func(*Server) error) (*Server, error) {
+	if target == nil {
+		return nil, errors.New("MapAdminHub needs a target server")
+	}
+	if authorize == nil {
+		return nil, errors.New("MapAdminHub needs an AdminAuthorizerFunc")
+	}
+	server, err := NewServer(append([]func(*Server) error{
+		HubFactory(func() HubInterface { return &adminHub{target: target} })}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	authorized := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if !authorize(req) {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, req)
+		})
+	}
+	mux.Handle(path+"/negotiate", authorized(http.HandlerFunc(server.negotiateHandler)))
+	mux.Handle(path, authorized(server.webSocketHandler()))
+	return server, nil
+}
+
+type adminHub struct {
+	Hub
+	target *Server
+}
+
+func (a *adminHub) ListConnections() []AdminConnection {
+	lm := a.target.localLifetimeManager
+	connections := make([]AdminConnection, 0)
+	for _, conn := range lm.allConnections() {
+		groups := lm.groupsOf(conn.ConnectionID())
+		sort.Strings(groups)
+		connections = append(connections, AdminConnection{
+			ConnectionID: conn.ConnectionID(),
+			UserID:       conn.UserID(),
+			RemoteAddr:   conn.RemoteAddr(),
+			Groups:       groups,
+			Tags:         conn.Tags().List(),
+			Stats:        conn.Stats(),
+		})
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ConnectionID < connections[j].ConnectionID })
+	return connections
+}
+
+func (a *adminHub) KickConnection(connectionID string, reason string) bool {
+	conn, ok := a.target.connection(connectionID)
+	if !ok {
+		return false
+	}
+	info, _ := a.target.prefixLogger()
+	_ = info.Log(evt, "admin kick", "connectionId", connectionID, "reason", reason, "admin", a.context.ConnectionID())
+	conn.AbortWithError(&disconnectUserError{reason: reason})
+	return true
+}
+
+func (a *adminHub) SendTestMessage(connectionID string, target string, args []interface{}) bool {
+	if _, ok := a.target.connection(connectionID); !ok {
+		return false
+	}
+	return a.target.lifetimeManager.InvokeClient(context.Background(), connectionID, target, args) == nil
+}
+
+func (a *adminHub) ListGroups() map[string][]string {
+	groups := make(map[string][]string)
+	lm := a.target.localLifetimeManager
+	lm.groupsMx.Lock()
+	for groupName, members := range lm.groups {
+		for connectionID := range members {
+			groups[groupName] = append(groups[groupName], connectionID)
+		}
+	}
+	lm.groupsMx.Unlock()
+	for _, members := range groups {
+		sort.Strings(members)
+	}
+	return groups
+}
+
+// AdminTokenAuthorizer returns an AdminAuthorizerFunc which accepts requests with the bearer token token.
+// An empty token rejects all requests.
+func AdminTokenAuthorizer(token string) AdminAuthorizerFunc {
+	return func(req *http.Request) bool {
+		return token != "" && subtle.ConstantTimeCompare([]byte(bearerToken(req)), []byte(token)) == 1
+	}
+}
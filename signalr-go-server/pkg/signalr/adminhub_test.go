@@ -0,0 +1,90 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"time"
+)
+
+type adminTestHub struct {
+	Hub
+}
+
+func (a *adminTestHub) Join(groupName string) {
+	a.Groups().AddToGroup(groupName, a.context.ConnectionID())
+}
+
+var _ = Describe("MapAdminHub", func() {
+	var baseURL string
+	BeforeEach(func() {
+		router := http.NewServeMux()
+		target, err := MapHub(router, "/hub", &adminTestHub{})
+		Expect(err).To(BeNil())
+		_, err = MapAdminHub(router, "/admin", target, AdminTokenAuthorizer("secret"))
+		Expect(err).To(BeNil())
+		port := freePort()
+		go func() {
+			_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+		}()
+		waitForPort(port)
+		baseURL = fmt.Sprintf("http://127.0.0.1:%v", port)
+	})
+	connect := func(path string, options ...func(*Client) error) (*Client, error) {
+		client, err := NewClient(baseURL+path, options...)
+		Expect(err).To(BeNil())
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		return client, client.Connect(ctx)
+	}
+	Context("When the operator is authorized", func() {
+		It("should list, message and kick the connections of the target server", func() {
+			user, err := connect("/hub")
+			Expect(err).To(BeNil())
+			defer func() { _ = user.Close() }()
+			received := make(chan string, 1)
+			Expect(user.On("test", func(text string) { received <- text })).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(user.Invoke(ctx, nil, "join", "admins")).To(BeNil())
+			admin, err := connect("/admin", ClientHeader(http.Header{"Authorization": []string{"Bearer secret"}}))
+			Expect(err).To(BeNil())
+			defer func() { _ = admin.Close() }()
+			var connections []AdminConnection
+			Expect(admin.Invoke(ctx, &connections, "ListConnections")).To(BeNil())
+			Expect(connections).To(HaveLen(1))
+			Expect(connections[0].Groups).To(Equal([]string{"admins"}))
+			connectionID := connections[0].ConnectionID
+			var groups map[string][]string
+			Expect(admin.Invoke(ctx, &groups, "ListGroups")).To(BeNil())
+			Expect(groups).To(Equal(map[string][]string{"admins": {connectionID}}))
+			var ok bool
+			Expect(admin.Invoke(ctx, &ok, "SendTestMessage", connectionID, "test", []interface{}{"hello"})).To(BeNil())
+			Expect(ok).To(BeTrue())
+			Eventually(received).Should(Receive(Equal("hello")))
+			Expect(admin.Invoke(ctx, &ok, "KickConnection", "unknown", "spam")).To(BeNil())
+			Expect(ok).To(BeFalse())
+			Expect(admin.Invoke(ctx, &ok, "KickConnection", connectionID, "spam")).To(BeNil())
+			Expect(ok).To(BeTrue())
+			Eventually(user.Done()).Should(BeClosed())
+		})
+	})
+	Context("When the operator is not authorized", func() {
+		It("should not connect", func() {
+			_, err := connect("/admin")
+			Expect(err).NotTo(BeNil())
+			_, err = connect("/admin", ClientHeader(http.Header{"Authorization": []string{"Bearer guess"}}))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("When no AdminAuthorizerFunc is given", func() {
+		It("should return an error", func() {
+			target, err := NewServer(SimpleHubFactory(&adminTestHub{}))
+			Expect(err).To(BeNil())
+			_, err = MapAdminHub(http.NewServeMux(), "/admin", target, nil)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,77 @@
+package signalr
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Application is a named, isolated set of hubs inside one process.
+// Each server created by an Application has its own connection registry and groups,
+// uses the options of the Application as defaults and logs with the application name,
+// so several tenants can be served from one binary without cross-talk.
+type Application struct {
+	name    string
+	options []func(*Server) error
+	servers []*Server
+	mx      sync.Mutex
+}
+
+// NewApplication creates a new Application with the given name.
+// The options are applied to every server of the Application before the server specific options.
+func NewApplication(name string, options ...func(*Server) error) (*Application, error) {
+	if name == "" {
+		return nil, errors.New("application name must not be empty")
+	}
+	return &Application{name: name, options: options}, nil
+}
+
+// Name returns the name of the Application
+func (a *Application) Name() string {
+	return a.name
+}
+
+// NewServer creates a new server for one type of hub inside the Application
+func (a *Application) NewServer(options ...func(*Server) error) (*Server, error) {
+	server, err := NewServer(a.serverOptions(options)...)
+	if err != nil {
+		return nil, err
+	}
+	a.addServer(server)
+	return server, nil
+}
+
+// MapHub registers a SignalR Hub of the Application with the specified ServeMux
+func (a *Application) MapHub(mux *http.ServeMux, path string, hubProto HubInterface, options ...func(*Server) error) (*Server, error) {
+	server, err := MapHub(mux, path, hubProto, a.serverOptions(options)...)
+	if err != nil {
+		return nil, err
+	}
+	a.addServer(server)
+	return server, nil
+}
+
+// Servers returns all servers created by the Application
+func (a *Application) Servers() []*Server {
+	defer a.mx.Unlock()
+	a.mx.Lock()
+	servers := make([]*Server, len(a.servers))
+	copy(servers, a.servers)
+	return servers
+}
+
+func (a *Application) serverOptions(options []func(*Server) error) []func(*Server) error {
+	serverOptions := make([]func(*Server) error, 0, len(a.options)+len(options)+1)
+	serverOptions = append(serverOptions, a.options...)
+	serverOptions = append(serverOptions, options...)
+	return append(serverOptions, func(s *Server) error {
+		s.appName = a.name
+		return nil
+	})
+}
+
+func (a *Application) addServer(server *Server) {
+	defer a.mx.Unlock()
+	a.mx.Lock()
+	a.servers = append(a.servers, server)
+}
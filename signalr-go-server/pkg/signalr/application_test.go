@@ -0,0 +1,68 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"os"
+	"time"
+)
+
+type applicationHub struct {
+	Hub
+}
+
+func (a *applicationHub) OnConnected(connectionID string) {
+	applicationHubOnConnectMsg <- connectionID
+}
+
+func (a *applicationHub) CallAll() {
+	a.Clients().All().Send("clientFunc")
+}
+
+var applicationHubOnConnectMsg = make(chan string, 10)
+
+var _ = Describe("Application", func() {
+	Context("NewApplication is called without name", func() {
+		It("should return an error", func() {
+			app, err := NewApplication("")
+			Expect(app).To(BeNil())
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("Two applications serve the same hub type", func() {
+		It("should not send messages across applications", func() {
+			app1, err := NewApplication("tenant1", Logger(log.NewLogfmtLogger(os.Stderr), false))
+			Expect(err).To(BeNil())
+			app2, err := NewApplication("tenant2", Logger(log.NewLogfmtLogger(os.Stderr), false))
+			Expect(err).To(BeNil())
+			server1, err := app1.NewServer(SimpleHubFactory(&applicationHub{}))
+			Expect(err).To(BeNil())
+			Expect(server1.AppName()).To(Equal("tenant1"))
+			server2, err := app2.NewServer(SimpleHubFactory(&applicationHub{}))
+			Expect(err).To(BeNil())
+			Expect(app1.Servers()).To(ConsistOf(server1))
+			conn1 := newTestingConnection()
+			go server1.Run(context.TODO(), conn1)
+			<-applicationHubOnConnectMsg
+			conn2 := newTestingConnection()
+			go server2.Run(context.TODO(), conn2)
+			<-applicationHubOnConnectMsg
+			conn1.ClientSend(`{"type":1,"target":"callall"}`)
+			select {
+			case m := <-conn1.ReceiveChan():
+				Expect(m).To(BeAssignableToTypeOf(invocationMessage{}))
+			case <-time.After(100 * time.Millisecond):
+				Fail("timed out")
+			}
+			select {
+			case m := <-conn2.ReceiveChan():
+				Fail(fmt.Sprintf("message across applications %v", m))
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+})
@@ -0,0 +1,49 @@
+package signalr
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// argumentLimits holds the limits of the invocations of a hub method
+type argumentLimits struct {
+	maxCount int
+	maxSize  int
+}
+
+// checkArgumentLimits checks the invocation against the limits registered for its target with ArgumentLimits.
+// It returns the error which is sent to the client as completion error, or "" if the invocation is within the limits
+func checkArgumentLimits(limits map[string]argumentLimits, invocation invocationMessage) string {
+	l, ok := limits[strings.ToLower(invocation.Target)]
+	if !ok {
+		return ""
+	}
+	if count := len(invocation.Arguments) + len(invocation.StreamIds); l.maxCount > 0 && count > l.maxCount {
+		return fmt.Sprintf("Invocation of %s rejected: %d arguments exceed the limit of %d arguments",
+			invocation.Target, count, l.maxCount)
+	}
+	if l.maxSize > 0 {
+		if size := argumentsSize(invocation.Arguments); size > l.maxSize {
+			return fmt.Sprintf("Invocation of %s rejected: arguments of %d bytes exceed the limit of %d bytes",
+				invocation.Target, size, l.maxSize)
+		}
+	}
+	return ""
+}
+
+// argumentsSize returns the serialized size of the arguments. JSON arguments are still raw,
+// MessagePack arguments are already decoded and are measured by encoding them again
+func argumentsSize(args []interface{}) int {
+	size := 0
+	for _, arg := range args {
+		if raw, ok := arg.(json.RawMessage); ok {
+			size += len(raw)
+			continue
+		}
+		var encoder msgpackEncoder
+		_ = encoder.encode(arg)
+		size += encoder.buf.Len()
+	}
+	return size
+}
@@ -0,0 +1,77 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// TokenAuthenticatorFunc validates the access token of a negotiate or websocket request
+// and returns the principal of the authenticated user, e.g. the claims of a JWT.
+// An error rejects the request with 401 Unauthorized.
+type TokenAuthenticatorFunc func(token string, req *http.Request) (principal interface{}, err error)
+
+// TokenAuthentication lets the server authenticate negotiate and websocket requests with authenticate.
+// The token is taken from the Authorization header or, as browsers can not set headers for websockets,
+// from the access_token query parameter. Requests without token or with a rejected token get 401 Unauthorized
+// before the connection is upgraded. The principal of an accepted connection can be read with PrincipalFromContext
+// from the context of its hub method invocations and with ConnectionPrincipal, e.g. in a UserIDProvider.
+func TokenAuthentication(authenticate TokenAuthenticatorFunc) func(*Server) error {
+	return func(s *Server) error {
+		if authenticate == nil {
+			return errors.New("TokenAuthentication needs a TokenAuthenticatorFunc")
+		}
+		s.tokenAuthenticator = authenticate
+		return nil
+	}
+}
+
+type principalContextKey struct{}
+
+// PrincipalFromContext returns the principal of the authenticated connection ctx belongs to
+func PrincipalFromContext(ctx context.Context) (interface{}, bool) {
+	principal := ctx.Value(principalContextKey{})
+	return principal, principal != nil
+}
+
+// ConnectionPrincipal returns the principal of an authenticated connection which has been established by an http request
+func ConnectionPrincipal(conn Connection) (interface{}, bool) {
+	if p, ok := conn.(*principalConnection); ok {
+		return p.principal, p.principal != nil
+	}
+	if httpConn, ok := conn.(HTTPConnection); ok && httpConn.Request() != nil {
+		return PrincipalFromContext(httpConn.Request().Context())
+	}
+	return nil, false
+}
+
+// authenticate checks the access token of req. It returns req with the principal in its context
+// or responds 401 Unauthorized and returns false
+func (s *Server) authenticate(w http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	if s.tokenAuthenticator == nil {
+		return req, true
+	}
+	token := bearerToken(req)
+	if token == "" {
+		_ = s.info.Log(evt, "authenticate", "error", "no access token", react, "401")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	principal, err := s.tokenAuthenticator(token, req)
+	if err != nil || principal == nil {
+		_ = s.info.Log(evt, "authenticate", "error", err, react, "401")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	return req.WithContext(context.WithValue(req.Context(), principalContextKey{}, principal)), true
+}
+
+// bearerToken returns the bearer token of the request from the Authorization header
+// or, as browsers can not set headers for websockets, the access_token query parameter
+func bearerToken(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return req.URL.Query().Get("access_token")
+}
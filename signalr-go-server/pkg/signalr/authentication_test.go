@@ -0,0 +1,98 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type authenticationHub struct {
+	Hub
+}
+
+func (a *authenticationHub) Whoami(ctx context.Context) string {
+	principal, _ := PrincipalFromContext(ctx)
+	return fmt.Sprintf("%v", principal)
+}
+
+var _ = Describe("TokenAuthentication option", func() {
+	var baseURL string
+	BeforeEach(func() {
+		router := http.NewServeMux()
+		_, err := MapHub(router, "/hub", &authenticationHub{},
+			TokenAuthentication(func(token string, req *http.Request) (interface{}, error) {
+				if strings.HasPrefix(token, "valid-") {
+					return strings.TrimPrefix(token, "valid-"), nil
+				}
+				return nil, errors.New("invalid token")
+			}),
+			UserIDProvider(func(conn Connection) string {
+				principal, _ := ConnectionPrincipal(conn)
+				return fmt.Sprintf("%v", principal)
+			}))
+		Expect(err).To(BeNil())
+		port := freePort()
+		go func() {
+			_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+		}()
+		waitForPort(port)
+		baseURL = fmt.Sprintf("http://127.0.0.1:%v", port)
+	})
+	Context("When the client sends a valid token", func() {
+		It("should attach the principal to the connection", func() {
+			client, err := NewClient(baseURL+"/hub", ClientHeader(http.Header{"Authorization": []string{"Bearer valid-alice"}}))
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(client.Connect(ctx)).To(BeNil())
+			defer func() { _ = client.Close() }()
+			var whoami string
+			Expect(client.Invoke(ctx, &whoami, "Whoami")).To(BeNil())
+			Expect(whoami).To(Equal("alice"))
+		})
+	})
+	Context("When the token is passed as access_token query parameter", func() {
+		It("should accept the negotiate request", func() {
+			resp, err := http.Post(baseURL+"/hub/negotiate?access_token=valid-bob", "text/plain", nil)
+			Expect(err).To(BeNil())
+			_ = resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+	Context("When the client sends no or an invalid token", func() {
+		It("should reject negotiate and upgrade with 401", func() {
+			for _, url := range []string{baseURL + "/hub/negotiate", baseURL + "/hub/negotiate?access_token=guess"} {
+				resp, err := http.Post(url, "text/plain", nil)
+				Expect(err).To(BeNil())
+				_ = resp.Body.Close()
+				Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			}
+			req, err := http.NewRequest("GET", baseURL+"/hub?access_token=guess", nil)
+			Expect(err).To(BeNil())
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			_ = resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			client, err := NewClient(baseURL + "/hub")
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(client.Connect(ctx)).NotTo(BeNil())
+		})
+	})
+	Context("When no TokenAuthenticatorFunc is given", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&authenticationHub{}), TokenAuthentication(nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,100 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// AuthorizationContext describes an invocation which is checked by an AuthorizationPolicy.
+// Context belongs to the connection and carries its principal, see PrincipalFromContext.
+// Items are the items of the connection, e.g. to check its ConnectionMetadata.
+type AuthorizationContext struct {
+	Context      context.Context
+	ConnectionID string
+	UserID       string
+	Target       string
+	Items        *sync.Map
+}
+
+// AuthorizationPolicy decides if an invocation is authorized. A returned error rejects the invocation,
+// the hub method is not invoked and the client gets the error as completion error.
+type AuthorizationPolicy func(c AuthorizationContext) error
+
+// AuthorizeHub requires all invocations of the hub to satisfy policies
+func AuthorizeHub(policies ...AuthorizationPolicy) func(*Server) error {
+	return func(s *Server) error {
+		for _, policy := range policies {
+			if policy == nil {
+				return errors.New("AuthorizeHub needs policies which are not nil")
+			}
+		}
+		s.hubPolicies = append(s.hubPolicies, policies...)
+		return nil
+	}
+}
+
+// AuthorizeMethod requires the invocations of the hub method target to satisfy policies,
+// in addition to the policies of AuthorizeHub. The target is matched case-insensitively
+func AuthorizeMethod(target string, policies ...AuthorizationPolicy) func(*Server) error {
+	return func(s *Server) error {
+		for _, policy := range policies {
+			if policy == nil {
+				return fmt.Errorf("AuthorizeMethod %s needs policies which are not nil", target)
+			}
+		}
+		if s.methodPolicies == nil {
+			s.methodPolicies = make(map[string][]AuthorizationPolicy)
+		}
+		target = strings.ToLower(target)
+		s.methodPolicies[target] = append(s.methodPolicies[target], policies...)
+		return nil
+	}
+}
+
+// RequireAuthenticated is an AuthorizationPolicy which authorizes connections with a principal, see TokenAuthentication
+func RequireAuthenticated(c AuthorizationContext) error {
+	if _, ok := PrincipalFromContext(c.Context); !ok {
+		return errors.New("not authenticated")
+	}
+	return nil
+}
+
+// RequireUser returns an AuthorizationPolicy which authorizes the connections of the users with the given userIDs,
+// see UserIDProvider
+func RequireUser(userIDs ...string) AuthorizationPolicy {
+	return func(c AuthorizationContext) error {
+		for _, userID := range userIDs {
+			if c.UserID == userID {
+				return nil
+			}
+		}
+		return errors.New("user not allowed")
+	}
+}
+
+// authorize checks the invocation against the policies of the hub and its target.
+// It returns the error which is sent to the client as completion error, or nil if the invocation is authorized
+func (sl *serverLoop) authorize(invocation invocationMessage) error {
+	methodPolicies := sl.server.methodPolicies[strings.ToLower(invocation.Target)]
+	if len(sl.server.hubPolicies) == 0 && len(methodPolicies) == 0 {
+		return nil
+	}
+	c := AuthorizationContext{
+		Context:      sl.principalContext(),
+		ConnectionID: sl.hubConn.ConnectionID(),
+		UserID:       sl.hubConn.UserID(),
+		Target:       invocation.Target,
+		Items:        sl.hubConn.Items(),
+	}
+	for _, policies := range [][]AuthorizationPolicy{sl.server.hubPolicies, methodPolicies} {
+		for _, policy := range policies {
+			if err := policy(c); err != nil {
+				return fmt.Errorf("Invocation of %s not authorized: %v", invocation.Target, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type authorizationHub struct {
+	Hub
+}
+
+func (a *authorizationHub) Public() string {
+	return "public"
+}
+
+func (a *authorizationHub) Admin() string {
+	return "admin"
+}
+
+var _ = Describe("Authorization policies", func() {
+	Context("When AuthorizeMethod is used", func() {
+		It("should reject the invocations of the method which do not satisfy the policies", func() {
+			server, err := NewServer(SimpleHubFactory(&authorizationHub{}),
+				UserIDProvider(func(conn Connection) string { return "alice" }),
+				AuthorizeMethod("Admin", RequireUser("bob")))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"public"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "public"})))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"admin"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2",
+				Error: "Invocation of admin not authorized: user not allowed"})))
+		})
+	})
+	Context("When AuthorizeHub is used", func() {
+		It("should check all invocations of the hub", func() {
+			var targets []string
+			server, err := NewServer(SimpleHubFactory(&authorizationHub{}),
+				AuthorizeHub(RequireAuthenticated, func(c AuthorizationContext) error {
+					targets = append(targets, c.Target)
+					if principal, _ := PrincipalFromContext(c.Context); principal != "alice" {
+						return errors.New("only alice")
+					}
+					return nil
+				}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.WithValue(context.TODO(), principalContextKey{}, "alice"), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"admin"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "admin"})))
+			Expect(targets).To(Equal([]string{"admin"}))
+			conn = newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"public"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2",
+				Error: "Invocation of public not authorized: not authenticated"})))
+		})
+	})
+	Context("When a nil policy is given", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&authorizationHub{}), AuthorizeHub(nil))
+			Expect(err).NotTo(BeNil())
+			_, err = NewServer(SimpleHubFactory(&authorizationHub{}), AuthorizeMethod("admin", nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
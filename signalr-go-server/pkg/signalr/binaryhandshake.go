@@ -0,0 +1,167 @@
+package signalr
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// binaryHandshakeMarker starts a binary handshake. JSON handshakes never start with it
+const binaryHandshakeMarker = 0x00
+
+// BinaryHandshake lets the server accept a compact binary handshake from constrained embedded clients,
+// in addition to the JSON handshake. A binary handshake is the byte 0x00, followed by the length of the request
+// as protobuf varint and the request as protocol buffers message:
+//
+//	message HandshakeRequest {
+//	  string protocol = 1;
+//	  int32 version = 2;
+//	  repeated string features = 3;
+//	  string locale = 4;
+//	  string time_zone = 5;
+//	  string app_version = 6;
+//	  string resume_token = 7;
+//	}
+//
+// The server answers with the length of the response as protobuf varint and the response:
+//
+//	message HandshakeResponse {
+//	  string error = 1;
+//	  repeated string features = 2;
+//	  string resume_token = 3;
+//	}
+//
+// The binary handshake is off by default, so a handshake starting with 0x00 is rejected as malformed JSON.
+func BinaryHandshake() func(*Server) error {
+	return func(s *Server) error {
+		s.binaryHandshake = true
+		return nil
+	}
+}
+
+// readBinaryHandshake reads the length and the request of a binary handshake after its marker, byte by byte,
+// so no data of the messages following it is consumed
+func (s *Server) readBinaryHandshake(conn Connection) (handshakeRequest, error) {
+	var length uint64
+	data := make([]byte, 1)
+	for shift := uint(0); ; shift += 7 {
+		if shift > 63 {
+			return handshakeRequest{}, errors.New("binary handshake length overflows")
+		}
+		if _, err := readFull(conn, data); err != nil {
+			return handshakeRequest{}, err
+		}
+		length |= uint64(data[0]&0x7f) << shift
+		if data[0] < 0x80 {
+			break
+		}
+	}
+	if length >= uint64(s.maximumReceiveMessageSize) {
+		return handshakeRequest{}, errors.New("handshake exceeds maximum receive message size")
+	}
+	message := make([]byte, length)
+	if _, err := readFull(conn, message); err != nil {
+		return handshakeRequest{}, err
+	}
+	return decodeBinaryHandshakeRequest(message)
+}
+
+// readFull reads len(data) bytes from conn
+func readFull(conn Connection, data []byte) (int, error) {
+	read := 0
+	for read < len(data) {
+		n, err := conn.Read(data[read:])
+		read += n
+		if err != nil {
+			return read, err
+		}
+	}
+	return read, nil
+}
+
+func decodeBinaryHandshakeRequest(message []byte) (handshakeRequest, error) {
+	var request handshakeRequest
+	var metadata ConnectionMetadata
+	for len(message) > 0 {
+		key, n := binary.Uvarint(message)
+		if n <= 0 {
+			return request, errors.New("malformed binary handshake")
+		}
+		message = message[n:]
+		field, wireType := key>>3, key&7
+		switch wireType {
+		case 0:
+			value, n := binary.Uvarint(message)
+			if n <= 0 {
+				return request, errors.New("malformed binary handshake")
+			}
+			message = message[n:]
+			if field == 2 {
+				request.Version = int(value)
+			}
+		case 2:
+			length, n := binary.Uvarint(message)
+			if n <= 0 || length > uint64(len(message)-n) {
+				return request, errors.New("malformed binary handshake")
+			}
+			value := string(message[n : n+int(length)])
+			message = message[n+int(length):]
+			switch field {
+			case 1:
+				request.Protocol = value
+			case 3:
+				request.Features = append(request.Features, value)
+			case 4:
+				metadata.Locale = value
+			case 5:
+				metadata.TimeZone = value
+			case 6:
+				metadata.AppVersion = value
+			case 7:
+				request.ResumeToken = value
+			}
+		case 1, 5:
+			// Fixed size fields are not used by the handshake, skip them
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(message) < size {
+				return request, errors.New("malformed binary handshake")
+			}
+			message = message[size:]
+		default:
+			return request, fmt.Errorf("unsupported wire type %v in binary handshake", wireType)
+		}
+	}
+	if metadata != (ConnectionMetadata{}) {
+		request.Metadata = &metadata
+	}
+	return request, nil
+}
+
+// encodeBinaryHandshakeResponse returns the length prefixed HandshakeResponse
+func encodeBinaryHandshakeResponse(errorMessage string, features []string, resumeToken string) []byte {
+	var message []byte
+	if errorMessage != "" {
+		message = appendProtobufString(message, 1, errorMessage)
+	}
+	for _, feature := range features {
+		message = appendProtobufString(message, 2, feature)
+	}
+	if resumeToken != "" {
+		message = appendProtobufString(message, 3, resumeToken)
+	}
+	return append(appendUvarint(nil, uint64(len(message))), message...)
+}
+
+func appendProtobufString(buf []byte, field uint64, value string) []byte {
+	buf = appendUvarint(buf, field<<3|2)
+	buf = appendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	varint := make([]byte, binary.MaxVarintLen64)
+	return append(buf, varint[:binary.PutUvarint(varint, value)]...)
+}
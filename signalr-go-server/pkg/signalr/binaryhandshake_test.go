@@ -0,0 +1,91 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+)
+
+type binaryHandshakeHub struct {
+	Hub
+}
+
+func (b *binaryHandshakeHub) Locale() string {
+	return b.Metadata().Locale
+}
+
+// binaryHandshakeRequest returns the binary handshake for protocol, with version 1, locale and features
+func binaryHandshakeRequest(protocol string, locale string, features ...string) []byte {
+	message := appendProtobufString(nil, 1, protocol)
+	message = append(message, 2<<3, 1)
+	message = appendProtobufString(message, 4, locale)
+	for _, feature := range features {
+		message = appendProtobufString(message, 3, feature)
+	}
+	return append(append([]byte{binaryHandshakeMarker}, appendUvarint(nil, uint64(len(message)))...), message...)
+}
+
+// readBinaryHandshakeResponse reads the length prefixed response, which is shorter than 128 bytes
+func readBinaryHandshakeResponse(conn *testingConnection) []byte {
+	length := make([]byte, 1)
+	_, err := io.ReadFull(conn.cliReader, length)
+	Expect(err).To(BeNil())
+	response := make([]byte, length[0])
+	_, err = io.ReadFull(conn.cliReader, response)
+	Expect(err).To(BeNil())
+	return response
+}
+
+var _ = Describe("BinaryHandshake option", func() {
+	Context("When a client sends a binary handshake", func() {
+		It("should answer in binary and connect the client with the requested protocol", func() {
+			server, err := NewServer(SimpleHubFactory(&binaryHandshakeHub{}), BinaryHandshake(), Features("compression"))
+			Expect(err).To(BeNil())
+			conn := newTestingConnectionBeforeHandshake()
+			go server.Run(context.TODO(), conn)
+			_, err = conn.cliWriter.Write(binaryHandshakeRequest("json", "fr-FR", "compression", "unknown"))
+			Expect(err).To(BeNil())
+			Expect(readBinaryHandshakeResponse(conn)).To(Equal(appendProtobufString(nil, 2, "compression")))
+			go receiveLoop(conn)()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"locale"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "fr-FR"})))
+		})
+	})
+	Context("When a client requests an unknown protocol with a binary handshake", func() {
+		It("should answer with a binary error", func() {
+			server, err := NewServer(SimpleHubFactory(&binaryHandshakeHub{}), BinaryHandshake())
+			Expect(err).To(BeNil())
+			conn := newTestingConnectionBeforeHandshake()
+			go server.Run(context.TODO(), conn)
+			_, err = conn.cliWriter.Write(binaryHandshakeRequest("cbor", ""))
+			Expect(err).To(BeNil())
+			Expect(readBinaryHandshakeResponse(conn)).To(Equal(appendProtobufString(nil, 1, "protocol cbor not supported")))
+		})
+	})
+	Context("When the binary handshake is not enabled", func() {
+		It("should not connect a client which sends it", func() {
+			server, err := NewServer(SimpleHubFactory(&binaryHandshakeHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnectionBeforeHandshake()
+			done := make(chan struct{})
+			go func() {
+				server.Run(context.TODO(), conn)
+				close(done)
+			}()
+			_, err = conn.cliWriter.Write(append(binaryHandshakeRequest("json", ""), 30))
+			Expect(err).To(BeNil())
+			Eventually(done).Should(BeClosed())
+		})
+	})
+	Context("When a binary handshake is decoded", func() {
+		It("should skip unknown fields", func() {
+			message := append([]byte{7<<3 | 0, 42, 8<<3 | 5, 1, 2, 3, 4}, appendProtobufString(nil, 1, "json")...)
+			request, err := decodeBinaryHandshakeRequest(message)
+			Expect(err).To(BeNil())
+			Expect(request.Protocol).To(Equal("json"))
+			_, err = decodeBinaryHandshakeRequest([]byte{1<<3 | 2, 10, 'j'})
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,97 @@
+package signalr
+
+import (
+	"sync"
+	"time"
+)
+
+// broadcastThrottle limits the rate of broadcasts per target and group. Broadcasts arriving faster
+// than the interval of the target are not sent immediately. Only the latest of them is sent at the
+// end of the interval, earlier ones are dropped.
+// A slot is idle when it waits for no broadcast and its interval has elapsed. It behaves like no slot then,
+// so idle slots are evicted, at most once per longest interval, and groups which are gone leave no slots behind.
+type broadcastThrottle struct {
+	intervals   map[string]time.Duration
+	mx          sync.Mutex
+	slots       map[throttleKey]*throttleSlot
+	maxInterval time.Duration
+	lastEvicted time.Time
+}
+
+// throttleKey identifies a broadcast. all is true for broadcasts to all connections, else group is the receiving group
+type throttleKey struct {
+	all    bool
+	group  string
+	target string
+}
+
+type throttleSlot struct {
+	lastSent time.Time
+	args     []interface{}
+	send     func(args []interface{})
+	timer    *time.Timer
+}
+
+func newBroadcastThrottle(intervals map[string]time.Duration) *broadcastThrottle {
+	if len(intervals) == 0 {
+		return nil
+	}
+	b := &broadcastThrottle{intervals: intervals, slots: make(map[throttleKey]*throttleSlot), lastEvicted: time.Now()}
+	for _, interval := range intervals {
+		if interval > b.maxInterval {
+			b.maxInterval = interval
+		}
+	}
+	return b
+}
+
+// evictIdle removes the idle slots. b.mx must be locked
+func (b *broadcastThrottle) evictIdle(now time.Time) {
+	for key, slot := range b.slots {
+		if slot.timer == nil && now.Sub(slot.lastSent) >= b.intervals[key.target] {
+			delete(b.slots, key)
+		}
+	}
+	b.lastEvicted = now
+}
+
+// throttled returns false if the broadcast should be sent now. Otherwise it keeps args as latest value
+// and send is called with the latest value when the interval of the target has elapsed
+func (b *broadcastThrottle) throttled(key throttleKey, args []interface{}, send func(args []interface{})) bool {
+	if b == nil {
+		return false
+	}
+	interval, ok := b.intervals[key.target]
+	if !ok {
+		return false
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	now := time.Now()
+	if now.Sub(b.lastEvicted) >= b.maxInterval {
+		b.evictIdle(now)
+	}
+	slot, ok := b.slots[key]
+	if !ok {
+		slot = &throttleSlot{}
+		b.slots[key] = slot
+	}
+	if slot.timer == nil && now.Sub(slot.lastSent) >= interval {
+		slot.lastSent = now
+		return false
+	}
+	// Latest value wins
+	slot.args = args
+	slot.send = send
+	if slot.timer == nil {
+		slot.timer = time.AfterFunc(slot.lastSent.Add(interval).Sub(now), func() {
+			b.mx.Lock()
+			args, send := slot.args, slot.send
+			slot.args, slot.send, slot.timer = nil, nil, nil
+			slot.lastSent = time.Now()
+			b.mx.Unlock()
+			send(args)
+		})
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	errNotConnected = errors.New("connection is not connected")
+	errDraining     = errors.New("connection is draining")
+)
+
+// InvokeConnections sends the invocation to the connections with the connectionIDs, to bulkSendParallelism connections
+// at a time, so a slow connection only holds up its own worker. The invocation is encoded only once per protocol.
+// The result has an entry for each connection id, which is nil if the invocation has been written to the connection.
+// When ctx is done, the connections which have not been sent to yet get the error of ctx.
+func (d *defaultHubLifetimeManager) InvokeConnections(ctx context.Context, connectionIDs []string, target string, args []interface{}) map[string]error {
+	results := make(map[string]error, len(connectionIDs))
+	var conns []hubConnection
+	for _, connectionID := range connectionIDs {
+		if _, ok := results[connectionID]; ok {
+			continue
+		}
+		if client, ok := d.clients.Load(connectionID); !ok {
+			results[connectionID] = errNotConnected
+		} else if conn := client.(hubConnection); conn.Draining() {
+			results[connectionID] = errDraining
+		} else {
+			// Reserve the entry, so duplicate ids are sent to only once
+			results[connectionID] = nil
+			conns = append(conns, conn)
+		}
+	}
+	var prepared *preparedInvocation
+	if len(conns) > 1 && len(d.transformers[target]) == 0 && !d.encryption.applies(target) {
+		prepared = newPreparedInvocation(target, args, traceHeaders(ctx))
+	}
+	workers := d.bulkSendParallelism
+	if workers > len(conns) {
+		workers = len(conns)
+	}
+	queue := make(chan hubConnection)
+	var mx sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for conn := range queue {
+				err := ctx.Err()
+				if err == nil {
+					var msg interface{}
+					if msg, err = d.sendInvocation(ctx, conn, prepared, "", target, args); err != nil {
+						_ = d.info.Log(evt, msgSend, "message", fmtMsg(msg), "error", err)
+					}
+				}
+				mx.Lock()
+				results[conn.ConnectionID()] = err
+				mx.Unlock()
+			}
+		}()
+	}
+	for _, conn := range conns {
+		queue <- conn
+	}
+	close(queue)
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,66 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+type bulkHub struct {
+	Hub
+}
+
+func (b *bulkHub) OnConnected(connectionID string) {
+	bulkHubOnConnected <- connectionID
+}
+
+var bulkHubOnConnected = make(chan string, 10)
+
+var _ = Describe("SendToConnections", func() {
+	var server *Server
+	var conns []*testingConnection
+	BeforeEach(func() {
+		var err error
+		server, err = NewServer(SimpleHubFactory(&bulkHub{}), BulkSendParallelism(2))
+		Expect(err).To(BeNil())
+		conns = make([]*testingConnection, 3)
+		for i, id := range []string{"bulk1", "bulk2", "bulk3"} {
+			conns[i] = newTestingConnection()
+			conns[i].connectionID = id
+			go server.Run(context.TODO(), conns[i])
+			<-bulkHubOnConnected
+		}
+	})
+	Context("When invocations are sent to some connections", func() {
+		It("should send once to each of them and return the result per connection", func() {
+			results := server.defaultHubClients.SendToConnections([]string{"bulk1", "bulk2", "missing", "bulk1"}, "bulk", 1)
+			Expect(results).To(Equal(map[string]error{"bulk1": nil, "bulk2": nil, "missing": errNotConnected}))
+			for _, conn := range conns[:2] {
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+					invocationMessage{Type: 1, Target: "bulk", Arguments: []interface{}{float64(1)}})))
+			}
+			for _, conn := range conns {
+				Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			}
+		})
+	})
+	Context("When the context is canceled", func() {
+		It("should send to no connection and return the error of the context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			results := server.defaultHubClients.SendToConnectionsContext(ctx, []string{"bulk1", "bulk2", "bulk3"}, "bulk")
+			Expect(results).To(HaveLen(3))
+			for _, err := range results {
+				Expect(err).To(Equal(context.Canceled))
+			}
+			Consistently(conns[0].ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+	Context("When the BulkSendParallelism is less than 1", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&bulkHub{}), BulkSendParallelism(0))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,132 @@
+package signalr
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ChaosOptions configures the faults a ChaosConnection injects into the frames written to the client.
+// Seed makes the faults reproducible.
+// MaxDelay is the maximum random delay before a frame is written.
+// DropRate is the probability that a frame is dropped.
+// ReorderRate is the probability that a frame is held back and written after the following frame.
+// A held back frame is written at the latest after chaosHoldTimeout.
+type ChaosOptions struct {
+	Seed        int64
+	MaxDelay    time.Duration
+	DropRate    float64
+	ReorderRate float64
+}
+
+// chaosHoldTimeout is the time a held back frame waits for the frame it is reordered with
+const chaosHoldTimeout = 10 * time.Millisecond
+
+// ChaosConnection wraps a Connection and randomly delays, drops or reorders the frames written to it,
+// so hubs and clients can be tested for their handling of unreliable connections.
+// A frame is the data of one Write, which is one hub message. The handshake response is never affected,
+// and frames are reordered only by swapping them with the following frame, never by splitting them.
+type ChaosConnection struct {
+	Connection
+	options   ChaosOptions
+	random    *rand.Rand
+	handshake bool
+	held      []byte
+	holdTimer *time.Timer
+	dropped   int
+	reordered int
+	mx        sync.Mutex
+}
+
+// NewChaosConnection creates a ChaosConnection which injects faults into conn
+func NewChaosConnection(conn Connection, options ChaosOptions) *ChaosConnection {
+	return &ChaosConnection{
+		Connection: conn,
+		options:    options,
+		random:     rand.New(rand.NewSource(options.Seed)),
+	}
+}
+
+// Write writes p with the faults decided by the ChaosOptions
+func (c *ChaosConnection) Write(p []byte) (int, error) {
+	c.mx.Lock()
+	if !c.handshake {
+		// The first write is the handshake response
+		c.handshake = true
+		c.mx.Unlock()
+		return c.Connection.Write(p)
+	}
+	var delay time.Duration
+	if c.options.MaxDelay > 0 {
+		delay = time.Duration(c.random.Int63n(int64(c.options.MaxDelay)))
+	}
+	drop := c.random.Float64() < c.options.DropRate
+	hold := !drop && c.held == nil && c.random.Float64() < c.options.ReorderRate
+	c.mx.Unlock()
+	time.Sleep(delay)
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	if drop {
+		c.dropped++
+		return len(p), nil
+	}
+	if hold {
+		c.held = append([]byte(nil), p...)
+		c.holdTimer = time.AfterFunc(chaosHoldTimeout, c.flush)
+		return len(p), nil
+	}
+	if _, err := c.Connection.Write(p); err != nil {
+		return 0, err
+	}
+	if c.held != nil {
+		c.holdTimer.Stop()
+		c.reordered++
+		return len(p), c.writeHeld()
+	}
+	return len(p), nil
+}
+
+func (c *ChaosConnection) flush() {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	_ = c.writeHeld()
+}
+
+// writeHeld writes the held back frame. It must be called with mx locked
+func (c *ChaosConnection) writeHeld() error {
+	if c.held == nil {
+		return nil
+	}
+	held := c.held
+	c.held = nil
+	_, err := c.Connection.Write(held)
+	return err
+}
+
+// Close closes the wrapped connection if it is a ClosableConnection
+func (c *ChaosConnection) Close(code int, reason string) error {
+	c.flush()
+	if closer, ok := c.Connection.(ClosableConnection); ok {
+		return closer.Close(code, reason)
+	}
+	return nil
+}
+
+// RemoteAddr returns the remote address of the wrapped connection if it is a ConnectionInfo
+func (c *ChaosConnection) RemoteAddr() string {
+	return remoteAddr(c.Connection)
+}
+
+// DroppedFrames returns the number of frames which have been dropped
+func (c *ChaosConnection) DroppedFrames() int {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.dropped
+}
+
+// ReorderedFrames returns the number of frames which have been written after their following frame
+func (c *ChaosConnection) ReorderedFrames() int {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.reordered
+}
@@ -0,0 +1,68 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+func runChaosConnection(connectionID string, options ChaosOptions) (*Server, *testingConnection, *ChaosConnection) {
+	server, err := NewServer(SimpleHubFactory(&groupHub{}))
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	conn.connectionID = connectionID
+	chaos := NewChaosConnection(conn, options)
+	go server.Run(context.TODO(), chaos)
+	Expect(<-groupHubOnConnectMsg).To(Equal(connectionID))
+	return server, conn, chaos
+}
+
+// receiveSequence sends count invocations with their sequence number and returns the received sequence numbers
+func receiveSequence(server *Server, conn *testingConnection, count int) []float64 {
+	for i := 0; i < count; i++ {
+		Expect(server.lifetimeManager.InvokeClient(context.TODO(), conn.ConnectionID(), "seq", []interface{}{i})).To(BeNil())
+	}
+	var received []float64
+	for {
+		select {
+		case message := <-conn.ReceiveChan():
+			received = append(received, message.(invocationMessage).Arguments[0].(float64))
+		case <-time.After(200 * time.Millisecond):
+			return received
+		}
+	}
+}
+
+var _ = Describe("ChaosConnection", func() {
+	Context("When frames are dropped", func() {
+		It("should drop some frames, reproducible with the seed", func() {
+			server, conn, chaos := runChaosConnection("chaosdrop", ChaosOptions{Seed: 1, DropRate: 0.5})
+			received := receiveSequence(server, conn, 20)
+			Expect(chaos.DroppedFrames()).To(BeNumerically(">", 0))
+			Expect(len(received) + chaos.DroppedFrames()).To(Equal(20))
+			server, conn, chaos = runChaosConnection("chaosdrop2", ChaosOptions{Seed: 1, DropRate: 0.5})
+			Expect(receiveSequence(server, conn, 20)).To(Equal(received))
+		})
+	})
+	Context("When frames are reordered", func() {
+		It("should deliver all frames, but not in order", func() {
+			server, conn, chaos := runChaosConnection("chaosreorder", ChaosOptions{Seed: 2, ReorderRate: 0.5})
+			received := receiveSequence(server, conn, 20)
+			Expect(received).To(HaveLen(20))
+			expected := make([]interface{}, 20)
+			for i := range expected {
+				expected[i] = float64(i)
+			}
+			Expect(received).To(ConsistOf(expected...))
+			Expect(chaos.ReorderedFrames()).To(BeNumerically(">", 0))
+		})
+	})
+	Context("When frames are delayed", func() {
+		It("should deliver all frames in order", func() {
+			server, conn, _ := runChaosConnection("chaosdelay", ChaosOptions{Seed: 3, MaxDelay: 5 * time.Millisecond})
+			received := receiveSequence(server, conn, 10)
+			Expect(received).To(Equal([]float64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}))
+		})
+	})
+})
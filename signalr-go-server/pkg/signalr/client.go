@@ -0,0 +1,747 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is a SignalR client which connects to a hub over websockets, e.g. to a hub hosted by ASP.NET Core or by
+// this package. Handlers for the client methods the hub invokes are registered with On, before or after Connect.
+// Items are uploaded to channel parameters of hub methods with an UploadStream, streams of hub methods are
+// received with Stream.
+type Client struct {
+	url               string
+	header            http.Header
+	protocolName      string
+	keepAliveInterval time.Duration
+	metadata          *ConnectionMetadata
+	info              StructuredLogger
+	dbg               StructuredLogger
+	mx                sync.Mutex
+	handlers          map[string]reflect.Value
+	pending           map[string]chan completionMessage
+	lastID            uint64
+	protocol          HubProtocol
+	conn              hubConnection
+	cancel            context.CancelFunc
+	done              chan struct{}
+	err               error
+	// reconnect state, see ClientReconnect
+	reconnectPolicy     *ClientReconnectPolicy
+	reconnecting        bool
+	circuitState        CircuitState
+	circuitStateChanged func(state CircuitState)
+	random              *rand.Rand
+	// resumeToken is the resume token of the last connection, see UseResumeStore
+	resumeToken string
+	// offline is the queue of the calls issued while reconnecting, see ClientOfflineQueue
+	offline *clientOfflineQueue
+	// filters and handlerFilters, see ClientFilters and ClientHandlerFilters
+	filters        []ClientFilter
+	handlerFilters []ClientHandlerFilter
+	// metrics and hooks, see ClientMetrics and ClientMetricsHooks
+	metrics ClientMetrics
+	hooks   ClientHooks
+	// streams are the open streams by invocation id, see Stream and ClientStreamResubscription
+	streams     map[string]*ClientStream
+	resubscribe func(target string, args []interface{}) []interface{}
+}
+
+// NewClient creates a client for the hub at url, e.g. "https://example.com/chat".
+// The client is not connected before Connect is called.
+func NewClient(url string, options ...func(*Client) error) (*Client, error) {
+	info, dbg := buildInfoDebugLogger(log.NewLogfmtLogger(ioutil.Discard), false)
+	c := &Client{
+		url:               strings.TrimSuffix(url, "/"),
+		header:            make(http.Header),
+		protocolName:      "json",
+		keepAliveInterval: 15 * time.Second,
+		info:              info,
+		dbg:               dbg,
+		handlers:          make(map[string]reflect.Value),
+		pending:           make(map[string]chan completionMessage),
+		done:              make(chan struct{}),
+		random:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	for _, option := range options {
+		if option != nil {
+			if err := option(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+// ClientProtocol sets the hub protocol the client requests, "json" or "messagepack". Default is "json".
+func ClientProtocol(name string) func(*Client) error {
+	return func(c *Client) error {
+		if _, ok := protocolMap[name]; !ok {
+			return fmt.Errorf("protocol %v not supported", name)
+		}
+		c.protocolName = name
+		return nil
+	}
+}
+
+// ClientHeader sets header fields which are sent with the negotiate and the websocket request, e.g. Authorization
+func ClientHeader(header http.Header) func(*Client) error {
+	return func(c *Client) error {
+		for key, values := range header {
+			c.header[key] = append([]string(nil), values...)
+		}
+		return nil
+	}
+}
+
+// ClientMetadata sets the locale, time zone and app version which the client sends to the server in the handshake
+func ClientMetadata(metadata ConnectionMetadata) func(*Client) error {
+	return func(c *Client) error {
+		c.metadata = &metadata
+		return nil
+	}
+}
+
+// ClientKeepAliveInterval is the interval in which the client sends a ping to the server. Default is 15 seconds.
+func ClientKeepAliveInterval(interval time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("keep alive interval must be positive")
+		}
+		c.keepAliveInterval = interval
+		return nil
+	}
+}
+
+// ClientLogger sets the logger for the info events of the client, e.g. failing handlers. Default is no logging.
+// If debug is true, debug log events like the messages read by the protocol are generated, too
+func ClientLogger(logger StructuredLogger, debug bool) func(*Client) error {
+	return func(c *Client) error {
+		info, dbg := buildInfoDebugLogger(logger, debug)
+		c.info = log.WithPrefix(info, "ts", log.DefaultTimestampUTC, "class", "Client")
+		c.dbg = log.WithPrefix(dbg, "ts", log.DefaultTimestampUTC, "class", "Client")
+		return nil
+	}
+}
+
+// On registers handler for the client method target. handler must be a func. The arguments of the invocation
+// are converted to its parameter types. If the hub waits for a result of the client, the return values of the handler
+// are sent as result. A last return value of type error is sent as error.
+func (c *Client) On(target string, handler interface{}) error {
+	h := reflect.ValueOf(handler)
+	if h.Kind() != reflect.Func {
+		return fmt.Errorf("handler for %v is not a func", target)
+	}
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.handlers[strings.ToLower(target)] = h
+	return nil
+}
+
+// Connect negotiates with the server, opens the websocket and sends the handshake.
+// After Connect returned without error, the client receives invocations until Close is called or the server closes
+// the connection.
+func (c *Client) Connect(ctx context.Context) error {
+	hubURL, header, connectionID, err := c.negotiate(ctx)
+	if err != nil {
+		return err
+	}
+	wsURL, err := url.Parse(hubURL)
+	if err != nil {
+		return err
+	}
+	switch wsURL.Scheme {
+	case "https":
+		wsURL.Scheme = "wss"
+	case "http":
+		wsURL.Scheme = "ws"
+	}
+	query := wsURL.Query()
+	query.Set("id", connectionID)
+	wsURL.RawQuery = query.Encode()
+	config, err := websocket.NewConfig(wsURL.String(), hubURL)
+	if err != nil {
+		return err
+	}
+	config.Header = header
+	netConn, err := dialWebSocketHost(ctx, config)
+	if err != nil {
+		return err
+	}
+	// Dial, upgrade and handshake end when ctx is done, e.g. when Close is called during a reconnect
+	stopClosing := closeWhenDone(ctx, netConn)
+	ws, err := websocket.NewClient(config, netConn)
+	if err != nil {
+		_ = netConn.Close()
+		return contextError(stopClosing(), err)
+	}
+	wsConn := &webSocketConnection{conn: ws, connectionID: connectionID}
+	if err = c.handshake(wsConn); err != nil {
+		_ = ws.Close()
+		return contextError(stopClosing(), err)
+	}
+	if err = stopClosing(); err != nil {
+		return err
+	}
+	protocol := reflect.New(reflect.ValueOf(protocolMap[c.protocolName]).Elem().Type()).Interface().(HubProtocol)
+	if dp, ok := protocol.(debugLoggingProtocol); ok {
+		dp.setDebugLogger(c.dbg)
+	}
+	if bp, ok := protocol.(binaryProtocol); ok && bp.isBinary() {
+		wsConn.useBinaryFrames()
+	}
+	connCtx, cancel := context.WithCancel(context.Background())
+	conn := newHubConnection(connCtx, wsConn, protocol, 1<<20, "", nil)
+	conn.Start()
+	c.mx.Lock()
+	select {
+	case <-c.done:
+		c.mx.Unlock()
+		cancel()
+		_ = ws.Close()
+		return errors.New("client connection closed")
+	default:
+	}
+	c.countTraffic(c.conn)
+	c.protocol, c.conn, c.cancel, c.reconnecting = protocol, conn, cancel, false
+	flush := c.offline != nil && len(c.offline.calls) > 0
+	if flush {
+		c.offline.flushing = true
+	}
+	resubscribe := c.resubscribe != nil && len(c.streams) > 0
+	c.mx.Unlock()
+	go c.receiveLoop(conn)
+	go c.keepAlive(connCtx, conn)
+	if flush {
+		go c.flushOffline(conn)
+	}
+	if resubscribe {
+		go c.resubscribeStreams(conn)
+	}
+	return nil
+}
+
+// dialWebSocketHost opens the TCP connection, and for wss the TLS connection, to the host of config.
+// Dialing stops when ctx is done
+func dialWebSocketHost(ctx context.Context, config *websocket.Config) (net.Conn, error) {
+	host := config.Location.Host
+	if config.Location.Port() == "" {
+		switch config.Location.Scheme {
+		case "wss":
+			host = net.JoinHostPort(config.Location.Hostname(), "443")
+		default:
+			host = net.JoinHostPort(config.Location.Hostname(), "80")
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil || config.Location.Scheme != "wss" {
+		return conn, err
+	}
+	tlsConfig := &tls.Config{}
+	if config.TlsConfig != nil {
+		tlsConfig = config.TlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = config.Location.Hostname()
+	}
+	return tls.Client(conn, tlsConfig), nil
+}
+
+// closeWhenDone closes conn when ctx is done before stop is called. stop returns the error of ctx if conn has been closed
+func closeWhenDone(ctx context.Context, conn io.Closer) (stop func() error) {
+	stopped, result := make(chan struct{}), make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+			result <- ctx.Err()
+		case <-stopped:
+			result <- nil
+		}
+	}()
+	return func() error {
+		close(stopped)
+		return <-result
+	}
+}
+
+// contextError returns ctxErr if it is not nil, else err
+func contextError(ctxErr error, err error) error {
+	if ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// negotiate posts the negotiate request and follows redirects to other servers.
+// It returns the url of the hub, the header for the websocket request and the connection id
+func (c *Client) negotiate(ctx context.Context) (string, http.Header, string, error) {
+	hubURL, header := c.url, c.header.Clone()
+	c.mx.Lock()
+	resumeToken := c.resumeToken
+	c.mx.Unlock()
+	// Servers might redirect to other servers, but not endlessly
+	for redirects := 0; redirects < 100; redirects++ {
+		negotiateURL := hubURL + "/negotiate?negotiateVersion=1"
+		if redirects == 0 && resumeToken != "" {
+			// The server which issued the token might have left a reconnect hint for it
+			negotiateURL += "&resumeToken=" + url.QueryEscape(resumeToken)
+		}
+		req, err := http.NewRequest("POST", negotiateURL, nil)
+		if err != nil {
+			return "", nil, "", err
+		}
+		req.Header = header.Clone()
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return "", nil, "", err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return "", nil, "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", nil, "", fmt.Errorf("negotiate failed with status %v", resp.Status)
+		}
+		var response struct {
+			negotiateResponse
+			ConnectionToken string `json:"connectionToken"`
+			Error           string `json:"error"`
+		}
+		if err = json.Unmarshal(body, &response); err != nil {
+			return "", nil, "", err
+		}
+		switch {
+		case response.Error != "":
+			return "", nil, "", fmt.Errorf("negotiate failed: %v", response.Error)
+		case response.URL != "":
+			hubURL = strings.TrimSuffix(response.URL, "/")
+			if response.AccessToken != "" {
+				header.Set("Authorization", "Bearer "+response.AccessToken)
+			}
+			continue
+		}
+		webSockets := false
+		for _, transport := range response.AvailableTransports {
+			webSockets = webSockets || transport.Transport == "WebSockets"
+		}
+		if !webSockets {
+			return "", nil, "", errors.New("server does not support websockets")
+		}
+		// Servers with negotiate version 1 identify the connection by the token
+		if response.ConnectionToken != "" {
+			return hubURL, header, response.ConnectionToken, nil
+		}
+		return hubURL, header, response.ConnectionID, nil
+	}
+	return "", nil, "", errors.New("too many negotiate redirects")
+}
+
+// handshake sends the handshake request and reads the response byte by byte,
+// so no data of the messages following it is consumed
+func (c *Client) handshake(conn *webSocketConnection) error {
+	c.mx.Lock()
+	request, _ := json.Marshal(handshakeRequest{Protocol: c.protocolName, Version: 1, Metadata: c.metadata, ResumeToken: c.resumeToken})
+	c.mx.Unlock()
+	if _, err := conn.Write(append(request, 30)); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	var scanner recordSeparatorScanner
+	data := make([]byte, 1)
+	for {
+		n, err := conn.Read(data)
+		if err != nil {
+			return err
+		}
+		buf.Write(data[:n])
+		if rawResponse, complete := scanner.next(&buf); complete {
+			var response struct {
+				Error       string `json:"error"`
+				ResumeToken string `json:"resumeToken"`
+			}
+			if err = json.Unmarshal(rawResponse, &response); err != nil {
+				return err
+			}
+			if response.Error != "" {
+				return fmt.Errorf("handshake failed: %v", response.Error)
+			}
+			c.mx.Lock()
+			c.resumeToken = response.ResumeToken
+			c.mx.Unlock()
+			return nil
+		}
+	}
+}
+
+// Send invokes the hub method target without waiting for its result.
+// While the client reconnects, the call is queued if the client has a ClientOfflineQueue.
+func (c *Client) Send(target string, args ...interface{}) error {
+	return c.filterCall(&ClientInvocation{Context: context.Background(), Target: target, Args: args, Send: true},
+		func(invocation *ClientInvocation) error {
+			return c.send(invocation.Target, invocation.Args...)
+		})
+}
+
+func (c *Client) send(target string, args ...interface{}) error {
+	args, streams := splitUploadStreams(args)
+	if len(streams) == 0 {
+		c.mx.Lock()
+		call, err := c.enqueueOffline("", target, args)
+		c.mx.Unlock()
+		if call != nil || err != nil {
+			return err
+		}
+	}
+	conn, err := c.connection()
+	if err != nil {
+		return err
+	}
+	return c.sendInvocation(context.Background(), conn, "", target, args, streams)
+}
+
+// Invoke invokes the hub method target and waits until it returns or ctx is done.
+// The result of the hub method is converted by the hub protocol into result, which must be a pointer,
+// or nil if the result is not needed. If the hub method failed, the error is returned.
+// If ctx is done before, Invoke sends a CancelInvocation to the server and returns an *InvocationCanceledError.
+// While the client reconnects, the call is queued if the client has a ClientOfflineQueue. If ctx is done
+// before the queued call has been sent, it is removed from the queue and the error of ctx is returned.
+func (c *Client) Invoke(ctx context.Context, result interface{}, target string, args ...interface{}) error {
+	return c.filterCall(&ClientInvocation{Context: ctx, Target: target, Args: args, Result: result},
+		func(invocation *ClientInvocation) error {
+			return c.invoke(invocation.Context, invocation.Result, invocation.Target, invocation.Args...)
+		})
+}
+
+func (c *Client) invoke(ctx context.Context, result interface{}, target string, args ...interface{}) (err error) {
+	start := time.Now()
+	defer func() { c.invocationCompleted(target, time.Since(start), err) }()
+	completions := make(chan completionMessage, 1)
+	args, streams := splitUploadStreams(args)
+	var call *offlineCall
+	c.mx.Lock()
+	c.lastID++
+	id := strconv.FormatUint(c.lastID, 10)
+	if len(streams) == 0 {
+		call, err = c.enqueueOffline(id, target, args)
+	}
+	if err == nil {
+		c.pending[id] = completions
+	}
+	c.mx.Unlock()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		c.mx.Lock()
+		delete(c.pending, id)
+		c.mx.Unlock()
+	}()
+	var failed <-chan error
+	var expired <-chan time.Time
+	if call == nil {
+		conn, err := c.connection()
+		if err != nil {
+			return err
+		}
+		if err = c.sendInvocation(ctx, conn, id, target, args, streams); err != nil {
+			if ctx.Err() != nil {
+				// The invocation might have been written anyway
+				return c.cancelInvocation(ctx, conn, id)
+			}
+			return err
+		}
+	} else {
+		failed = call.failed
+		timer := time.NewTimer(c.offline.policy.TTL)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		select {
+		case completion := <-completions:
+			if call != nil && completion.InvocationID == "" && c.queuedOffline(call) {
+				// The connection was lost again before the queued call has been sent, it stays queued
+				continue
+			}
+			if completion.Error != "" {
+				return errors.New(completion.Error)
+			}
+			if result == nil || completion.Result == nil {
+				return nil
+			}
+			c.mx.Lock()
+			protocol := c.protocol
+			c.mx.Unlock()
+			return protocol.UnmarshalArgument(completion.Result, result)
+		case err := <-failed:
+			return err
+		case <-expired:
+			if c.removeOffline(call) {
+				return ErrOfflineCallExpired
+			}
+			expired = nil
+		case <-c.done:
+			return c.Err()
+		case <-ctx.Done():
+			if call != nil && c.removeOffline(call) {
+				return ctx.Err()
+			}
+			conn, err := c.connection()
+			if err != nil {
+				return &InvocationCanceledError{InvocationID: id, Err: ctx.Err()}
+			}
+			return c.cancelInvocation(ctx, conn, id)
+		}
+	}
+}
+
+// InvocationCanceledError is returned by Client.Invoke when its context is done before the hub method returned.
+// Delivered tells if the CancelInvocation message has been sent to the server, which may stop the hub method then.
+// Err is the error of the context.
+type InvocationCanceledError struct {
+	InvocationID string
+	Delivered    bool
+	Err          error
+}
+
+func (e *InvocationCanceledError) Error() string {
+	if e.Delivered {
+		return fmt.Sprintf("invocation %v canceled: %v", e.InvocationID, e.Err)
+	}
+	return fmt.Sprintf("invocation %v canceled, cancellation not delivered: %v", e.InvocationID, e.Err)
+}
+
+// Unwrap returns the error of the context, so errors.Is(err, context.Canceled) works
+func (e *InvocationCanceledError) Unwrap() error {
+	return e.Err
+}
+
+// cancelInvocation sends a CancelInvocation for the invocation id after ctx is done
+func (c *Client) cancelInvocation(ctx context.Context, conn hubConnection, id string) error {
+	_, err := conn.CancelInvocation(id)
+	if err != nil {
+		_ = c.info.Log(evt, "cancel", "invocationId", id, "error", err)
+	}
+	return &InvocationCanceledError{InvocationID: id, Delivered: err == nil, Err: ctx.Err()}
+}
+
+// Close sends a close message to the server and closes the connection. A reconnecting client stops reconnecting
+func (c *Client) Close() error {
+	c.mx.Lock()
+	reconnecting := c.reconnecting
+	c.mx.Unlock()
+	if reconnecting {
+		c.stop(nil)
+		return nil
+	}
+	conn, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Close("", false)
+	c.stop(nil)
+	return err
+}
+
+// Done returns a channel which is closed when the connection has ended
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error the connection ended with. It is nil while the client is connected or after Close
+func (c *Client) Err() error {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.err
+}
+
+func (c *Client) connection() (hubConnection, error) {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	if c.conn == nil {
+		return nil, errors.New("client not connected")
+	}
+	if c.reconnecting {
+		return nil, errors.New("client reconnecting")
+	}
+	select {
+	case <-c.done:
+		return nil, errors.New("client connection closed")
+	default:
+		return c.conn, nil
+	}
+}
+
+// stop ends the connection with err, once
+func (c *Client) stop(err error) {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.err = err
+	close(c.done)
+	for id, s := range c.streams {
+		if err != nil {
+			s.end(err)
+		} else {
+			s.end(errors.New("client connection closed"))
+		}
+		delete(c.streams, id)
+	}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	closeClientTransport(c.conn)
+}
+
+// closeClientTransport closes the websocket of conn
+func closeClientTransport(conn hubConnection) {
+	if ws, ok := conn.(*defaultHubConnection); ok {
+		if closer, ok := ws.connection.(ClosableConnection); ok {
+			_ = closer.Close(CloseNormal, "")
+		}
+	}
+}
+
+func (c *Client) receiveLoop(conn hubConnection) {
+	for {
+		message, err := conn.Receive()
+		if err != nil {
+			c.connectionLost(conn, err)
+			return
+		}
+		switch message := message.(type) {
+		case invocationMessage:
+			go c.handleInvocation(conn, message)
+		case streamItemMessage:
+			c.receiveStreamItem(message)
+		case completionMessage:
+			if c.completeStream(message) {
+				continue
+			}
+			c.mx.Lock()
+			completions, ok := c.pending[message.InvocationID]
+			c.mx.Unlock()
+			if ok {
+				completions <- message
+			}
+		case closeMessage:
+			if message.AllowReconnect && c.reconnectPolicy != nil {
+				c.connectionLost(conn, fmt.Errorf("server closed the connection: %v", message.Error))
+			} else if message.Error != "" {
+				c.stop(fmt.Errorf("server closed the connection: %v", message.Error))
+			} else {
+				c.stop(nil)
+			}
+			return
+		}
+	}
+}
+
+func (c *Client) handleInvocation(conn hubConnection, invocation invocationMessage) {
+	c.mx.Lock()
+	handler, ok := c.handlers[strings.ToLower(invocation.Target)]
+	protocol := c.protocol
+	c.mx.Unlock()
+	var result interface{}
+	var errorMessage string
+	values, err := c.filterHandler(&ClientHandlerInvocation{
+		InvocationID: invocation.InvocationID,
+		Target:       invocation.Target,
+		Args:         invocation.Arguments,
+	}, func(h *ClientHandlerInvocation) ([]interface{}, error) {
+		if !ok {
+			return nil, fmt.Errorf("Client has no handler for %v", h.Target)
+		}
+		return callClientHandler(protocol, handler, h.Args)
+	})
+	if err != nil {
+		_ = c.info.Log(evt, "invocation", "error", err, "name", invocation.Target)
+		c.handlerFailed(invocation.Target, err)
+		errorMessage = err.Error()
+	} else if len(values) == 1 {
+		result = values[0]
+	} else if len(values) > 1 {
+		result = values
+	}
+	// Hubs waiting for a client result send an invocation id
+	if invocation.InvocationID != "" {
+		sendMessageAndLog(func() (interface{}, error) {
+			return conn.Completion(invocation.InvocationID, result, errorMessage)
+		}, c.info)
+	}
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// callClientHandler converts the arguments to the parameter types of handler and calls it.
+// It returns the return values of handler, without a last error value
+func callClientHandler(protocol HubProtocol, handler reflect.Value, arguments []interface{}) (values []interface{}, err error) {
+	t := handler.Type()
+	if len(arguments) != t.NumIn() {
+		return nil, fmt.Errorf("handler expects %v arguments, got %v", t.NumIn(), len(arguments))
+	}
+	in := make([]reflect.Value, len(arguments))
+	for i, argument := range arguments {
+		value := reflect.New(t.In(i))
+		if err = protocol.UnmarshalArgument(argument, value.Interface()); err != nil {
+			return nil, err
+		}
+		in[i] = value.Elem()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	out := handler.Call(in)
+	if len(out) > 0 && t.Out(len(out)-1) == errorType {
+		if e := out[len(out)-1]; !e.IsNil() {
+			return nil, e.Interface().(error)
+		}
+		out = out[:len(out)-1]
+	}
+	for _, value := range out {
+		values = append(values, value.Interface())
+	}
+	return values, nil
+}
+
+// keepAlive sends pings, so the server does not time out the connection
+func (c *Client) keepAlive(ctx context.Context, conn hubConnection) {
+	ticker := time.NewTicker(c.keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := conn.Ping(false); err != nil {
+				_ = c.info.Log(evt, "ping", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
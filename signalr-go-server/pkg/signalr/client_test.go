@@ -0,0 +1,723 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type clientTestHub struct {
+	Hub
+}
+
+type clientTestPoint struct {
+	X int
+	Y int
+}
+
+func (c *clientTestHub) Add2(i int) int {
+	return i + 2
+}
+
+func (c *clientTestHub) Move(p clientTestPoint, dx int) clientTestPoint {
+	return clientTestPoint{X: p.X + dx, Y: p.Y}
+}
+
+func (c *clientTestHub) Fail() int {
+	panic("failed")
+}
+
+func (c *clientTestHub) Echo(message string) {
+	c.Clients().Caller().Send("echo", message, len(message))
+}
+
+// Ask invokes the client method "ask" with an invocation id and returns how the client completed it
+func (c *clientTestHub) Ask(question string) string {
+	delivery := c.SendWithAck(c.context.ConnectionID(), "ask", question)
+	<-delivery.Done()
+	if err := delivery.Err(); err != nil {
+		return err.Error()
+	}
+	return "answered"
+}
+
+func (c *clientTestHub) Leave() {
+	_ = c.Drain(c.context.ConnectionID(), time.Second)
+}
+
+func (c *clientTestHub) Drop() {
+	c.context.Abort()
+}
+
+func (c *clientTestHub) Sleep() {
+	time.Sleep(200 * time.Millisecond)
+}
+
+func (c *clientTestHub) Sum(numbers <-chan int, offset int) {
+	sum := offset
+	for number := range numbers {
+		sum += number
+	}
+	c.Clients().Caller().Send("sum", sum)
+}
+
+func (c *clientTestHub) Collect(words <-chan string) {
+	var collected []string
+	for word := range words {
+		collected = append(collected, word)
+	}
+	c.Clients().Caller().Send("collected", strings.Join(collected, " "))
+}
+
+func (c *clientTestHub) Count(from int, to int) <-chan int {
+	numbers := make(chan int)
+	go func() {
+		defer close(numbers)
+		for i := from; i <= to; i++ {
+			numbers <- i
+		}
+	}()
+	return numbers
+}
+
+func (c *clientTestHub) Ticks(ctx context.Context, from int) <-chan int {
+	ticks := make(chan int)
+	go func() {
+		defer close(ticks)
+		for i := from; ; i++ {
+			select {
+			case ticks <- i:
+			case <-ctx.Done():
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	return ticks
+}
+
+// stalledUploadConnection is a hubConnection which passes the stream items written to it to items
+// and the completions to completions
+type stalledUploadConnection struct {
+	hubConnection
+	items       chan interface{}
+	completions chan string
+}
+
+func (s *stalledUploadConnection) StreamItem(id string, item interface{}) (streamItemMessage, error) {
+	s.items <- item
+	return streamItemMessage{Type: 2, InvocationID: id, Item: item}, nil
+}
+
+func (s *stalledUploadConnection) Completion(id string, result interface{}, error string) (completionMessage, error) {
+	s.completions <- id
+	return completionMessage{Type: 3, InvocationID: id}, nil
+}
+
+// cancelRecorder is a MessageInterceptor which passes the ids of the CancelInvocation messages of the clients to canceled
+type cancelRecorder struct {
+	canceled chan string
+}
+
+func (c *cancelRecorder) Inbound(connectionID string, message interface{}) (interface{}, bool) {
+	if cancel, ok := message.(CancelInvocationMessage); ok {
+		c.canceled <- cancel.InvocationID
+	}
+	return message, true
+}
+
+func (c *cancelRecorder) Outbound(connectionID string, message interface{}) (interface{}, bool) {
+	return message, true
+}
+
+// completionRecorder is a MessageInterceptor which passes the completions of the clients to completions
+type completionRecorder struct {
+	completions chan CompletionMessage
+}
+
+func (r *completionRecorder) Inbound(connectionID string, message interface{}) (interface{}, bool) {
+	if completion, ok := message.(CompletionMessage); ok {
+		r.completions <- completion
+	}
+	return message, true
+}
+
+func (r *completionRecorder) Outbound(connectionID string, message interface{}) (interface{}, bool) {
+	return message, true
+}
+
+// invocationRecorder is a MessageInterceptor which passes the targets and first arguments of the invocations of the
+// clients to invocations, in the order they were received
+type invocationRecorder struct {
+	invocations chan string
+}
+
+func (r *invocationRecorder) Inbound(connectionID string, message interface{}) (interface{}, bool) {
+	if invocation, ok := message.(InvocationMessage); ok {
+		if len(invocation.Arguments) == 0 {
+			r.invocations <- invocation.Target
+		} else {
+			r.invocations <- fmt.Sprintf("%v %s", invocation.Target, invocation.Arguments[0])
+		}
+	}
+	return message, true
+}
+
+func (r *invocationRecorder) Outbound(connectionID string, message interface{}) (interface{}, bool) {
+	return message, true
+}
+
+func startClientTestServer(options ...func(*Server) error) string {
+	router := http.NewServeMux()
+	_, err := MapHub(router, "/hub", &clientTestHub{}, options...)
+	Expect(err).To(BeNil())
+	port := freePort()
+	go func() {
+		_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+	}()
+	waitForPort(port)
+	return fmt.Sprintf("http://127.0.0.1:%v/hub", port)
+}
+
+// startUnavailableClientTestServer starts a server which answers all requests with 503 Service Unavailable
+// while unavailable is not 0
+func startUnavailableClientTestServer(unavailable *int32, options ...func(*Server) error) string {
+	router := http.NewServeMux()
+	_, err := MapHub(router, "/hub", &clientTestHub{}, options...)
+	Expect(err).To(BeNil())
+	port := freePort()
+	go func() {
+		_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if atomic.LoadInt32(unavailable) != 0 {
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			router.ServeHTTP(w, req)
+		}))
+	}()
+	waitForPort(port)
+	return fmt.Sprintf("http://127.0.0.1:%v/hub", port)
+}
+
+// startHangingClientTestServer starts a server which negotiates, but never answers the websocket upgrade
+func startHangingClientTestServer(release chan struct{}) string {
+	port := freePort()
+	go func() {
+		_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasSuffix(req.URL.Path, "/negotiate") {
+				_, _ = w.Write([]byte(`{"connectionId":"hanging","availableTransports":[{"transport":"WebSockets","transferFormats":["Text"]}]}`))
+				return
+			}
+			<-release
+		}))
+	}()
+	waitForPort(port)
+	return fmt.Sprintf("http://127.0.0.1:%v/hub", port)
+}
+
+var _ = Describe("Client", func() {
+	for _, protocol := range []string{"json", "messagepack"} {
+		protocol := protocol
+		Context(fmt.Sprintf("When the client is connected with the %v protocol", protocol), func() {
+			var client *Client
+			BeforeEach(func() {
+				var err error
+				client, err = NewClient(startClientTestServer(), ClientProtocol(protocol))
+				Expect(err).To(BeNil())
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				Expect(client.Connect(ctx)).To(BeNil())
+			})
+			AfterEach(func() {
+				_ = client.Close()
+			})
+			It("should invoke hub methods and return their results", func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				var sum int
+				Expect(client.Invoke(ctx, &sum, "add2", 1)).To(BeNil())
+				Expect(sum).To(Equal(3))
+				var p clientTestPoint
+				Expect(client.Invoke(ctx, &p, "Move", clientTestPoint{X: 1, Y: 2}, 3)).To(BeNil())
+				Expect(p).To(Equal(clientTestPoint{X: 4, Y: 2}))
+				Expect(client.Invoke(ctx, nil, "fail")).NotTo(BeNil())
+			})
+			It("should call the handlers of client methods", func() {
+				received := make(chan string, 1)
+				Expect(client.On("echo", func(message string, length int) {
+					received <- fmt.Sprintf("%v %v", message, length)
+				})).To(BeNil())
+				Expect(client.Send("echo", "hello")).To(BeNil())
+				Eventually(received).Should(Receive(Equal("hello 5")))
+			})
+			It("should end when the server closes the connection", func() {
+				Expect(client.Send("leave")).To(BeNil())
+				Eventually(client.Done()).Should(BeClosed())
+			})
+		})
+	}
+	Context("When the server invokes a client method with an invocation id", func() {
+		It("should complete the invocation with the result or the error of the handler", func() {
+			recorder := &completionRecorder{completions: make(chan CompletionMessage, 10)}
+			client, err := NewClient(startClientTestServer(MessageInterceptors(recorder)))
+			Expect(err).To(BeNil())
+			defer func() { _ = client.Close() }()
+			Expect(client.On("ask", func(question string) (string, error) {
+				if question == "fail" {
+					return "", errors.New("no answer")
+				}
+				return strings.ToUpper(question), nil
+			})).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(client.Connect(ctx)).To(BeNil())
+			var outcome string
+			Expect(client.Invoke(ctx, &outcome, "ask", "why")).To(BeNil())
+			Expect(outcome).To(Equal("answered"))
+			var completion CompletionMessage
+			Eventually(recorder.completions).Should(Receive(&completion))
+			Expect(completion.Result).To(Equal("WHY"))
+			Expect(completion.Error).To(BeEmpty())
+			Expect(client.Invoke(ctx, &outcome, "ask", "fail")).To(BeNil())
+			Expect(outcome).To(Equal("no answer"))
+			Eventually(recorder.completions).Should(Receive(&completion))
+			Expect(completion.Error).To(Equal("no answer"))
+		})
+	})
+	Context("When the client has a reconnect policy and the connection is lost", func() {
+		It("should reconnect and invoke hub methods again", func() {
+			client, err := NewClient(startClientTestServer(), ClientReconnect(ClientReconnectPolicy{
+				InitialDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond, Jitter: 0.5}))
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(client.Connect(ctx)).To(BeNil())
+			defer func() { _ = client.Close() }()
+			Expect(client.Send("drop")).To(BeNil())
+			var sum int
+			Eventually(func() error { return client.Invoke(ctx, &sum, "add2", 1) }, 2*time.Second, 20*time.Millisecond).Should(BeNil())
+			Expect(sum).To(Equal(3))
+			Expect(client.Done()).NotTo(BeClosed())
+		})
+		It("should open the circuit after repeated failures and give up after the MaxRetryDuration", func() {
+			var unavailable int32
+			states := make(chan CircuitState, 100)
+			client, err := NewClient(startUnavailableClientTestServer(&unavailable), ClientReconnect(ClientReconnectPolicy{
+				InitialDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond, MaxRetryDuration: 2 * time.Second,
+				FailureThreshold: 2, OpenDuration: 50 * time.Millisecond}),
+				ClientCircuitStateChanged(func(state CircuitState) { states <- state }))
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(client.Connect(ctx)).To(BeNil())
+			atomic.StoreInt32(&unavailable, 1)
+			Expect(client.Send("drop")).To(BeNil())
+			Eventually(states, 2*time.Second).Should(Receive(Equal(CircuitOpen)))
+			Eventually(states, 2*time.Second).Should(Receive(Equal(CircuitHalfOpen)))
+			Eventually(states, 2*time.Second).Should(Receive(Equal(CircuitOpen)))
+			Eventually(client.Done(), 4*time.Second).Should(BeClosed())
+			Expect(client.Err()).NotTo(BeNil())
+			Expect(client.Send("add2", 1)).NotTo(BeNil())
+		})
+	})
+	Context("When the client has an offline queue and reconnects", func() {
+		var unavailable int32
+		var client *Client
+		var expired chan string
+		var recorder *invocationRecorder
+		// goOffline drops the connection and waits until the client reconnects
+		goOffline := func(policy ClientOfflineQueuePolicy) {
+			atomic.StoreInt32(&unavailable, 0)
+			expired = make(chan string, 10)
+			policy.Expired = func(target string, args []interface{}) { expired <- target }
+			recorder = &invocationRecorder{invocations: make(chan string, 10)}
+			var err error
+			client, err = NewClient(startUnavailableClientTestServer(&unavailable, MessageInterceptors(recorder)), ClientReconnect(ClientReconnectPolicy{
+				InitialDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond}), ClientOfflineQueue(policy))
+			Expect(err).To(BeNil())
+			Expect(client.Connect(context.TODO())).To(BeNil())
+			atomic.StoreInt32(&unavailable, 1)
+			Expect(client.Send("drop")).To(BeNil())
+			Eventually(func() error {
+				_, err := client.connection()
+				return err
+			}).Should(MatchError("client reconnecting"))
+		}
+		AfterEach(func() {
+			_ = client.Close()
+		})
+		It("should send the queued calls in order after reconnecting", func() {
+			goOffline(ClientOfflineQueuePolicy{MaxSize: 10, TTL: 5 * time.Second})
+			Expect(client.Send("echo", "first")).To(BeNil())
+			Expect(client.Send("echo", "second")).To(BeNil())
+			sum := make(chan int, 1)
+			go func() {
+				defer GinkgoRecover()
+				var result int
+				Expect(client.Invoke(context.TODO(), &result, "add2", 1)).To(BeNil())
+				sum <- result
+			}()
+			Consistently(sum, 100*time.Millisecond).ShouldNot(Receive())
+			atomic.StoreInt32(&unavailable, 0)
+			Eventually(sum, 2*time.Second).Should(Receive(Equal(3)))
+			for _, invocation := range []string{"drop", `echo "first"`, `echo "second"`, "add2 1"} {
+				Expect(recorder.invocations).To(Receive(Equal(invocation)))
+			}
+		})
+		It("should fail calls which expired in the queue", func() {
+			goOffline(ClientOfflineQueuePolicy{MaxSize: 10, TTL: 50 * time.Millisecond})
+			Expect(client.Send("echo", "late")).To(BeNil())
+			Expect(client.Invoke(context.TODO(), nil, "add2", 1)).To(Equal(ErrOfflineCallExpired))
+			atomic.StoreInt32(&unavailable, 0)
+			Eventually(expired, 2*time.Second).Should(Receive(Equal("echo")))
+		})
+		It("should reject calls when the queue is full", func() {
+			goOffline(ClientOfflineQueuePolicy{MaxSize: 1, TTL: 5 * time.Second})
+			Expect(client.Send("echo", "queued")).To(BeNil())
+			Expect(client.Send("echo", "rejected")).NotTo(BeNil())
+			Expect(client.Invoke(context.TODO(), nil, "add2", 1)).NotTo(BeNil())
+		})
+	})
+	Context("When the client has filters", func() {
+		It("should pass Send, Invoke and the invocations of client methods through them", func() {
+			var calls []string
+			var mx sync.Mutex
+			record := func(call string) {
+				mx.Lock()
+				defer mx.Unlock()
+				calls = append(calls, call)
+			}
+			client, err := NewClient(startClientTestServer(),
+				ClientFilters(func(invocation *ClientInvocation, next ClientInvoker) error {
+					record(fmt.Sprintf("outer %v %v", invocation.Target, invocation.Send))
+					return next(invocation)
+				}, func(invocation *ClientInvocation, next ClientInvoker) error {
+					record("inner " + invocation.Target)
+					if invocation.Target == "add2" {
+						invocation.Args = []interface{}{40}
+					}
+					return next(invocation)
+				}),
+				ClientHandlerFilters(func(invocation *ClientHandlerInvocation, next ClientHandlerInvoker) ([]interface{}, error) {
+					record("handler " + invocation.Target)
+					invocation.Args = []interface{}{"filtered", 8}
+					return next(invocation)
+				}))
+			Expect(err).To(BeNil())
+			Expect(client.Connect(context.TODO())).To(BeNil())
+			defer func() { _ = client.Close() }()
+			received := make(chan string, 1)
+			Expect(client.On("echo", func(message string, length int) {
+				received <- fmt.Sprintf("%v %v", message, length)
+			})).To(BeNil())
+			var sum int
+			Expect(client.Invoke(context.TODO(), &sum, "add2", 1)).To(BeNil())
+			Expect(sum).To(Equal(42))
+			Expect(client.Send("echo", "hello")).To(BeNil())
+			Eventually(received).Should(Receive(Equal("filtered 8")))
+			mx.Lock()
+			defer mx.Unlock()
+			Expect(calls).To(Equal([]string{"outer add2 false", "inner add2", "outer echo true", "inner echo", "handler echo"}))
+		})
+		It("should reject nil filters", func() {
+			_, err := NewClient("http://127.0.0.1:1/hub", ClientFilters(nil))
+			Expect(err).NotTo(BeNil())
+			_, err = NewClient("http://127.0.0.1:1/hub", ClientHandlerFilters(nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("When the client has metrics hooks", func() {
+		It("should count reconnects, invocations, handler errors and traffic", func() {
+			reconnected := make(chan int, 1)
+			invocations := make(chan string, 2)
+			handlerErrors := make(chan string, 1)
+			client, err := NewClient(startClientTestServer(),
+				ClientReconnect(ClientReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond}),
+				ClientMetricsHooks(ClientHooks{
+					Reconnected: func(attempts int, downtime time.Duration) { reconnected <- attempts },
+					InvocationCompleted: func(target string, latency time.Duration, err error) {
+						invocations <- fmt.Sprintf("%v %v", target, err != nil)
+					},
+					HandlerFailed: func(target string, err error) { handlerErrors <- target },
+				}))
+			Expect(err).To(BeNil())
+			Expect(client.Connect(context.TODO())).To(BeNil())
+			defer func() { _ = client.Close() }()
+			Expect(client.Invoke(context.TODO(), nil, "add2", 1)).To(BeNil())
+			Expect(client.Invoke(context.TODO(), nil, "fail")).NotTo(BeNil())
+			Expect(invocations).To(Receive(Equal("add2 false")))
+			Expect(invocations).To(Receive(Equal("fail true")))
+			Expect(client.Send("echo", "no handler")).To(BeNil())
+			Eventually(handlerErrors).Should(Receive(Equal("echo")))
+			Expect(client.Send("drop")).To(BeNil())
+			Eventually(reconnected, 2*time.Second).Should(Receive(Equal(1)))
+			metrics := client.Metrics()
+			Expect(metrics.Reconnects).To(Equal(int64(1)))
+			Expect(metrics.Invocations).To(Equal(int64(2)))
+			Expect(metrics.FailedInvocations).To(Equal(int64(1)))
+			Expect(metrics.InvocationTime).To(BeNumerically(">", 0))
+			Expect(metrics.HandlerErrors).To(Equal(int64(1)))
+			Expect(metrics.BytesSent).To(BeNumerically(">", 0))
+			Expect(metrics.BytesReceived).To(BeNumerically(">", 0))
+		})
+	})
+	Context("When reconnect delays are computed", func() {
+		It("should double the delay up to MaxDelay and vary it by the jitter", func() {
+			policy := ClientReconnectPolicy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second, Jitter: 0.2}
+			Expect(policy.delay(0, 0.5)).To(Equal(100 * time.Millisecond))
+			Expect(policy.delay(2, 0.5)).To(Equal(400 * time.Millisecond))
+			Expect(policy.delay(10, 0.5)).To(Equal(time.Second))
+			Expect(policy.delay(100, 0.5)).To(Equal(time.Second))
+			Expect(policy.delay(0, 0)).To(Equal(80 * time.Millisecond))
+			Expect(policy.delay(0, 1)).To(Equal(120 * time.Millisecond))
+		})
+		It("should reject invalid policies", func() {
+			_, err := NewClient("http://127.0.0.1:1/hub", ClientReconnect(ClientReconnectPolicy{Jitter: 2}))
+			Expect(err).NotTo(BeNil())
+			_, err = NewClient("http://127.0.0.1:1/hub", ClientReconnect(ClientReconnectPolicy{InitialDelay: time.Second}))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("When the context of an invocation is canceled", func() {
+		It("should send a CancelInvocation to the server", func() {
+			recorder := &cancelRecorder{canceled: make(chan string, 1)}
+			client, err := NewClient(startClientTestServer(MessageInterceptors(recorder)))
+			Expect(err).To(BeNil())
+			Expect(client.Connect(context.TODO())).To(BeNil())
+			defer func() { _ = client.Close() }()
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			err = client.Invoke(ctx, nil, "sleep")
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			canceled, ok := err.(*InvocationCanceledError)
+			Expect(ok).To(BeTrue())
+			Expect(canceled.Delivered).To(BeTrue())
+			Eventually(recorder.canceled).Should(Receive(Equal(canceled.InvocationID)))
+		})
+	})
+	Context("When the websocket upgrade hangs", func() {
+		It("should stop connecting when the context is canceled", func() {
+			release := make(chan struct{})
+			defer close(release)
+			client, err := NewClient(startHangingClientTestServer(release))
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithCancel(context.Background())
+			connected := make(chan error, 1)
+			go func() { connected <- client.Connect(ctx) }()
+			Consistently(connected, 100*time.Millisecond).ShouldNot(Receive())
+			cancel()
+			Eventually(connected, time.Second).Should(Receive(Equal(context.Canceled)))
+		})
+	})
+	Context("When the client has a logger", func() {
+		for _, debug := range []bool{false, true} {
+			debug := debug
+			It(fmt.Sprintf("should log the messages read at debug level only if debug is %v", debug), func() {
+				var mx sync.Mutex
+				var reads []string
+				logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+					line := fmt.Sprint(keyvals...)
+					if strings.Contains(line, "read") {
+						mx.Lock()
+						reads = append(reads, line)
+						mx.Unlock()
+					}
+					return nil
+				})
+				client, err := NewClient(startClientTestServer(), ClientLogger(logger, debug))
+				Expect(err).To(BeNil())
+				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+				defer cancel()
+				Expect(client.Connect(ctx)).To(BeNil())
+				defer func() { _ = client.Close() }()
+				var sum int
+				Expect(client.Invoke(ctx, &sum, "add2", 1)).To(BeNil())
+				mx.Lock()
+				defer mx.Unlock()
+				if !debug {
+					Expect(reads).To(BeEmpty())
+					return
+				}
+				Expect(reads).NotTo(BeEmpty())
+				for _, line := range reads {
+					Expect(line).To(ContainSubstring("leveldebug"))
+				}
+			})
+		}
+	})
+	Context("When the client receives streams", func() {
+		newClient := func(options ...func(*Client) error) *Client {
+			client, err := NewClient(startClientTestServer(), append(options, ClientReconnect(ClientReconnectPolicy{
+				InitialDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}))...)
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(client.Connect(ctx)).To(BeNil())
+			return client
+		}
+		receive := func(stream *ClientStream, count int) []int {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			var numbers []int
+			for i := 0; i < count; i++ {
+				var number int
+				Expect(stream.Receive(ctx, &number)).To(BeNil())
+				numbers = append(numbers, number)
+			}
+			return numbers
+		}
+		It("should receive the items until the stream ends", func() {
+			client := newClient()
+			defer func() { _ = client.Close() }()
+			stream, err := client.Stream(context.TODO(), "count", 1, 5)
+			Expect(err).To(BeNil())
+			Expect(receive(stream, 5)).To(Equal([]int{1, 2, 3, 4, 5}))
+			var number int
+			Expect(stream.Receive(context.TODO(), &number)).To(Equal(io.EOF))
+		})
+		It("should end the stream when it is closed", func() {
+			client := newClient()
+			defer func() { _ = client.Close() }()
+			stream, err := client.Stream(context.TODO(), "ticks", 1)
+			Expect(err).To(BeNil())
+			Expect(receive(stream, 2)).To(Equal([]int{1, 2}))
+			Expect(stream.Close()).To(BeNil())
+			Eventually(stream.Done()).Should(BeClosed())
+			Expect(stream.Err()).To(BeNil())
+		})
+		It("should end the stream with an error when the connection is lost", func() {
+			client := newClient()
+			defer func() { _ = client.Close() }()
+			stream, err := client.Stream(context.TODO(), "ticks", 1)
+			Expect(err).To(BeNil())
+			Expect(receive(stream, 2)).To(Equal([]int{1, 2}))
+			Expect(client.Send("drop")).To(BeNil())
+			Eventually(stream.Done()).Should(BeClosed())
+			Expect(stream.Err()).NotTo(BeNil())
+		})
+		It("should invoke the stream again with the adjusted arguments after a reconnect with ClientStreamResubscription", func() {
+			var last int32
+			resubscribed := make(chan []interface{}, 1)
+			client := newClient(ClientStreamResubscription(func(target string, args []interface{}) []interface{} {
+				resubscribed <- args
+				return []interface{}{int(atomic.LoadInt32(&last)) + 1}
+			}))
+			defer func() { _ = client.Close() }()
+			stream, err := client.Stream(context.TODO(), "ticks", 1)
+			Expect(err).To(BeNil())
+			for _, number := range receive(stream, 3) {
+				atomic.StoreInt32(&last, int32(number))
+			}
+			Expect(client.Send("drop")).To(BeNil())
+			Eventually(resubscribed, 2*time.Second).Should(Receive(Equal([]interface{}{1})))
+			// Items sent before the connection was lost might still arrive
+			numbers := receive(stream, 10)
+			for i := 1; i < len(numbers); i++ {
+				Expect(numbers[i]).To(BeNumerically("<=", numbers[i-1]+1))
+			}
+			Expect(numbers[len(numbers)-1]).To(BeNumerically(">", 4))
+			Expect(stream.Done()).NotTo(BeClosed())
+		})
+	})
+	Context("When the client uploads streams", func() {
+		var client *Client
+		BeforeEach(func() {
+			var err error
+			client, err = NewClient(startClientTestServer())
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			Expect(client.Connect(ctx)).To(BeNil())
+		})
+		AfterEach(func() {
+			_ = client.Close()
+		})
+		It("should pass their items to the channel parameters of hub methods", func() {
+			numbers, err := NewUploadStream(2)
+			Expect(err).To(BeNil())
+			go func() {
+				for i := 1; i <= 10; i++ {
+					Expect(numbers.Write(context.TODO(), i)).To(BeNil())
+				}
+				_ = numbers.Close()
+			}()
+			sums := make(chan int, 1)
+			Expect(client.On("sum", func(sum int) {
+				sums <- sum
+			})).To(BeNil())
+			Expect(client.Send("sum", numbers, 100)).To(BeNil())
+			Eventually(sums).Should(Receive(Equal(155)))
+			Eventually(numbers.Done()).Should(BeClosed())
+			Expect(numbers.Err()).To(BeNil())
+			Expect(numbers.Write(context.TODO(), 1)).To(Equal(ErrUploadStreamClosed))
+			Expect(client.Send("sum", numbers, 100)).NotTo(BeNil())
+		})
+		It("should send the items written before Close with Send", func() {
+			collected := make(chan string, 1)
+			Expect(client.On("collected", func(words string) {
+				collected <- words
+			})).To(BeNil())
+			words, err := NewUploadStream(3)
+			Expect(err).To(BeNil())
+			Expect(words.TryWrite("one")).To(BeNil())
+			Expect(words.TryWrite("two")).To(BeNil())
+			Expect(words.TryWrite("three")).To(BeNil())
+			Expect(client.Send("collect", words)).To(BeNil())
+			Expect(words.Close()).To(BeNil())
+			Eventually(collected).Should(Receive(Equal("one two three")))
+		})
+	})
+	Context("When the transport can not keep up with an upload stream", func() {
+		It("should block Write and fail TryWrite while the buffer is full", func() {
+			conn := &stalledUploadConnection{items: make(chan interface{}), completions: make(chan string, 1)}
+			stream, err := NewUploadStream(2)
+			Expect(err).To(BeNil())
+			go stream.upload(conn, "1", make(chan struct{}))
+			// One item is taken from the buffer and stalls in the transport, two fill the buffer
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			Expect(stream.Write(ctx, 1)).To(BeNil())
+			Expect(stream.Write(ctx, 2)).To(BeNil())
+			Expect(stream.Write(ctx, 3)).To(BeNil())
+			Expect(stream.TryWrite(4)).To(Equal(ErrUploadBufferFull))
+			ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			Expect(stream.Write(ctx, 4)).To(Equal(context.DeadlineExceeded))
+			written := make(chan error, 1)
+			go func() {
+				written <- stream.Write(context.TODO(), 4)
+			}()
+			Consistently(written, 100*time.Millisecond).ShouldNot(Receive())
+			Expect(<-conn.items).To(Equal(1))
+			Eventually(written).Should(Receive(BeNil()))
+			Expect(stream.Close()).To(BeNil())
+			Expect(<-conn.items).To(Equal(2))
+			Expect(<-conn.items).To(Equal(3))
+			Expect(<-conn.items).To(Equal(4))
+			Eventually(conn.completions).Should(Receive(Equal("1")))
+			Eventually(stream.Done()).Should(BeClosed())
+		})
+		It("should reject invalid buffer sizes", func() {
+			_, err := NewUploadStream(0)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("When the client is not connected", func() {
+		It("should return errors", func() {
+			client, err := NewClient("http://127.0.0.1:1/hub")
+			Expect(err).To(BeNil())
+			Expect(client.Send("add2", 1)).NotTo(BeNil())
+			Expect(client.On("echo", "no func")).NotTo(BeNil())
+			Expect(client.Connect(context.TODO())).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,96 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+)
+
+// ClientInvocation describes a Send or Invoke of a Client for a ClientFilter.
+// Send is true for Client.Send, which does not wait for a result. Result is the pointer passed to Invoke.
+// A filter may replace Context, Args and Result before it calls next, e.g. to set a deadline or to stamp
+// an access token into the arguments.
+type ClientInvocation struct {
+	Context context.Context
+	Target  string
+	Args    []interface{}
+	Result  interface{}
+	Send    bool
+}
+
+// ClientInvoker continues a Send or Invoke with the next ClientFilter or sends it to the server
+type ClientInvoker func(invocation *ClientInvocation) error
+
+// ClientFilter is called around every Send and Invoke of a Client, the client side counterpart of a HubFilter.
+// It calls next to continue the call and returns its error, possibly changed. It can retry the call by calling next
+// again or short-circuit it by returning without calling next.
+type ClientFilter func(invocation *ClientInvocation, next ClientInvoker) error
+
+// ClientHandlerInvocation describes the invocation of a client method by the server for a ClientHandlerFilter.
+// InvocationID is empty if the server does not wait for a result. Args are the arguments as decoded by the hub protocol,
+// before they are converted to the parameter types of the handler.
+type ClientHandlerInvocation struct {
+	InvocationID string
+	Target       string
+	Args         []interface{}
+}
+
+// ClientHandlerInvoker continues the invocation of a client method with the next ClientHandlerFilter or the handler
+// registered with On. It returns the return values of the handler
+type ClientHandlerInvoker func(invocation *ClientHandlerInvocation) ([]interface{}, error)
+
+// ClientHandlerFilter is called around every invocation of a client method by the server. It calls next to continue
+// the invocation and returns its results, possibly changed. A returned error is sent to the server as completion error
+// if the server waits for a result. Invocations of client methods without handler pass the filters as well.
+type ClientHandlerFilter func(invocation *ClientHandlerInvocation, next ClientHandlerInvoker) ([]interface{}, error)
+
+// ClientFilters adds ClientFilters which are called around every Send and Invoke of the client, e.g. for logging,
+// retries, stamping access tokens or metrics. The first filter added is the outermost.
+func ClientFilters(filters ...ClientFilter) func(*Client) error {
+	return func(c *Client) error {
+		for _, filter := range filters {
+			if filter == nil {
+				return errors.New("ClientFilter must not be nil")
+			}
+		}
+		c.filters = append(c.filters, filters...)
+		return nil
+	}
+}
+
+// ClientHandlerFilters adds ClientHandlerFilters which are called around every invocation of a client method by
+// the server. The first filter added is the outermost.
+func ClientHandlerFilters(filters ...ClientHandlerFilter) func(*Client) error {
+	return func(c *Client) error {
+		for _, filter := range filters {
+			if filter == nil {
+				return errors.New("ClientHandlerFilter must not be nil")
+			}
+		}
+		c.handlerFilters = append(c.handlerFilters, filters...)
+		return nil
+	}
+}
+
+// filterCall runs invocation through the ClientFilters of the client, the innermost next is send
+func (c *Client) filterCall(invocation *ClientInvocation, send ClientInvoker) error {
+	next := send
+	for i := len(c.filters) - 1; i >= 0; i-- {
+		filter, inner := c.filters[i], next
+		next = func(invocation *ClientInvocation) error {
+			return filter(invocation, inner)
+		}
+	}
+	return next(invocation)
+}
+
+// filterHandler runs invocation through the ClientHandlerFilters of the client, the innermost next is call
+func (c *Client) filterHandler(invocation *ClientHandlerInvocation, call ClientHandlerInvoker) ([]interface{}, error) {
+	next := call
+	for i := len(c.handlerFilters) - 1; i >= 0; i-- {
+		filter, inner := c.handlerFilters[i], next
+		next = func(invocation *ClientHandlerInvocation) ([]interface{}, error) {
+			return filter(invocation, inner)
+		}
+	}
+	return next(invocation)
+}
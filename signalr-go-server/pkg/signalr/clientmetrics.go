@@ -0,0 +1,102 @@
+package signalr
+
+import "time"
+
+// ClientMetrics holds the counters of a Client since it was created, e.g. to report the connection health
+// of a fleet of clients to a central monitoring.
+// Reconnects and FailedReconnects count the successful and failed reconnect attempts.
+// Invocations and FailedInvocations count the calls of Invoke, InvocationTime is their total latency,
+// so InvocationTime / Invocations is the mean latency. HandlerErrors counts the handlers of client methods
+// which returned an error or panicked and the invocations of client methods without handler.
+// BytesSent and BytesReceived count the traffic of all connections of the client.
+type ClientMetrics struct {
+	Reconnects        int64
+	FailedReconnects  int64
+	Invocations       int64
+	FailedInvocations int64
+	InvocationTime    time.Duration
+	HandlerErrors     int64
+	BytesSent         int64
+	BytesReceived     int64
+}
+
+// ClientHooks are called on the events counted by ClientMetrics. They are called synchronously and should return
+// quickly. Hooks which are not set are not called.
+// Reconnected gets the number of attempts and the time since the connection was lost,
+// ReconnectFailed the number of the attempt and its error.
+type ClientHooks struct {
+	Reconnected         func(attempts int, downtime time.Duration)
+	ReconnectFailed     func(attempt int, err error)
+	InvocationCompleted func(target string, latency time.Duration, err error)
+	HandlerFailed       func(target string, err error)
+}
+
+// ClientMetricsHooks sets hooks which are called on the events counted by ClientMetrics
+func ClientMetricsHooks(hooks ClientHooks) func(*Client) error {
+	return func(c *Client) error {
+		c.hooks = hooks
+		return nil
+	}
+}
+
+// Metrics returns the counters of the client
+func (c *Client) Metrics() ClientMetrics {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	metrics := c.metrics
+	if c.conn != nil {
+		stats := c.conn.Stats()
+		metrics.BytesSent += stats.BytesSent
+		metrics.BytesReceived += stats.BytesReceived
+	}
+	return metrics
+}
+
+// countTraffic adds the traffic of conn, which is replaced by a new connection, to the metrics. c.mx must be locked
+func (c *Client) countTraffic(conn hubConnection) {
+	if conn != nil {
+		stats := conn.Stats()
+		c.metrics.BytesSent += stats.BytesSent
+		c.metrics.BytesReceived += stats.BytesReceived
+	}
+}
+
+func (c *Client) reconnected(attempts int, downtime time.Duration) {
+	c.mx.Lock()
+	c.metrics.Reconnects++
+	c.mx.Unlock()
+	if c.hooks.Reconnected != nil {
+		c.hooks.Reconnected(attempts, downtime)
+	}
+}
+
+func (c *Client) reconnectFailed(attempt int, err error) {
+	c.mx.Lock()
+	c.metrics.FailedReconnects++
+	c.mx.Unlock()
+	if c.hooks.ReconnectFailed != nil {
+		c.hooks.ReconnectFailed(attempt, err)
+	}
+}
+
+func (c *Client) invocationCompleted(target string, latency time.Duration, err error) {
+	c.mx.Lock()
+	c.metrics.Invocations++
+	c.metrics.InvocationTime += latency
+	if err != nil {
+		c.metrics.FailedInvocations++
+	}
+	c.mx.Unlock()
+	if c.hooks.InvocationCompleted != nil {
+		c.hooks.InvocationCompleted(target, latency, err)
+	}
+}
+
+func (c *Client) handlerFailed(target string, err error) {
+	c.mx.Lock()
+	c.metrics.HandlerErrors++
+	c.mx.Unlock()
+	if c.hooks.HandlerFailed != nil {
+		c.hooks.HandlerFailed(target, err)
+	}
+}
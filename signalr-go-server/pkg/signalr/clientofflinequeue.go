@@ -0,0 +1,131 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ClientOfflineQueuePolicy configures the offline queue of a Client.
+// MaxSize is the number of Send and Invoke calls which are queued at most, further calls fail at once.
+// TTL is the time a call waits in the queue. Invoke returns ErrOfflineCallExpired when its call has expired,
+// Sends which expired are passed to Expired, if it is set.
+type ClientOfflineQueuePolicy struct {
+	MaxSize int
+	TTL     time.Duration
+	Expired func(target string, args []interface{})
+}
+
+// ErrOfflineCallExpired is returned by Client.Invoke when the call expired in the offline queue before the client
+// reconnected
+var ErrOfflineCallExpired = errors.New("call expired in the offline queue")
+
+// ClientOfflineQueue queues the Send and Invoke calls which are issued while the client reconnects, see ClientReconnect.
+// The queued calls are sent in order after the client has reconnected. Calls issued while the queue is sent
+// are queued behind them.
+func ClientOfflineQueue(policy ClientOfflineQueuePolicy) func(*Client) error {
+	return func(c *Client) error {
+		if policy.MaxSize <= 0 || policy.TTL <= 0 {
+			return errors.New("ClientOfflineQueue needs a positive MaxSize and TTL")
+		}
+		c.offline = &clientOfflineQueue{policy: policy}
+		return nil
+	}
+}
+
+type clientOfflineQueue struct {
+	policy   ClientOfflineQueuePolicy
+	calls    []*offlineCall
+	flushing bool
+}
+
+// offlineCall is a queued Send or Invoke. Invocations have an id and get the error of the call in failed
+type offlineCall struct {
+	id      string
+	target  string
+	args    []interface{}
+	expires time.Time
+	failed  chan error
+}
+
+// enqueueOffline queues the call if the client has an offline queue and reconnects or sends its queue.
+// It returns nil if the call is not queued. c.mx must be locked
+func (c *Client) enqueueOffline(id string, target string, args []interface{}) (*offlineCall, error) {
+	q := c.offline
+	if q == nil || !(c.reconnecting || q.flushing) {
+		return nil, nil
+	}
+	select {
+	case <-c.done:
+		return nil, nil
+	default:
+	}
+	if len(q.calls) >= q.policy.MaxSize {
+		return nil, errors.New("offline queue full")
+	}
+	call := &offlineCall{id: id, target: target, args: args, expires: time.Now().Add(q.policy.TTL), failed: make(chan error, 1)}
+	q.calls = append(q.calls, call)
+	return call, nil
+}
+
+// removeOffline removes call from the queue. It returns false if the call is not queued anymore
+func (c *Client) removeOffline(call *offlineCall) bool {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	if i := c.offlineIndex(call); i >= 0 {
+		c.offline.calls = append(c.offline.calls[:i], c.offline.calls[i+1:]...)
+		return true
+	}
+	return false
+}
+
+// queuedOffline tells if call is still queued
+func (c *Client) queuedOffline(call *offlineCall) bool {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.offlineIndex(call) >= 0
+}
+
+// offlineIndex returns the index of call in the queue or -1. c.mx must be locked
+func (c *Client) offlineIndex(call *offlineCall) int {
+	for i, queued := range c.offline.calls {
+		if queued == call {
+			return i
+		}
+	}
+	return -1
+}
+
+// flushOffline sends the queued calls over conn, until the queue is empty or conn has been lost
+func (c *Client) flushOffline(conn hubConnection) {
+	q := c.offline
+	for {
+		c.mx.Lock()
+		if len(q.calls) == 0 || c.conn != conn || c.reconnecting {
+			if c.conn == conn {
+				q.flushing = false
+			}
+			c.mx.Unlock()
+			return
+		}
+		call := q.calls[0]
+		q.calls = q.calls[1:]
+		c.mx.Unlock()
+		if time.Now().After(call.expires) {
+			c.offlineCallExpired(call)
+			continue
+		}
+		if _, err := conn.SendInvocationWithID(context.Background(), call.id, call.target, call.args...); err != nil {
+			_ = c.info.Log(evt, "send offline call", "name", call.target, "error", err)
+			call.failed <- err
+		}
+	}
+}
+
+func (c *Client) offlineCallExpired(call *offlineCall) {
+	_ = c.info.Log(evt, "send offline call", "name", call.target, "error", ErrOfflineCallExpired)
+	call.failed <- ErrOfflineCallExpired
+	if call.id == "" && c.offline.policy.Expired != nil {
+		c.offline.policy.Expired(call.target, call.args)
+	}
+}
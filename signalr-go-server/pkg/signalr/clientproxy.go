@@ -1,8 +1,22 @@
 package signalr
 
+import (
+	"context"
+	"time"
+)
+
 // ClientProxy allows the hub to send messages to one or more of its clients
+// Send() sends the invocation immediately.
+// SendContext() sends the invocation immediately, but stops waiting for the clients' send queues when ctx is done.
+// It returns the error of ctx in this case.
+// SendAfter() sends the invocation after delay. The returned ScheduledSend can be used to cancel it.
+// SendDurable() stores the invocation in the Outbox of the server before sending it. The invocation is resent
+// until the client acknowledges it with a completion. Returns an error if the server has no Outbox or storing fails.
 type ClientProxy interface {
 	Send(target string, args ...interface{})
+	SendDurable(target string, args ...interface{}) error
+	SendContext(ctx context.Context, target string, args ...interface{}) error
+	SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend
 }
 
 type allClientProxy struct {
@@ -10,7 +24,40 @@ type allClientProxy struct {
 }
 
 func (a *allClientProxy) Send(target string, args ...interface{}) {
-	a.lifetimeManager.InvokeAll(target, args)
+	_ = a.lifetimeManager.InvokeAll(context.Background(), target, args)
+}
+
+func (a *allClientProxy) SendContext(ctx context.Context, target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeAll(ctx, target, args)
+}
+
+func (a *allClientProxy) SendDurable(target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeAllDurable(context.Background(), target, args)
+}
+
+func (a *allClientProxy) SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend {
+	return scheduleSend(delay, func() { a.Send(target, args...) })
+}
+
+type allExceptClientProxy struct {
+	excluded        string
+	lifetimeManager HubLifetimeManager
+}
+
+func (a *allExceptClientProxy) Send(target string, args ...interface{}) {
+	_ = a.lifetimeManager.InvokeAllExcept(context.Background(), a.excluded, target, args)
+}
+
+func (a *allExceptClientProxy) SendContext(ctx context.Context, target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeAllExcept(ctx, a.excluded, target, args)
+}
+
+func (a *allExceptClientProxy) SendDurable(target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeAllExceptDurable(context.Background(), a.excluded, target, args)
+}
+
+func (a *allExceptClientProxy) SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend {
+	return scheduleSend(delay, func() { a.Send(target, args...) })
 }
 
 type singleClientProxy struct {
@@ -19,7 +66,19 @@ type singleClientProxy struct {
 }
 
 func (a *singleClientProxy) Send(target string, args ...interface{}) {
-	a.lifetimeManager.InvokeClient(a.connectionID, target, args)
+	_ = a.lifetimeManager.InvokeClient(context.Background(), a.connectionID, target, args)
+}
+
+func (a *singleClientProxy) SendContext(ctx context.Context, target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeClient(ctx, a.connectionID, target, args)
+}
+
+func (a *singleClientProxy) SendDurable(target string, args ...interface{}) error {
+	return a.lifetimeManager.InvokeClientDurable(context.Background(), a.connectionID, target, args)
+}
+
+func (a *singleClientProxy) SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend {
+	return scheduleSend(delay, func() { a.Send(target, args...) })
 }
 
 type groupClientProxy struct {
@@ -28,5 +87,38 @@ type groupClientProxy struct {
 }
 
 func (g *groupClientProxy) Send(target string, args ...interface{}) {
-	g.lifetimeManager.InvokeGroup(g.groupName, target, args)
+	_ = g.lifetimeManager.InvokeGroup(context.Background(), g.groupName, target, args)
+}
+
+func (g *groupClientProxy) SendContext(ctx context.Context, target string, args ...interface{}) error {
+	return g.lifetimeManager.InvokeGroup(ctx, g.groupName, target, args)
+}
+
+func (g *groupClientProxy) SendDurable(target string, args ...interface{}) error {
+	return g.lifetimeManager.InvokeGroupDurable(context.Background(), g.groupName, target, args)
+}
+
+func (g *groupClientProxy) SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend {
+	return scheduleSend(delay, func() { g.Send(target, args...) })
+}
+
+type userClientProxy struct {
+	userID          string
+	lifetimeManager HubLifetimeManager
+}
+
+func (u *userClientProxy) Send(target string, args ...interface{}) {
+	_ = u.lifetimeManager.InvokeUser(context.Background(), u.userID, target, args)
+}
+
+func (u *userClientProxy) SendContext(ctx context.Context, target string, args ...interface{}) error {
+	return u.lifetimeManager.InvokeUser(ctx, u.userID, target, args)
+}
+
+func (u *userClientProxy) SendDurable(target string, args ...interface{}) error {
+	return u.lifetimeManager.InvokeUserDurable(context.Background(), u.userID, target, args)
+}
+
+func (u *userClientProxy) SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend {
+	return scheduleSend(delay, func() { u.Send(target, args...) })
 }
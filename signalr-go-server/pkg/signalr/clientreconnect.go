@@ -0,0 +1,202 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ClientReconnectPolicy configures how a Client reconnects after the connection to the server has been lost.
+// InitialDelay is the delay before the first attempt, it doubles with each failed attempt up to MaxDelay.
+// Jitter is the fraction, between 0 and 1, by which each delay is randomly varied, so clients which lost
+// their connections at the same time do not reconnect at the same time.
+// MaxRetryDuration is the time after which the client gives up and ends with an error. 0 retries forever.
+// FailureThreshold is the number of failed attempts in a row which open the circuit. While the circuit is open,
+// the client waits OpenDuration before it tries again with a single attempt in the half open state.
+// If that attempt fails, the circuit opens again. A FailureThreshold of 0 disables the circuit breaker.
+type ClientReconnectPolicy struct {
+	InitialDelay     time.Duration
+	MaxDelay         time.Duration
+	Jitter           float64
+	MaxRetryDuration time.Duration
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+// CircuitState is the state of the circuit breaker of a reconnecting Client
+type CircuitState int
+
+// The states of the circuit breaker
+const (
+	// CircuitClosed is the state while the client is connected or reconnects with backoff
+	CircuitClosed CircuitState = iota
+	// CircuitOpen is the state after FailureThreshold failed attempts, the client waits OpenDuration
+	CircuitOpen
+	// CircuitHalfOpen is the state of the single attempt after OpenDuration
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+// ClientReconnect lets the client reconnect with policy when the connection to the server has been lost
+// or the server closed it and allowed to reconnect. While the client reconnects, Send and Invoke fail
+// and pending invocations end with an error. Done is closed when the client gives up.
+// Handlers registered with On are kept for the new connection, but its connection id is a new one.
+func ClientReconnect(policy ClientReconnectPolicy) func(*Client) error {
+	return func(c *Client) error {
+		switch {
+		case policy.InitialDelay < 0 || policy.MaxDelay < policy.InitialDelay:
+			return errors.New("ClientReconnect needs 0 <= InitialDelay <= MaxDelay")
+		case policy.Jitter < 0 || policy.Jitter > 1:
+			return errors.New("ClientReconnect needs a Jitter between 0 and 1")
+		case policy.MaxRetryDuration < 0 || policy.FailureThreshold < 0 || policy.OpenDuration < 0:
+			return errors.New("ClientReconnect needs MaxRetryDuration, FailureThreshold and OpenDuration >= 0")
+		}
+		c.reconnectPolicy = &policy
+		return nil
+	}
+}
+
+// ClientCircuitStateChanged sets a handler which is called when the circuit breaker of the client changes its state,
+// e.g. to tell users that the server is unavailable while the circuit is open
+func ClientCircuitStateChanged(handler func(state CircuitState)) func(*Client) error {
+	return func(c *Client) error {
+		c.circuitStateChanged = handler
+		return nil
+	}
+}
+
+// CircuitState returns the state of the circuit breaker of the client
+func (c *Client) CircuitState() CircuitState {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.circuitState
+}
+
+func (c *Client) setCircuitState(state CircuitState) {
+	c.mx.Lock()
+	changed := c.circuitState != state
+	c.circuitState = state
+	handler := c.circuitStateChanged
+	c.mx.Unlock()
+	if changed && handler != nil {
+		handler(state)
+	}
+}
+
+// delay returns the jittered delay before attempt, counted from 0. random is between 0 and 1
+func (p *ClientReconnectPolicy) delay(attempt int, random float64) time.Duration {
+	d := p.MaxDelay
+	// Beyond 30 doublings, every sensible delay has reached MaxDelay
+	if attempt < 30 && p.InitialDelay<<uint(attempt) < p.MaxDelay {
+		d = p.InitialDelay << uint(attempt)
+	}
+	return p.jitter(d, random)
+}
+
+// jitter varies d randomly by the fraction Jitter
+func (p *ClientReconnectPolicy) jitter(d time.Duration, random float64) time.Duration {
+	return time.Duration(float64(d) * (1 - p.Jitter + 2*p.Jitter*random))
+}
+
+// connectionLost handles the end of conn with err. Without reconnect policy, the client ends with err.
+// Otherwise, pending invocations end with an error and the client starts reconnecting
+func (c *Client) connectionLost(conn hubConnection, err error) {
+	if c.reconnectPolicy == nil {
+		c.stop(err)
+		return
+	}
+	c.mx.Lock()
+	select {
+	case <-c.done:
+		c.mx.Unlock()
+		return
+	default:
+	}
+	if c.conn != conn || c.reconnecting {
+		c.mx.Unlock()
+		return
+	}
+	c.reconnecting = true
+	c.cancel()
+	closeClientTransport(conn)
+	for _, completions := range c.pending {
+		select {
+		case completions <- completionMessage{Error: fmt.Sprintf("connection lost: %v", err)}:
+		default:
+		}
+	}
+	c.streamsLost(err)
+	c.mx.Unlock()
+	_ = c.info.Log(evt, "connection lost", "error", err, react, "reconnect")
+	go c.reconnect(err)
+}
+
+// reconnect tries to connect again until it succeeds, the client is closed or MaxRetryDuration has passed
+func (c *Client) reconnect(cause error) {
+	policy := c.reconnectPolicy
+	start := time.Now()
+	failures := 0
+	for attempt := 0; ; attempt++ {
+		delay := policy.delay(attempt, c.random.Float64())
+		if c.CircuitState() == CircuitOpen {
+			delay = policy.jitter(policy.OpenDuration, c.random.Float64())
+		}
+		if policy.MaxRetryDuration > 0 && time.Since(start)+delay > policy.MaxRetryDuration {
+			_ = c.info.Log(evt, "reconnect", "error", cause, react, "give up")
+			c.stop(fmt.Errorf("reconnect gave up after %v attempts: %v", attempt, cause))
+			return
+		}
+		select {
+		case <-time.After(delay):
+		case <-c.done:
+			return
+		}
+		if c.CircuitState() == CircuitOpen {
+			c.setCircuitState(CircuitHalfOpen)
+		}
+		if cause = c.reconnectAttempt(start); cause == nil {
+			c.setCircuitState(CircuitClosed)
+			c.reconnected(attempt+1, time.Since(start))
+			return
+		}
+		_ = c.info.Log(evt, "reconnect", "error", cause, "attempt", attempt+1)
+		c.reconnectFailed(attempt+1, cause)
+		failures++
+		if policy.FailureThreshold > 0 && (failures >= policy.FailureThreshold || c.CircuitState() == CircuitHalfOpen) {
+			c.setCircuitState(CircuitOpen)
+		}
+	}
+}
+
+// reconnectAttempt connects once. The attempt is canceled when the client is closed or MaxRetryDuration has passed
+func (c *Client) reconnectAttempt(start time.Time) error {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.reconnectPolicy.MaxRetryDuration > 0 {
+		ctx, cancel = context.WithDeadline(context.Background(), start.Add(c.reconnectPolicy.MaxRetryDuration))
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return c.Connect(ctx)
+}
@@ -0,0 +1,217 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// ClientStream is a stream of items a hub method sends to the Client, see Client.Stream.
+// The client reads no further messages while the items are not received, so they should be received promptly.
+type ClientStream struct {
+	client *Client
+	target string
+	args   []interface{}
+	items  chan interface{}
+	done   chan struct{}
+	mx     sync.Mutex
+	id     string
+	err    error
+}
+
+// ClientStreamResubscription lets the client invoke the hub methods of its open streams again when it has
+// reconnected, see ClientReconnect, so the streams go on over the new connection. Before a stream is invoked again,
+// adjust is called with the target and the arguments of its last invocation. It returns the arguments for the new
+// invocation, e.g. to resume after the last received item. If adjust is nil, the arguments are not changed.
+// Without ClientStreamResubscription, streams end with an error when the connection is lost.
+func ClientStreamResubscription(adjust func(target string, args []interface{}) []interface{}) func(*Client) error {
+	return func(c *Client) error {
+		if adjust == nil {
+			adjust = func(target string, args []interface{}) []interface{} { return args }
+		}
+		c.resubscribe = adjust
+		return nil
+	}
+}
+
+// Stream invokes the stream hub method target, which returns a channel, and returns the stream of its items.
+// Stream fails while the client is not connected.
+func (c *Client) Stream(ctx context.Context, target string, args ...interface{}) (*ClientStream, error) {
+	conn, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	s := &ClientStream{
+		client: c,
+		target: target,
+		args:   args,
+		items:  make(chan interface{}, 16),
+		done:   make(chan struct{}),
+	}
+	if _, err = conn.SendStreamInvocation(ctx, c.subscribe(s), target, args...); err != nil {
+		c.unsubscribe(s)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Receive waits for the next item of the stream and converts it into item, which must be a pointer.
+// It returns io.EOF when the stream has ended and all its items are received, or the error the stream ended with.
+func (s *ClientStream) Receive(ctx context.Context, item interface{}) error {
+	var raw interface{}
+	select {
+	case raw = <-s.items:
+	default:
+		select {
+		case raw = <-s.items:
+		case <-s.done:
+			// Items might have arrived before the end
+			select {
+			case raw = <-s.items:
+			default:
+				if err := s.Err(); err != nil {
+					return err
+				}
+				return io.EOF
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.client.mx.Lock()
+	protocol := s.client.protocol
+	s.client.mx.Unlock()
+	return protocol.UnmarshalArgument(raw, item)
+}
+
+// Close ends the stream and tells the hub method to stop it
+func (s *ClientStream) Close() error {
+	id := s.client.unsubscribe(s)
+	s.end(nil)
+	if conn, err := s.client.connection(); err == nil && id != "" {
+		_, err = conn.CancelInvocation(id)
+		return err
+	}
+	return nil
+}
+
+// Done returns a channel which is closed when the stream has ended
+func (s *ClientStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error the stream ended with. It is nil while the stream is open and after it ended regularly
+func (s *ClientStream) Err() error {
+	defer s.mx.Unlock()
+	s.mx.Lock()
+	return s.err
+}
+
+// end ends the stream with err, once
+func (s *ClientStream) end(err error) {
+	defer s.mx.Unlock()
+	s.mx.Lock()
+	select {
+	case <-s.done:
+	default:
+		s.err = err
+		close(s.done)
+	}
+}
+
+// subscribe registers s under a new invocation id and returns the id
+func (c *Client) subscribe(s *ClientStream) string {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.lastID++
+	id := strconv.FormatUint(c.lastID, 10)
+	if c.streams == nil {
+		c.streams = make(map[string]*ClientStream)
+	}
+	c.streams[id] = s
+	s.mx.Lock()
+	s.id = id
+	s.mx.Unlock()
+	return id
+}
+
+// unsubscribe removes s and returns the id it was registered under
+func (c *Client) unsubscribe(s *ClientStream) string {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	s.mx.Lock()
+	id := s.id
+	s.mx.Unlock()
+	if c.streams[id] == s {
+		delete(c.streams, id)
+		return id
+	}
+	return ""
+}
+
+// receiveStreamItem passes the item to its stream. It waits while the buffer of the stream is full
+func (c *Client) receiveStreamItem(item streamItemMessage) {
+	c.mx.Lock()
+	s, ok := c.streams[item.InvocationID]
+	c.mx.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case s.items <- item.Item:
+	case <-s.done:
+	case <-c.done:
+	}
+}
+
+// completeStream ends the stream of the completion. It returns false if the completion is not for a stream
+func (c *Client) completeStream(completion completionMessage) bool {
+	c.mx.Lock()
+	s, ok := c.streams[completion.InvocationID]
+	if ok {
+		delete(c.streams, completion.InvocationID)
+	}
+	c.mx.Unlock()
+	if !ok {
+		return false
+	}
+	if completion.Error != "" {
+		s.end(errors.New(completion.Error))
+	} else {
+		s.end(nil)
+	}
+	return true
+}
+
+// streamsLost ends the streams with err when the connection has been lost, unless they are invoked again after
+// the reconnect. c.mx must be locked
+func (c *Client) streamsLost(err error) {
+	if c.resubscribe != nil {
+		return
+	}
+	for id, s := range c.streams {
+		s.end(fmt.Errorf("connection lost: %v", err))
+		delete(c.streams, id)
+	}
+}
+
+// resubscribeStreams invokes the hub methods of the open streams again over conn
+func (c *Client) resubscribeStreams(conn hubConnection) {
+	c.mx.Lock()
+	streams := make([]*ClientStream, 0, len(c.streams))
+	for id, s := range c.streams {
+		streams = append(streams, s)
+		delete(c.streams, id)
+	}
+	c.mx.Unlock()
+	for _, s := range streams {
+		s.args = c.resubscribe(s.target, s.args)
+		if _, err := conn.SendStreamInvocation(context.Background(), c.subscribe(s), s.target, s.args...); err != nil {
+			// The connection is lost again, the stream is invoked after the next reconnect
+			_ = c.info.Log(evt, "resubscribe", "error", err, "name", s.target)
+		}
+	}
+}
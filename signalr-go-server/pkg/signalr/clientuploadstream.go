@@ -0,0 +1,223 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// UploadStream is a stream of items a Client uploads to a channel parameter of a hub method.
+// It is passed to Client.Invoke or Client.Send as the argument for the channel parameter.
+// Written items are buffered up to the size of the stream and sent in order. While the buffer is full,
+// because the transport or the server can not keep up, Write blocks and TryWrite fails with ErrUploadBufferFull,
+// so a slow server slows down the writer instead of filling the memory of the client.
+// An UploadStream can be passed to one invocation only. It can not be queued by the ClientOfflineQueue.
+// Servers of this package send no completion for invocations with streams, so Invoke them only on servers
+// like ASP.NET Core SignalR, which do, and use Send otherwise.
+type UploadStream struct {
+	items     chan interface{}
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	mx        sync.Mutex
+	started   bool
+	err       error
+}
+
+// ErrUploadBufferFull is returned by UploadStream.TryWrite when the buffer of the stream is full
+var ErrUploadBufferFull = errors.New("upload buffer full")
+
+// ErrUploadStreamClosed is returned by UploadStream.Write and UploadStream.TryWrite after the stream has been closed
+var ErrUploadStreamClosed = errors.New("upload stream closed")
+
+// NewUploadStream creates an UploadStream which buffers up to bufferSize items. bufferSize must be positive
+func NewUploadStream(bufferSize int) (*UploadStream, error) {
+	if bufferSize <= 0 {
+		return nil, errors.New("NewUploadStream needs a positive bufferSize")
+	}
+	return &UploadStream{
+		items:   make(chan interface{}, bufferSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Write puts item in the buffer of the stream. If the buffer is full, it blocks until the item fits in,
+// ctx is done or the stream has ended
+func (s *UploadStream) Write(ctx context.Context, item interface{}) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	select {
+	case s.items <- item:
+		return nil
+	case <-s.closing:
+		return ErrUploadStreamClosed
+	case <-s.done:
+		return s.endError()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TryWrite puts item in the buffer of the stream, or returns ErrUploadBufferFull if the buffer is full
+func (s *UploadStream) TryWrite(item interface{}) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	select {
+	case s.items <- item:
+		return nil
+	default:
+		return ErrUploadBufferFull
+	}
+}
+
+func (s *UploadStream) writable() error {
+	select {
+	case <-s.closing:
+		return ErrUploadStreamClosed
+	case <-s.done:
+		return s.endError()
+	default:
+		return nil
+	}
+}
+
+// endError returns the error for writes after the stream has ended
+func (s *UploadStream) endError() error {
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return ErrUploadStreamClosed
+}
+
+// Close ends the stream. The buffered items are sent before the server is told that the stream has ended
+func (s *UploadStream) Close() error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	return nil
+}
+
+// Done returns a channel which is closed when the stream has ended, after Close or when the upload failed
+func (s *UploadStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error the upload failed with. It is nil while the stream is uploaded and after Close
+func (s *UploadStream) Err() error {
+	defer s.mx.Unlock()
+	s.mx.Lock()
+	return s.err
+}
+
+// start marks the stream as passed to an invocation. It returns false if it has been passed before
+func (s *UploadStream) start() bool {
+	defer s.mx.Unlock()
+	s.mx.Lock()
+	started := s.started
+	s.started = true
+	return !started
+}
+
+// end ends the stream with err, once
+func (s *UploadStream) end(err error) {
+	defer s.mx.Unlock()
+	s.mx.Lock()
+	select {
+	case <-s.done:
+	default:
+		s.err = err
+		close(s.done)
+	}
+}
+
+// upload sends the items of the stream over conn, one at a time, until the stream is closed and all buffered
+// items are sent. As each item is written before the next one is taken, the buffer fills up when the transport
+// is slow. The upload ends when the client is closed
+func (s *UploadStream) upload(conn hubConnection, id string, clientDone <-chan struct{}) {
+	for {
+		select {
+		case item := <-s.items:
+			if _, err := conn.StreamItem(id, item); err != nil {
+				s.end(err)
+				return
+			}
+		case <-s.closing:
+			err := s.flush(conn, id)
+			if err == nil {
+				_, err = conn.Completion(id, nil, "")
+			}
+			s.end(err)
+			return
+		case <-clientDone:
+			s.end(errors.New("client connection closed"))
+			return
+		}
+	}
+}
+
+// flush sends the items left in the buffer
+func (s *UploadStream) flush(conn hubConnection, id string) error {
+	for {
+		select {
+		case item := <-s.items:
+			if _, err := conn.StreamItem(id, item); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// splitUploadStreams removes the UploadStreams from args
+func splitUploadStreams(args []interface{}) ([]interface{}, []*UploadStream) {
+	var streams []*UploadStream
+	for _, arg := range args {
+		if stream, ok := arg.(*UploadStream); ok {
+			streams = append(streams, stream)
+		}
+	}
+	if len(streams) == 0 {
+		return args, nil
+	}
+	rest := make([]interface{}, 0, len(args)-len(streams))
+	for _, arg := range args {
+		if _, ok := arg.(*UploadStream); !ok {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, streams
+}
+
+// sendInvocation sends the invocation with the id, which is empty for Send, and starts the upload of its streams
+func (c *Client) sendInvocation(ctx context.Context, conn hubConnection, id string, target string,
+	args []interface{}, streams []*UploadStream) error {
+	if len(streams) == 0 {
+		_, err := conn.SendInvocationWithID(ctx, id, target, args...)
+		return err
+	}
+	for _, stream := range streams {
+		if !stream.start() {
+			return errors.New("upload stream passed to more than one invocation")
+		}
+	}
+	streamIds := make([]string, len(streams))
+	c.mx.Lock()
+	for i := range streams {
+		c.lastID++
+		streamIds[i] = strconv.FormatUint(c.lastID, 10)
+	}
+	c.mx.Unlock()
+	if _, err := conn.SendInvocationWithStreams(ctx, id, target, streamIds, args...); err != nil {
+		for _, stream := range streams {
+			stream.end(err)
+		}
+		return err
+	}
+	for i, stream := range streams {
+		go stream.upload(conn, streamIds[i], c.done)
+	}
+	return nil
+}
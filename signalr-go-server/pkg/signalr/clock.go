@@ -0,0 +1,125 @@
+package signalr
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for the handshake timeout, the keep alive interval, the client timeout
+// and the timeout for hub methods receiving client streams.
+// The default is the system clock. Tests can use a ManualClock with the UseClock option to check timeouts without waiting.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) ClockTimer
+}
+
+// ClockTimer is a timer started by Clock.AfterFunc. Stop prevents the timer from firing
+// and returns false if it has already fired or been stopped.
+type ClockTimer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// ManualClock is a Clock which only advances when Advance is called
+type ManualClock struct {
+	now    time.Time
+	timers []*manualTimer
+	mx     sync.Mutex
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	deadline time.Time
+	fire     func(now time.Time)
+}
+
+// NewManualClock creates a ManualClock which starts at start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the current time of the clock
+func (m *ManualClock) Now() time.Time {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	return m.now
+}
+
+// After returns a channel which receives the time of the clock when the clock has been advanced by d
+func (m *ManualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	m.start(d, func(now time.Time) { ch <- now })
+	return ch
+}
+
+// AfterFunc calls f in its own goroutine when the clock has been advanced by d
+func (m *ManualClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	return m.start(d, func(time.Time) { go f() })
+}
+
+// PendingTimers returns the number of timers which have not fired yet.
+// Tests can wait for the server to start its timers before they advance the clock.
+func (m *ManualClock) PendingTimers() int {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	return len(m.timers)
+}
+
+// Advance moves the clock forward by d and fires all timers which are due, earliest first
+func (m *ManualClock) Advance(d time.Duration) {
+	m.mx.Lock()
+	m.now = m.now.Add(d)
+	var due, pending []*manualTimer
+	for _, timer := range m.timers {
+		if timer.deadline.After(m.now) {
+			pending = append(pending, timer)
+		} else {
+			due = append(due, timer)
+		}
+	}
+	m.timers = pending
+	now := m.now
+	m.mx.Unlock()
+	sort.SliceStable(due, func(i, j int) bool { return due[i].deadline.Before(due[j].deadline) })
+	for _, timer := range due {
+		timer.fire(now)
+	}
+}
+
+func (m *ManualClock) start(d time.Duration, fire func(now time.Time)) *manualTimer {
+	m.mx.Lock()
+	timer := &manualTimer{clock: m, deadline: m.now.Add(d), fire: fire}
+	m.timers = append(m.timers, timer)
+	m.mx.Unlock()
+	if d <= 0 {
+		m.Advance(0)
+	}
+	return timer
+}
+
+func (t *manualTimer) Stop() bool {
+	defer t.clock.mx.Unlock()
+	t.clock.mx.Lock()
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
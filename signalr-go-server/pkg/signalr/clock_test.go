@@ -0,0 +1,153 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"sync"
+	"time"
+)
+
+// closableTestingConnection is a testingConnection whose reads fail after it has been closed
+type closableTestingConnection struct {
+	*testingConnection
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *closableTestingConnection) Read(b []byte) (int, error) {
+	n, err := c.testingConnection.Read(b)
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+		return n, err
+	}
+}
+
+func (c *closableTestingConnection) Close(int, string) error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		_ = c.srvReader.(io.Closer).Close()
+	})
+	return nil
+}
+
+var _ = Describe("Clock", func() {
+	Context("ManualClock", func() {
+		It("should fire timers only when advanced beyond their deadline", func() {
+			start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			clock := NewManualClock(start)
+			after := clock.After(time.Second)
+			called := make(chan struct{}, 1)
+			clock.AfterFunc(2*time.Second, func() { called <- struct{}{} })
+			Expect(clock.PendingTimers()).To(Equal(2))
+			clock.Advance(999 * time.Millisecond)
+			Expect(after).NotTo(Receive())
+			clock.Advance(time.Millisecond)
+			Expect(after).To(Receive(Equal(start.Add(time.Second))))
+			clock.Advance(time.Second)
+			Eventually(called).Should(Receive())
+			Expect(clock.Now()).To(Equal(start.Add(2 * time.Second)))
+			Expect(clock.PendingTimers()).To(Equal(0))
+		})
+		It("should not fire stopped timers", func() {
+			clock := NewManualClock(time.Now())
+			timer := clock.AfterFunc(time.Second, func() { Fail("stopped timer fired") })
+			Expect(timer.Stop()).To(BeTrue())
+			Expect(timer.Stop()).To(BeFalse())
+			clock.Advance(time.Minute)
+		})
+	})
+	Context("When the server uses a ManualClock", func() {
+		It("should close the connection when the clock passes the client timeout", func() {
+			clock := NewManualClock(time.Now())
+			server, err := NewServer(UseHub(&invocationHub{}), UseClock(clock),
+				KeepAliveInterval(15*time.Second), ClientTimeoutInterval(30*time.Second))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			Eventually(clock.PendingTimers).Should(Equal(2))
+			Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			clock.Advance(30 * time.Second)
+			Eventually(conn.ReceiveChan()).Should(Receive(BeAssignableToTypeOf(closeMessage{})))
+		})
+		It("should fail the handshake when the clock passes the handshake timeout", func() {
+			clock := NewManualClock(time.Now())
+			server, err := NewServer(UseHub(&invocationHub{}), UseClock(clock), HandshakeTimeout(time.Minute))
+			Expect(err).To(BeNil())
+			conn := &closableTestingConnection{testingConnection: newTestingConnectionBeforeHandshake(), closed: make(chan struct{})}
+			done := make(chan struct{})
+			go func() {
+				server.Run(context.TODO(), conn)
+				close(done)
+			}()
+			Eventually(clock.PendingTimers).Should(Equal(1))
+			Consistently(done, 100*time.Millisecond).ShouldNot(BeClosed())
+			clock.Advance(time.Minute)
+			Eventually(done).Should(BeClosed())
+			Expect(conn.closed).To(BeClosed())
+		})
+		It("should measure the idleness of streams with the clock", func() {
+			clock := NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			server, err := NewServer(SimpleHubFactory(&clockStreamHub{}), UseClock(clock),
+				KeepAliveInterval(15*time.Second), ClientTimeoutInterval(time.Hour))
+			Expect(err).To(BeNil())
+			conn := newTestingConnectionBeforeHandshake()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"protocol": "json","version": 1}`)
+			hr, _ := conn.ClientReceive()
+			Expect(hr).To(Equal("{}"))
+			received := make(chan string, 10)
+			go func() {
+				for {
+					m, err := conn.ClientReceive()
+					if err != nil {
+						return
+					}
+					received <- m
+				}
+			}()
+			Eventually(clock.PendingTimers).Should(Equal(2))
+			conn.ClientSend(`{"type":4,"invocationId":"items","target":"items"}`)
+			// The stream has started after the first item has been taken, the keep-alive watchdog is due at 15 seconds
+			clockStreamItems <- 1
+			Eventually(received).Should(Receive(ContainSubstring(`"type":2`)))
+			clock.Advance(10 * time.Second)
+			clockStreamItems <- 2
+			Eventually(received).Should(Receive(ContainSubstring(`"type":2`)))
+			Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+			// The stream item was sent 5 seconds ago, no ping is due
+			clock.Advance(5 * time.Second)
+			Consistently(received, 100*time.Millisecond).ShouldNot(Receive())
+			clock.Advance(10 * time.Second)
+			Eventually(received).Should(Receive(Equal("{\"type\":6}\n")))
+		})
+		It("should resend the outbox when the clock passes the retry interval", func() {
+			clock := NewManualClock(time.Now())
+			outbox := NewMemoryOutbox()
+			server, err := NewServer(SimpleHubFactory(&groupHub{}), UseClock(clock), UseOutbox(outbox, time.Minute),
+				KeepAliveInterval(time.Hour), ClientTimeoutInterval(2*time.Hour))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			<-groupHubOnConnectMsg
+			conn.ClientSend(`{"type":1,"target":"sendcommand"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(BeAssignableToTypeOf(invocationMessage{})))
+			Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			clock.Advance(time.Minute)
+			Eventually(conn.ReceiveChan()).Should(Receive(BeAssignableToTypeOf(invocationMessage{})))
+		})
+	})
+})
+
+var clockStreamItems = make(chan int)
+
+type clockStreamHub struct {
+	Hub
+}
+
+func (c *clockStreamHub) Items() <-chan int {
+	return clockStreamItems
+}
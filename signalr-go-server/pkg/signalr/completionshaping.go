@@ -0,0 +1,41 @@
+package signalr
+
+// CompletionInfo describes the completion of an invocation for a CompletionShaperFunc.
+// Result is the value the hub method returned, Error the error the invocation failed with.
+// Error is nil if the invocation succeeded. Errors of a Future or an InvocationHandler are passed as they are,
+// so their types can be mapped, e.g. to error codes.
+type CompletionInfo struct {
+	ConnectionID string
+	InvocationID string
+	Target       string
+	Result       interface{}
+	Error        error
+}
+
+// CompletionShaperFunc returns the result and the error message which are sent as completion of an invocation.
+// A non empty error message lets the invocation fail on the client, the result is not sent then.
+type CompletionShaperFunc func(info CompletionInfo) (result interface{}, errorMessage string)
+
+// complete sends the completion of invocation with result or err, shaped by the CompletionShaperFunc of the server
+func (sl *serverLoop) complete(invocation invocationMessage, result interface{}, err error) {
+	errorMessage := ""
+	if err != nil {
+		errorMessage = err.Error()
+	}
+	// Completions which end stream invocations successfully carry no result
+	if shaper := sl.server.completionShaper; shaper != nil && (invocation.Type != 4 || err != nil) {
+		result, errorMessage = shaper(CompletionInfo{
+			ConnectionID: sl.hubConn.ConnectionID(),
+			InvocationID: invocation.InvocationID,
+			Target:       invocation.Target,
+			Result:       result,
+			Error:        err,
+		})
+	}
+	if errorMessage != "" {
+		result = nil
+	}
+	sendMessageAndLog(func() (interface{}, error) {
+		return sl.hubConn.Completion(invocation.InvocationID, result, errorMessage)
+	}, sl.info)
+}
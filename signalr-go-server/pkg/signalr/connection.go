@@ -2,6 +2,7 @@ package signalr
 
 import (
 	"io"
+	"net/http"
 	"time"
 )
 
@@ -13,3 +14,39 @@ type Connection interface {
 	SetTimeout(duration time.Duration)
 	Timeout() time.Duration
 }
+
+// HTTPConnection can be implemented by a Connection which has been established by an http request.
+// Request() returns the request, e.g. to get the user or headers of the client
+type HTTPConnection interface {
+	Request() *http.Request
+}
+
+// Status codes passed to ClosableConnection.Close. They are the WebSocket close codes of RFC 6455
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	ClosePolicyViolation = 1008
+	CloseInternalError   = 1011
+)
+
+// ClosableConnection can be implemented by a Connection whose transport can be closed with a status code and reason.
+// The server calls Close when the connection ends, after the close message has been sent.
+// CloseNormal is used when the connection ends regularly, CloseGoingAway when the connection is rejected because the
+// server is stopping, ClosePolicyViolation when the connection is rejected, its user is disconnected or it is aborted
+// without reconnect and CloseInternalError after a panic in the server.
+type ClosableConnection interface {
+	Close(code int, reason string) error
+}
+
+// closeTransport closes conn with code and reason if conn is a ClosableConnection
+func closeTransport(conn Connection, code int, reason string) {
+	if closer, ok := conn.(ClosableConnection); ok {
+		_ = closer.Close(code, reason)
+	}
+}
+
+// binaryFramesConnection is implemented by connections whose transport distinguishes text and binary frames.
+// The server calls useBinaryFrames after the handshake when the client requested a binary protocol
+type binaryFramesConnection interface {
+	useBinaryFrames()
+}
@@ -3,6 +3,7 @@ package signalr
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"time"
@@ -38,6 +39,68 @@ var _ = Describe("Connection", func() {
 			})
 		})
 	})
+
+	Describe("Connection stats", func() {
+		Context("When messages are sent and received", func() {
+			It("should count the traffic of the connection", func() {
+				server, err := NewServer(SimpleHubFactory(&invocationHub{}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				conn.connectionID = "counted"
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"unknownFunc"}`)
+				Expect((<-conn.received).(completionMessage).InvocationID).To(Equal("1"))
+				Eventually(func() int64 {
+					stats, _ := server.ConnectionStats("counted")
+					return stats.MessagesSent
+				}).Should(Equal(int64(1)))
+				stats := server.AllConnectionStats()["counted"]
+				Expect(stats.MessagesReceived).To(Equal(int64(1)))
+				Expect(stats.BytesReceived).To(BeNumerically(">", 0))
+				Expect(stats.BytesSent).To(BeNumerically(">", 0))
+				Expect(stats.MessagesReceivedRate).To(Equal(0.1))
+			})
+		})
+	})
+
+	Describe("Pause reading", func() {
+		Context("When reading from the connection is paused", func() {
+			It("should not process messages until reading is resumed", func() {
+				server, err := NewServer(SimpleHubFactory(&invocationHub{}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				conn.connectionID = "paused"
+				go server.Run(context.TODO(), conn)
+				Eventually(func() bool { return server.PauseReading("paused") }).Should(BeTrue())
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"unknownFunc"}`)
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"unknownFunc"}`)
+				// A receive which was already pending when reading was paused still completes
+				received := 0
+				select {
+				case message := <-conn.received:
+					Expect(message.(completionMessage).InvocationID).To(Equal("1"))
+					received++
+				case <-time.After(100 * time.Millisecond):
+				}
+				select {
+				case message := <-conn.received:
+					Fail(fmt.Sprintf("received %v", message))
+				case <-time.After(100 * time.Millisecond):
+				}
+				Expect(server.ResumeReading("paused")).To(BeTrue())
+				for ; received < 2; received++ {
+					Expect(<-conn.received).To(BeAssignableToTypeOf(completionMessage{}))
+				}
+			})
+		})
+		Context("When the connection does not exist", func() {
+			It("should return false", func() {
+				server, err := NewServer(SimpleHubFactory(&invocationHub{}))
+				Expect(err).To(BeNil())
+				Expect(server.PauseReading("unknown")).To(BeFalse())
+			})
+		})
+	})
 })
 
 var _ = Describe("Protocol", func() {
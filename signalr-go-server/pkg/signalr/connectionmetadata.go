@@ -0,0 +1,76 @@
+package signalr
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ConnectionMetadata describes the client of a connection, e.g. to localize messages or to keep
+// compatibility with older app versions. Clients send it in the handshake as "metadata" field, like
+// {"protocol":"json","version":1,"metadata":{"locale":"de-DE","timeZone":"Europe/Berlin","appVersion":"2.1.0"}}
+// or, if the connection has been established by an http request, as headers: Accept-Language,
+// X-Client-Time-Zone and X-Client-App-Version. Fields of the handshake take precedence over the headers.
+type ConnectionMetadata struct {
+	Locale     string `json:"locale,omitempty"`
+	TimeZone   string `json:"timeZone,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+}
+
+// connectionMetadataKey is the key of the ConnectionMetadata in the Items of a connection
+type connectionMetadataKey struct{}
+
+// ConnectionMetadataFromItems returns the ConnectionMetadata stored in the Items of a connection,
+// e.g. in an InvocationTransformerFunc
+func ConnectionMetadataFromItems(items *sync.Map) ConnectionMetadata {
+	if items != nil {
+		if metadata, ok := items.Load(connectionMetadataKey{}); ok {
+			return metadata.(ConnectionMetadata)
+		}
+	}
+	return ConnectionMetadata{}
+}
+
+// ConnectionMetadata returns the ConnectionMetadata of the connection with the given connectionID,
+// e.g. in a MessageInterceptor. It returns false if the connection is not connected to the server.
+func (s *Server) ConnectionMetadata(connectionID string) (ConnectionMetadata, bool) {
+	if conn, ok := s.connection(connectionID); ok {
+		return ConnectionMetadataFromItems(conn.Items()), true
+	}
+	return ConnectionMetadata{}, false
+}
+
+// connectionMetadata merges the metadata sent in the handshake with the headers of the request of conn
+func connectionMetadata(conn Connection, handshake *ConnectionMetadata) ConnectionMetadata {
+	var metadata ConnectionMetadata
+	if httpConn, ok := conn.(HTTPConnection); ok && httpConn.Request() != nil {
+		header := httpConn.Request().Header
+		metadata = ConnectionMetadata{
+			Locale:     acceptedLanguage(header),
+			TimeZone:   header.Get("X-Client-Time-Zone"),
+			AppVersion: header.Get("X-Client-App-Version"),
+		}
+	}
+	if handshake != nil {
+		if handshake.Locale != "" {
+			metadata.Locale = handshake.Locale
+		}
+		if handshake.TimeZone != "" {
+			metadata.TimeZone = handshake.TimeZone
+		}
+		if handshake.AppVersion != "" {
+			metadata.AppVersion = handshake.AppVersion
+		}
+	}
+	return metadata
+}
+
+// acceptedLanguage returns the first language of the Accept-Language header, without its weight
+func acceptedLanguage(header http.Header) string {
+	language := strings.Split(header.Get("Accept-Language"), ",")[0]
+	language = strings.TrimSpace(strings.Split(language, ";")[0])
+	if language == "*" {
+		return ""
+	}
+	return language
+}
@@ -0,0 +1,103 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type metadataHub struct {
+	Hub
+}
+
+func (m *metadataHub) Describe() string {
+	metadata := m.Metadata()
+	return fmt.Sprintf("%v|%v|%v", metadata.Locale, metadata.TimeZone, metadata.AppVersion)
+}
+
+func (m *metadataHub) Greet() {
+	m.Clients().Caller().Send("greeting", "Hello")
+}
+
+func (m *metadataHub) ID() string {
+	return m.context.ConnectionID()
+}
+
+var _ = Describe("ConnectionMetadata", func() {
+	var baseURL string
+	var server *Server
+	BeforeEach(func() {
+		router := http.NewServeMux()
+		var err error
+		server, err = MapHub(router, "/hub", &metadataHub{},
+			InvocationTransformer("greeting", func(connectionID string, items *sync.Map, args []interface{}) []interface{} {
+				if strings.HasPrefix(ConnectionMetadataFromItems(items).Locale, "de") {
+					return []interface{}{"Hallo"}
+				}
+				return args
+			}))
+		Expect(err).To(BeNil())
+		port := freePort()
+		go func() {
+			_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+		}()
+		waitForPort(port)
+		baseURL = fmt.Sprintf("http://127.0.0.1:%v", port)
+	})
+	connect := func(options ...func(*Client) error) *Client {
+		client, err := NewClient(baseURL+"/hub", options...)
+		Expect(err).To(BeNil())
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		Expect(client.Connect(ctx)).To(BeNil())
+		return client
+	}
+	Context("When the client sends metadata as headers and in the handshake", func() {
+		It("should expose the merged metadata to hubs, transformers and the server", func() {
+			client := connect(
+				ClientHeader(http.Header{
+					"Accept-Language":      []string{"de-CH;q=0.9, en;q=0.8"},
+					"X-Client-Time-Zone":   []string{"Europe/Zurich"},
+					"X-Client-App-Version": []string{"1.0.0"},
+				}),
+				ClientMetadata(ConnectionMetadata{AppVersion: "2.1.0"}))
+			defer func() { _ = client.Close() }()
+			greetings := make(chan string, 1)
+			Expect(client.On("greeting", func(text string) { greetings <- text })).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			var description string
+			Expect(client.Invoke(ctx, &description, "Describe")).To(BeNil())
+			Expect(description).To(Equal("de-CH|Europe/Zurich|2.1.0"))
+			Expect(client.Invoke(ctx, nil, "Greet")).To(BeNil())
+			Eventually(greetings).Should(Receive(Equal("Hallo")))
+			var connectionID string
+			Expect(client.Invoke(ctx, &connectionID, "ID")).To(BeNil())
+			metadata, ok := server.ConnectionMetadata(connectionID)
+			Expect(ok).To(BeTrue())
+			Expect(metadata).To(Equal(ConnectionMetadata{Locale: "de-CH", TimeZone: "Europe/Zurich", AppVersion: "2.1.0"}))
+			_, ok = server.ConnectionMetadata("unknown")
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("When the client sends no metadata", func() {
+		It("should expose empty metadata", func() {
+			client := connect()
+			defer func() { _ = client.Close() }()
+			greetings := make(chan string, 1)
+			Expect(client.On("greeting", func(text string) { greetings <- text })).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			var description string
+			Expect(client.Invoke(ctx, &description, "Describe")).To(BeNil())
+			Expect(description).To(Equal("||"))
+			Expect(client.Invoke(ctx, nil, "Greet")).To(BeNil())
+			Eventually(greetings).Should(Receive(Equal("Hello")))
+		})
+	})
+})
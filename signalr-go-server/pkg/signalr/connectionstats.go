@@ -0,0 +1,103 @@
+package signalr
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// ConnectionStats holds statistics of a single connection
+// RoundTripTime is the estimated round trip time of the connection. It is only measured if PingTimestamps are enabled.
+// BytesReceived, BytesSent, MessagesReceived and MessagesSent count the traffic since the connection started.
+// The Rate fields are the average traffic per second during the last rateWindow (10 seconds).
+// PendingInvocations is the number of invocations of the connection which are queued or running, see InvocationShedding.
+type ConnectionStats struct {
+	RoundTripTime        time.Duration
+	BytesReceived        int64
+	BytesSent            int64
+	MessagesReceived     int64
+	MessagesSent         int64
+	BytesReceivedRate    float64
+	BytesSentRate        float64
+	MessagesReceivedRate float64
+	MessagesSentRate     float64
+	PendingInvocations   int64
+}
+
+// AllConnectionStats returns the statistics of all connections of the server by connection id,
+// e.g. to find the clients responsible for a bandwidth spike
+func (s *Server) AllConnectionStats() map[string]ConnectionStats {
+	stats := make(map[string]ConnectionStats)
+	for _, conn := range s.localLifetimeManager.allConnections() {
+		stats[conn.ConnectionID()] = conn.Stats()
+	}
+	return stats
+}
+
+// connectionTraffic counts the traffic of a connection
+type connectionTraffic struct {
+	bytesReceived    rateCounter
+	bytesSent        rateCounter
+	messagesReceived rateCounter
+	messagesSent     rateCounter
+}
+
+func (t *connectionTraffic) stats(roundTripTime time.Duration) ConnectionStats {
+	now := time.Now()
+	stats := ConnectionStats{RoundTripTime: roundTripTime}
+	stats.BytesReceived, stats.BytesReceivedRate = t.bytesReceived.read(now)
+	stats.BytesSent, stats.BytesSentRate = t.bytesSent.read(now)
+	stats.MessagesReceived, stats.MessagesReceivedRate = t.messagesReceived.read(now)
+	stats.MessagesSent, stats.MessagesSentRate = t.messagesSent.read(now)
+	return stats
+}
+
+// rateWindow is the number of seconds over which the rates are averaged
+const rateWindow = 10
+
+// rateCounter counts a total and the counts of the last rateWindow seconds
+type rateCounter struct {
+	mx    sync.Mutex
+	total int64
+	// counts[i] holds the count of the second seconds[i]
+	counts  [rateWindow]int64
+	seconds [rateWindow]int64
+}
+
+func (r *rateCounter) add(now time.Time, n int64) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	second := now.Unix()
+	i := second % rateWindow
+	if r.seconds[i] != second {
+		r.seconds[i] = second
+		r.counts[i] = 0
+	}
+	r.counts[i] += n
+	r.total += n
+}
+
+func (r *rateCounter) read(now time.Time) (total int64, rate float64) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	second := now.Unix()
+	var sum int64
+	for i, s := range r.seconds {
+		if second-s < rateWindow {
+			sum += r.counts[i]
+		}
+	}
+	return r.total, float64(sum) / rateWindow
+}
+
+// countingWriter counts the bytes written to writer
+type countingWriter struct {
+	writer io.Writer
+	n      int64
+}
+
+func (c *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = c.writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
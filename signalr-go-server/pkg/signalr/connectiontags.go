@@ -0,0 +1,202 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagSet holds the tags of a connection, e.g. "platform:ios" or "plan:pro".
+// Tagged connections can be addressed with HubClients.Tagged(). TagSet is safe for concurrent use.
+type TagSet struct {
+	mx   sync.RWMutex
+	tags map[string]bool
+}
+
+// Add adds tags to the set
+func (t *TagSet) Add(tags ...string) {
+	defer t.mx.Unlock()
+	t.mx.Lock()
+	if t.tags == nil {
+		t.tags = make(map[string]bool)
+	}
+	for _, tag := range tags {
+		t.tags[tag] = true
+	}
+}
+
+// Remove removes tags from the set
+func (t *TagSet) Remove(tags ...string) {
+	defer t.mx.Unlock()
+	t.mx.Lock()
+	for _, tag := range tags {
+		delete(t.tags, tag)
+	}
+}
+
+// Has tells if the set contains tag
+func (t *TagSet) Has(tag string) bool {
+	defer t.mx.RUnlock()
+	t.mx.RLock()
+	return t.tags[tag]
+}
+
+// List returns the tags of the set in sorted order
+func (t *TagSet) List() []string {
+	defer t.mx.RUnlock()
+	t.mx.RLock()
+	tags := make([]string, 0, len(t.tags))
+	for tag := range t.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// tagExpression is a parsed tag expression, which tells if the tags of a connection match it
+type tagExpression func(tags *TagSet) bool
+
+// parseTagExpression parses expressions of tags combined with "&&", "||", "!" and parentheses,
+// e.g. "platform:ios && (plan:pro || plan:team) && !beta". "&&" binds stronger than "||".
+func parseTagExpression(expression string) (tagExpression, error) {
+	p := tagParser{tokens: tokenizeTagExpression(expression)}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid tag expression %q: %v", expression, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("invalid tag expression %q: unexpected %q", expression, p.tokens[p.pos])
+	}
+	return expr, nil
+}
+
+func tokenizeTagExpression(expression string) []string {
+	var tokens []string
+	for i := 0; i < len(expression); {
+		switch c := expression[i]; {
+		case c == ' ' || c == '\t':
+			i++
+		case strings.HasPrefix(expression[i:], "&&") || strings.HasPrefix(expression[i:], "||"):
+			tokens = append(tokens, expression[i:i+2])
+			i += 2
+		case strings.IndexByte("()!&|", c) >= 0:
+			tokens = append(tokens, expression[i:i+1])
+			i++
+		default:
+			end := i
+			for end < len(expression) && strings.IndexByte("()!&| \t", expression[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, expression[i:end])
+			i = end
+		}
+	}
+	return tokens
+}
+
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) next() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *tagParser) parseOr() (tagExpression, error) {
+	left, err := p.parseAnd()
+	for err == nil && p.next() == "||" {
+		p.pos++
+		var right tagExpression
+		if right, err = p.parseAnd(); err == nil {
+			l := left
+			left = func(tags *TagSet) bool { return l(tags) || right(tags) }
+		}
+	}
+	return left, err
+}
+
+func (p *tagParser) parseAnd() (tagExpression, error) {
+	left, err := p.parseUnary()
+	for err == nil && p.next() == "&&" {
+		p.pos++
+		var right tagExpression
+		if right, err = p.parseUnary(); err == nil {
+			l := left
+			left = func(tags *TagSet) bool { return l(tags) && right(tags) }
+		}
+	}
+	return left, err
+}
+
+func (p *tagParser) parseUnary() (tagExpression, error) {
+	switch token := p.next(); token {
+	case "":
+		return nil, fmt.Errorf("unexpected end")
+	case "!":
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(tags *TagSet) bool { return !operand(tags) }, nil
+	case "(":
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return inner, nil
+	case ")", "&&", "||", "&", "|":
+		return nil, fmt.Errorf("unexpected %q", token)
+	default:
+		p.pos++
+		return func(tags *TagSet) bool { return tags.Has(token) }, nil
+	}
+}
+
+// taggedConnections returns the connected connections whose tags match the expression
+func (d *defaultHubLifetimeManager) taggedConnections(expression string) ([]hubConnection, error) {
+	match, err := parseTagExpression(expression)
+	if err != nil {
+		return nil, err
+	}
+	var conns []hubConnection
+	for _, conn := range d.allConnections() {
+		if match(conn.Tags()) {
+			conns = append(conns, conn)
+		}
+	}
+	return conns, nil
+}
+
+type taggedClientProxy struct {
+	expression      string
+	lifetimeManager HubLifetimeManager
+}
+
+func (t *taggedClientProxy) Send(target string, args ...interface{}) {
+	_ = t.lifetimeManager.InvokeTagged(context.Background(), t.expression, target, args)
+}
+
+func (t *taggedClientProxy) SendContext(ctx context.Context, target string, args ...interface{}) error {
+	return t.lifetimeManager.InvokeTagged(ctx, t.expression, target, args)
+}
+
+func (t *taggedClientProxy) SendDurable(target string, args ...interface{}) error {
+	return t.lifetimeManager.InvokeTaggedDurable(context.Background(), t.expression, target, args)
+}
+
+func (t *taggedClientProxy) SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend {
+	return scheduleSend(delay, func() { t.Send(target, args...) })
+}
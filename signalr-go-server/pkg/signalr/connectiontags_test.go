@@ -0,0 +1,85 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+type taggedHub struct {
+	Hub
+}
+
+func (t *taggedHub) OnConnected(connectionID string) {
+	taggedHubOnConnected <- connectionID
+}
+
+func (t *taggedHub) AddTags(tags []string) {
+	t.Tags().Add(tags...)
+}
+
+func (t *taggedHub) Notify(expression string) {
+	t.Clients().Tagged(expression).Send("notified", expression)
+}
+
+var taggedHubOnConnected = make(chan string, 10)
+
+var _ = Describe("Connection tags", func() {
+	Context("When tag expressions are parsed", func() {
+		It("should match the tags with the precedence of the operators", func() {
+			tags := &TagSet{}
+			tags.Add("platform:ios", "plan:pro", "beta")
+			tags.Remove("beta")
+			Expect(tags.List()).To(Equal([]string{"plan:pro", "platform:ios"}))
+			for expression, matches := range map[string]bool{
+				"platform:ios":                            true,
+				"!platform:ios":                           false,
+				"platform:android || plan:pro":            true,
+				"platform:android || plan:pro && beta":    false,
+				"(platform:android || plan:pro) && !beta": true,
+				"!(plan:pro&&platform:ios)":               false,
+			} {
+				match, err := parseTagExpression(expression)
+				Expect(err).To(BeNil(), expression)
+				Expect(match(tags)).To(Equal(matches), expression)
+			}
+		})
+		It("should reject invalid expressions", func() {
+			for _, expression := range []string{"", "a &&", "(a || b", "a & b", "a b", ")"} {
+				_, err := parseTagExpression(expression)
+				Expect(err).NotTo(BeNil(), expression)
+			}
+		})
+	})
+	Context("When a hub sends to tagged connections", func() {
+		It("should send only to the connections whose tags match", func() {
+			server, err := NewServer(SimpleHubFactory(&taggedHub{}))
+			Expect(err).To(BeNil())
+			conns := make([]*testingConnection, 3)
+			for i, id := range []string{"ios", "android", "web"} {
+				conns[i] = newTestingConnection()
+				conns[i].connectionID = id
+				go server.Run(context.TODO(), conns[i])
+				<-taggedHubOnConnected
+			}
+			conns[0].ClientSend(`{"type":1,"invocationId":"1","target":"addtags","arguments":[["platform:ios","plan:pro"]]}`)
+			Eventually(conns[0].ReceiveChan()).Should(Receive(BeAssignableToTypeOf(completionMessage{})))
+			tags, ok := server.Tags("android")
+			Expect(ok).To(BeTrue())
+			tags.Add("platform:android", "plan:pro")
+			conns[2].ClientSend(`{"type":1,"target":"notify","arguments":["plan:pro && !platform:android"]}`)
+			Eventually(conns[0].ReceiveChan()).Should(Receive(Equal(
+				invocationMessage{Type: 1, Target: "notified", Arguments: []interface{}{"plan:pro && !platform:android"}})))
+			Consistently(conns[1].ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			Consistently(conns[2].ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+	Context("When a tagged send has an invalid expression", func() {
+		It("should return an error from SendContext", func() {
+			server, err := NewServer(SimpleHubFactory(&taggedHub{}))
+			Expect(err).To(BeNil())
+			Expect(server.defaultHubClients.Tagged("a ||").SendContext(context.TODO(), "notified")).NotTo(BeNil())
+		})
+	})
+})
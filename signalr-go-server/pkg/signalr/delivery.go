@@ -0,0 +1,141 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DeliveryStatus is the status of an invocation sent with SendWithAck
+type DeliveryStatus int
+
+const (
+	// DeliveryPending means the client has not acknowledged the invocation yet
+	DeliveryPending DeliveryStatus = iota
+	// DeliveryAcknowledged means the client has acknowledged the invocation
+	DeliveryAcknowledged
+	// DeliveryFailed means the client did not acknowledge the invocation after the maximum number of attempts
+	DeliveryFailed
+)
+
+// Delivery tracks an invocation which is sent with an invocation id and resent with exponential backoff
+// until the client acknowledges it. Clients acknowledge an invocation by sending a completion with its invocation id,
+// which current SignalR client libraries do automatically after the client method returns.
+type Delivery struct {
+	id       string
+	done     chan struct{}
+	ack      chan error
+	mx       sync.Mutex
+	status   DeliveryStatus
+	err      error
+	attempts int
+}
+
+// ID returns the invocation id
+func (d *Delivery) ID() string {
+	return d.id
+}
+
+// Status returns the current DeliveryStatus
+func (d *Delivery) Status() DeliveryStatus {
+	defer d.mx.Unlock()
+	d.mx.Lock()
+	return d.status
+}
+
+// Done is closed when the delivery has been acknowledged or failed
+func (d *Delivery) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns the error sent by the client with the acknowledgement, or why the delivery failed
+func (d *Delivery) Err() error {
+	defer d.mx.Unlock()
+	d.mx.Lock()
+	return d.err
+}
+
+// Attempts returns how often the invocation has been sent
+func (d *Delivery) Attempts() int {
+	defer d.mx.Unlock()
+	d.mx.Lock()
+	return d.attempts
+}
+
+func (d *Delivery) finish(status DeliveryStatus, err error) {
+	d.mx.Lock()
+	d.status = status
+	d.err = err
+	d.mx.Unlock()
+	close(d.done)
+}
+
+// ackRetryPolicy controls the resends of a Delivery
+type ackRetryPolicy struct {
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	maxAttempts     int
+}
+
+func (d *defaultHubLifetimeManager) InvokeClientWithAck(connectionID string, target string, args []interface{}) *Delivery {
+	delivery := &Delivery{
+		id:   newOutboxMessageID(),
+		done: make(chan struct{}),
+		ack:  make(chan error, 1),
+	}
+	d.deliveries.Store(delivery.id, delivery)
+	go d.deliver(connectionID, target, args, delivery)
+	return delivery
+}
+
+func (d *defaultHubLifetimeManager) deliver(connectionID string, target string, args []interface{}, delivery *Delivery) {
+	defer d.deliveries.Delete(delivery.id)
+	interval := d.ackRetry.initialInterval
+	for {
+		// The client might have reconnected with the same connection id, so look it up on every attempt
+		if client, ok := d.clients.Load(connectionID); ok {
+			conn := client.(hubConnection)
+			sendMessageAndLog(func() (interface{}, error) {
+				connArgs, err := d.seal(conn, "", target, args)
+				if err != nil {
+					return nil, err
+				}
+				return conn.SendInvocationWithID(context.Background(), delivery.id, target, connArgs...)
+			}, d.info)
+		}
+		delivery.mx.Lock()
+		delivery.attempts++
+		attempts := delivery.attempts
+		delivery.mx.Unlock()
+		select {
+		case err := <-delivery.ack:
+			delivery.finish(DeliveryAcknowledged, err)
+			return
+		case <-time.After(interval):
+		}
+		if attempts >= d.ackRetry.maxAttempts {
+			delivery.finish(DeliveryFailed, errors.New("not acknowledged by the client"))
+			return
+		}
+		if interval *= 2; interval > d.ackRetry.maxInterval {
+			interval = d.ackRetry.maxInterval
+		}
+	}
+}
+
+func (d *defaultHubLifetimeManager) Acknowledge(invocationID string, errorMessage string) bool {
+	if delivery, ok := d.deliveries.Load(invocationID); ok {
+		var err error
+		if errorMessage != "" {
+			err = errors.New(errorMessage)
+		}
+		select {
+		case delivery.(*Delivery).ack <- err:
+		default:
+			// already acknowledged
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Drain", func() {
+	Context("When a connection is drained", func() {
+		It("should send the queued messages, no more broadcasts and close the connection", func() {
+			server, err := NewServer(SimpleHubFactory(&groupHub{}))
+			Expect(err).To(BeNil())
+			conn1 := newTestingConnection()
+			conn1.connectionID = "drained"
+			go server.Run(context.TODO(), conn1)
+			<-groupHubOnConnectMsg
+			conn2 := newTestingConnection()
+			conn2.connectionID = "kept"
+			go server.Run(context.TODO(), conn2)
+			<-groupHubOnConnectMsg
+			Expect(server.lifetimeManager.InvokeClient(context.TODO(), "drained", "queued", nil)).To(BeNil())
+			Expect(server.Drain("drained", time.Second)).To(BeNil())
+			Expect(server.lifetimeManager.InvokeAll(context.TODO(), "broadcast", nil)).To(BeNil())
+			Expect((<-conn1.ReceiveChan()).(invocationMessage).Target).To(Equal("queued"))
+			closed := (<-conn1.ReceiveChan()).(closeMessage)
+			Expect(closed.AllowReconnect).To(BeTrue())
+			Expect((<-conn2.ReceiveChan()).(invocationMessage).Target).To(Equal("broadcast"))
+			Eventually(func() error { return server.Drain("drained", time.Second) }).ShouldNot(BeNil())
+		})
+	})
+})
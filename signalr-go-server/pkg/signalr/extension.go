@@ -0,0 +1,70 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Extension is a subsystem whose lifecycle is managed by the server, like a backplane or a metrics exporter.
+// Start is called by Server.Start and should return when the extension is ready, long running work belongs
+// into goroutines which end when Stop is called. Stop is called by Server.Stop and should release all resources
+// of the extension before ctx is done.
+type Extension interface {
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// UseExtensions registers extensions with the server. They are started by Server.Start in the order they have been
+// registered and stopped by Server.Stop in the reverse order. A HubLifetimeManager set with UseHubLifetimeManager
+// which implements Extension is registered after them.
+func UseExtensions(extensions ...Extension) func(*Server) error {
+	return func(s *Server) error {
+		for _, extension := range extensions {
+			if extension == nil {
+				return errors.New("UseExtensions needs extensions which are not nil")
+			}
+		}
+		s.extensions = append(s.extensions, extensions...)
+		return nil
+	}
+}
+
+// Start starts the extensions and the InvocationWorkers of the server. If an extension fails to start, the extensions which have been
+// started before are stopped again and the error is returned. Start returns an error if the server has already been started.
+// A server which has been stopped accepts connections again after Start.
+func (s *Server) Start(ctx context.Context) error {
+	defer s.extensionsMx.Unlock()
+	s.extensionsMx.Lock()
+	if s.started {
+		return errors.New("server already started")
+	}
+	for i, extension := range s.extensions {
+		if err := extension.Start(ctx); err != nil {
+			_ = s.info.Log(evt, "start extension", "extension", fmt.Sprintf("%T", extension), "error", err, react, "stop started extensions")
+			_ = s.stopExtensions(ctx, s.extensions[:i])
+			return fmt.Errorf("start %T: %v", extension, err)
+		}
+	}
+	if s.scheduler != nil {
+		s.scheduler.start()
+	}
+	s.started = true
+	atomic.StoreInt32(&s.stopping, 0)
+	return nil
+}
+
+// stopExtensions stops extensions in reverse order and returns the first error
+func (s *Server) stopExtensions(ctx context.Context, extensions []Extension) error {
+	var first error
+	for i := len(extensions) - 1; i >= 0; i-- {
+		if err := extensions[i].Stop(ctx); err != nil {
+			_ = s.info.Log(evt, "stop extension", "extension", fmt.Sprintf("%T", extensions[i]), "error", err)
+			if first == nil {
+				first = fmt.Errorf("stop %T: %v", extensions[i], err)
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,94 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+)
+
+type recordingExtension struct {
+	name      string
+	failStart bool
+	mx        *sync.Mutex
+	events    *[]string
+}
+
+func (r *recordingExtension) record(event string) {
+	r.mx.Lock()
+	*r.events = append(*r.events, fmt.Sprintf("%v %v", event, r.name))
+	r.mx.Unlock()
+}
+
+func (r *recordingExtension) Start(context.Context) error {
+	if r.failStart {
+		return errors.New("failed")
+	}
+	r.record("start")
+	return nil
+}
+
+func (r *recordingExtension) Stop(context.Context) error {
+	r.record("stop")
+	return nil
+}
+
+type extensionHub struct {
+	Hub
+}
+
+// extensionLifetimeManager is a HubLifetimeManager which is also an Extension
+type extensionLifetimeManager struct {
+	HubLifetimeManager
+	*recordingExtension
+}
+
+var _ = Describe("UseExtensions option", func() {
+	var mx sync.Mutex
+	var events []string
+	extension := func(name string, failStart bool) *recordingExtension {
+		return &recordingExtension{name: name, failStart: failStart, mx: &mx, events: &events}
+	}
+	BeforeEach(func() {
+		events = nil
+	})
+	Context("When the server is started and stopped", func() {
+		It("should start the extensions in order and stop them in reverse order", func() {
+			server, err := NewServer(SimpleHubFactory(&extensionHub{}),
+				UseExtensions(extension("metrics", false), extension("cache", false)),
+				UseHubLifetimeManager(func(local HubLifetimeManager) HubLifetimeManager {
+					return &extensionLifetimeManager{HubLifetimeManager: local, recordingExtension: extension("backplane", false)}
+				}))
+			Expect(err).To(BeNil())
+			Expect(server.Start(context.TODO())).To(BeNil())
+			Expect(server.Start(context.TODO())).NotTo(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(1))
+			Expect(server.Stop(context.TODO())).To(BeNil())
+			closed := (<-conn.ReceiveChan()).(closeMessage)
+			Expect(closed.AllowReconnect).To(BeTrue())
+			Expect(events).To(Equal([]string{"start metrics", "start cache", "start backplane",
+				"stop backplane", "stop cache", "stop metrics"}))
+			Expect(server.Stop(context.TODO())).To(BeNil())
+			Expect(events).To(HaveLen(6))
+		})
+	})
+	Context("When an extension fails to start", func() {
+		It("should stop the extensions which have been started and return the error", func() {
+			server, err := NewServer(SimpleHubFactory(&extensionHub{}),
+				UseExtensions(extension("metrics", false), extension("broken", true), extension("cache", false)))
+			Expect(err).To(BeNil())
+			Expect(server.Start(context.TODO())).NotTo(BeNil())
+			Expect(events).To(Equal([]string{"start metrics", "stop metrics"}))
+		})
+	})
+	Context("When a nil extension is registered", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&extensionHub{}), UseExtensions(nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,161 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// fairScheduler runs tasks on a fixed number of workers. Each connection has its own queue and
+// the workers take the tasks round-robin from the queues, so a connection with many pending
+// invocations can not starve the other connections.
+// The workers run between start and stop. Each queue holds up to queueLimit tasks.
+type fairScheduler struct {
+	mx         sync.Mutex
+	cond       *sync.Cond
+	workers    int
+	queueLimit int
+	queues     map[string][]scheduledTask
+	// ready holds the ids of the connections with pending tasks in the order they are served
+	ready []string
+	// quit is closed when the workers of the current start should end. It is nil while the workers are stopped
+	quit    chan struct{}
+	running sync.WaitGroup
+}
+
+// scheduledTask is run by a worker, or dropped if its connection is gone or the scheduler stops before
+type scheduledTask struct {
+	run  func()
+	drop func()
+}
+
+var errSchedulerQueueFull = errors.New("Server busy")
+var errSchedulerStopped = errors.New("server stopped")
+
+func newFairScheduler(workers int, queueLimit int) *fairScheduler {
+	f := &fairScheduler{workers: workers, queueLimit: queueLimit, queues: make(map[string][]scheduledTask)}
+	f.cond = sync.NewCond(&f.mx)
+	return f
+}
+
+// start starts the workers, if they are not running
+func (f *fairScheduler) start() {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.quit != nil {
+		return
+	}
+	f.quit = make(chan struct{})
+	f.running.Add(f.workers)
+	for i := 0; i < f.workers; i++ {
+		go f.work(f.quit)
+	}
+}
+
+// stop drops all queued tasks and waits until the workers have finished their running tasks or ctx is done
+func (f *fairScheduler) stop(ctx context.Context) error {
+	f.mx.Lock()
+	if f.quit != nil {
+		close(f.quit)
+		f.quit = nil
+	}
+	var dropped []scheduledTask
+	for _, queue := range f.queues {
+		dropped = append(dropped, queue...)
+	}
+	f.queues = make(map[string][]scheduledTask)
+	f.ready = nil
+	f.cond.Broadcast()
+	f.mx.Unlock()
+	for _, task := range dropped {
+		task.drop()
+	}
+	done := make(chan struct{})
+	go func() {
+		f.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// schedule queues the task of the connection. It returns an error if the queue of the connection is full
+// or the workers are stopped. drop is called instead of run if the task is dropped
+func (f *fairScheduler) schedule(connectionID string, run func(), drop func()) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	if f.quit == nil {
+		return errSchedulerStopped
+	}
+	queue, ok := f.queues[connectionID]
+	if len(queue) >= f.queueLimit {
+		return errSchedulerQueueFull
+	}
+	if !ok {
+		f.ready = append(f.ready, connectionID)
+	}
+	f.queues[connectionID] = append(queue, scheduledTask{run: run, drop: drop})
+	f.cond.Signal()
+	return nil
+}
+
+// dropConnection drops the queued tasks of the connection
+func (f *fairScheduler) dropConnection(connectionID string) {
+	f.mx.Lock()
+	dropped := f.queues[connectionID]
+	delete(f.queues, connectionID)
+	for i, id := range f.ready {
+		if id == connectionID {
+			f.ready = append(f.ready[:i], f.ready[i+1:]...)
+			break
+		}
+	}
+	f.mx.Unlock()
+	for _, task := range dropped {
+		task.drop()
+	}
+}
+
+// next waits for the next task. It returns false when quit is closed
+func (f *fairScheduler) next(quit chan struct{}) (func(), bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for {
+		select {
+		case <-quit:
+			return nil, false
+		default:
+		}
+		if len(f.ready) > 0 {
+			break
+		}
+		f.cond.Wait()
+	}
+	connectionID := f.ready[0]
+	f.ready = f.ready[1:]
+	queue := f.queues[connectionID]
+	task := queue[0]
+	if len(queue) > 1 {
+		f.queues[connectionID] = queue[1:]
+		// Back to the end of the line
+		f.ready = append(f.ready, connectionID)
+	} else {
+		delete(f.queues, connectionID)
+	}
+	return task.run, true
+}
+
+func (f *fairScheduler) work(quit chan struct{}) {
+	defer f.running.Done()
+	for {
+		task, ok := f.next(quit)
+		if !ok {
+			return
+		}
+		task()
+	}
+}
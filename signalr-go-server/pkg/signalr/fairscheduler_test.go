@@ -0,0 +1,89 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("FairScheduler", func() {
+	var scheduler *fairScheduler
+	BeforeEach(func() {
+		scheduler = newFairScheduler(1, 3)
+		scheduler.start()
+	})
+	AfterEach(func() {
+		Expect(scheduler.stop(context.TODO())).To(BeNil())
+	})
+	// block occupies the worker until the returned channel is closed
+	block := func() chan struct{} {
+		gate := make(chan struct{})
+		started := make(chan struct{})
+		Expect(scheduler.schedule("gate", func() {
+			close(started)
+			<-gate
+		}, func() {})).To(BeNil())
+		<-started
+		return gate
+	}
+	Context("When one connection has queued more tasks than another", func() {
+		It("should serve the connections round-robin", func() {
+			gate := block()
+			order := make(chan string, 4)
+			for _, name := range []string{"a1", "a2", "a3"} {
+				name := name
+				Expect(scheduler.schedule("a", func() { order <- name }, func() {})).To(BeNil())
+			}
+			Expect(scheduler.schedule("b", func() { order <- "b1" }, func() {})).To(BeNil())
+			close(gate)
+			for _, name := range []string{"a1", "b1", "a2", "a3"} {
+				Expect(<-order).To(Equal(name))
+			}
+		})
+	})
+	Context("When the queue of a connection is full", func() {
+		It("should reject the task", func() {
+			gate := block()
+			defer close(gate)
+			for i := 0; i < 3; i++ {
+				Expect(scheduler.schedule("a", func() {}, func() {})).To(BeNil())
+			}
+			Expect(scheduler.schedule("a", func() {}, func() {})).To(Equal(errSchedulerQueueFull))
+			Expect(scheduler.schedule("b", func() {}, func() {})).To(BeNil())
+		})
+	})
+	Context("When the tasks of a connection are dropped", func() {
+		It("should not run them but the tasks of the other connections", func() {
+			gate := block()
+			dropped := make(chan string, 2)
+			ran := make(chan string, 2)
+			Expect(scheduler.schedule("a", func() { ran <- "a" }, func() { dropped <- "a" })).To(BeNil())
+			Expect(scheduler.schedule("b", func() { ran <- "b" }, func() { dropped <- "b" })).To(BeNil())
+			scheduler.dropConnection("a")
+			Expect(dropped).To(Receive(Equal("a")))
+			close(gate)
+			Eventually(ran).Should(Receive(Equal("b")))
+			Consistently(ran, 50*time.Millisecond).ShouldNot(Receive())
+			Expect(dropped).NotTo(Receive())
+		})
+	})
+	Context("When the scheduler is stopped", func() {
+		It("should drop the queued tasks, end the workers and reject new tasks until it is started again", func() {
+			gate := block()
+			dropped := make(chan struct{}, 1)
+			Expect(scheduler.schedule("a", func() {}, func() { dropped <- struct{}{} })).To(BeNil())
+			stopped := make(chan error, 1)
+			go func() { stopped <- scheduler.stop(context.TODO()) }()
+			Eventually(dropped).Should(Receive())
+			Consistently(stopped, 50*time.Millisecond).ShouldNot(Receive())
+			close(gate)
+			Eventually(stopped).Should(Receive(BeNil()))
+			Expect(scheduler.schedule("a", func() {}, func() {})).To(Equal(errSchedulerStopped))
+			scheduler.start()
+			ran := make(chan struct{})
+			Expect(scheduler.schedule("a", func() { close(ran) }, func() {})).To(BeNil())
+			Eventually(ran).Should(BeClosed())
+		})
+	})
+})
@@ -0,0 +1,112 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// FileInfo describes the content which is streamed with FileChunks
+type FileInfo struct {
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+}
+
+// FileChunk is one stream item of a streamed file. The first chunk carries the FileInfo.
+// If reading the content failed, the last chunk carries the Error and no Data.
+type FileChunk struct {
+	Info   *FileInfo `json:"info,omitempty"`
+	Offset int64     `json:"offset"`
+	Data   []byte    `json:"data,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// DefaultFileChunkSize is the chunk size used by StreamReader when chunkSize is not positive
+const DefaultFileChunkSize = 16 * 1024
+
+// StreamReader reads r in chunks of chunkSize bytes and sends them on the returned channel.
+// The channel is unbuffered, so r is only read as fast as the chunks are sent to the client.
+// It is closed when r is read completely, reading failed or ctx is canceled.
+// Hub methods can return the channel to stream r to the client. Declare a context.Context
+// parameter in the hub method to get a ctx which is canceled when the client cancels the stream.
+// If r is an io.Closer, it is closed when the channel is closed.
+func StreamReader(ctx context.Context, r io.Reader, info FileInfo, chunkSize int) <-chan FileChunk {
+	if chunkSize <= 0 {
+		chunkSize = DefaultFileChunkSize
+	}
+	chunks := make(chan FileChunk)
+	go func() {
+		defer close(chunks)
+		if closer, ok := r.(io.Closer); ok {
+			defer func() { _ = closer.Close() }()
+		}
+		send := func(chunk FileChunk) bool {
+			select {
+			case chunks <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		var offset int64
+		first := true
+		for {
+			data := make([]byte, chunkSize)
+			n, err := io.ReadFull(r, data)
+			// An empty reader still gets one chunk with the FileInfo
+			if n > 0 || (first && err == io.EOF) {
+				chunk := FileChunk{Offset: offset, Data: data[:n]}
+				if first {
+					chunk.Info = &info
+					first = false
+				}
+				if !send(chunk) {
+					return
+				}
+				offset += int64(n)
+			}
+			switch err {
+			case nil:
+			case io.EOF, io.ErrUnexpectedEOF:
+				return
+			default:
+				send(FileChunk{Offset: offset, Error: err.Error()})
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+// ReceiveToWriter writes the data of the chunks to w until chunks is closed or ctx is canceled.
+// It returns the FileInfo sent with the first chunk and the number of bytes written.
+// Chunks have to arrive in order, a chunk with an unexpected Offset is an error.
+// Hub methods which take a <-chan FileChunk parameter can use it to receive uploads.
+func ReceiveToWriter(ctx context.Context, chunks <-chan FileChunk, w io.Writer) (info FileInfo, written int64, err error) {
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				return info, written, nil
+			}
+			if chunk.Info != nil {
+				info = *chunk.Info
+			}
+			if chunk.Error != "" {
+				return info, written, errors.New(chunk.Error)
+			}
+			if chunk.Offset != written {
+				return info, written, fmt.Errorf("file chunk at offset %v, expected %v", chunk.Offset, written)
+			}
+			n, err := w.Write(chunk.Data)
+			written += int64(n)
+			if err != nil {
+				return info, written, err
+			}
+		case <-ctx.Done():
+			return info, written, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+var fileStreamQueue = make(chan string, 20)
+
+type fileStreamHub struct {
+	Hub
+}
+
+func (f *fileStreamHub) Download(ctx context.Context, content string) <-chan FileChunk {
+	return StreamReader(ctx, strings.NewReader(content), FileInfo{Name: "test.txt", ContentType: "text/plain", Size: int64(len(content))}, 4)
+}
+
+// endlessReader reads zeros forever
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (f *fileStreamHub) EndlessDownload(ctx context.Context) <-chan FileChunk {
+	go func() {
+		<-ctx.Done()
+		fileStreamQueue <- "canceled"
+	}()
+	return StreamReader(ctx, endlessReader{}, FileInfo{}, 4)
+}
+
+func (f *fileStreamHub) Upload(ctx context.Context, chunks <-chan FileChunk) {
+	var buf bytes.Buffer
+	info, n, err := ReceiveToWriter(ctx, chunks, &buf)
+	fileStreamQueue <- fmt.Sprintf("%v %v %v %v", info.Name, n, err, buf.String())
+}
+
+var _ = Describe("FileStream", func() {
+	Context("When a hub method streams a reader", func() {
+		It("should send the content in chunks with the FileInfo in the first chunk", func() {
+			conn := connect(&fileStreamHub{})
+			conn.ClientSend(`{"type":4,"invocationId":"dl","target":"download","arguments":["0123456789"]}`)
+			var content []byte
+			for i := 0; ; i++ {
+				message := <-conn.received
+				if completion, ok := message.(completionMessage); ok {
+					Expect(completion.Error).To(Equal(""))
+					break
+				}
+				item := message.(streamItemMessage).Item.(map[string]interface{})
+				Expect(item["offset"]).To(Equal(float64(len(content))))
+				if i == 0 {
+					Expect(item["info"]).To(Equal(map[string]interface{}{"name": "test.txt", "contentType": "text/plain", "size": float64(10)}))
+				} else {
+					Expect(item["info"]).To(BeNil())
+				}
+				data, err := base64.StdEncoding.DecodeString(item["data"].(string))
+				Expect(err).To(BeNil())
+				content = append(content, data...)
+			}
+			Expect(string(content)).To(Equal("0123456789"))
+		})
+	})
+	Context("When the client cancels the stream", func() {
+		It("should cancel the context of the hub method", func() {
+			conn := connect(&fileStreamHub{})
+			conn.ClientSend(`{"type":4,"invocationId":"dl","target":"endlessdownload"}`)
+			Expect(<-conn.received).To(BeAssignableToTypeOf(streamItemMessage{}))
+			conn.ClientSend(`{"type":5,"invocationId":"dl"}`)
+			select {
+			case r := <-fileStreamQueue:
+				Expect(r).To(Equal("canceled"))
+			case <-time.After(2 * time.Second):
+				Fail("context not canceled")
+			}
+		})
+	})
+	Context("When the client uploads chunks", func() {
+		It("should write them to the writer", func() {
+			conn := connect(&fileStreamHub{})
+			conn.ClientSend(`{"type":1,"invocationId":"up","target":"upload","streamIds":["s"]}`)
+			conn.ClientSend(`{"type":2,"invocationId":"s","item":{"info":{"name":"up.txt"},"offset":0,"data":"` +
+				base64.StdEncoding.EncodeToString([]byte("Hello ")) + `"}}`)
+			conn.ClientSend(`{"type":2,"invocationId":"s","item":{"offset":6,"data":"` +
+				base64.StdEncoding.EncodeToString([]byte("World")) + `"}}`)
+			conn.ClientSend(`{"type":3,"invocationId":"s"}`)
+			Expect(<-fileStreamQueue).To(Equal("up.txt 11 <nil> Hello World"))
+		})
+		It("should fail on chunks out of order", func() {
+			conn := connect(&fileStreamHub{})
+			conn.ClientSend(`{"type":1,"invocationId":"up","target":"upload","streamIds":["s"]}`)
+			conn.ClientSend(`{"type":2,"invocationId":"s","item":{"offset":3,"data":"` +
+				base64.StdEncoding.EncodeToString([]byte("abc")) + `"}}`)
+			Expect(<-fileStreamQueue).To(Equal(" 0 file chunk at offset 3, expected 0 "))
+		})
+	})
+})
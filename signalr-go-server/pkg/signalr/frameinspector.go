@@ -0,0 +1,68 @@
+package signalr
+
+import "bytes"
+
+// FrameInspector sees the raw data of a connection on the transport layer, after the handshake.
+// Inbound() is called with the data read from the transport, before it is parsed. With websockets this is the
+// payload of one websocket message.
+// Outbound() is called with the serialized data of each hub message, before it is written to the transport.
+// Both return the data which should be used instead, so frames can be inspected, accounted or transformed,
+// e.g. compressed. If they return an error, the connection is closed.
+type FrameInspector interface {
+	Inbound(connectionID string, data []byte) ([]byte, error)
+	Outbound(connectionID string, data []byte) ([]byte, error)
+}
+
+// inspectedConnection passes the data read from and written to a Connection through the FrameInspectors
+type inspectedConnection struct {
+	Connection
+	inspectors []FrameInspector
+	pending    bytes.Reader
+}
+
+func newInspectedConnection(conn Connection, inspectors []FrameInspector) Connection {
+	if len(inspectors) == 0 {
+		return conn
+	}
+	return &inspectedConnection{Connection: conn, inspectors: inspectors}
+}
+
+func (i *inspectedConnection) Read(p []byte) (int, error) {
+	if i.pending.Len() == 0 {
+		buf := make([]byte, len(p))
+		n, err := i.Connection.Read(buf)
+		if n == 0 {
+			return 0, err
+		}
+		data := buf[:n]
+		for _, inspector := range i.inspectors {
+			var inspectErr error
+			if data, inspectErr = inspector.Inbound(i.ConnectionID(), data); inspectErr != nil {
+				return 0, inspectErr
+			}
+		}
+		i.pending.Reset(data)
+		if err != nil && i.pending.Len() == 0 {
+			return 0, err
+		}
+	}
+	return i.pending.Read(p)
+}
+
+func (i *inspectedConnection) Write(p []byte) (int, error) {
+	data := p
+	for _, inspector := range i.inspectors {
+		var err error
+		if data, err = inspector.Outbound(i.ConnectionID(), data); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := i.Connection.Write(data); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (i *inspectedConnection) RemoteAddr() string {
+	return remoteAddr(i.Connection)
+}
@@ -0,0 +1,54 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// renamingInspector renames the target "plus" to "simpleint" and records the outbound frames
+type renamingInspector struct {
+	outbound chan string
+}
+
+func (r *renamingInspector) Inbound(connectionID string, data []byte) ([]byte, error) {
+	if bytes.Contains(data, []byte("forbidden")) {
+		return nil, errors.New("forbidden frame")
+	}
+	return bytes.Replace(data, []byte(`"plus"`), []byte(`"simpleint"`), -1), nil
+}
+
+func (r *renamingInspector) Outbound(connectionID string, data []byte) ([]byte, error) {
+	r.outbound <- connectionID + " " + string(data)
+	return data, nil
+}
+
+var _ = Describe("FrameInspectors", func() {
+	Context("When a FrameInspector changes the inbound frames", func() {
+		It("should parse the changed data and pass the outbound frames to the inspector", func() {
+			inspector := &renamingInspector{outbound: make(chan string, 10)}
+			server, err := NewServer(SimpleHubFactory(&invocationHub{}), FrameInspectors(inspector))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "inspected"
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"plus","arguments":[1]}`)
+			Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+			Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "1", Result: float64(2)}))
+			Expect(<-inspector.outbound).To(Equal("inspected {\"type\":3,\"invocationId\":\"1\",\"result\":2}\n\u001e"))
+		})
+	})
+	Context("When a FrameInspector returns an error", func() {
+		It("should close the connection", func() {
+			inspector := &renamingInspector{outbound: make(chan string, 10)}
+			server, err := NewServer(SimpleHubFactory(&invocationHub{}), FrameInspectors(inspector))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"forbidden"}`)
+			Expect(<-conn.ReceiveChan()).To(BeAssignableToTypeOf(closeMessage{}))
+		})
+	})
+})
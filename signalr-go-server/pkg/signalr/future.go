@@ -0,0 +1,47 @@
+package signalr
+
+import "sync"
+
+// Future is the deferred single result of a hub method.
+// A hub method can return a *Future to hand off its work and complete the invocation later,
+// without blocking the processing of other messages on the connection.
+// The invocation is completed when Resolve() or Reject() is called.
+type Future struct {
+	done   chan struct{}
+	once   sync.Once
+	result interface{}
+	err    error
+}
+
+// NewFuture creates a new, not yet completed Future
+func NewFuture() *Future {
+	return &Future{done: make(chan struct{})}
+}
+
+// Resolve completes the Future with result. Only the first call of Resolve or Reject has an effect.
+func (f *Future) Resolve(result interface{}) {
+	f.once.Do(func() {
+		f.result = result
+		close(f.done)
+	})
+}
+
+// Reject completes the Future with err. The client receives a completion with the error message.
+// Only the first call of Resolve or Reject has an effect.
+func (f *Future) Reject(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.done)
+	})
+}
+
+// Done returns a channel which is closed when the Future is completed
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the Future to be completed and returns its result or error
+func (f *Future) Result() (interface{}, error) {
+	<-f.done
+	return f.result, f.err
+}
@@ -0,0 +1,197 @@
+package signalr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// GatewayAuthFunc authenticates the request of a client at the Gateway. It returns the headers which are sent
+// to the upstream server instead of the credentials of the client, e.g. a header with the verified user id.
+// If it returns an error, the request is answered with 401 Unauthorized.
+type GatewayAuthFunc func(req *http.Request) (http.Header, error)
+
+// Gateway is an http.Handler which accepts the connections of SignalR clients and forwards their hub traffic
+// to upstream SignalR servers, so the upstream servers can run in an internal network behind a thin edge tier.
+// The Gateway answers the negotiate requests itself and opens one websocket connection to an upstream server
+// for each client connection. The upstream servers of a hub are used round-robin.
+// The messages are forwarded unchanged, so all hub protocols are supported.
+type Gateway struct {
+	auth   GatewayAuthFunc
+	routes map[string]*gatewayRoute
+	mx     sync.RWMutex
+}
+
+type gatewayRoute struct {
+	upstreams []*url.URL
+	next      uint32
+}
+
+// NewGateway creates a Gateway without routes. auth might be nil, then all clients are accepted
+// and no headers are sent to the upstream servers.
+func NewGateway(auth GatewayAuthFunc) *Gateway {
+	return &Gateway{auth: auth, routes: make(map[string]*gatewayRoute)}
+}
+
+// Route forwards the connections to the hub at path to the upstreams, which are the http or https urls
+// of the hub on the upstream servers, e.g. http://10.0.0.1:8080/chat
+func (g *Gateway) Route(path string, upstreams ...string) error {
+	if path == "" || strings.HasSuffix(path, "/negotiate") {
+		return fmt.Errorf("invalid hub path %q", path)
+	}
+	if len(upstreams) == 0 {
+		return fmt.Errorf("no upstreams for hub path %q", path)
+	}
+	route := &gatewayRoute{}
+	for _, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return err
+		}
+		switch u.Scheme {
+		case "http":
+			u.Scheme = "ws"
+		case "https":
+			u.Scheme = "wss"
+		default:
+			return fmt.Errorf("upstream %q is not an http or https url", upstream)
+		}
+		route.upstreams = append(route.upstreams, u)
+	}
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.routes[path] = route
+	return nil
+}
+
+// ServeHTTP authenticates the client and serves the negotiate request or forwards the websocket connection
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.Path
+	negotiate := strings.HasSuffix(path, "/negotiate")
+	if negotiate {
+		path = strings.TrimSuffix(path, "/negotiate")
+	}
+	g.mx.RLock()
+	route, ok := g.routes[path]
+	g.mx.RUnlock()
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	header := make(http.Header)
+	if g.auth != nil {
+		var err error
+		if header, err = g.auth(req); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	if negotiate {
+		g.negotiate(w, req)
+		return
+	}
+	upstream := route.upstream(req)
+	websocket.Handler(func(ws *websocket.Conn) {
+		g.forward(ws, upstream, header)
+	}).ServeHTTP(w, req)
+}
+
+func (g *Gateway) negotiate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(400)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(negotiateResponse{
+		ConnectionID: getConnectionID(),
+		AvailableTransports: []availableTransport{
+			{
+				Transport:       "WebSockets",
+				TransferFormats: []string{"Text", "Binary"},
+			},
+		},
+	})
+}
+
+// upstream returns the url of the next upstream server with the query of the client request.
+// The access token of the client is not forwarded.
+func (r *gatewayRoute) upstream(req *http.Request) *url.URL {
+	next := atomic.AddUint32(&r.next, 1)
+	u := *r.upstreams[int(next-1)%len(r.upstreams)]
+	query := req.URL.Query()
+	query.Del("access_token")
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// forward copies the messages between the client and the upstream server until one of them closes the connection
+func (g *Gateway) forward(client *websocket.Conn, upstream *url.URL, header http.Header) {
+	origin := *upstream
+	origin.Scheme, origin.Path, origin.RawQuery = strings.Replace(origin.Scheme, "ws", "http", 1), "", ""
+	config, err := websocket.NewConfig(upstream.String(), origin.String())
+	if err != nil {
+		return
+	}
+	config.Header = header.Clone()
+	if host, _, err := net.SplitHostPort(client.Request().RemoteAddr); err == nil {
+		if prior := client.Request().Header.Get("X-Forwarded-For"); prior != "" {
+			host = prior + ", " + host
+		}
+		config.Header.Set("X-Forwarded-For", host)
+	}
+	server, err := websocket.DialConfig(config)
+	if err != nil {
+		_ = (&webSocketConnection{conn: client}).Close(CloseInternalError, "upstream not available")
+		return
+	}
+	done := make(chan struct{}, 2)
+	go copyFrames(server, client, done)
+	go copyFrames(client, server, done)
+	<-done
+	_ = server.Close()
+	_ = client.Close()
+	<-done
+}
+
+// frame is a websocket message with its payload type, so text and binary messages are forwarded as they are
+type frame struct {
+	data        []byte
+	payloadType byte
+}
+
+var frameCodec = websocket.Codec{
+	Marshal: func(v interface{}) ([]byte, byte, error) {
+		f, ok := v.(*frame)
+		if !ok {
+			return nil, 0, errors.New("not a frame")
+		}
+		return f.data, f.payloadType, nil
+	},
+	Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+		f, ok := v.(*frame)
+		if !ok {
+			return errors.New("not a frame")
+		}
+		f.data, f.payloadType = data, payloadType
+		return nil
+	},
+}
+
+func copyFrames(dst *websocket.Conn, src *websocket.Conn, done chan struct{}) {
+	defer func() { done <- struct{}{} }()
+	for {
+		var f frame
+		if err := frameCodec.Receive(src, &f); err != nil {
+			return
+		}
+		if err := frameCodec.Send(dst, &f); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package signalr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+)
+
+var _ = Describe("Gateway", func() {
+	startUpstream := func(users chan string) int {
+		router := http.NewServeMux()
+		_, err := MapHub(router, "/hub", &webSocketHub{},
+			HandshakeValidator(func(conn Connection, protocol string, version int) error {
+				req := conn.(HTTPConnection).Request()
+				users <- req.Header.Get("X-User") + " " + req.URL.Query().Get("access_token") + " " + req.Header.Get("X-Forwarded-For")
+				return nil
+			}))
+		Expect(err).To(BeNil())
+		port := freePort()
+		go func() {
+			_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+		}()
+		waitForPort(port)
+		return port
+	}
+	startGateway := func(upstreamPorts ...int) int {
+		gateway := NewGateway(func(req *http.Request) (http.Header, error) {
+			if req.URL.Query().Get("access_token") != "secret" {
+				return nil, errors.New("invalid token")
+			}
+			return http.Header{"X-User": []string{"alice"}}, nil
+		})
+		var upstreams []string
+		for _, port := range upstreamPorts {
+			upstreams = append(upstreams, fmt.Sprintf("http://127.0.0.1:%v/hub", port))
+		}
+		Expect(gateway.Route("/hub", upstreams...)).To(BeNil())
+		Expect(gateway.Route("/hub", "ftp://127.0.0.1/hub")).NotTo(BeNil())
+		port := freePort()
+		go func() {
+			_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), gateway)
+		}()
+		waitForPort(port)
+		return port
+	}
+	Context("When an authenticated client connects to the gateway", func() {
+		It("should forward the hub traffic to the upstream servers with the headers of the auth func", func() {
+			users1 := make(chan string, 10)
+			users2 := make(chan string, 10)
+			port := startGateway(startUpstream(users1), startUpstream(users2))
+			for i := 0; i < 2; i++ {
+				negotiateResponse := negotiateWebSocketTestServerWithQuery(port, "?access_token=secret")
+				Expect(negotiateResponse["connectionId"]).NotTo(BeEmpty())
+				handShakeAndCallWebSocketTestServerWithQuery(port, fmt.Sprint(negotiateResponse["connectionId"]), "&access_token=secret")
+			}
+			// Round-robin over both upstreams, the access token is not forwarded
+			Expect(<-users1).To(Equal("alice  127.0.0.1"))
+			Expect(<-users2).To(Equal("alice  127.0.0.1"))
+		})
+	})
+	Context("When a client is not authenticated", func() {
+		It("should reject the request", func() {
+			port := startGateway(startUpstream(make(chan string, 10)))
+			resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiate", port), "text/plain;charset=UTF-8", &bytes.Buffer{})
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			resp, err = http.Post(fmt.Sprintf("http://127.0.0.1:%v/other/negotiate?access_token=secret", port), "text/plain;charset=UTF-8", &bytes.Buffer{})
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+})
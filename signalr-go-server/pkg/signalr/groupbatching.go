@@ -0,0 +1,82 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+// groupBatcher collects the sends to a group during a window and sends them together,
+// so each member gets the burst with one write to its transport
+type groupBatcher struct {
+	window  time.Duration
+	mx      sync.Mutex
+	batches map[string][]*preparedInvocation
+}
+
+func newGroupBatcher(window time.Duration) *groupBatcher {
+	if window <= 0 {
+		return nil
+	}
+	return &groupBatcher{window: window, batches: make(map[string][]*preparedInvocation)}
+}
+
+// batched adds invocation to the batch of group and returns true. The first invocation of a batch starts the window,
+// at its end send is called with all invocations of the batch in the order they have been added.
+// If batching is disabled, batched returns false and the invocation should be sent now
+func (b *groupBatcher) batched(group string, invocation *preparedInvocation, send func(invocations []*preparedInvocation)) bool {
+	if b == nil {
+		return false
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	batch, ok := b.batches[group]
+	b.batches[group] = append(batch, invocation)
+	if !ok {
+		time.AfterFunc(b.window, func() {
+			b.mx.Lock()
+			invocations := b.batches[group]
+			delete(b.batches, group)
+			b.mx.Unlock()
+			send(invocations)
+		})
+	}
+	return true
+}
+
+// sendBatch sends the batch of invocations to the current members of group
+func (d *defaultHubLifetimeManager) sendBatch(group string, invocations []*preparedInvocation) {
+	if d.replayBuffer != nil {
+		for _, invocation := range invocations {
+			d.replayBuffer.Add(group, GroupMessage{Target: invocation.message.Target, Args: invocation.message.Arguments, Sent: time.Now()})
+		}
+	}
+	for _, conn := range receivers(d.groupMembers(group)) {
+		if err := conn.SendPreparedInvocations(context.Background(), invocations); err != nil {
+			_ = d.info.Log(evt, "send group batch", "group", group, "connectionId", conn.ConnectionID(), "error", err)
+		}
+	}
+}
+
+// SendPreparedInvocations sends the invocations with one write to the transport. With interceptors,
+// which might change each message for this connection, they are sent one by one
+func (c *defaultHubConnection) SendPreparedInvocations(ctx context.Context, invocations []*preparedInvocation) error {
+	if len(c.interceptors) > 0 {
+		for _, invocation := range invocations {
+			if _, err := c.SendPreparedInvocation(ctx, invocation); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, invocation := range invocations {
+		data, err := invocation.encode(c.protocol)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return c.writeMessageContext(ctx, encodedMessage(buf.Bytes()))
+}
@@ -0,0 +1,51 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+// burstCountingConnection records how many burst invocations each write to the transport contains
+type burstCountingConnection struct {
+	*testingConnection
+	bursts chan int
+}
+
+func (b *burstCountingConnection) Write(data []byte) (int, error) {
+	if n := bytes.Count(data, []byte(`"target":"burst"`)); n > 0 {
+		b.bursts <- n
+	}
+	return b.testingConnection.Write(data)
+}
+
+var _ = Describe("GroupSendBatching option", func() {
+	Context("When a burst of messages is sent to a group", func() {
+		It("should send the burst to each member with one write", func() {
+			server, err := NewServer(SimpleHubFactory(&groupHub{}), GroupSendBatching(50*time.Millisecond))
+			Expect(err).To(BeNil())
+			conn := &burstCountingConnection{testingConnection: newTestingConnection(), bursts: make(chan int, 10)}
+			conn.connectionID = "member"
+			go server.Run(context.TODO(), conn)
+			<-groupHubOnConnectMsg
+			server.Groups().AddToGroup("burst", "member")
+			for i := 0; i < 3; i++ {
+				Expect(server.lifetimeManager.InvokeGroup(context.TODO(), "burst", "burst", []interface{}{i})).To(BeNil())
+			}
+			Eventually(conn.bursts).Should(Receive(Equal(3)))
+			for i := 0; i < 3; i++ {
+				Expect(<-conn.ReceiveChan()).To(Equal(invocationMessage{Type: 1, Target: "burst", Arguments: []interface{}{float64(i)}}))
+			}
+			Expect(server.lifetimeManager.InvokeGroup(context.TODO(), "burst", "burst", []interface{}{3})).To(BeNil())
+			Eventually(conn.bursts).Should(Receive(Equal(1)))
+		})
+	})
+	Context("When the window is not positive", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&groupHub{}), GroupSendBatching(0))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,96 @@
+package signalr
+
+import "time"
+
+// GroupExpirationPolicy defines when a group expires.
+// TTL is the maximum lifetime of the group, counted from adding its first member.
+// MaxIdle is the maximum time without adding members or sending to the group.
+// Zero values mean no limit.
+type GroupExpirationPolicy struct {
+	TTL     time.Duration
+	MaxIdle time.Duration
+}
+
+// GroupExpirationFunc returns the GroupExpirationPolicy of a group. It is called when the group is created
+type GroupExpirationFunc func(groupName string) GroupExpirationPolicy
+
+type groupExpiry struct {
+	policy     GroupExpirationPolicy
+	created    time.Time
+	lastActive time.Time
+	timer      *time.Timer
+}
+
+func (g *groupExpiry) deadline() time.Time {
+	var deadline time.Time
+	if g.policy.TTL > 0 {
+		deadline = g.created.Add(g.policy.TTL)
+	}
+	if g.policy.MaxIdle > 0 {
+		if idle := g.lastActive.Add(g.policy.MaxIdle); deadline.IsZero() || idle.Before(deadline) {
+			deadline = idle
+		}
+	}
+	return deadline
+}
+
+// startExpiry starts the expiration of a new group. It must be called with groupsMx locked
+func (d *defaultHubLifetimeManager) startExpiry(groupName string, policy GroupExpirationPolicy) {
+	if policy.TTL <= 0 && policy.MaxIdle <= 0 {
+		return
+	}
+	if d.expiries == nil {
+		d.expiries = make(map[string]*groupExpiry)
+	}
+	now := time.Now()
+	expiry := &groupExpiry{policy: policy, created: now, lastActive: now}
+	expiry.timer = time.AfterFunc(time.Until(expiry.deadline()), func() {
+		d.expire(groupName, expiry)
+	})
+	d.expiries[groupName] = expiry
+}
+
+// stopExpiry stops the expiration of a deleted group. It must be called with groupsMx locked
+func (d *defaultHubLifetimeManager) stopExpiry(groupName string) {
+	if expiry, ok := d.expiries[groupName]; ok {
+		expiry.timer.Stop()
+		delete(d.expiries, groupName)
+	}
+}
+
+// touchGroup marks the group as active, which delays its expiration by MaxIdle
+func (d *defaultHubLifetimeManager) touchGroup(groupName string) {
+	defer d.groupsMx.Unlock()
+	d.groupsMx.Lock()
+	if expiry, ok := d.expiries[groupName]; ok {
+		expiry.lastActive = time.Now()
+	}
+}
+
+// expire removes all members of the group if its deadline has passed, else it waits for the new deadline
+func (d *defaultHubLifetimeManager) expire(groupName string, expiry *groupExpiry) {
+	d.groupsMx.Lock()
+	if d.expiries[groupName] != expiry {
+		// The group has been deleted in the meantime
+		d.groupsMx.Unlock()
+		return
+	}
+	if wait := time.Until(expiry.deadline()); wait > 0 {
+		expiry.timer.Reset(wait)
+		d.groupsMx.Unlock()
+		return
+	}
+	var connectionIDs []string
+	for connectionID := range d.groups[groupName] {
+		connectionIDs = append(connectionIDs, connectionID)
+	}
+	delete(d.groups, groupName)
+	delete(d.expiries, groupName)
+	d.groupsMx.Unlock()
+	for _, connectionID := range connectionIDs {
+		d.raiseGroupMembershipEvent(groupName, connectionID, GroupMemberExpired)
+	}
+	if d.groupExpired != nil {
+		d.groupExpired(groupName)
+	}
+}
@@ -1,17 +1,63 @@
 package signalr
 
+import (
+	"fmt"
+	"sync"
+)
+
 // GroupManager manages the client groups of the hub
 type GroupManager interface {
-	AddToGroup(groupName string, connectionID string)
+	AddToGroup(groupName string, connectionID string) error
 	RemoveFromGroup(groupName string, connectionID string)
 }
 
+// GroupJoinAuthorizerFunc decides if a connection may join a group.
+// items are the items of the connection, which typically hold the principal of the connection.
+// If the GroupJoinAuthorizerFunc returns an error, the connection is not added to the group.
+type GroupJoinAuthorizerFunc func(groupName string, connectionID string, items *sync.Map) error
+
+// GroupMembershipChange is the kind of change of a GroupMembershipEvent
+type GroupMembershipChange int
+
+const (
+	// GroupMemberAdded is raised when a connection has been added to a group
+	GroupMemberAdded GroupMembershipChange = iota
+	// GroupMemberRemoved is raised when a connection has been removed from a group by RemoveFromGroup
+	GroupMemberRemoved
+	// GroupMemberDisconnected is raised when a connection has been removed from a group because it disconnected
+	GroupMemberDisconnected
+	// GroupMemberExpired is raised when a connection has been removed from a group because the group expired
+	GroupMemberExpired
+)
+
+// GroupMembershipEvent describes the change of the membership of a connection in a group
+type GroupMembershipEvent struct {
+	GroupName    string
+	ConnectionID string
+	Change       GroupMembershipChange
+}
+
 type defaultGroupManager struct {
 	lifetimeManager HubLifetimeManager
+	server          *Server
 }
 
-func (d *defaultGroupManager) AddToGroup(groupName string, connectionID string) {
+func (d *defaultGroupManager) AddToGroup(groupName string, connectionID string) error {
+	if authorize := d.server.groupJoinAuthorizer; authorize != nil {
+		var items *sync.Map
+		if conn, ok := d.server.connection(connectionID); ok {
+			items = conn.Items()
+		} else {
+			items = &sync.Map{}
+		}
+		if err := authorize(groupName, connectionID, items); err != nil {
+			err = fmt.Errorf("connection %v not authorized to join group %v: %w", connectionID, groupName, err)
+			_ = d.server.info.Log(evt, "AddToGroup", "error", err, react, "do not add to group")
+			return err
+		}
+	}
 	d.lifetimeManager.AddToGroup(groupName, connectionID)
+	return nil
 }
 
 func (d *defaultGroupManager) RemoveFromGroup(groupName string, connectionID string) {
@@ -0,0 +1,73 @@
+package signalr
+
+import (
+	"sync"
+	"time"
+)
+
+// GroupMessage is an invocation which has been sent to a group
+type GroupMessage struct {
+	Target string
+	Args   []interface{}
+	Sent   time.Time
+}
+
+// GroupReplayBuffer retains the messages sent to groups.
+// When a connection joins a group, the retained messages of the group are replayed to it.
+// Add() stores a message which has been sent to the group
+// Messages() returns the retained messages of the group, oldest first
+type GroupReplayBuffer interface {
+	Add(groupName string, message GroupMessage)
+	Messages(groupName string) []GroupMessage
+}
+
+// NewMemoryGroupReplayBuffer creates a GroupReplayBuffer which retains the last maxMessages messages of each group
+// in memory, as long as they are not older than maxAge.
+// maxMessages 0 means no limit for the message count, maxAge 0 means no limit for the message age.
+func NewMemoryGroupReplayBuffer(maxMessages int, maxAge time.Duration) GroupReplayBuffer {
+	return &memoryGroupReplayBuffer{
+		maxMessages: maxMessages,
+		maxAge:      maxAge,
+		messages:    make(map[string][]GroupMessage),
+	}
+}
+
+type memoryGroupReplayBuffer struct {
+	maxMessages int
+	maxAge      time.Duration
+	messages    map[string][]GroupMessage
+	mx          sync.Mutex
+}
+
+func (m *memoryGroupReplayBuffer) Add(groupName string, message GroupMessage) {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	messages := append(m.messages[groupName], message)
+	if m.maxMessages > 0 && len(messages) > m.maxMessages {
+		messages = messages[len(messages)-m.maxMessages:]
+	}
+	m.messages[groupName] = m.dropExpired(messages)
+}
+
+func (m *memoryGroupReplayBuffer) Messages(groupName string) []GroupMessage {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	messages := m.dropExpired(m.messages[groupName])
+	if len(messages) == 0 {
+		delete(m.messages, groupName)
+		return nil
+	}
+	m.messages[groupName] = messages
+	return append([]GroupMessage(nil), messages...)
+}
+
+// dropExpired must be called with mx locked
+func (m *memoryGroupReplayBuffer) dropExpired(messages []GroupMessage) []GroupMessage {
+	if m.maxAge > 0 {
+		oldest := time.Now().Add(-m.maxAge)
+		for len(messages) > 0 && messages[0].Sent.Before(oldest) {
+			messages = messages[1:]
+		}
+	}
+	return messages
+}
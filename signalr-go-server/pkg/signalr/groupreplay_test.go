@@ -0,0 +1,33 @@
+package signalr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("MemoryGroupReplayBuffer", func() {
+	Context("When more than maxMessages are added", func() {
+		It("should retain only the last maxMessages", func() {
+			buffer := NewMemoryGroupReplayBuffer(2, 0)
+			for _, target := range []string{"a", "b", "c"} {
+				buffer.Add("group", GroupMessage{Target: target, Sent: time.Now()})
+			}
+			messages := buffer.Messages("group")
+			Expect(len(messages)).To(Equal(2))
+			Expect(messages[0].Target).To(Equal("b"))
+			Expect(messages[1].Target).To(Equal("c"))
+			Expect(buffer.Messages("other")).To(BeNil())
+		})
+	})
+	Context("When messages are older than maxAge", func() {
+		It("should not return them", func() {
+			buffer := NewMemoryGroupReplayBuffer(0, time.Minute)
+			buffer.Add("group", GroupMessage{Target: "old", Sent: time.Now().Add(-2 * time.Minute)})
+			buffer.Add("group", GroupMessage{Target: "new", Sent: time.Now()})
+			messages := buffer.Messages("group")
+			Expect(len(messages)).To(Equal(1))
+			Expect(messages[0].Target).To(Equal("new"))
+		})
+	})
+})
@@ -0,0 +1,21 @@
+package signalr
+
+import "fmt"
+
+// Handoff migrates the clients of the server to the server at targetURL, e.g. before this server is shut down
+// in a rolling deploy. From now on, negotiate requests are redirected to targetURL and all connections are
+// closed with the reconnect hint and targetURL in the close error, so the clients negotiate again and get redirected.
+// To let the groups and items of the connections follow the clients, all servers must share the ResumeStore
+// (see UseResumeStore) and the clients must reconnect with the resume token of their connection.
+// Handoff with an empty targetURL stops redirecting, but does not close connections.
+func (s *Server) Handoff(targetURL string) {
+	s.handoffMx.Lock()
+	s.handoffURL = targetURL
+	s.handoffMx.Unlock()
+	if targetURL == "" {
+		return
+	}
+	for _, conn := range s.localLifetimeManager.allConnections() {
+		conn.AbortWithError(fmt.Errorf("server handoff, reconnect to %v", targetURL))
+	}
+}
@@ -1,6 +1,9 @@
 package signalr
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // HubInterface is a hubs interface
 type HubInterface interface {
@@ -9,6 +12,13 @@ type HubInterface interface {
 	OnDisconnected(connectionID string)
 }
 
+// DisconnectErrorHandler can be implemented by a hub which needs to know why a connection ended,
+// e.g. to tell clients which left from clients which timed out. If the hub implements it, OnDisconnectedWithError
+// is called instead of OnDisconnected. err is the error which ended the connection, or nil if the client closed it.
+type DisconnectErrorHandler interface {
+	OnDisconnectedWithError(connectionID string, err error)
+}
+
 // Hub is a base class for hubs
 type Hub struct {
 	context HubContext
@@ -37,6 +47,71 @@ func (h *Hub) Items() *sync.Map {
 	return h.context.Items()
 }
 
+// Tags returns the tags of this connection
+func (h *Hub) Tags() *TagSet {
+	return h.context.Tags()
+}
+
+// RemoteAddr returns the address of the client of this connection, if the connection knows it
+func (h *Hub) RemoteAddr() string {
+	return h.context.RemoteAddr()
+}
+
+// DisconnectUser closes all connections of the user. The clients are not allowed to reconnect
+func (h *Hub) DisconnectUser(userID string, reason string) {
+	h.context.DisconnectUser(userID, reason)
+}
+
+// AbortConnection closes the connection with reason as close error, e.g. to kick a banned user.
+// allowReconnect tells the client if it may reconnect. It returns an error if the connection is not connected
+func (h *Hub) AbortConnection(connectionID string, reason string, allowReconnect bool) error {
+	return h.context.AbortConnection(connectionID, reason, allowReconnect)
+}
+
+// SendWithAck sends an invocation to the connection and resends it until the client acknowledges it
+func (h *Hub) SendWithAck(connectionID string, target string, args ...interface{}) *Delivery {
+	return h.context.SendWithAck(connectionID, target, args...)
+}
+
+// PauseReading stops reading messages from the connection, e.g. while an expensive upload is processed.
+// The client is not timed out while reading is paused
+func (h *Hub) PauseReading() {
+	h.context.PauseReading()
+}
+
+// ResumeReading resumes reading messages from the connection
+func (h *Hub) ResumeReading() {
+	h.context.ResumeReading()
+}
+
+// Drain stops sending broadcasts to the connection, waits up to timeout until its queued messages are sent
+// and closes it. The client is allowed to reconnect, e.g. to another server.
+func (h *Hub) Drain(connectionID string, timeout time.Duration) error {
+	return h.context.Drain(connectionID, timeout)
+}
+
+// Ping sends a ping to the connection and returns an error if the connection is not connected
+// or the ping could not be written, e.g. to check if a client is still there before an expensive operation
+func (h *Hub) Ping(connectionID string) error {
+	return h.context.Ping(connectionID)
+}
+
+// Features returns the optional features offered by the server which the client accepted in the handshake.
+// Clients which do not know the features accept none of them
+func (h *Hub) Features() []string {
+	return h.context.Features()
+}
+
+// Metadata returns the locale, time zone and app version the client sent at the handshake, see ConnectionMetadata
+func (h *Hub) Metadata() ConnectionMetadata {
+	return h.context.Metadata()
+}
+
+// Context returns the HubCallerContext of this connection, with its user, principal, transport and items
+func (h *Hub) Context() HubCallerContext {
+	return h.context.CallerContext()
+}
+
 // OnConnected is called when the hub is connected
 func (h *Hub) OnConnected(string) {}
 
@@ -0,0 +1,57 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type lifetimeHub struct {
+	Hub
+}
+
+type lifetimeEvent struct {
+	connectionID string
+	err          error
+}
+
+var lifetimeHubEvents = make(chan lifetimeEvent, 10)
+
+func (l *lifetimeHub) OnConnected(connectionID string) {
+	lifetimeHubEvents <- lifetimeEvent{connectionID: connectionID}
+}
+
+func (l *lifetimeHub) OnDisconnectedWithError(connectionID string, err error) {
+	lifetimeHubEvents <- lifetimeEvent{connectionID: connectionID, err: err}
+}
+
+func (l *lifetimeHub) Leave() {
+	l.context.Abort()
+}
+
+var _ = Describe("Hub", func() {
+	Context("When the hub implements DisconnectErrorHandler", func() {
+		It("should be told that the client closed the connection", func() {
+			server, err := NewServer(SimpleHubFactory(&lifetimeHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "closing"
+			go server.Run(context.TODO(), conn)
+			Expect(<-lifetimeHubEvents).To(Equal(lifetimeEvent{connectionID: "closing"}))
+			conn.ClientSend(`{"type":7}`)
+			Expect(<-lifetimeHubEvents).To(Equal(lifetimeEvent{connectionID: "closing"}))
+		})
+		It("should get the error which ended the connection", func() {
+			server, err := NewServer(SimpleHubFactory(&lifetimeHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "aborted"
+			go server.Run(context.TODO(), conn)
+			Expect(<-lifetimeHubEvents).To(Equal(lifetimeEvent{connectionID: "aborted"}))
+			conn.ClientSend(`{"type":1,"target":"leave"}`)
+			event := <-lifetimeHubEvents
+			Expect(event.connectionID).To(Equal("aborted"))
+			Expect(event.err).To(MatchError("connection aborted from hub"))
+		})
+	})
+})
@@ -0,0 +1,74 @@
+package signalr
+
+import (
+	"context"
+	"sync"
+)
+
+// HubCallerContext describes the connection which called a hub method, like HubCallerContext in ASP.NET Core.
+// ConnectionID() gets the ID of the connection
+// UserIdentifier() gets the user ID of the connection, see UserIDProvider
+// Principal() gets the principal of the authenticated connection, e.g. the claims returned by a TokenAuthenticatorFunc.
+// It is nil if the connection has not been authenticated and replaced when the client reauthenticates, see Reauthentication
+// Transport() gets the transport of the connection, e.g. "WebSockets", or "" if the connection does not know it
+// Items() holds key/value pairs scoped to the connection. The map is safe for concurrent use
+// Abort() aborts the connection
+type HubCallerContext interface {
+	ConnectionID() string
+	UserIdentifier() string
+	Principal() interface{}
+	Transport() string
+	Items() *sync.Map
+	Abort()
+}
+
+// ConnectionTransport can be implemented by a Connection which knows the name of its transport.
+// Transport() returns the name as used in the negotiation, e.g. "WebSockets"
+type ConnectionTransport interface {
+	Transport() string
+}
+
+// hubCaller holds the parts of the HubCallerContext of a connection which are known when it is established
+type hubCaller struct {
+	principal interface{}
+	transport string
+}
+
+// hubCallerKey is the key of the hubCaller in the Items of a connection
+type hubCallerKey struct{}
+
+// newHubCaller gets the principal from ctx or the request of conn and the transport from conn
+func newHubCaller(ctx context.Context, conn Connection) hubCaller {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		principal, _ = ConnectionPrincipal(conn)
+	}
+	var transport string
+	if t, ok := conn.(ConnectionTransport); ok {
+		transport = t.Transport()
+	}
+	return hubCaller{principal: principal, transport: transport}
+}
+
+func hubCallerFromItems(items *sync.Map) hubCaller {
+	if caller, ok := items.Load(hubCallerKey{}); ok {
+		return caller.(hubCaller)
+	}
+	return hubCaller{}
+}
+
+func (c *connectionHubContext) CallerContext() HubCallerContext {
+	return c
+}
+
+func (c *connectionHubContext) UserIdentifier() string {
+	return c.connection.UserID()
+}
+
+func (c *connectionHubContext) Principal() interface{} {
+	return hubCallerFromItems(c.connection.Items()).principal
+}
+
+func (c *connectionHubContext) Transport() string {
+	return hubCallerFromItems(c.connection.Items()).transport
+}
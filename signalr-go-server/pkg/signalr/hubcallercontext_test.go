@@ -0,0 +1,79 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type callerHub struct {
+	Hub
+}
+
+func (c *callerHub) Describe() string {
+	caller := c.Context()
+	return fmt.Sprintf("%v %v %v %v", caller.ConnectionID(), caller.UserIdentifier(), caller.Principal(), caller.Transport())
+}
+
+func (c *callerHub) Remember(value string) {
+	c.Context().Items().Store("remembered", value)
+}
+
+func (c *callerHub) Recall() string {
+	value, _ := c.Context().Items().Load("remembered")
+	return fmt.Sprint(value)
+}
+
+func (c *callerHub) Leave() {
+	c.Context().Abort()
+}
+
+type transportTestingConnection struct {
+	*testingConnection
+}
+
+func (t *transportTestingConnection) Transport() string {
+	return "LongPolling"
+}
+
+var _ = Describe("HubCallerContext", func() {
+	Context("When a hub method gets its caller context", func() {
+		It("should describe the calling connection", func() {
+			server, err := NewServer(SimpleHubFactory(&callerHub{}),
+				UserIDProvider(func(conn Connection) string { return "user-" + conn.ConnectionID() }))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "caller"
+			ctx := context.WithValue(context.TODO(), principalContextKey{}, "alice")
+			go server.Run(ctx, &transportTestingConnection{conn})
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"describe"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "caller user-caller alice LongPolling"})))
+		})
+	})
+	Context("When the connection is not authenticated and does not know its transport", func() {
+		It("should have no principal and no transport", func() {
+			server, err := NewServer(SimpleHubFactory(&callerHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "anonymous"
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"describe"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "anonymous  <nil> "})))
+		})
+	})
+	Context("When hub methods use the items and abort", func() {
+		It("should keep the items between invocations and close the connection", func() {
+			server, err := NewServer(SimpleHubFactory(&callerHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"remember","arguments":["blue"]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1"})))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"recall"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Result: "blue"})))
+			conn.ClientSend(`{"type":1,"invocationId":"3","target":"leave"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(BeAssignableToTypeOf(closeMessage{})))
+		})
+	})
+})
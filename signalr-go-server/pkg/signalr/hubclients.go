@@ -1,15 +1,31 @@
 package signalr
 
+import "context"
+
 // HubClients gives the hub access to various client groups
 // All() gets a ClientProxy that can be used to invoke methods on all clients connected to the hub
 // Caller() gets a ClientProxy that can be used to invoke methods of the current calling client
+// Others() gets a ClientProxy that can be used to invoke methods on all clients connected to the hub except the current calling client
 // Client() gets a ClientProxy that can be used to invoke methods on the specified client connection
 // Group() gets a ClientProxy that can be used to invoke methods on all connections in the specified group
+// User() gets a ClientProxy that can be used to invoke methods on all connections of the specified user, see UserIDProvider
+// Tagged() gets a ClientProxy that can be used to invoke methods on all connections whose tags match the expression.
+// Expressions combine tags with "&&", "||", "!" and parentheses, e.g. "platform:ios && !plan:free".
+// Send() ignores invalid expressions, SendContext() and SendDurable() return an error for them
+// SendToConnections() sends an invocation to the connections with the specified ids, for targeted pushes to many
+// connections. The invocation is encoded only once per protocol and sent to several connections in parallel,
+// see BulkSendParallelism. It returns the result for each id, which is nil if the invocation has been sent.
+// SendToConnectionsContext() stops sending when ctx is done. The connections not sent to get the error of ctx.
 type HubClients interface {
 	All() ClientProxy
 	Caller() ClientProxy
+	Others() ClientProxy
 	Client(connectionID string) ClientProxy
 	Group(groupName string) ClientProxy
+	User(userID string) ClientProxy
+	Tagged(expression string) ClientProxy
+	SendToConnections(connectionIDs []string, target string, args ...interface{}) map[string]error
+	SendToConnectionsContext(ctx context.Context, connectionIDs []string, target string, args ...interface{}) map[string]error
 }
 
 type defaultHubClients struct {
@@ -29,6 +45,14 @@ func (c *defaultHubClients) Group(groupName string) ClientProxy {
 	return &groupClientProxy{groupName: groupName, lifetimeManager: c.lifetimeManager}
 }
 
+func (c *defaultHubClients) User(userID string) ClientProxy {
+	return &userClientProxy{userID: userID, lifetimeManager: c.lifetimeManager}
+}
+
+func (c *defaultHubClients) Tagged(expression string) ClientProxy {
+	return &taggedClientProxy{expression: expression, lifetimeManager: c.lifetimeManager}
+}
+
 type callerHubClients struct {
 	defaultHubClients *defaultHubClients
 	connectionID      string
@@ -42,6 +66,10 @@ func (c *callerHubClients) Caller() ClientProxy {
 	return c.defaultHubClients.Client(c.connectionID)
 }
 
+func (c *callerHubClients) Others() ClientProxy {
+	return &allExceptClientProxy{excluded: c.connectionID, lifetimeManager: c.defaultHubClients.lifetimeManager}
+}
+
 func (c *callerHubClients) Client(connectionID string) ClientProxy {
 	return c.defaultHubClients.Client(connectionID)
 }
@@ -49,3 +77,27 @@ func (c *callerHubClients) Client(connectionID string) ClientProxy {
 func (c *callerHubClients) Group(groupName string) ClientProxy {
 	return c.defaultHubClients.Group(groupName)
 }
+
+func (c *callerHubClients) User(userID string) ClientProxy {
+	return c.defaultHubClients.User(userID)
+}
+
+func (c *callerHubClients) Tagged(expression string) ClientProxy {
+	return c.defaultHubClients.Tagged(expression)
+}
+
+func (c *defaultHubClients) SendToConnections(connectionIDs []string, target string, args ...interface{}) map[string]error {
+	return c.lifetimeManager.InvokeConnections(context.Background(), connectionIDs, target, args)
+}
+
+func (c *defaultHubClients) SendToConnectionsContext(ctx context.Context, connectionIDs []string, target string, args ...interface{}) map[string]error {
+	return c.lifetimeManager.InvokeConnections(ctx, connectionIDs, target, args)
+}
+
+func (c *callerHubClients) SendToConnections(connectionIDs []string, target string, args ...interface{}) map[string]error {
+	return c.defaultHubClients.SendToConnections(connectionIDs, target, args...)
+}
+
+func (c *callerHubClients) SendToConnectionsContext(ctx context.Context, connectionIDs []string, target string, args ...interface{}) map[string]error {
+	return c.defaultHubClients.SendToConnectionsContext(ctx, connectionIDs, target, args...)
+}
@@ -3,51 +3,133 @@ package signalr
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type hubConnection interface {
 	Start()
 	IsConnected() bool
 	ConnectionID() string
+	UserID() string
+	RemoteAddr() string
 	Receive() (interface{}, error)
-	SendInvocation(target string, args ...interface{}) (invocationMessage, error)
+	SendInvocation(ctx context.Context, target string, args ...interface{}) (invocationMessage, error)
+	SendInvocationWithID(ctx context.Context, invocationID string, target string, args ...interface{}) (invocationMessage, error)
+	SendInvocationWithStreams(ctx context.Context, invocationID string, target string, streamIds []string, args ...interface{}) (invocationMessage, error)
+	SendStreamInvocation(ctx context.Context, invocationID string, target string, args ...interface{}) (invocationMessage, error)
+	SendPreparedInvocation(ctx context.Context, invocation *preparedInvocation) (invocationMessage, error)
+	SendPreparedInvocations(ctx context.Context, invocations []*preparedInvocation) error
 	StreamItem(id string, item interface{}) (streamItemMessage, error)
 	Completion(id string, result interface{}, error string) (completionMessage, error)
+	CancelInvocation(id string) (cancelInvocationMessage, error)
 	Close(error string, allowReconnect bool) (closeMessage, error)
-	Ping() (hubMessage, error)
+	HandshakeResponse(error string) error
+	Ping(withTimestamp bool) (pingMessage, error)
+	RoundTripTime() time.Duration
+	LastStreamItemSent() time.Time
+	Stats() ConnectionStats
+	AddPendingInvocations(delta int64)
+	PendingInvocations() int64
 	Items() *sync.Map
+	Tags() *TagSet
 	Abort()
+	AbortWithError(err error)
 	Aborted() <-chan error
+	PauseReading()
+	ResumeReading()
+	ReadingPaused() bool
+	Drain()
+	Draining() bool
+	SetQuarantined(quarantined bool)
+	Quarantined() bool
+	Flush(ctx context.Context) error
+	SetFeatures(features []string)
+	Features() []string
+	SetClock(clock Clock)
+	SetResumeToken(resumeToken string)
+	ResumeToken() string
+	KeepUnsent()
+	Unsent() []interface{}
+	Requeue(messages []interface{}) error
+	TraceInvocation(invocationID string, headers map[string]string)
 }
 
-func newHubConnection(parentContext context.Context, connection Connection, protocol HubProtocol, maximumReceiveMessageSize uint) hubConnection {
-	return &defaultHubConnection{
+func newHubConnection(parentContext context.Context, connection Connection, protocol HubProtocol, maximumReceiveMessageSize uint,
+	userID string, onPanic func(err *PanicError), interceptors ...MessageInterceptor) hubConnection {
+	c := &defaultHubConnection{
+		userID:                    userID,
+		onPanic:                   onPanic,
+		interceptors:              interceptors,
 		protocol:                  protocol,
 		connection:                connection,
 		maximumReceiveMessageSize: maximumReceiveMessageSize,
 		items:                     &sync.Map{},
+		tags:                      &TagSet{},
 		context:                   parentContext,
 		aborted:                   make(chan error, 1),
+		priorityQueue:             make(chan sendRequest, 16),
+		normalQueue:               make(chan sendRequest, 16),
+		sendLoopDone:              make(chan struct{}),
+		clock:                     systemClock{},
 	}
+	c.goSafe(c.sendLoop)
+	return c
 }
 
 type defaultHubConnection struct {
+	// pendingInvocations is accessed atomically, as first field it is 64 bit aligned
+	pendingInvocations        int64
 	protocol                  HubProtocol
 	mx                        sync.Mutex
 	connected                 bool
 	aborted                   chan error
 	connection                Connection
+	userID                    string
+	onPanic                   func(err *PanicError)
 	maximumReceiveMessageSize uint
+	receiveBuf                bytes.Buffer
+	readBuf                   []byte
 	items                     *sync.Map
+	tags                      *TagSet
 	context                   context.Context
+	pingSent                  time.Time
+	roundTripTime             time.Duration
+	interceptors              []MessageInterceptor
+	priorityQueue             chan sendRequest
+	normalQueue               chan sendRequest
+	sendLoopDone              chan struct{}
+	traffic                   connectionTraffic
+	lastStreamItemSent        time.Time
+	// readResumed is closed when reading is resumed, it is nil while reading is not paused
+	readResumed chan struct{}
+	draining    bool
+	quarantined bool
+	// features are the optional features the client accepted in the handshake
+	features []string
+	// clock stamps lastStreamItemSent, so it can be compared with the time of the server
+	clock Clock
+	// resumeToken is the secret resume token the server issued for the connection in the handshake
+	resumeToken string
+	// keepUnsent tells the sendLoop to keep the messages which are still queued when it ends in unsent
+	keepUnsent bool
+	unsent     []interface{}
+	// traces holds the trace headers of the running invocations by invocation id
+	traces sync.Map
 }
 
 func (c *defaultHubConnection) Items() *sync.Map {
 	return c.items
 }
 
+func (c *defaultHubConnection) Tags() *TagSet {
+	return c.tags
+}
+
 func (c *defaultHubConnection) Start() {
 	defer c.mx.Unlock()
 	c.mx.Lock()
@@ -69,67 +151,264 @@ func (c *defaultHubConnection) Close(error string, allowReconnect bool) (closeMe
 	return closeMessage, c.writeMessage(closeMessage)
 }
 
+// HandshakeResponse sends a handshake response with the error. Handshake responses are JSON text in all protocols
+func (c *defaultHubConnection) HandshakeResponse(error string) error {
+	// json.Marshal of a string does not fail
+	errMsg, _ := json.Marshal(error)
+	return c.writeMessage(encodedMessage(fmt.Sprintf("{\"error\":%s}\u001e", errMsg)))
+}
+
 func (c *defaultHubConnection) ConnectionID() string {
 	return c.connection.ConnectionID()
 }
 
+func (c *defaultHubConnection) RemoteAddr() string {
+	return remoteAddr(c.connection)
+}
+
+func (c *defaultHubConnection) UserID() string {
+	return c.userID
+}
+
 func (c *defaultHubConnection) Abort() {
+	c.AbortWithError(errors.New("connection aborted from hub"))
+}
+
+func (c *defaultHubConnection) AbortWithError(err error) {
 	defer c.mx.Unlock()
 	c.mx.Lock()
 	if c.connected {
-		c.aborted <- errors.New("connection aborted from hub")
+		c.aborted <- err
 		c.connected = false
 	}
 }
 
+// goSafe runs f in a new goroutine. A panic in f is reported to onPanic and aborts the connection
+func (c *defaultHubConnection) goSafe(f func()) {
+	goSafe(c.connection.ConnectionID(), f, func(err *PanicError) {
+		if c.onPanic != nil {
+			c.onPanic(err)
+		}
+		c.AbortWithError(err)
+	})
+}
+
 func (c *defaultHubConnection) Aborted() <-chan error {
 	return c.aborted
 }
 
+// PauseReading stops reading from the connection after the message which is currently received
+func (c *defaultHubConnection) PauseReading() {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	if c.readResumed == nil {
+		c.readResumed = make(chan struct{})
+	}
+}
+
+func (c *defaultHubConnection) ResumeReading() {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	if c.readResumed != nil {
+		close(c.readResumed)
+		c.readResumed = nil
+	}
+}
+
+func (c *defaultHubConnection) ReadingPaused() bool {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.readResumed != nil
+}
+
+// Drain marks the connection as draining, so it gets no more broadcasts
+func (c *defaultHubConnection) Drain() {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.draining = true
+}
+
+func (c *defaultHubConnection) Draining() bool {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.draining
+}
+
+// SetQuarantined puts the connection into quarantine or releases it, see Server.Quarantine
+func (c *defaultHubConnection) SetQuarantined(quarantined bool) {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.quarantined = quarantined
+}
+
+func (c *defaultHubConnection) Quarantined() bool {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.quarantined
+}
+
+// SetFeatures sets the features the client accepted in the handshake
+func (c *defaultHubConnection) SetFeatures(features []string) {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.features = features
+}
+
+func (c *defaultHubConnection) Features() []string {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.features
+}
+
+// SetClock sets the Clock which stamps LastStreamItemSent. Default is the system clock
+func (c *defaultHubConnection) SetClock(clock Clock) {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.clock = clock
+}
+
+// SetResumeToken sets the resume token the server issued for the connection
+func (c *defaultHubConnection) SetResumeToken(resumeToken string) {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.resumeToken = resumeToken
+}
+
+func (c *defaultHubConnection) ResumeToken() string {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.resumeToken
+}
+
+// KeepUnsent lets the connection keep the messages which are still queued when it is closed, see Unsent
+func (c *defaultHubConnection) KeepUnsent() {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	c.keepUnsent = true
+}
+
+// Unsent waits until the connection is closed and returns the messages which were queued but never written.
+// It returns nothing if KeepUnsent has not been called.
+func (c *defaultHubConnection) Unsent() []interface{} {
+	<-c.sendLoopDone
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.unsent
+}
+
+// Requeue queues messages which were taken from another connection by Unsent and waits until they are written.
+// The messages have already passed the interceptors, so they are written unchanged.
+func (c *defaultHubConnection) Requeue(messages []interface{}) error {
+	for _, message := range messages {
+		if err := c.enqueue(context.Background(), c.normalQueue, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TraceInvocation sets the headers which are sent with the stream items and the completion of the invocation
+func (c *defaultHubConnection) TraceInvocation(invocationID string, headers map[string]string) {
+	c.traces.Store(invocationID, headers)
+}
+
+// invocationHeaders returns the trace headers of the invocation. The completion ends the invocation, so they are removed then
+func (c *defaultHubConnection) invocationHeaders(invocationID string, completed bool) map[string]string {
+	headers, ok := c.traces.Load(invocationID)
+	if !ok {
+		return nil
+	}
+	if completed {
+		c.traces.Delete(invocationID)
+	}
+	return headers.(map[string]string)
+}
+
+// flushMarker is queued by Flush. When the sendLoop takes it, all messages queued before have been written
+type flushMarker struct{}
+
+// Flush waits until all messages queued so far have been written
+func (c *defaultHubConnection) Flush(ctx context.Context) error {
+	request := sendRequest{message: flushMarker{}, result: make(chan error, 1)}
+	select {
+	case c.normalQueue <- request:
+	case <-c.sendLoopDone:
+		return errors.New("connection closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-request.result:
+		return err
+	case <-c.sendLoopDone:
+		return errors.New("connection closed")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (c *defaultHubConnection) Receive() (interface{}, error) {
+	c.mx.Lock()
+	readResumed := c.readResumed
+	c.mx.Unlock()
+	if readResumed != nil {
+		select {
+		case <-readResumed:
+		case <-c.context.Done():
+			return nil, c.context.Err()
+		}
+	}
 	if !c.IsConnected() {
 		return nil, c.context.Err()
 	}
 	m := make(chan interface{}, 1)
 	e := make(chan error, 1)
-	go func() {
-		var buf bytes.Buffer
-		var data = make([]byte, c.maximumReceiveMessageSize)
-		var n int
+	c.goSafe(func() {
+		if c.readBuf == nil {
+			c.readBuf = make([]byte, c.maximumReceiveMessageSize)
+		}
 		for {
-			if message, complete, err := c.protocol.ReadMessage(&buf); !complete {
-				// Partial message, need more data
-				// ReadMessage read data out of the buf, so its gone there: refill
-				buf.Write(data[:n])
-				nc := make(chan int)
-				e2 := make(chan error)
-				go func() {
-					if n, err = c.connection.Read(data); err == nil {
-						buf.Write(data[:n])
-						nc <- n
-					} else {
-						e2 <- err
-					}
-				}()
-				select {
-				case n = <-nc:
-				case err = <-e2:
-					c.Abort()
-					e <- err
-					m <- nil
-					return
-				case <-c.context.Done():
-					e <- c.context.Err()
-					m <- nil
-					return
+			// receiveBuf keeps data of the following messages when a read returned more than one message
+			if message, complete, err := c.protocol.ReadMessage(&c.receiveBuf); complete {
+				if err == nil {
+					c.measureRoundTripTime()
+					c.traffic.messagesReceived.add(time.Now(), 1)
 				}
-			} else {
 				m <- message
 				e <- err
 				return
 			}
+			// Partial message, need more data
+			if uint(c.receiveBuf.Len()) > c.maximumReceiveMessageSize {
+				e <- fmt.Errorf("message exceeds the maximum receive message size of %v bytes", c.maximumReceiveMessageSize)
+				m <- nil
+				return
+			}
+			nc := make(chan int, 1)
+			e2 := make(chan error, 1)
+			c.goSafe(func() {
+				if n, err := c.connection.Read(c.readBuf); err == nil {
+					nc <- n
+				} else {
+					e2 <- err
+				}
+			})
+			select {
+			case n := <-nc:
+				c.traffic.bytesReceived.add(time.Now(), int64(n))
+				c.receiveBuf.Write(c.readBuf[:n])
+			case err := <-e2:
+				c.Abort()
+				e <- err
+				m <- nil
+				return
+			case <-c.context.Done():
+				e <- c.context.Err()
+				m <- nil
+				return
+			}
 		}
-	}()
+	})
 	select {
 	case <-c.context.Done():
 		return nil, c.context.Err()
@@ -140,13 +419,58 @@ func (c *defaultHubConnection) Receive() (interface{}, error) {
 	}
 }
 
-func (c *defaultHubConnection) SendInvocation(target string, args ...interface{}) (invocationMessage, error) {
+func (c *defaultHubConnection) SendInvocation(ctx context.Context, target string, args ...interface{}) (invocationMessage, error) {
+	return c.SendInvocationWithID(ctx, "", target, args...)
+}
+
+func (c *defaultHubConnection) SendInvocationWithID(ctx context.Context, invocationID string, target string, args ...interface{}) (invocationMessage, error) {
+	return c.SendInvocationWithStreams(ctx, invocationID, target, nil, args...)
+}
+
+// SendInvocationWithStreams sends an invocation whose channel parameters get the items of the streams with streamIds
+func (c *defaultHubConnection) SendInvocationWithStreams(ctx context.Context, invocationID string, target string, streamIds []string, args ...interface{}) (invocationMessage, error) {
+	if args == nil {
+		// Clients expect an array, even if there are no arguments
+		args = make([]interface{}, 0)
+	}
 	var invocationMessage = invocationMessage{
-		Type:      1,
-		Target:    target,
-		Arguments: args,
+		Type:         1,
+		Target:       target,
+		InvocationID: invocationID,
+		Arguments:    args,
+		StreamIds:    streamIds,
+		Headers:      traceHeaders(ctx),
+	}
+	return invocationMessage, c.writeMessageContext(ctx, invocationMessage)
+}
+
+// SendStreamInvocation sends an invocation of a hub method which streams its results
+func (c *defaultHubConnection) SendStreamInvocation(ctx context.Context, invocationID string, target string, args ...interface{}) (invocationMessage, error) {
+	if args == nil {
+		args = make([]interface{}, 0)
+	}
+	var invocationMessage = invocationMessage{
+		Type:         4,
+		Target:       target,
+		InvocationID: invocationID,
+		Arguments:    args,
+		Headers:      traceHeaders(ctx),
+	}
+	return invocationMessage, c.writeMessageContext(ctx, invocationMessage)
+}
+
+// SendPreparedInvocation sends the invocation encoded by the protocol of the connection.
+// The encoding is shared with all other connections using the same protocol type.
+func (c *defaultHubConnection) SendPreparedInvocation(ctx context.Context, invocation *preparedInvocation) (invocationMessage, error) {
+	if len(c.interceptors) > 0 {
+		// Interceptors might change the message for this connection
+		return c.SendInvocation(ctx, invocation.message.Target, invocation.message.Arguments...)
 	}
-	return invocationMessage, c.writeMessage(invocationMessage)
+	data, err := invocation.encode(c.protocol)
+	if err != nil {
+		return invocation.message, err
+	}
+	return invocation.message, c.writeMessageContext(ctx, data)
 }
 
 func (c *defaultHubConnection) StreamItem(id string, item interface{}) (streamItemMessage, error) {
@@ -154,6 +478,7 @@ func (c *defaultHubConnection) StreamItem(id string, item interface{}) (streamIt
 		Type:         2,
 		InvocationID: id,
 		Item:         item,
+		Headers:      c.invocationHeaders(id, false),
 	}
 	return streamItemMessage, c.writeMessage(streamItemMessage)
 }
@@ -164,36 +489,220 @@ func (c *defaultHubConnection) Completion(id string, result interface{}, error s
 		InvocationID: id,
 		Result:       result,
 		Error:        error,
+		Headers:      c.invocationHeaders(id, true),
 	}
 	return completionMessage, c.writeMessage(completionMessage)
 }
 
-func (c *defaultHubConnection) Ping() (hubMessage, error) {
-	var pingMessage = hubMessage{
+func (c *defaultHubConnection) CancelInvocation(id string) (cancelInvocationMessage, error) {
+	var cancelInvocationMessage = cancelInvocationMessage{
+		Type:         5,
+		InvocationID: id,
+	}
+	return cancelInvocationMessage, c.writeMessage(cancelInvocationMessage)
+}
+
+func (c *defaultHubConnection) Ping(withTimestamp bool) (pingMessage, error) {
+	var pingMessage = pingMessage{
 		Type: 6,
 	}
+	if withTimestamp {
+		now := time.Now()
+		pingMessage.Timestamp = now.UnixNano() / int64(time.Millisecond)
+		c.mx.Lock()
+		if c.pingSent.IsZero() {
+			c.pingSent = now
+		}
+		c.mx.Unlock()
+	}
 	return pingMessage, c.writeMessage(pingMessage)
 }
 
+// RoundTripTime is the estimated round trip time, measured from sending a timestamped ping
+// until the next message of the client arrives. It is 0 as long as nothing has been measured.
+func (c *defaultHubConnection) RoundTripTime() time.Duration {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.roundTripTime
+}
+
+// LastStreamItemSent is the time the last stream item has been written to the connection
+func (c *defaultHubConnection) LastStreamItemSent() time.Time {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	return c.lastStreamItemSent
+}
+
+// Stats returns the round trip time and the traffic of the connection
+func (c *defaultHubConnection) Stats() ConnectionStats {
+	stats := c.traffic.stats(c.RoundTripTime())
+	stats.PendingInvocations = c.PendingInvocations()
+	return stats
+}
+
+// AddPendingInvocations adds delta to the number of invocations which have been dispatched but not yet returned
+func (c *defaultHubConnection) AddPendingInvocations(delta int64) {
+	atomic.AddInt64(&c.pendingInvocations, delta)
+}
+
+func (c *defaultHubConnection) PendingInvocations() int64 {
+	return atomic.LoadInt64(&c.pendingInvocations)
+}
+
+func (c *defaultHubConnection) measureRoundTripTime() {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	if !c.pingSent.IsZero() {
+		c.roundTripTime = time.Since(c.pingSent)
+		c.pingSent = time.Time{}
+	}
+}
+
 func (c *defaultHubConnection) writeMessage(message interface{}) error {
+	return c.writeMessageContext(context.Background(), message)
+}
+
+// writeMessageContext queues the message and waits until it is written.
+// If ctx is done before, it returns the error of ctx, but the message might be written anyway when it is already queued.
+func (c *defaultHubConnection) writeMessageContext(ctx context.Context, message interface{}) error {
 	_, isCloseMsg := message.(closeMessage)
 	if !c.IsConnected() &&
 		// Allow sending closeMessage when Aborted
 		(c.Aborted() == nil || !isCloseMsg) {
 		return c.context.Err()
 	}
-	e := make(chan error, 1)
-	go func() { e <- c.protocol.WriteMessage(message, c.connection) }()
+	message, ok := interceptOutbound(c.interceptors, c.ConnectionID(), message)
+	if !ok {
+		return nil
+	}
+	queue := c.normalQueue
+	if hasSendPriority(message) {
+		queue = c.priorityQueue
+	}
+	return c.enqueue(ctx, queue, message)
+}
+
+// enqueue puts the message in the queue and waits until it is written
+func (c *defaultHubConnection) enqueue(ctx context.Context, queue chan sendRequest, message interface{}) error {
+	request := sendRequest{message: message, result: make(chan error, 1)}
 	select {
+	case queue <- request:
+	case <-c.sendLoopDone:
+		// Nothing is sent anymore, stop senders like streams which check IsConnected
+		c.Abort()
+		return errors.New("connection closed")
 	case <-c.context.Done():
-		// Wait for WriteMessage to return
-		<-e
 		c.Abort()
 		return c.context.Err()
-	case err := <-e:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-request.result:
 		if err != nil {
 			c.Abort()
 		}
 		return err
+	case <-c.sendLoopDone:
+		c.Abort()
+		return errors.New("connection closed")
+	case <-c.context.Done():
+		c.Abort()
+		return c.context.Err()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type sendRequest struct {
+	message interface{}
+	result  chan error
+}
+
+// hasSendPriority tells if a message should be sent before all other messages,
+// so pings, completions and close messages are not starved behind stream items and invocations
+func hasSendPriority(message interface{}) bool {
+	switch message.(type) {
+	case pingMessage, completionMessage, closeMessage:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendLoop writes the queued messages to the connection, messages from the priorityQueue first.
+// It ends when the context is done or a close message has been sent.
+func (c *defaultHubConnection) sendLoop() {
+	defer func() {
+		c.keepQueued()
+		close(c.sendLoopDone)
+	}()
+	for {
+		var request sendRequest
+		select {
+		case request = <-c.priorityQueue:
+		default:
+			select {
+			case request = <-c.priorityQueue:
+			case request = <-c.normalQueue:
+			case <-c.context.Done():
+				return
+			}
+		}
+		if _, ok := request.message.(flushMarker); ok {
+			request.result <- nil
+			continue
+		}
+		writer := &countingWriter{writer: c.connection}
+		var err error
+		if data, ok := request.message.(encodedMessage); ok {
+			_, err = writer.Write(data)
+		} else {
+			err = c.protocol.WriteMessage(request.message, writer)
+		}
+		now := time.Now()
+		c.traffic.bytesSent.add(now, writer.n)
+		if err == nil {
+			c.traffic.messagesSent.add(now, 1)
+			if _, ok := request.message.(streamItemMessage); ok {
+				c.mx.Lock()
+				c.lastStreamItemSent = c.clock.Now()
+				c.mx.Unlock()
+			}
+		}
+		request.result <- err
+		if _, isCloseMsg := request.message.(closeMessage); isCloseMsg {
+			return
+		}
+	}
+}
+
+// keepQueued takes the messages which are still queued when the sendLoop ends into unsent, if keepUnsent is set.
+// Their senders get no error, as the messages can still be sent when the client reconnects.
+// Pings and close messages are dropped, they belong to the closed connection.
+func (c *defaultHubConnection) keepQueued() {
+	defer c.mx.Unlock()
+	c.mx.Lock()
+	if !c.keepUnsent {
+		return
+	}
+	for _, queue := range []chan sendRequest{c.priorityQueue, c.normalQueue} {
+	drain:
+		for {
+			select {
+			case request := <-queue:
+				switch request.message.(type) {
+				case pingMessage, closeMessage:
+				case flushMarker:
+					request.result <- errors.New("connection closed")
+					continue
+				default:
+					c.unsent = append(c.unsent, request.message)
+				}
+				request.result <- nil
+			default:
+				break drain
+			}
+		}
 	}
 }
@@ -0,0 +1,155 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+type gatedConnection struct {
+	gate    chan bool
+	written chan string
+}
+
+func (g *gatedConnection) Read([]byte) (n int, err error) {
+	select {}
+}
+
+func (g *gatedConnection) Write(p []byte) (n int, err error) {
+	<-g.gate
+	g.written <- string(p)
+	return len(p), nil
+}
+
+func (g *gatedConnection) ConnectionID() string {
+	return "gated"
+}
+
+func (g *gatedConnection) SetTimeout(time.Duration) {}
+
+func (g *gatedConnection) Timeout() time.Duration {
+	return 0
+}
+
+// chunkedConnection returns its data in reads of at most chunkSize bytes
+type chunkedConnection struct {
+	gatedConnection
+	data      []byte
+	chunkSize int
+}
+
+func (c *chunkedConnection) Read(p []byte) (n int, err error) {
+	if len(c.data) == 0 {
+		select {}
+	}
+	size := c.chunkSize
+	if size > len(c.data) {
+		size = len(c.data)
+	}
+	n = copy(p[:size], c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func newChunkedHubConnection(data string, chunkSize int, maximumReceiveMessageSize uint) hubConnection {
+	conn := &chunkedConnection{data: []byte(data), chunkSize: chunkSize}
+	protocol := &JSONHubProtocol{}
+	protocol.setDebugLogger(log.NewNopLogger())
+	hubConn := newHubConnection(context.TODO(), conn, protocol, maximumReceiveMessageSize, "", nil)
+	hubConn.Start()
+	return hubConn
+}
+
+var _ = Describe("HubConnection", func() {
+	Context("When a message is split across many reads", func() {
+		It("should receive the complete message", func() {
+			arg := strings.Repeat("x", 1000)
+			hubConn := newChunkedHubConnection(`{"type":1,"target":"t","arguments":["`+arg+`"]}`+"\u001e", 7, 1<<15)
+			message, err := hubConn.Receive()
+			Expect(err).To(BeNil())
+			Expect(message.(invocationMessage).Target).To(Equal("t"))
+			Expect(len(message.(invocationMessage).Arguments)).To(Equal(1))
+		})
+	})
+	Context("When one read contains several messages", func() {
+		It("should receive all messages", func() {
+			hubConn := newChunkedHubConnection(`{"type":1,"target":"a"}`+"\u001e"+`{"type":1,"target":"b"}`+"\u001e", 1<<10, 1<<15)
+			for _, target := range []string{"a", "b"} {
+				message, err := hubConn.Receive()
+				Expect(err).To(BeNil())
+				Expect(message.(invocationMessage).Target).To(Equal(target))
+			}
+		})
+	})
+	Context("When a message exceeds the maximum receive message size", func() {
+		It("should return an error", func() {
+			hubConn := newChunkedHubConnection(`{"type":1,"target":"`+strings.Repeat("x", 100)+`"}`+"\u001e", 16, 64)
+			_, err := hubConn.Receive()
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When stream items are queued before a completion", func() {
+		It("should send the completion before the queued stream items", func() {
+			conn := &gatedConnection{gate: make(chan bool), written: make(chan string, 20)}
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			hubConn := newHubConnection(context.TODO(), conn, protocol, 1<<15, "", nil)
+			hubConn.Start()
+			for i := 0; i < 5; i++ {
+				go func() { _, _ = hubConn.StreamItem("stream", 1) }()
+			}
+			// Wait until the stream items are queued
+			time.Sleep(50 * time.Millisecond)
+			go func() { _, _ = hubConn.Completion("simple", 1, "") }()
+			time.Sleep(50 * time.Millisecond)
+			completionIndex := -1
+			for i := 0; i < 6; i++ {
+				conn.gate <- true
+				if strings.HasPrefix(<-conn.written, `{"type":3`) {
+					completionIndex = i
+				}
+			}
+			// The first stream item might already be written when the completion is queued
+			Expect(completionIndex).To(BeNumerically("<=", 1))
+		})
+	})
+
+	Context("When messages are still queued when the connection is closed", func() {
+		It("should keep them as unsent", func() {
+			conn := &gatedConnection{gate: make(chan bool), written: make(chan string, 20)}
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			hubConn := newHubConnection(context.TODO(), conn, protocol, 1<<15, "", nil)
+			hubConn.KeepUnsent()
+			hubConn.Start()
+			errs := make(chan error, 3)
+			for _, target := range []string{"a", "b", "c"} {
+				target := target
+				go func() {
+					_, err := hubConn.SendInvocation(context.TODO(), target)
+					errs <- err
+				}()
+				// Keep the order of the queued invocations
+				time.Sleep(20 * time.Millisecond)
+			}
+			go func() { _, _ = hubConn.Close("", true) }()
+			time.Sleep(20 * time.Millisecond)
+			// "a" is written first, then the close message, which has priority
+			conn.gate <- true
+			conn.gate <- true
+			Expect(<-conn.written).To(ContainSubstring(`"target":"a"`))
+			Expect(<-conn.written).To(ContainSubstring(`"type":7`))
+			unsent := hubConn.Unsent()
+			Expect(unsent).To(HaveLen(2))
+			Expect(unsent[0].(invocationMessage).Target).To(Equal("b"))
+			Expect(unsent[1].(invocationMessage).Target).To(Equal("c"))
+			for i := 0; i < 3; i++ {
+				Expect(<-errs).To(BeNil())
+			}
+		})
+	})
+})
@@ -1,25 +1,52 @@
 package signalr
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // HubContext is a context abstraction for a hub
 // Clients() gets a HubClients that can be used to invoke methods on clients connected to the hub
 // Groups() gets a GroupManager that can be used to add and remove connections to named groups
 // Items() holds key/value pairs scoped to the hubs connection
+// Tags() holds the tags of the current connection, which can be addressed with Clients().Tagged()
 // ConnectionID() gets the ID of the current connection
+// RemoteAddr() gets the address of the client of the current connection, if the connection knows it
 // Abort() aborts the current connection
+// DisconnectUser() closes all connections of the specified user with reason as close error. The clients are not allowed to reconnect
+// AbortConnection() closes the specified connection with reason as close error and returns an error if it is not connected
+// SendWithAck() sends an invocation to the specified connection and resends it until the client acknowledges it
+// PauseReading() stops reading messages from the current connection until ResumeReading() is called
+// Drain() stops sending broadcasts to the specified connection, waits up to timeout until its queued messages are sent and closes it
+// Ping() sends a ping to the specified connection and returns an error if the connection is not connected or the ping could not be written
+// Features() gets the optional features offered by the server which the client of the current connection accepted in the handshake
+// Metadata() gets the locale, time zone and app version the client of the current connection sent at the handshake
+// CallerContext() gets the HubCallerContext of the current connection
 type HubContext interface {
 	Clients() HubClients
 	Groups() GroupManager
 	Items() *sync.Map
+	Tags() *TagSet
 	ConnectionID() string
+	RemoteAddr() string
 	Abort()
+	DisconnectUser(userID string, reason string)
+	AbortConnection(connectionID string, reason string, allowReconnect bool) error
+	SendWithAck(connectionID string, target string, args ...interface{}) *Delivery
+	PauseReading()
+	ResumeReading()
+	Drain(connectionID string, timeout time.Duration) error
+	Ping(connectionID string) error
+	Features() []string
+	Metadata() ConnectionMetadata
+	CallerContext() HubCallerContext
 }
 
 type connectionHubContext struct {
-	connection hubConnection
-	clients    HubClients
-	groups     GroupManager
+	connection      hubConnection
+	clients         HubClients
+	groups          GroupManager
+	lifetimeManager HubLifetimeManager
 }
 
 func (c *connectionHubContext) Clients() HubClients {
@@ -34,10 +61,54 @@ func (c *connectionHubContext) Items() *sync.Map {
 	return c.connection.Items()
 }
 
+func (c *connectionHubContext) Tags() *TagSet {
+	return c.connection.Tags()
+}
+
 func (c *connectionHubContext) ConnectionID() string {
 	return c.connection.ConnectionID()
 }
 
+func (c *connectionHubContext) RemoteAddr() string {
+	return c.connection.RemoteAddr()
+}
+
 func (c *connectionHubContext) Abort() {
 	c.connection.Abort()
 }
+
+func (c *connectionHubContext) DisconnectUser(userID string, reason string) {
+	c.lifetimeManager.DisconnectUser(userID, reason)
+}
+
+func (c *connectionHubContext) AbortConnection(connectionID string, reason string, allowReconnect bool) error {
+	return c.lifetimeManager.AbortConnection(connectionID, reason, allowReconnect)
+}
+
+func (c *connectionHubContext) SendWithAck(connectionID string, target string, args ...interface{}) *Delivery {
+	return c.lifetimeManager.InvokeClientWithAck(connectionID, target, args)
+}
+
+func (c *connectionHubContext) PauseReading() {
+	c.connection.PauseReading()
+}
+
+func (c *connectionHubContext) ResumeReading() {
+	c.connection.ResumeReading()
+}
+
+func (c *connectionHubContext) Drain(connectionID string, timeout time.Duration) error {
+	return c.lifetimeManager.Drain(connectionID, timeout)
+}
+
+func (c *connectionHubContext) Ping(connectionID string) error {
+	return c.lifetimeManager.Ping(connectionID)
+}
+
+func (c *connectionHubContext) Features() []string {
+	return c.connection.Features()
+}
+
+func (c *connectionHubContext) Metadata() ConnectionMetadata {
+	return ConnectionMetadataFromItems(c.connection.Items())
+}
@@ -8,6 +8,7 @@ import (
 	. "github.com/onsi/gomega"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -31,6 +32,11 @@ func (c *contextHub) CallCaller() {
 	hubContextInvocationQueue <- "CallCaller()"
 }
 
+func (c *contextHub) CallOthers() {
+	c.Clients().Others().Send("clientFunc")
+	hubContextInvocationQueue <- "CallOthers()"
+}
+
 func (c *contextHub) CallClient(connectionID string) {
 	c.Clients().Client(connectionID).Send("clientFunc")
 	hubContextInvocationQueue <- "CallClient()"
@@ -52,6 +58,27 @@ func (c *contextHub) CallGroup() {
 	hubContextInvocationQueue <- "CallGroup()"
 }
 
+func (c *contextHub) CallCallerAfter(cancel bool) {
+	scheduled := c.Clients().Caller().SendAfter(100*time.Millisecond, "clientFunc")
+	if cancel {
+		scheduled.Cancel()
+	}
+	hubContextInvocationQueue <- "CallCallerAfter()"
+}
+
+func (c *contextHub) CallCallerWithArgs(greeting string, secret string) {
+	c.Clients().Caller().Send("clientFunc", greeting, secret)
+	hubContextInvocationQueue <- "CallCallerWithArgs()"
+}
+
+func (c *contextHub) CallAllCanceled() string {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := c.Clients().All().SendContext(ctx, "clientFunc")
+	hubContextInvocationQueue <- "CallAllCanceled()"
+	return fmt.Sprint(err)
+}
+
 func (c *contextHub) AddItem(key string, value interface{}) {
 	c.Items().Store(key, value)
 	hubContextInvocationQueue <- "AddItem()"
@@ -163,6 +190,19 @@ var _ = Describe("HubContext", func() {
 		})
 	})
 
+	Context("Clients().Others()", func() {
+		It("should invoke all clients except the caller", func() {
+			conns := connectMany()
+			conns[0].ClientSend(`{"type":1,"target":"callothers"}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("CallOthers()"))
+			for _, conn := range conns[1:] {
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+					invocationMessage{Type: 1, Target: "clientFunc", Arguments: []interface{}{}})))
+			}
+			Consistently(conns[0].ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
 	Context("Clients().Client()", func() {
 		It("should invoke only the client which was addressed", func() {
 			conns := connectMany()
@@ -291,6 +331,82 @@ var _ = Describe("HubContext", func() {
 		})
 	})
 
+	Context("Clients().Caller().SendAfter()", func() {
+		It("should invoke the caller after the delay", func() {
+			conn := connect(&contextHub{})
+			<-hubContextOnConnectMsg
+			conn.ClientSend(`{"type":1,"invocationId": "123","target":"callcallerafter","arguments":[false]}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("CallCallerAfter()"))
+			Expect(<-conn.received).To(BeAssignableToTypeOf(completionMessage{}))
+			select {
+			case msg := <-conn.received:
+				Fail(fmt.Sprintf("received %v before delay", msg))
+			case <-time.After(50 * time.Millisecond):
+			}
+			select {
+			case msg := <-conn.received:
+				Expect(msg).To(BeAssignableToTypeOf(invocationMessage{}))
+				Expect(strings.ToLower(msg.(invocationMessage).Target)).To(Equal("clientfunc"))
+			case <-time.After(500 * time.Millisecond):
+				Fail("timed out")
+			}
+		})
+		It("should not invoke the caller when canceled", func() {
+			conn := connect(&contextHub{})
+			<-hubContextOnConnectMsg
+			conn.ClientSend(`{"type":1,"invocationId": "123","target":"callcallerafter","arguments":[true]}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("CallCallerAfter()"))
+			Expect(<-conn.received).To(BeAssignableToTypeOf(completionMessage{}))
+			select {
+			case msg := <-conn.received:
+				Fail(fmt.Sprintf("received %v after cancel", msg))
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	})
+
+	Context("Clients().All().SendContext()", func() {
+		It("should not invoke the clients when the context is canceled", func() {
+			conn := connect(&contextHub{})
+			<-hubContextOnConnectMsg
+			conn.ClientSend(`{"type":1,"invocationId": "123","target":"callallcanceled"}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("CallAllCanceled()"))
+			msg := <-conn.received
+			Expect(msg).To(BeAssignableToTypeOf(completionMessage{}))
+			Expect(msg.(completionMessage).Result).To(Equal(context.Canceled.Error()))
+			select {
+			case msg := <-conn.received:
+				Fail(fmt.Sprintf("received %v", msg))
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+
+	Context("InvocationTransformer", func() {
+		It("should transform the arguments per recipient", func() {
+			server, err := NewServer(SimpleHubFactory(&contextHub{}),
+				Logger(log.NewLogfmtLogger(os.Stderr), false),
+				InvocationTransformer("clientFunc", func(connectionID string, items *sync.Map, args []interface{}) []interface{} {
+					if _, ok := items.Load("admin"); !ok {
+						return args[:1]
+					}
+					return args
+				}),
+				InvocationTransformer("clientFunc", func(connectionID string, items *sync.Map, args []interface{}) []interface{} {
+					return append([]interface{}{strings.ToUpper(args[0].(string))}, args[1:]...)
+				}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			<-hubContextOnConnectMsg
+			conn.ClientSend(`{"type":1,"target":"callcallerwithargs","arguments":["hello","secret"]}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("CallCallerWithArgs()"))
+			msg := <-conn.received
+			Expect(msg).To(BeAssignableToTypeOf(invocationMessage{}))
+			Expect(msg.(invocationMessage).Arguments).To(Equal([]interface{}{"HELLO"}))
+		})
+	})
+
 	Context("Items()", func() {
 		It("should hold Items connection wise", func() {
 			conns := connectMany()
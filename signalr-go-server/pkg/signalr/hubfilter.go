@@ -0,0 +1,116 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+// HubInvocationContext describes the invocation of a hub method for a HubFilter.
+// Context is canceled when the invocation ends. Hub is the hub instance the method is invoked on.
+// Args are the arguments of the method, including injected parameters and the channels of client streams.
+// A filter may replace Args before it calls next, but each argument must be assignable to its parameter.
+type HubInvocationContext struct {
+	Context      context.Context
+	ConnectionID string
+	InvocationID string
+	Target       string
+	Hub          HubInterface
+	Args         []interface{}
+}
+
+// HubInvoker continues the invocation of a hub method with the next HubFilter or the method itself.
+// It returns the return values of the method
+type HubInvoker func(invocation *HubInvocationContext) ([]interface{}, error)
+
+// HubFilter is called around every invocation of a hub method, like the IHubFilter of ASP.NET Core SignalR.
+// It calls next to continue the invocation and returns its results, possibly changed. It can short-circuit the
+// invocation by returning without calling next. A returned error is sent to the client as completion error,
+// e.g. to reject unauthorized invocations, and the results are not sent then.
+// Invocations dispatched by an InvocationHandler are not filtered.
+type HubFilter func(invocation *HubInvocationContext, next HubInvoker) ([]interface{}, error)
+
+// invokeHubMethod calls method with in through the HubFilters of the server
+func (sl *serverLoop) invokeHubMethod(ctx context.Context, hub HubInterface, invocation invocationMessage,
+	method reflect.Value, in []reflect.Value) ([]reflect.Value, error) {
+	call := method.Call
+	if sl.server.resultCache.applies(invocation) {
+		call = sl.cachedCall(invocation.Target, call)
+	}
+	filters := sl.server.hubFilters
+	if len(filters) == 0 {
+		return call(in), nil
+	}
+	methodType := method.Type()
+	next := func(c *HubInvocationContext) ([]interface{}, error) {
+		if len(c.Args) != methodType.NumIn() {
+			return nil, fmt.Errorf("method %v expects %v arguments, filters passed %v", c.Target, methodType.NumIn(), len(c.Args))
+		}
+		args := make([]reflect.Value, len(c.Args))
+		for i, arg := range c.Args {
+			paramType := methodType.In(i)
+			if arg == nil {
+				args[i] = reflect.Zero(paramType)
+			} else if args[i] = reflect.ValueOf(arg); !args[i].Type().AssignableTo(paramType) {
+				return nil, fmt.Errorf("filters passed %T as argument %v of method %v, which expects %v", arg, i, c.Target, paramType)
+			}
+		}
+		return valuesToInterfaces(call(args)), nil
+	}
+	for i := len(filters) - 1; i >= 0; i-- {
+		filter, inner := filters[i], next
+		next = func(c *HubInvocationContext) ([]interface{}, error) {
+			return filter(c, inner)
+		}
+	}
+	results, err := next(&HubInvocationContext{
+		Context:      ctx,
+		ConnectionID: sl.hubConn.ConnectionID(),
+		InvocationID: invocation.InvocationID,
+		Target:       invocation.Target,
+		Hub:          hub,
+		Args:         valuesToInterfaces(in),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]reflect.Value, len(results))
+	for i, result := range results {
+		switch {
+		case result != nil:
+			out[i] = reflect.ValueOf(result)
+		case i < methodType.NumOut():
+			out[i] = reflect.Zero(methodType.Out(i))
+		default:
+			out[i] = reflect.ValueOf(&results[i]).Elem()
+		}
+	}
+	return out, nil
+}
+
+// cachedCall returns call with its results taken from and stored in the result cache.
+// It runs after the filters, so rejected invocations are never answered from the cache
+// and the key is built from the arguments the filters passed on
+func (sl *serverLoop) cachedCall(target string, call func([]reflect.Value) []reflect.Value) func([]reflect.Value) []reflect.Value {
+	return func(args []reflect.Value) []reflect.Value {
+		key, ok := sl.server.resultCache.key(target, args)
+		if !ok {
+			return call(args)
+		}
+		if result, ok := sl.server.resultCache.get(key, sl.server.clock.Now()); ok {
+			sl.server.statsD.count("cache.hits", 1, sl.server.statsDTarget(target))
+			return result
+		}
+		result := call(args)
+		sl.server.resultCache.put(key, target, result, sl.server.clock.Now())
+		return result
+	}
+}
+
+func valuesToInterfaces(values []reflect.Value) []interface{} {
+	interfaces := make([]interface{}, len(values))
+	for i, value := range values {
+		interfaces[i] = value.Interface()
+	}
+	return interfaces
+}
@@ -0,0 +1,95 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+)
+
+type filterHub struct {
+	Hub
+}
+
+func (f *filterHub) Add(a int, b int) int {
+	return a + b
+}
+
+func (f *filterHub) Secret() string {
+	return "secret"
+}
+
+var _ = Describe("HubFilters option", func() {
+	Context("When filters are added", func() {
+		It("should call them around the hub methods in the order they were added", func() {
+			calls := make(chan string, 10)
+			logging := func(invocation *HubInvocationContext, next HubInvoker) ([]interface{}, error) {
+				calls <- fmt.Sprintf("%v%v", invocation.Target, invocation.Args)
+				results, err := next(invocation)
+				calls <- fmt.Sprintf("%v done", invocation.Target)
+				return results, err
+			}
+			doubling := func(invocation *HubInvocationContext, next HubInvoker) ([]interface{}, error) {
+				if strings.ToLower(invocation.Target) == "add" {
+					invocation.Args[0] = invocation.Args[0].(int) * 2
+				}
+				return next(invocation)
+			}
+			server, err := NewServer(SimpleHubFactory(&filterHub{}), HubFilters(logging, doubling))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: float64(4)})))
+			Expect(calls).To(Receive(Equal("add[1 2]")))
+			Expect(calls).To(Receive(Equal("add done")))
+		})
+	})
+	Context("When a filter short-circuits the invocation", func() {
+		It("should send the error of the filter and not call the hub method", func() {
+			authorize := func(invocation *HubInvocationContext, next HubInvoker) ([]interface{}, error) {
+				if strings.ToLower(invocation.Target) == "secret" {
+					return nil, errors.New("not authorized")
+				}
+				return next(invocation)
+			}
+			server, err := NewServer(SimpleHubFactory(&filterHub{}), HubFilters(authorize))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"secret"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Error: "not authorized"})))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"add","arguments":[1,2]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Result: float64(3)})))
+		})
+	})
+	Context("When a filter changes the results or passes invalid arguments", func() {
+		It("should send the changed results or an error", func() {
+			wrap := func(invocation *HubInvocationContext, next HubInvoker) ([]interface{}, error) {
+				if strings.ToLower(invocation.Target) == "add" {
+					invocation.Args[1] = "two"
+					return next(invocation)
+				}
+				results, err := next(invocation)
+				return []interface{}{strings.ToUpper(results[0].(string))}, err
+			}
+			server, err := NewServer(SimpleHubFactory(&filterHub{}), HubFilters(wrap))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"secret"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "SECRET"})))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"add","arguments":[1,2]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2",
+				Error: "filters passed string as argument 1 of method add, which expects int"})))
+		})
+	})
+	Context("When a nil filter is added", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&filterHub{}), HubFilters(nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
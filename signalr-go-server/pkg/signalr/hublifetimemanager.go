@@ -1,24 +1,61 @@
 package signalr
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/go-kit/kit/log"
 	"sync"
+	"time"
 )
 
-// HubLifetimeManager is a lifetime manager abstraction for hub instances
+// HubConnection is a connection of the server as seen by a HubLifetimeManager
+type HubConnection = hubConnection
+
+// HubLifetimeManager is a lifetime manager abstraction for hub instances. Use UseHubLifetimeManager to replace
+// the in-process implementation, e.g. with one which routes the messages over a backplane to other servers
 // OnConnected() is called when a connection is started
 // OnDisconnected() is called when a connection is finished
 // InvokeAll() sends an invocation message to all hub connections
+// InvokeAllExcept() sends an invocation message to all hub connections except the connection with the excluded id
 // InvokeClient() sends an invocation message to a specified hub connection
+// InvokeConnections() sends an invocation message to the hub connections with the specified ids and returns the result per id
 // InvokeGroup() sends an invocation message to a specified group of hub connections
+// InvokeUser() sends an invocation message to all hub connections of a specified user
+// InvokeAllDurable(), InvokeAllExceptDurable(), InvokeClientDurable(), InvokeGroupDurable() and InvokeUserDurable()
+// store the invocation in the Outbox before sending it, so it is resent until the client acknowledges it
+// InvokeClientWithAck() sends an invocation message with invocation id to a specified hub connection
+// and resends it until the client acknowledges it
+// Acknowledge() completes the Delivery with the invocation id. It returns false if there is no such Delivery
+// The Invoke functions stop sending and return the error of ctx when ctx is done before all messages are sent
+// DisconnectUser() closes all connections of the specified user. The clients are not allowed to reconnect
+// AbortConnection() closes a connection with reason as close error and returns an error if it is not connected
+// Drain() stops sending broadcasts to a connection, waits until its queued messages are sent and closes it
+// Ping() sends a ping to a connection and returns an error if it could not be written
 // AddToGroup() adds a connection to the specified group
 // RemoveFromGroup() removes a connection from the specified group
 type HubLifetimeManager interface {
-	OnConnected(conn hubConnection)
-	OnDisconnected(conn hubConnection)
-	InvokeAll(target string, args []interface{})
-	InvokeClient(connectionID string, target string, args []interface{})
-	InvokeGroup(groupName string, target string, args []interface{})
+	OnConnected(conn HubConnection)
+	OnDisconnected(conn HubConnection)
+	InvokeAll(ctx context.Context, target string, args []interface{}) error
+	InvokeAllExcept(ctx context.Context, excludedID string, target string, args []interface{}) error
+	InvokeClient(ctx context.Context, connectionID string, target string, args []interface{}) error
+	InvokeConnections(ctx context.Context, connectionIDs []string, target string, args []interface{}) map[string]error
+	InvokeGroup(ctx context.Context, groupName string, target string, args []interface{}) error
+	InvokeAllDurable(ctx context.Context, target string, args []interface{}) error
+	InvokeAllExceptDurable(ctx context.Context, excludedID string, target string, args []interface{}) error
+	InvokeClientDurable(ctx context.Context, connectionID string, target string, args []interface{}) error
+	InvokeGroupDurable(ctx context.Context, groupName string, target string, args []interface{}) error
+	InvokeUser(ctx context.Context, userID string, target string, args []interface{}) error
+	InvokeUserDurable(ctx context.Context, userID string, target string, args []interface{}) error
+	InvokeTagged(ctx context.Context, expression string, target string, args []interface{}) error
+	InvokeTaggedDurable(ctx context.Context, expression string, target string, args []interface{}) error
+	InvokeClientWithAck(connectionID string, target string, args []interface{}) *Delivery
+	Acknowledge(invocationID string, errorMessage string) bool
+	DisconnectUser(userID string, reason string)
+	AbortConnection(connectionID string, reason string, allowReconnect bool) error
+	Drain(connectionID string, timeout time.Duration) error
+	Ping(connectionID string) error
 	AddToGroup(groupName, connectionID string)
 	RemoveFromGroup(groupName, connectionID string)
 }
@@ -27,13 +64,33 @@ func newLifeTimeManager(info StructuredLogger) defaultHubLifetimeManager {
 	return defaultHubLifetimeManager{
 		info: log.WithPrefix(info, "ts", log.DefaultTimestampUTC,
 			"class", "lifeTimeManager"),
+		bulkSendParallelism: 16,
+		ackRetry: ackRetryPolicy{
+			initialInterval: time.Second,
+			maxInterval:     30 * time.Second,
+			maxAttempts:     5,
+		},
 	}
 }
 
 type defaultHubLifetimeManager struct {
-	clients sync.Map
-	groups  sync.Map
-	info    StructuredLogger
+	clients              sync.Map
+	groups               map[string]map[string]hubConnection
+	groupsMx             sync.Mutex
+	info                 StructuredLogger
+	groupMembershipEvent func(event GroupMembershipEvent)
+	groupExpiration      GroupExpirationFunc
+	groupExpired         func(groupName string)
+	expiries             map[string]*groupExpiry
+	transformers         map[string][]InvocationTransformerFunc
+	throttle             *broadcastThrottle
+	batcher              *groupBatcher
+	encryption           *payloadEncryption
+	replayBuffer         GroupReplayBuffer
+	outbox               Outbox
+	deliveries           sync.Map
+	ackRetry             ackRetryPolicy
+	bulkSendParallelism  int
 }
 
 func (d *defaultHubLifetimeManager) OnConnected(conn hubConnection) {
@@ -42,44 +99,387 @@ func (d *defaultHubLifetimeManager) OnConnected(conn hubConnection) {
 
 func (d *defaultHubLifetimeManager) OnDisconnected(conn hubConnection) {
 	d.clients.Delete(conn.ConnectionID())
+	// Remove the connection from all its groups
+	var groupNames []string
+	d.groupsMx.Lock()
+	for groupName, group := range d.groups {
+		if _, ok := group[conn.ConnectionID()]; ok {
+			groupNames = append(groupNames, groupName)
+			d.deleteFromGroup(groupName, conn.ConnectionID())
+		}
+	}
+	d.groupsMx.Unlock()
+	for _, groupName := range groupNames {
+		d.raiseGroupMembershipEvent(groupName, conn.ConnectionID(), GroupMemberDisconnected)
+	}
 }
 
-func (d *defaultHubLifetimeManager) InvokeAll(target string, args []interface{}) {
+func (d *defaultHubLifetimeManager) InvokeAll(ctx context.Context, target string, args []interface{}) error {
+	if d.throttle.throttled(throttleKey{all: true, target: target}, args, func(args []interface{}) {
+		_ = d.invokeConnections(context.Background(), receivers(d.allConnections()), "", target, args)
+	}) {
+		return nil
+	}
+	return d.invokeConnections(ctx, receivers(d.allConnections()), "", target, args)
+}
+
+func (d *defaultHubLifetimeManager) allConnections() []hubConnection {
+	var conns []hubConnection
 	d.clients.Range(func(key, value interface{}) bool {
-		sendMessageAndLog(func() (i interface{}, err error) {
-			return value.(hubConnection).SendInvocation(target, args)
-		}, d.info)
+		conns = append(conns, value.(hubConnection))
 		return true
 	})
+	return conns
+}
+
+func (d *defaultHubLifetimeManager) InvokeAllExcept(ctx context.Context, excludedID string, target string, args []interface{}) error {
+	return d.invokeConnections(ctx, receivers(d.allConnectionsExcept(excludedID)), "", target, args)
+}
+
+func (d *defaultHubLifetimeManager) allConnectionsExcept(excludedID string) []hubConnection {
+	var conns []hubConnection
+	for _, conn := range d.allConnections() {
+		if conn.ConnectionID() != excludedID {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
 }
 
-func (d *defaultHubLifetimeManager) InvokeClient(connectionID string, target string, args []interface{}) {
+func (d *defaultHubLifetimeManager) InvokeClient(ctx context.Context, connectionID string, target string, args []interface{}) error {
 	if client, ok := d.clients.Load(connectionID); ok {
+		return d.invokeConnections(ctx, []hubConnection{client.(hubConnection)}, "", target, args)
+	}
+	return nil
+}
+
+func (d *defaultHubLifetimeManager) InvokeGroup(ctx context.Context, groupName string, target string, args []interface{}) error {
+	d.touchGroup(groupName)
+	if d.throttle.throttled(throttleKey{group: groupName, target: target}, args, func(args []interface{}) {
+		_ = d.invokeGroup(context.Background(), groupName, target, args)
+	}) {
+		return nil
+	}
+	// Invocations which are changed per connection can not be batched
+	if len(d.transformers[target]) == 0 && !d.encryption.applies(target) &&
+		d.batcher.batched(groupName, newPreparedInvocation(target, args, traceHeaders(ctx)), func(invocations []*preparedInvocation) {
+			d.sendBatch(groupName, invocations)
+		}) {
+		return nil
+	}
+	return d.invokeGroup(ctx, groupName, target, args)
+}
+
+func (d *defaultHubLifetimeManager) invokeGroup(ctx context.Context, groupName string, target string, args []interface{}) error {
+	if d.replayBuffer != nil {
+		d.replayBuffer.Add(groupName, GroupMessage{Target: target, Args: args, Sent: time.Now()})
+	}
+	return d.invokeConnections(ctx, receivers(d.groupMembers(groupName)), groupName, target, args)
+}
+
+// invokeConnections sends the invocation to conns. group is the group the invocation is sent to, or ""
+func (d *defaultHubLifetimeManager) invokeConnections(ctx context.Context, conns []hubConnection, group string, target string, args []interface{}) error {
+	// Without transformers, all connections get the same message, which needs to be encoded only once per protocol
+	var prepared *preparedInvocation
+	if len(conns) > 1 && len(d.transformers[target]) == 0 && !d.encryption.applies(target) {
+		prepared = newPreparedInvocation(target, args, traceHeaders(ctx))
+	}
+	for _, conn := range conns {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c := conn
 		sendMessageAndLog(func() (i interface{}, err error) {
-			return client.(hubConnection).SendInvocation(target, args)
+			return d.sendInvocation(ctx, c, prepared, group, target, args)
 		}, d.info)
 	}
+	return ctx.Err()
+}
+
+// sendInvocation sends the prepared invocation to conn, or, if prepared is nil, the invocation sealed for conn
+func (d *defaultHubLifetimeManager) sendInvocation(ctx context.Context, conn hubConnection, prepared *preparedInvocation,
+	group string, target string, args []interface{}) (interface{}, error) {
+	if prepared != nil {
+		return conn.SendPreparedInvocation(ctx, prepared)
+	}
+	connArgs, err := d.seal(conn, group, target, args)
+	if err != nil {
+		return nil, err
+	}
+	return conn.SendInvocation(ctx, target, connArgs...)
 }
 
-func (d *defaultHubLifetimeManager) InvokeGroup(groupName string, target string, args []interface{}) {
-	if groups, ok := d.groups.Load(groupName); ok {
-		for _, v := range groups.(map[string]hubConnection) {
-			sendMessageAndLog(func() (i interface{}, err error) {
-				return v.SendInvocation(target, args)
-			}, d.info)
+func (d *defaultHubLifetimeManager) InvokeAllDurable(ctx context.Context, target string, args []interface{}) error {
+	return d.invokeConnectionsDurable(ctx, receivers(d.allConnections()), "", target, args)
+}
+
+func (d *defaultHubLifetimeManager) InvokeAllExceptDurable(ctx context.Context, excludedID string, target string, args []interface{}) error {
+	return d.invokeConnectionsDurable(ctx, receivers(d.allConnectionsExcept(excludedID)), "", target, args)
+}
+
+func (d *defaultHubLifetimeManager) InvokeClientDurable(ctx context.Context, connectionID string, target string, args []interface{}) error {
+	if client, ok := d.clients.Load(connectionID); ok {
+		return d.invokeConnectionsDurable(ctx, []hubConnection{client.(hubConnection)}, "", target, args)
+	}
+	if d.outbox == nil {
+		return errNoOutbox
+	}
+	// The client might reconnect with the same connection id, so keep the message for it
+	return d.outbox.Put(OutboxMessage{ID: newOutboxMessageID(), ConnectionID: connectionID, Target: target, Args: args})
+}
+
+func (d *defaultHubLifetimeManager) InvokeGroupDurable(ctx context.Context, groupName string, target string, args []interface{}) error {
+	d.touchGroup(groupName)
+	return d.invokeConnectionsDurable(ctx, receivers(d.groupMembers(groupName)), groupName, target, args)
+}
+
+func (d *defaultHubLifetimeManager) InvokeUser(ctx context.Context, userID string, target string, args []interface{}) error {
+	return d.invokeConnections(ctx, receivers(d.userConnections(userID)), "", target, args)
+}
+
+func (d *defaultHubLifetimeManager) InvokeUserDurable(ctx context.Context, userID string, target string, args []interface{}) error {
+	return d.invokeConnectionsDurable(ctx, receivers(d.userConnections(userID)), "", target, args)
+}
+
+// userConnections returns the connections of the user
+func (d *defaultHubLifetimeManager) userConnections(userID string) []hubConnection {
+	var conns []hubConnection
+	for _, conn := range d.allConnections() {
+		if conn.UserID() == userID {
+			conns = append(conns, conn)
 		}
 	}
+	return conns
+}
+
+func (d *defaultHubLifetimeManager) InvokeTagged(ctx context.Context, expression string, target string, args []interface{}) error {
+	conns, err := d.taggedConnections(expression)
+	if err != nil {
+		return err
+	}
+	return d.invokeConnections(ctx, receivers(conns), "", target, args)
+}
+
+func (d *defaultHubLifetimeManager) InvokeTaggedDurable(ctx context.Context, expression string, target string, args []interface{}) error {
+	conns, err := d.taggedConnections(expression)
+	if err != nil {
+		return err
+	}
+	return d.invokeConnectionsDurable(ctx, receivers(conns), "", target, args)
+}
+
+var errNoOutbox = errors.New("durable send without Outbox. Use the UseOutbox option")
+
+func (d *defaultHubLifetimeManager) invokeConnectionsDurable(ctx context.Context, conns []hubConnection, group string, target string, args []interface{}) error {
+	if d.outbox == nil {
+		return errNoOutbox
+	}
+	for _, conn := range conns {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c := conn
+		connArgs, err := d.seal(c, group, target, args)
+		if err != nil {
+			return err
+		}
+		message := OutboxMessage{ID: newOutboxMessageID(), ConnectionID: c.ConnectionID(), Target: target, Args: connArgs}
+		if err := d.outbox.Put(message); err != nil {
+			return err
+		}
+		sendMessageAndLog(func() (i interface{}, err error) {
+			return c.SendInvocationWithID(ctx, message.ID, message.Target, message.Args...)
+		}, d.info)
+	}
+	return ctx.Err()
+}
+
+func (d *defaultHubLifetimeManager) DisconnectUser(userID string, reason string) {
+	d.clients.Range(func(key, value interface{}) bool {
+		if conn := value.(hubConnection); conn.UserID() == userID {
+			conn.AbortWithError(&disconnectUserError{reason: reason})
+		}
+		return true
+	})
+}
+
+// Drain blocks until the connection is closed or timeout has elapsed. It returns an error if the connection is not
+// connected or not all queued messages could be sent before timeout. The client is allowed to reconnect.
+func (d *defaultHubLifetimeManager) Drain(connectionID string, timeout time.Duration) error {
+	client, ok := d.clients.Load(connectionID)
+	if !ok {
+		return &notConnectedError{connectionID: connectionID}
+	}
+	conn := client.(hubConnection)
+	conn.Drain()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := conn.Flush(ctx)
+	conn.AbortWithError(errors.New("connection drained"))
+	return err
+}
+
+// Ping sends a ping to the connection and waits until it is written. It returns an error if the connection
+// is not connected or the ping could not be written
+func (d *defaultHubLifetimeManager) Ping(connectionID string) error {
+	client, ok := d.clients.Load(connectionID)
+	if !ok {
+		return &notConnectedError{connectionID: connectionID}
+	}
+	_, err := client.(hubConnection).Ping(false)
+	return err
+}
+
+// receivers returns the conns which receive broadcasts, which are all conns which are not draining or quarantined
+func receivers(conns []hubConnection) []hubConnection {
+	filtered := make([]hubConnection, 0, len(conns))
+	for _, conn := range conns {
+		if !conn.Draining() && !conn.Quarantined() {
+			filtered = append(filtered, conn)
+		}
+	}
+	return filtered
+}
+
+type disconnectUserError struct {
+	reason string
+}
+
+func (d *disconnectUserError) Error() string {
+	return d.reason
+}
+
+// AbortConnection closes the connection with reason as close error. allowReconnect is sent in the close message.
+// It returns an error if the connection is not connected
+func (d *defaultHubLifetimeManager) AbortConnection(connectionID string, reason string, allowReconnect bool) error {
+	client, ok := d.clients.Load(connectionID)
+	if !ok {
+		return &notConnectedError{connectionID: connectionID}
+	}
+	client.(hubConnection).AbortWithError(&abortConnectionError{reason: reason, allowReconnect: allowReconnect})
+	return nil
+}
+
+// notConnectedError is returned for a connection which is not connected to the server
+type notConnectedError struct {
+	connectionID string
+}
+
+func (n *notConnectedError) Error() string {
+	return fmt.Sprintf("connection %v is not connected", n.connectionID)
+}
+
+type abortConnectionError struct {
+	reason         string
+	allowReconnect bool
+}
+
+func (a *abortConnectionError) Error() string {
+	return a.reason
 }
 
 func (d *defaultHubLifetimeManager) AddToGroup(groupName string, connectionID string) {
 	if client, ok := d.clients.Load(connectionID); ok {
-		groups, _ := d.groups.LoadOrStore(groupName, make(map[string]hubConnection))
-		groups.(map[string]hubConnection)[connectionID] = client.(hubConnection)
+		var policy GroupExpirationPolicy
+		if d.groupExpiration != nil {
+			policy = d.groupExpiration(groupName)
+		}
+		d.groupsMx.Lock()
+		if d.groups == nil {
+			d.groups = make(map[string]map[string]hubConnection)
+		}
+		group, ok := d.groups[groupName]
+		if !ok {
+			group = make(map[string]hubConnection)
+			d.groups[groupName] = group
+			d.startExpiry(groupName, policy)
+		} else if expiry, ok := d.expiries[groupName]; ok {
+			expiry.lastActive = time.Now()
+		}
+		_, isMember := group[connectionID]
+		group[connectionID] = client.(hubConnection)
+		d.groupsMx.Unlock()
+		if !isMember {
+			d.raiseGroupMembershipEvent(groupName, connectionID, GroupMemberAdded)
+			d.replay(groupName, client.(hubConnection))
+		}
+	}
+}
+
+// replay sends the retained messages of the group to a connection which joined the group
+func (d *defaultHubLifetimeManager) replay(groupName string, conn hubConnection) {
+	if d.replayBuffer != nil {
+		for _, message := range d.replayBuffer.Messages(groupName) {
+			_ = d.invokeConnections(context.Background(), []hubConnection{conn}, groupName, message.Target, message.Args)
+		}
 	}
 }
 
 func (d *defaultHubLifetimeManager) RemoveFromGroup(groupName string, connectionID string) {
-	if groups, ok := d.groups.Load(groupName); ok {
-		delete(groups.(map[string]hubConnection), connectionID)
+	d.groupsMx.Lock()
+	_, isMember := d.groups[groupName][connectionID]
+	d.deleteFromGroup(groupName, connectionID)
+	d.groupsMx.Unlock()
+	if isMember {
+		d.raiseGroupMembershipEvent(groupName, connectionID, GroupMemberRemoved)
+	}
+}
+
+// deleteFromGroup must be called with groupsMx locked
+func (d *defaultHubLifetimeManager) deleteFromGroup(groupName string, connectionID string) {
+	if group, ok := d.groups[groupName]; ok {
+		delete(group, connectionID)
+		if len(group) == 0 {
+			delete(d.groups, groupName)
+			d.stopExpiry(groupName)
+		}
+	}
+}
+
+func (d *defaultHubLifetimeManager) groupMembers(groupName string) []hubConnection {
+	defer d.groupsMx.Unlock()
+	d.groupsMx.Lock()
+	members := make([]hubConnection, 0, len(d.groups[groupName]))
+	for _, conn := range d.groups[groupName] {
+		members = append(members, conn)
+	}
+	return members
+}
+
+func (d *defaultHubLifetimeManager) groupsOf(connectionID string) []string {
+	defer d.groupsMx.Unlock()
+	d.groupsMx.Lock()
+	var groupNames []string
+	for groupName, group := range d.groups {
+		if _, ok := group[connectionID]; ok {
+			groupNames = append(groupNames, groupName)
+		}
+	}
+	return groupNames
+}
+
+// seal applies the transformers and encrypts the args for conn, if the payloads of target are encrypted
+func (d *defaultHubLifetimeManager) seal(conn hubConnection, group string, target string, args []interface{}) ([]interface{}, error) {
+	args = d.transform(conn, target, args)
+	if d.encryption.applies(target) {
+		return d.encryption.encrypt(conn.ConnectionID(), group, args)
+	}
+	return args, nil
+}
+
+func (d *defaultHubLifetimeManager) transform(conn hubConnection, target string, args []interface{}) []interface{} {
+	for _, transformer := range d.transformers[target] {
+		args = transformer(conn.ConnectionID(), conn.Items(), args)
+	}
+	return args
+}
+
+func (d *defaultHubLifetimeManager) raiseGroupMembershipEvent(groupName string, connectionID string, change GroupMembershipChange) {
+	if d.groupMembershipEvent != nil {
+		d.groupMembershipEvent(GroupMembershipEvent{
+			GroupName:    groupName,
+			ConnectionID: connectionID,
+			Change:       change,
+		})
 	}
 }
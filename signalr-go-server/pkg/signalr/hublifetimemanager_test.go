@@ -0,0 +1,78 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+)
+
+// testBackplane connects the HubLifetimeManagers of several servers in memory
+type testBackplane struct {
+	mx     sync.Mutex
+	locals []HubLifetimeManager
+}
+
+func (b *testBackplane) manager(local HubLifetimeManager) HubLifetimeManager {
+	b.mx.Lock()
+	b.locals = append(b.locals, local)
+	b.mx.Unlock()
+	return &backplaneLifetimeManager{HubLifetimeManager: local, backplane: b}
+}
+
+// backplaneLifetimeManager sends broadcasts to the connections of all servers on the backplane
+type backplaneLifetimeManager struct {
+	HubLifetimeManager
+	backplane *testBackplane
+}
+
+func (m *backplaneLifetimeManager) InvokeAll(ctx context.Context, target string, args []interface{}) error {
+	m.backplane.mx.Lock()
+	locals := append([]HubLifetimeManager(nil), m.backplane.locals...)
+	m.backplane.mx.Unlock()
+	for _, local := range locals {
+		if err := local.InvokeAll(ctx, target, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type backplaneHub struct {
+	Hub
+}
+
+func (b *backplaneHub) Broadcast(text string) {
+	b.Clients().All().Send("message", text)
+}
+
+var _ = Describe("UseHubLifetimeManager option", func() {
+	Context("When servers share a backplane", func() {
+		It("should route the broadcasts of one server to the connections of all servers", func() {
+			backplane := &testBackplane{}
+			server1, err := NewServer(SimpleHubFactory(&backplaneHub{}), UseHubLifetimeManager(backplane.manager))
+			Expect(err).To(BeNil())
+			server2, err := NewServer(SimpleHubFactory(&backplaneHub{}), UseHubLifetimeManager(backplane.manager))
+			Expect(err).To(BeNil())
+			conn1 := newTestingConnection()
+			conn1.connectionID = "conn1"
+			go server1.Run(context.TODO(), conn1)
+			conn2 := newTestingConnection()
+			conn2.connectionID = "conn2"
+			go server2.Run(context.TODO(), conn2)
+			Eventually(func() error { return server2.Ping("conn2") }).Should(BeNil())
+			conn1.ClientSend(`{"type":1,"invocationId":"1","target":"broadcast","arguments":["hello"]}`)
+			Eventually(conn2.ReceiveChan()).Should(Receive(Equal(invocationMessage{Type: 1, Target: "message", Arguments: []interface{}{"hello"}})))
+			Expect(server1.AllConnectionStats()).To(HaveKey("conn1"))
+		})
+	})
+	Context("When the factory is nil or returns nil", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&backplaneHub{}), UseHubLifetimeManager(nil))
+			Expect(err).NotTo(BeNil())
+			_, err = NewServer(SimpleHubFactory(&backplaneHub{}),
+				UseHubLifetimeManager(func(local HubLifetimeManager) HubLifetimeManager { return nil }))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
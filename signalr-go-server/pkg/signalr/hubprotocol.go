@@ -7,40 +7,59 @@ import (
 
 // HubProtocol interface
 // ReadMessage() reads a message from buf and returns the message if the buf contained one completely.
-// If buf does not contain the whole message, it returns a nil message and complete false
+// If buf does not contain the whole message, it returns a nil message and complete false and leaves buf unchanged
 // WriteMessage writes a message to the specified writer
 // UnmarshalArgument() unmarshals a raw message depending of the specified value type into value
+// Each connection gets its own instance of the protocol, so implementations must be pointers to structs
+// whose zero value is ready to use.
 type HubProtocol interface {
 	ReadMessage(buf *bytes.Buffer) (interface{}, bool, error)
 	WriteMessage(message interface{}, writer io.Writer) error
 	UnmarshalArgument(argument interface{}, value interface{}) error
+}
+
+// debugLoggingProtocol is a HubProtocol which logs to the debug logger of the server
+type debugLoggingProtocol interface {
 	setDebugLogger(dbg StructuredLogger)
 }
 
+// binaryProtocol is a HubProtocol whose messages must be sent in binary frames
+type binaryProtocol interface {
+	isBinary() bool
+}
+
 // Protocol
 type hubMessage struct {
 	Type int `json:"type"`
 }
 
+type pingMessage struct {
+	Type      int   `json:"type"`
+	Timestamp int64 `json:"timestamp,omitempty"`
+}
+
 type invocationMessage struct {
-	Type         int           `json:"type"`
-	Target       string        `json:"target"`
-	InvocationID string        `json:"invocationId,omitempty"`
-	Arguments    []interface{} `json:"arguments"`
-	StreamIds    []string      `json:"streamIds,omitempty"`
+	Type         int               `json:"type"`
+	Target       string            `json:"target"`
+	InvocationID string            `json:"invocationId,omitempty"`
+	Arguments    []interface{}     `json:"arguments"`
+	StreamIds    []string          `json:"streamIds,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
 }
 
 type completionMessage struct {
-	Type         int         `json:"type"`
-	InvocationID string      `json:"invocationId"`
-	Result       interface{} `json:"result,omitempty"`
-	Error        string      `json:"error,omitempty"`
+	Type         int               `json:"type"`
+	InvocationID string            `json:"invocationId"`
+	Result       interface{}       `json:"result,omitempty"`
+	Error        string            `json:"error,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
 }
 
 type streamItemMessage struct {
-	Type         int         `json:"type"`
-	InvocationID string      `json:"invocationId"`
-	Item         interface{} `json:"item"`
+	Type         int               `json:"type"`
+	InvocationID string            `json:"invocationId"`
+	Item         interface{}       `json:"item"`
+	Headers      map[string]string `json:"headers,omitempty"`
 }
 
 type cancelInvocationMessage struct {
@@ -55,6 +74,10 @@ type closeMessage struct {
 }
 
 type handshakeRequest struct {
-	Protocol string `json:"Protocol"`
-	Version  int    `json:"version"`
+	Protocol string              `json:"Protocol"`
+	Version  int                 `json:"version"`
+	Features []string            `json:"features,omitempty"`
+	Metadata *ConnectionMetadata `json:"metadata,omitempty"`
+	// ResumeToken is the resume token of the former connection of the client, see UseResumeStore
+	ResumeToken string `json:"resumeToken,omitempty"`
 }
@@ -0,0 +1,121 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HubRegistry is an http.Handler serving hubs which can be added and removed while it is running,
+// e.g. for plugins which are loaded at runtime. Each hub is served at its path and path + "/negotiate",
+// like with MapHub.
+type HubRegistry struct {
+	servers map[string]*Server
+	mx      sync.RWMutex
+}
+
+// NewHubRegistry creates an empty HubRegistry
+func NewHubRegistry() *HubRegistry {
+	return &HubRegistry{servers: make(map[string]*Server)}
+}
+
+// AddHub creates a server for the hub and serves it at path. New connections to path are served by it at once.
+// The options are applied to the server of this hub only.
+func (r *HubRegistry) AddHub(path string, hubProto HubInterface, options ...func(*Server) error) (*Server, error) {
+	return r.addHub(path, SimpleHubFactory(hubProto), options)
+}
+
+// addHub creates a server with the option which sets its hub and the other options, and serves it at path
+func (r *HubRegistry) addHub(path string, hubOption func(*Server) error, options []func(*Server) error) (*Server, error) {
+	if path == "" || strings.HasSuffix(path, "/negotiate") {
+		return nil, fmt.Errorf("invalid hub path %q", path)
+	}
+	server, err := NewServer(append([]func(*Server) error{hubOption}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if _, ok := r.servers[path]; ok {
+		return nil, fmt.Errorf("path %q has already a hub", path)
+	}
+	r.servers[path] = server
+	return server, nil
+}
+
+// RemoveHub stops serving the hub at path. New connections and negotiate requests to path are answered with 404.
+// If closeConnections is true, the existing connections are closed with reconnect allowed,
+// else they are served until they end. Then the server of the hub is stopped in the background, see Server.Stop.
+// It returns false if there is no hub at path.
+func (r *HubRegistry) RemoveHub(path string, closeConnections bool) bool {
+	r.mx.Lock()
+	server, ok := r.servers[path]
+	delete(r.servers, path)
+	r.mx.Unlock()
+	if !ok {
+		return false
+	}
+	if closeConnections {
+		for _, conn := range server.localLifetimeManager.allConnections() {
+			conn.AbortWithError(errors.New("hub removed"))
+		}
+	}
+	go stopRemovedHub(server, !closeConnections)
+	return true
+}
+
+// stopRemovedHub stops the server of a removed hub, if waitForConnections is true after its connections have ended
+func stopRemovedHub(server *Server, waitForConnections bool) {
+	if waitForConnections {
+		ticker := time.NewTicker(stopPollInterval)
+		for len(server.localLifetimeManager.allConnections()) > 0 {
+			<-ticker.C
+		}
+		ticker.Stop()
+	}
+	if err := server.Stop(context.Background()); err != nil {
+		_ = server.info.Log(evt, "stop removed hub", "error", err)
+	}
+}
+
+// Paths returns the paths of the hubs which are currently served
+func (r *HubRegistry) Paths() []string {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	paths := make([]string, 0, len(r.servers))
+	for path := range r.servers {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// ServeHTTP dispatches the request to the server of the hub at the request path
+func (r *HubRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if _, server, ok := r.lookup(req); ok {
+		serveHub(server, w, req)
+	} else {
+		http.NotFound(w, req)
+	}
+}
+
+// lookup returns the path of the hub the request is for and its server
+func (r *HubRegistry) lookup(req *http.Request) (string, *Server, bool) {
+	path := strings.TrimSuffix(req.URL.Path, "/negotiate")
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+	server, ok := r.servers[path]
+	return path, server, ok
+}
+
+// serveHub lets server answer a negotiate request or a request which opens a connection
+func serveHub(server *Server, w http.ResponseWriter, req *http.Request) {
+	if strings.HasSuffix(req.URL.Path, "/negotiate") {
+		server.negotiateHandler(w, req)
+	} else {
+		server.webSocketHandler().ServeHTTP(w, req)
+	}
+}
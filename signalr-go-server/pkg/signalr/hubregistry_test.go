@@ -0,0 +1,95 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ = Describe("HubRegistry", func() {
+	Context("When hubs are added and removed at runtime", func() {
+		It("should serve only the registered hubs", func() {
+			registry := NewHubRegistry()
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), registry)
+			}()
+			waitForPort(port)
+			resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiate", port), "text/plain;charset=UTF-8", &bytes.Buffer{})
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			var mx sync.Mutex
+			var events []string
+			server, err := registry.AddHub("/hub", &webSocketHub{}, UseExtensions(&recordingExtension{name: "hub", mx: &mx, events: &events}))
+			Expect(err).To(BeNil())
+			Expect(server.Start(context.TODO())).To(BeNil())
+			_, err = registry.AddHub("/hub", &webSocketHub{})
+			Expect(err).NotTo(BeNil())
+			Expect(registry.Paths()).To(Equal([]string{"/hub"}))
+			negotiateResponse := negotiateWebSocketTestServer(port)
+			handShakeAndCallWebSocketTestServer(port, fmt.Sprint(negotiateResponse["connectionId"]))
+			// Keep a connection open
+			ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "json", "http://127.0.0.1")
+			Expect(err).To(BeNil())
+			defer func() { _ = ws.Close() }()
+			Expect(websocket.Message.Send(ws, `{"protocol":"json","version":1}`+"\u001e")).To(BeNil())
+			var handshakeResponse string
+			Expect(websocket.Message.Receive(ws, &handshakeResponse)).To(BeNil())
+			Expect(registry.RemoveHub("/hub", true)).To(BeTrue())
+			Expect(registry.RemoveHub("/hub", true)).To(BeFalse())
+			_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+			for {
+				var message string
+				Expect(websocket.Message.Receive(ws, &message)).To(BeNil())
+				if strings.Contains(message, `"type":7`) {
+					Expect(message).To(ContainSubstring(`"error":"hub removed","allowReconnect":true`))
+					break
+				}
+			}
+			resp, err = http.Post(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiate", port), "text/plain;charset=UTF-8", &bytes.Buffer{})
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+			Eventually(func() []string {
+				mx.Lock()
+				defer mx.Unlock()
+				return append([]string{}, events...)
+			}).Should(Equal([]string{"start hub", "stop hub"}))
+		})
+	})
+	Context("When a hub is removed without closing its connections", func() {
+		It("should stop its server after the connections have ended", func() {
+			registry := NewHubRegistry()
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), registry)
+			}()
+			waitForPort(port)
+			var mx sync.Mutex
+			var events []string
+			stopped := func() bool {
+				mx.Lock()
+				defer mx.Unlock()
+				return len(events) == 2
+			}
+			server, err := registry.AddHub("/hub", &webSocketHub{}, UseExtensions(&recordingExtension{name: "hub", mx: &mx, events: &events}))
+			Expect(err).To(BeNil())
+			Expect(server.Start(context.TODO())).To(BeNil())
+			ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "json", "http://127.0.0.1")
+			Expect(err).To(BeNil())
+			Expect(websocket.Message.Send(ws, `{"protocol":"json","version":1}`+"\u001e")).To(BeNil())
+			var handshakeResponse string
+			Expect(websocket.Message.Receive(ws, &handshakeResponse)).To(BeNil())
+			Expect(registry.RemoveHub("/hub", false)).To(BeTrue())
+			Consistently(stopped, 100*time.Millisecond).Should(BeFalse())
+			_ = ws.Close()
+			Eventually(stopped).Should(BeTrue())
+		})
+	})
+})
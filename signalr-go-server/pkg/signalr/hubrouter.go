@@ -0,0 +1,101 @@
+package signalr
+
+import (
+	"net/http"
+	"sync"
+)
+
+// HubMiddleware wraps the http.Handler of a hub, e.g. to authenticate requests or to set response headers.
+// It sees the negotiate requests and the requests which open a connection.
+type HubMiddleware func(next http.Handler) http.Handler
+
+// HubRouter is an http.Handler which serves several hubs, each at its path and path + "/negotiate",
+// with its own options and middleware:
+//
+//	router := NewHubRouter(logging)
+//	router.Handle("/chat", newChatHub)
+//	admin, _ := router.Handle("/admin", newAdminHub, InvocationWorkers(2))
+//	admin.Use(requireAdmin)
+//	http.ListenAndServe(":8080", router)
+type HubRouter struct {
+	registry   *HubRegistry
+	middleware []HubMiddleware
+	routes     map[string]*HubRoute
+	mx         sync.RWMutex
+}
+
+// HubRoute is a hub served by a HubRouter
+type HubRoute struct {
+	server     *Server
+	middleware []HubMiddleware
+	mx         sync.RWMutex
+}
+
+// NewHubRouter creates a HubRouter. The middleware is applied to the requests of all hubs, before the middleware of the hub.
+func NewHubRouter(middleware ...HubMiddleware) *HubRouter {
+	return &HubRouter{registry: NewHubRegistry(), middleware: middleware, routes: make(map[string]*HubRoute)}
+}
+
+// Handle serves the hub created by factory at path. The options are applied to the server of this hub only.
+func (r *HubRouter) Handle(path string, factory func() HubInterface, options ...func(*Server) error) (*HubRoute, error) {
+	server, err := r.registry.addHub(path, HubFactory(factory), options)
+	if err != nil {
+		return nil, err
+	}
+	route := &HubRoute{server: server}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.routes[path] = route
+	return route, nil
+}
+
+// Use adds middleware which is applied to all hubs
+func (r *HubRouter) Use(middleware ...HubMiddleware) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// ServeHTTP dispatches the request to the hub at the request path, through the middleware of the router and the hub
+func (r *HubRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path, _, ok := r.registry.lookup(req)
+	r.mx.RLock()
+	route, routed := r.routes[path]
+	middleware := r.middleware
+	r.mx.RUnlock()
+	if !ok || !routed {
+		http.NotFound(w, req)
+		return
+	}
+	handler := route.chain()
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	handler.ServeHTTP(w, req)
+}
+
+// Server returns the server of the hub
+func (h *HubRoute) Server() *Server {
+	return h.server
+}
+
+// Use adds middleware which is applied to this hub only
+func (h *HubRoute) Use(middleware ...HubMiddleware) *HubRoute {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.middleware = append(h.middleware, middleware...)
+	return h
+}
+
+// chain returns the handler of the hub wrapped by its middleware, the first middleware outermost
+func (h *HubRoute) chain() http.Handler {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		serveHub(h.server, w, req)
+	})
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+	return handler
+}
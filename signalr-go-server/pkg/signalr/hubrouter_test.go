@@ -0,0 +1,50 @@
+package signalr
+
+import (
+	"bytes"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+)
+
+var _ = Describe("HubRouter", func() {
+	Context("When hubs with middleware are handled by the router", func() {
+		It("should serve them through the middleware of the router and the hub", func() {
+			calls := make(chan string, 20)
+			tag := func(name string) HubMiddleware {
+				return func(next http.Handler) http.Handler {
+					return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+						calls <- name + " " + req.URL.Path
+						next.ServeHTTP(w, req)
+					})
+				}
+			}
+			router := NewHubRouter(tag("router"))
+			route, err := router.Handle("/hub", func() HubInterface { return &webSocketHub{} })
+			Expect(err).To(BeNil())
+			Expect(route.Server()).NotTo(BeNil())
+			route.Use(tag("hub"))
+			_, err = router.Handle("/hub", func() HubInterface { return &webSocketHub{} })
+			Expect(err).NotTo(BeNil())
+			_, err = router.Handle("/admin", func() HubInterface { return &webSocketHub{} }, Protocols("unknown"))
+			Expect(err).NotTo(BeNil())
+			_, err = router.Handle("/admin/negotiate", func() HubInterface { return &webSocketHub{} })
+			Expect(err).NotTo(BeNil())
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			}()
+			waitForPort(port)
+			negotiateResponse := negotiateWebSocketTestServer(port)
+			Expect(<-calls).To(Equal("router /hub/negotiate"))
+			Expect(<-calls).To(Equal("hub /hub/negotiate"))
+			handShakeAndCallWebSocketTestServer(port, fmt.Sprint(negotiateResponse["connectionId"]))
+			Expect(<-calls).To(Equal("router /hub"))
+			Expect(<-calls).To(Equal("hub /hub"))
+			resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/other/negotiate", port), "text/plain;charset=UTF-8", &bytes.Buffer{})
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+})
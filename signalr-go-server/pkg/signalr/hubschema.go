@@ -0,0 +1,114 @@
+package signalr
+
+import (
+	"context"
+	"reflect"
+	"sort"
+)
+
+// HubSchema describes the methods a hub offers to its clients
+type HubSchema struct {
+	Name    string         `json:"name"`
+	Methods []MethodSchema `json:"methods"`
+}
+
+// MethodSchema describes a hub method. Parameters are the arguments sent by the client,
+// parameters injected by the server like *Progress or context.Context are not listed.
+type MethodSchema struct {
+	Name       string            `json:"name"`
+	Parameters []ParameterSchema `json:"parameters"`
+	Results    []string          `json:"results"`
+	// Stream is true if the method returns a chan, which is streamed to the client on a StreamInvocation
+	Stream bool `json:"stream"`
+	// Async is true if the method returns a *Future
+	Async bool `json:"async"`
+}
+
+// ParameterSchema describes a parameter of a hub method. Type is the Go type of the parameter.
+// If Stream is true, the client streams items of Type to the parameter.
+type ParameterSchema struct {
+	Type   string `json:"type"`
+	Stream bool   `json:"stream"`
+}
+
+// HubSchema returns the schema of the hub of the server, e.g. for admin UIs which want to know what the server offers.
+// Methods are sorted by name. Methods of Hub, HubInterface and InvocationHandler are not part of the schema.
+func (s *Server) HubSchema() HubSchema {
+	hubType := reflect.TypeOf(s.newHub())
+	schema := HubSchema{Name: hubType.Elem().Name(), Methods: []MethodSchema{}}
+	for i := 0; i < hubType.NumMethod(); i++ {
+		method := hubType.Method(i)
+		if isBaseHubMethod(method) {
+			continue
+		}
+		schema.Methods = append(schema.Methods, methodSchema(method))
+	}
+	sort.Slice(schema.Methods, func(i, j int) bool { return schema.Methods[i].Name < schema.Methods[j].Name })
+	return schema
+}
+
+// isBaseHubMethod tells if method of a hub type is a method of Hub, HubInterface or InvocationHandler.
+// A hub method with the name of a base method but another signature is a method of the hub itself
+func isBaseHubMethod(method reflect.Method) bool {
+	signature := methodSignature(method.Type, 1)
+	for _, base := range []reflect.Type{
+		reflect.TypeOf(&Hub{}),
+		reflect.TypeOf((*HubInterface)(nil)).Elem(),
+		reflect.TypeOf((*InvocationHandler)(nil)).Elem(),
+	} {
+		if baseMethod, ok := base.MethodByName(method.Name); ok {
+			// Methods of interface types have no receiver
+			receiver := 1
+			if base.Kind() == reflect.Interface {
+				receiver = 0
+			}
+			if methodSignature(baseMethod.Type, receiver) == signature {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// methodSignature returns the func type of a method type without its receiver
+func methodSignature(methodType reflect.Type, receiver int) reflect.Type {
+	in := make([]reflect.Type, 0, methodType.NumIn())
+	for i := receiver; i < methodType.NumIn(); i++ {
+		in = append(in, methodType.In(i))
+	}
+	out := make([]reflect.Type, 0, methodType.NumOut())
+	for i := 0; i < methodType.NumOut(); i++ {
+		out = append(out, methodType.Out(i))
+	}
+	return reflect.FuncOf(in, out, methodType.IsVariadic())
+}
+
+func methodSchema(method reflect.Method) MethodSchema {
+	schema := MethodSchema{Name: method.Name, Parameters: []ParameterSchema{}, Results: []string{}}
+	// In(0) is the receiver
+	for i := 1; i < method.Type.NumIn(); i++ {
+		t := method.Type.In(i)
+		switch {
+		case t == reflect.TypeOf(&Progress{}), t == reflect.TypeOf((*context.Context)(nil)).Elem():
+			// injected by the server
+		case t.Kind() == reflect.Chan && t.ChanDir() != reflect.SendDir:
+			schema.Parameters = append(schema.Parameters, ParameterSchema{Type: t.Elem().String(), Stream: true})
+		default:
+			schema.Parameters = append(schema.Parameters, ParameterSchema{Type: t.String()})
+		}
+	}
+	for i := 0; i < method.Type.NumOut(); i++ {
+		t := method.Type.Out(i)
+		switch {
+		case method.Type.NumOut() == 1 && t == reflect.TypeOf(&Future{}):
+			schema.Async = true
+			schema.Results = append(schema.Results, "interface {}")
+		case method.Type.NumOut() == 1 && t.Kind() == reflect.Chan:
+			schema.Stream = true
+			schema.Results = append(schema.Results, t.Elem().String())
+		default:
+			schema.Results = append(schema.Results, t.String())
+		}
+	}
+	return schema
+}
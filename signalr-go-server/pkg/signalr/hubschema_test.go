@@ -0,0 +1,54 @@
+package signalr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HubSchema", func() {
+	Context("When the schema of a hub is requested", func() {
+		It("should describe the methods of the hub, but not the methods of the base hub", func() {
+			server, err := NewServer(SimpleHubFactory(&fileStreamHub{}))
+			Expect(err).To(BeNil())
+			Expect(server.HubSchema()).To(Equal(HubSchema{
+				Name: "fileStreamHub",
+				Methods: []MethodSchema{
+					{
+						Name:       "Download",
+						Parameters: []ParameterSchema{{Type: "string"}},
+						Results:    []string{"signalr.FileChunk"},
+						Stream:     true,
+					},
+					{
+						Name:       "EndlessDownload",
+						Parameters: []ParameterSchema{},
+						Results:    []string{"signalr.FileChunk"},
+						Stream:     true,
+					},
+					{
+						Name:       "Upload",
+						Parameters: []ParameterSchema{{Type: "signalr.FileChunk", Stream: true}},
+						Results:    []string{},
+					},
+				},
+			}))
+		})
+	})
+	Context("When a hub method returns a Future or more than one value", func() {
+		It("should describe the results", func() {
+			server, err := NewServer(SimpleHubFactory(&invocationHub{}))
+			Expect(err).To(BeNil())
+			for _, method := range server.HubSchema().Methods {
+				switch method.Name {
+				case "Future":
+					Expect(method.Async).To(BeTrue())
+					Expect(method.Parameters).To(Equal([]ParameterSchema{{Type: "bool"}}))
+				case "SimpleFloat":
+					Expect(method.Results).To(Equal([]string{"float64", "float64"}))
+				case "WithProgress":
+					Expect(method.Parameters).To(Equal([]ParameterSchema{{Type: "int"}}))
+				}
+			}
+		})
+	})
+})
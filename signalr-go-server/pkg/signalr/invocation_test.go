@@ -1,6 +1,7 @@
 package signalr
 
 import (
+	"errors"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -50,11 +51,61 @@ func (i *invocationHub) AsyncClosedChan() chan bool {
 	return r
 }
 
+func (i *invocationHub) Future(fail bool) *Future {
+	future := NewFuture()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		if fail {
+			future.Reject(errors.New("rejected"))
+		} else {
+			future.Resolve(42)
+		}
+	}()
+	invocationQueue <- "Future()"
+	return future
+}
+
+func (i *invocationHub) WithProgress(steps int, progress *Progress) string {
+	for step := 1; step <= steps; step++ {
+		_ = progress.Report(step)
+	}
+	invocationQueue <- "WithProgress()"
+	return "done"
+}
+
 func (i *invocationHub) Panic() {
 	invocationQueue <- "Panic()"
 	panic("Don't panic!")
 }
 
+type catchAllHub struct {
+	Hub
+}
+
+func (c *catchAllHub) HandleInvocation(invocation Invocation) (interface{}, error) {
+	switch invocation.Target() {
+	case "add":
+		var a, b int
+		if err := invocation.Arg(0, &a); err != nil {
+			return nil, err
+		}
+		if err := invocation.Arg(1, &b); err != nil {
+			return nil, err
+		}
+		return a + b, nil
+	default:
+		return nil, fmt.Errorf("unknown target %v", invocation.Target())
+	}
+}
+
+type pongHub struct {
+	Hub
+}
+
+func (p *pongHub) Ping() string {
+	return "pong"
+}
+
 var _ = Describe("Invocation", func() {
 
 	Describe("Simple invocation", func() {
@@ -218,6 +269,73 @@ var _ = Describe("Invocation", func() {
 		})
 	})
 
+	Describe("Future invocation", func() {
+		Context("When invoked by the client and the Future is resolved", func() {
+			It("should be invoked on the server and return the result asynchronously", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "fut","target":"future","arguments":[false]}`)
+				Expect(<-invocationQueue).To(Equal("Future()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("fut"))
+				Expect(recv.Result).To(Equal(float64(42)))
+				Expect(recv.Error).To(Equal(""))
+			})
+		})
+		Context("When invoked by the client and the Future is rejected", func() {
+			It("should be invoked on the server and return the error asynchronously", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "fut","target":"future","arguments":[true]}`)
+				Expect(<-invocationQueue).To(Equal("Future()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("fut"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal("rejected"))
+			})
+		})
+	})
+
+	Describe("Invocation with Progress", func() {
+		Context("When invoked by the client", func() {
+			It("should send the progress as stream items and the result as completion", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "prg","target":"withprogress","arguments":[2]}`)
+				Expect(<-invocationQueue).To(Equal("WithProgress()"))
+				for step := 1; step <= 2; step++ {
+					recv := (<-conn.received).(streamItemMessage)
+					Expect(recv.InvocationID).To(Equal("prg"))
+					Expect(recv.Item).To(Equal(float64(step)))
+				}
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("prg"))
+				Expect(recv.Result).To(Equal("done"))
+				Expect(recv.Error).To(Equal(""))
+			})
+		})
+	})
+
+	Describe("Invocation of a hub with InvocationHandler", func() {
+		Context("When invoked by the client with valid arguments", func() {
+			It("should dispatch to the InvocationHandler and return its result", func() {
+				conn := connect(&catchAllHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "ca","target":"add","arguments":[1,2]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("ca"))
+				Expect(recv.Result).To(Equal(float64(3)))
+				Expect(recv.Error).To(Equal(""))
+			})
+		})
+		Context("When invoked by the client with too few arguments", func() {
+			It("should return the error of the InvocationHandler", func() {
+				conn := connect(&catchAllHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "ca","target":"add","arguments":[1]}`)
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("ca"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).NotTo(Equal(""))
+			})
+		})
+	})
+
 	Describe("Panic in invoked func", func() {
 		Context("When a func is invoked by the client and panics", func() {
 			It("should be invoked on the server and return an error but no result", func() {
@@ -248,4 +366,40 @@ var _ = Describe("Invocation", func() {
 		})
 	})
 
+	Describe("Invocation of methods of Hub", func() {
+		Context("When the client invokes methods which the hub has from the embedded Hub", func() {
+			It("should return an error and not call them", func() {
+				conn := connect(&invocationHub{})
+				for i, invocation := range []struct {
+					target    string
+					arguments string
+				}{
+					{"abortconnection", `["%v","pwned",false]`},
+					{"initialize", `[null]`},
+					{"onconnected", `["%v"]`},
+					{"ondisconnected", `["%v"]`},
+					{"items", `[]`},
+					{"ping", `["%v"]`},
+					{"drain", `["%v",1000000000]`},
+					{"pausereading", `[]`},
+					{"resumereading", `[]`},
+					{"sendwithack", `["%v","target",1]`},
+					{"disconnectuser", `["user","pwned"]`},
+				} {
+					arguments := strings.Replace(invocation.arguments, "%v", conn.ConnectionID(), -1)
+					conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"%v","target":"%v","arguments":%v}`, i, invocation.target, arguments))
+					Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: fmt.Sprint(i),
+						Error: "Unknown method " + invocation.target})))
+				}
+			})
+		})
+		Context("When the hub has its own method with the name but not the signature of a method of Hub", func() {
+			It("should invoke the method of the hub", func() {
+				conn := connect(&pongHub{})
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"ping"}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "pong"})))
+			})
+		})
+	})
+
 })
@@ -0,0 +1,69 @@
+package signalr
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Invocation gives an InvocationHandler access to an invocation of the client
+// Target() is the name of the invoked method
+// ArgumentCount() is the number of arguments sent by the client
+// Arg() decodes the argument at index into value, which must be a pointer to the type the argument should have
+type Invocation interface {
+	Target() string
+	ArgumentCount() int
+	Arg(index int, value interface{}) error
+}
+
+// InvocationHandler can be implemented by a hub to dispatch invocations of methods the hub does not have itself.
+// HandleInvocation() is called with the invocation and returns the result or the error which should be sent
+// to the client. The result is handled like the result of a hub method, so it might be a Future or a chan, too.
+type InvocationHandler interface {
+	HandleInvocation(invocation Invocation) (interface{}, error)
+}
+
+type protocolInvocation struct {
+	message  invocationMessage
+	protocol HubProtocol
+}
+
+func (p *protocolInvocation) Target() string {
+	return p.message.Target
+}
+
+func (p *protocolInvocation) ArgumentCount() int {
+	return len(p.message.Arguments)
+}
+
+func (p *protocolInvocation) Arg(index int, value interface{}) error {
+	if index < 0 || index >= len(p.message.Arguments) {
+		return fmt.Errorf("argument index %v out of range, %v has %v arguments", index, p.message.Target, len(p.message.Arguments))
+	}
+	if v := reflect.ValueOf(value); v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("value must be a non nil pointer, not %T", value)
+	}
+	return p.protocol.UnmarshalArgument(p.message.Arguments[index], value)
+}
+
+func (sl *serverLoop) handleInvocationManually(handler InvocationHandler, invocation invocationMessage) {
+	sl.dispatchInvocation(invocation, func() {
+		var result []reflect.Value
+		func() {
+			defer sl.recoverInvocationPanic(invocation)
+			value, err := handler.HandleInvocation(&protocolInvocation{message: invocation, protocol: sl.protocol})
+			if err != nil {
+				if invocation.InvocationID != "" {
+					sl.complete(invocation, nil, err)
+				}
+				return
+			}
+			result = make([]reflect.Value, 0, 1)
+			if value != nil {
+				result = append(result, reflect.ValueOf(value))
+			}
+		}()
+		if result != nil {
+			sl.returnInvocationResult(invocation, result, func() {})
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// StuckInvocation describes a hub method which did not return within the invocation timeout and its grace period.
+// The invocation has been completed with an error, but the hub method is still running.
+type StuckInvocation struct {
+	ConnectionID string
+	InvocationID string
+	Target       string
+	Since        time.Time
+}
+
+// InvocationTimeoutError is passed to the OnError handler when a hub method is stuck
+type InvocationTimeoutError struct {
+	StuckInvocation
+}
+
+func (e *InvocationTimeoutError) Error() string {
+	return fmt.Sprintf("hub method %v of connection %v did not return within the invocation timeout", e.Target, e.ConnectionID)
+}
+
+// StuckInvocations returns the hub methods which are still running after their invocation timeout and grace period
+func (s *Server) StuckInvocations() []StuckInvocation {
+	var stuck []StuckInvocation
+	s.stuckInvocations.Range(func(key, value interface{}) bool {
+		stuck = append(stuck, *key.(*StuckInvocation))
+		return true
+	})
+	return stuck
+}
+
+// invocationContext returns the context for a hub method invocation, which has a deadline if InvocationTimeout is set
+func (sl *serverLoop) invocationContext() (context.Context, context.CancelFunc) {
+	if sl.server.invocationTimeout > 0 {
+		return context.WithTimeout(sl.principalContext(), sl.server.invocationTimeout)
+	}
+	return context.WithCancel(sl.principalContext())
+}
+
+// callHubMethod calls the hub method. If the hub method does not return within the invocation timeout
+// and grace period, the invocation is completed with an error, the invocation is registered as stuck
+// and ok is false
+func (sl *serverLoop) callHubMethod(invocation invocationMessage, call func() []reflect.Value) (result []reflect.Value, ok bool) {
+	if sl.server.invocationTimeout <= 0 {
+		return call(), true
+	}
+	done := make(chan []reflect.Value, 1)
+	sl.goSafe(func() { done <- call() })
+	select {
+	case result = <-done:
+		return result, true
+	case <-time.After(sl.server.invocationTimeout + sl.server.invocationGracePeriod):
+	}
+	stuck := &StuckInvocation{
+		ConnectionID: sl.hubConn.ConnectionID(),
+		InvocationID: invocation.InvocationID,
+		Target:       invocation.Target,
+		Since:        time.Now(),
+	}
+	sl.server.stuckInvocations.Store(stuck, true)
+	_ = sl.info.Log(evt, "invocation timeout", "name", invocation.Target, react, "send completion with error")
+	sl.notifyError(&InvocationTimeoutError{*stuck})
+	if invocation.InvocationID != "" {
+		sl.complete(invocation, nil, fmt.Errorf("invocation of %v timed out", invocation.Target))
+	}
+	sl.goSafe(func() {
+		<-done
+		sl.server.stuckInvocations.Delete(stuck)
+	})
+	return nil, false
+}
@@ -0,0 +1,89 @@
+package signalr
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// IPFilter decides by the network address of the client if a connection is accepted.
+// A client is rejected if its address is in one of the deny networks.
+// If there are allow networks, a client is only accepted when its address is in one of them.
+// The networks can be replaced by Update at any time, e.g. when the configuration is reloaded.
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+	mx    sync.RWMutex
+}
+
+// NewIPFilter creates an IPFilter from allow and deny networks in CIDR notation, e.g. "192.0.2.0/24"
+func NewIPFilter(allow []string, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	if err := f.Update(allow, deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update replaces the allow and deny networks of the IPFilter
+func (f *IPFilter) Update(allow []string, deny []string) error {
+	allowNets, err := parseCIDRs(allow)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseCIDRs(deny)
+	if err != nil {
+		return err
+	}
+	defer f.mx.Unlock()
+	f.mx.Lock()
+	f.allow = allowNets
+	f.deny = denyNets
+	return nil
+}
+
+// Allowed tells if a client with the address addr, e.g. "192.0.2.1:4711", may connect.
+// If the address is unknown, the client is only allowed when there are no allow networks.
+func (f *IPFilter) Allowed(addr string) bool {
+	defer f.mx.RUnlock()
+	f.mx.RLock()
+	ip := parseAddrIP(addr)
+	if ip == nil {
+		return len(f.allow) == 0
+	}
+	if containsIP(f.deny, ip) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, ip)
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network: %w", err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAddrIP returns the IP of an address with or without port, or nil if addr contains no IP
+func parseAddrIP(addr string) net.IP {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	return net.ParseIP(strings.Trim(host, "[]"))
+}
@@ -0,0 +1,65 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("IPFilter", func() {
+	Context("When created with invalid networks", func() {
+		It("should return an error", func() {
+			_, err := NewIPFilter([]string{"192.0.2.0"}, nil)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("When there are allow and deny networks", func() {
+		It("should only allow addresses from allowed and not denied networks", func() {
+			filter, err := NewIPFilter([]string{"192.0.2.0/24"}, []string{"192.0.2.128/25"})
+			Expect(err).To(BeNil())
+			Expect(filter.Allowed("192.0.2.1:4711")).To(BeTrue())
+			Expect(filter.Allowed("192.0.2.200:4711")).To(BeFalse())
+			Expect(filter.Allowed("198.51.100.1:4711")).To(BeFalse())
+			Expect(filter.Allowed("")).To(BeFalse())
+		})
+	})
+	Context("When there are only deny networks", func() {
+		It("should allow all other addresses", func() {
+			filter, err := NewIPFilter(nil, []string{"2001:db8::/32"})
+			Expect(err).To(BeNil())
+			Expect(filter.Allowed("[2001:db8::1]:4711")).To(BeFalse())
+			Expect(filter.Allowed("198.51.100.1")).To(BeTrue())
+			Expect(filter.Allowed("")).To(BeTrue())
+		})
+	})
+	Context("When the filter is updated", func() {
+		It("should use the new networks", func() {
+			filter, err := NewIPFilter(nil, nil)
+			Expect(err).To(BeNil())
+			Expect(filter.Allowed("192.0.2.1")).To(BeTrue())
+			Expect(filter.Update(nil, []string{"192.0.2.0/24"})).To(BeNil())
+			Expect(filter.Allowed("192.0.2.1")).To(BeFalse())
+		})
+	})
+	Context("When the server rejects a connection", func() {
+		It("should not answer the handshake", func() {
+			filter, _ := NewIPFilter([]string{"192.0.2.0/24"}, nil)
+			server, err := NewServer(UseHub(&invocationHub{}), UseIPFilter(filter))
+			Expect(err).To(BeNil())
+			conn := newTestingConnectionBeforeHandshake()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"protocol": "json","version": 1}`)
+			received := make(chan string, 1)
+			go func() {
+				hr, _ := conn.ClientReceive()
+				received <- hr
+			}()
+			select {
+			case hr := <-received:
+				Fail("handshake answered " + hr)
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+})
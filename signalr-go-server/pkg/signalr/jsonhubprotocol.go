@@ -3,7 +3,6 @@ package signalr
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/go-kit/kit/log"
 	"io"
@@ -11,7 +10,8 @@ import (
 
 // JSONHubProtocol is the JSON based SignalR protocol
 type JSONHubProtocol struct {
-	dbg StructuredLogger
+	dbg     StructuredLogger
+	scanner recordSeparatorScanner
 }
 
 // Protocol specific message for correct unmarshaling of Arguments
@@ -21,6 +21,7 @@ type jsonInvocationMessage struct {
 	InvocationID string            `json:"invocationId"`
 	Arguments    []json.RawMessage `json:"arguments"`
 	StreamIds    []string          `json:"streamIds,omitempty"`
+	Headers      map[string]string `json:"headers,omitempty"`
 }
 
 type jsonError struct {
@@ -34,21 +35,27 @@ func (j *jsonError) Error() string {
 
 // UnmarshalArgument unmarshals a json.RawMessage depending of the specified value type into value
 func (j *JSONHubProtocol) UnmarshalArgument(argument interface{}, value interface{}) error {
-	if err := json.Unmarshal(argument.(json.RawMessage), value); err != nil {
-		return &jsonError{string(argument.(json.RawMessage)), err}
+	raw, ok := argument.(json.RawMessage)
+	if !ok {
+		// Stream items and results are already decoded
+		data, err := json.Marshal(argument)
+		if err != nil {
+			return err
+		}
+		raw = data
+	}
+	if err := json.Unmarshal(raw, value); err != nil {
+		return &jsonError{string(raw), err}
 	}
 	return nil
 }
 
 // ReadMessage reads a JSON message from buf and returns the message if the buf contained one completely.
-// If buf does not contain the whole message, it returns a nil message and complete false
+// If buf does not contain the whole message, it returns a nil message and complete false and leaves buf unchanged.
 func (j *JSONHubProtocol) ReadMessage(buf *bytes.Buffer) (m interface{}, complete bool, err error) {
-	data, err := parseTextMessageFormat(buf)
-	switch {
-	case errors.Is(err, io.EOF):
-		return nil, false, err
-		// Other errors never happen, because parseTextMessageFormat will only return err
-		// from bytes.Buffer.ReadBytes() which is always io.EOF or nil
+	data, complete := j.scanner.next(buf)
+	if !complete {
+		return nil, false, io.EOF
 	}
 
 	message := hubMessage{}
@@ -74,6 +81,7 @@ func (j *JSONHubProtocol) ReadMessage(buf *bytes.Buffer) (m interface{}, complet
 			InvocationID: jsonInvocation.InvocationID,
 			Arguments:    arguments,
 			StreamIds:    jsonInvocation.StreamIds,
+			Headers:      jsonInvocation.Headers,
 		}
 		return invocation, true, err
 	case 2:
@@ -100,20 +108,42 @@ func (j *JSONHubProtocol) ReadMessage(buf *bytes.Buffer) (m interface{}, complet
 			err = &jsonError{string(data), err}
 		}
 		return cm, true, err
+	case 0:
+		// A client which sends the handshake again
+		request := handshakeRequest{}
+		if json.Unmarshal(data, &request) == nil && request.Protocol != "" {
+			return request, true, nil
+		}
+		return message, true, nil
 	default:
 		return message, true, nil
 	}
 }
 
-func parseTextMessageFormat(buf *bytes.Buffer) ([]byte, error) {
-	// 30 = ASCII record separator
-	data, err := buf.ReadBytes(30)
+// recordSeparatorScanner splits the messages of the text message format, which are terminated by the record separator.
+// The buffer it scans only grows by appending until a message is complete, so the scanner remembers how far
+// the buffer has been scanned and scans each byte only once, regardless of how many reads a message is split across.
+type recordSeparatorScanner struct {
+	scanned int
+}
 
-	if err != nil {
-		return data, err
+// next returns the next complete message from buf without the record separator and removes it from buf.
+// If buf does not contain a complete message, it returns false and leaves buf unchanged.
+func (r *recordSeparatorScanner) next(buf *bytes.Buffer) ([]byte, bool) {
+	if r.scanned > buf.Len() {
+		// buf has been consumed by someone else
+		r.scanned = 0
+	}
+	// 30 = ASCII record separator
+	i := bytes.IndexByte(buf.Bytes()[r.scanned:], 30)
+	if i < 0 {
+		r.scanned = buf.Len()
+		return nil, false
 	}
-	// Remove the delimiter
-	return data[0 : len(data)-1], err
+	data := buf.Next(r.scanned + i + 1)
+	r.scanned = 0
+	// Copy, data is only valid until buf is written again
+	return append([]byte(nil), data[:len(data)-1]...), true
 }
 
 // WriteMessage writes a message as JSON to the specified writer
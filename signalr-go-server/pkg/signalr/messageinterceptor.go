@@ -0,0 +1,50 @@
+package signalr
+
+// Hub message types as seen by a MessageInterceptor
+type (
+	// InvocationMessage is an Invocation (type 1) or StreamInvocation (type 4)
+	InvocationMessage = invocationMessage
+	// StreamItemMessage is a StreamItem (type 2)
+	StreamItemMessage = streamItemMessage
+	// CompletionMessage is a Completion (type 3)
+	CompletionMessage = completionMessage
+	// CancelInvocationMessage is a CancelInvocation (type 5)
+	CancelInvocationMessage = cancelInvocationMessage
+	// PingMessage is a Ping (type 6)
+	PingMessage = pingMessage
+	// CloseMessage is a Close (type 7)
+	CloseMessage = closeMessage
+	// HubMessage is any other message, only the type is known
+	HubMessage = hubMessage
+)
+
+// MessageInterceptor sees every hub message on the protocol layer.
+// Inbound() is called with every parsed message received from the client,
+// Outbound() is called with every message before it is serialized and sent to the client.
+// Both get the message as value of one of the hub message types and return the message which should be processed instead.
+// This allows to change the message or enrich it, e.g. by setting Headers.
+// If they return false, the message is dropped.
+type MessageInterceptor interface {
+	Inbound(connectionID string, message interface{}) (interface{}, bool)
+	Outbound(connectionID string, message interface{}) (interface{}, bool)
+}
+
+func interceptInbound(interceptors []MessageInterceptor, connectionID string, message interface{}) (interface{}, bool) {
+	for _, interceptor := range interceptors {
+		var ok bool
+		if message, ok = interceptor.Inbound(connectionID, message); !ok {
+			return nil, false
+		}
+	}
+	return message, true
+}
+
+func interceptOutbound(interceptors []MessageInterceptor, connectionID string, message interface{}) (interface{}, bool) {
+	for _, interceptor := range interceptors {
+		var ok bool
+		if message, ok = interceptor.Outbound(connectionID, message); !ok {
+			return nil, false
+		}
+	}
+	return message, true
+}
@@ -0,0 +1,289 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"io"
+)
+
+// MessagePackHubProtocol is the MessagePack based SignalR protocol.
+// Its messages are sent in binary frames, each prefixed by its length.
+// Arguments, stream items and results are converted to the parameter and channel types of the hub methods
+// like with the JSON protocol, so hub methods need no changes to be used with both protocols.
+type MessagePackHubProtocol struct {
+	dbg StructuredLogger
+}
+
+// Completion result kinds of the MessagePack protocol
+const (
+	msgpackResultError   = 1
+	msgpackResultVoid    = 2
+	msgpackResultNonVoid = 3
+)
+
+type msgpackError struct {
+	message string
+	err     error
+}
+
+func (m *msgpackError) Error() string {
+	return fmt.Sprintf("%v (message: %v)", m.err, m.message)
+}
+
+// UnmarshalArgument converts a decoded MessagePack value into value
+func (m *MessagePackHubProtocol) UnmarshalArgument(argument interface{}, value interface{}) error {
+	data, err := json.Marshal(argument)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+// ReadMessage reads a MessagePack message from buf and returns the message if the buf contained one completely.
+// If buf does not contain the whole message, it returns a nil message and complete false and leaves buf unchanged.
+func (m *MessagePackHubProtocol) ReadMessage(buf *bytes.Buffer) (interface{}, bool, error) {
+	// A client which sends the handshake again sends it as JSON text. A message in MessagePack can not start
+	// with a length of 0x7b followed by '"', as its content must start with an array
+	if bytes.HasPrefix(buf.Bytes(), []byte(`{"`)) {
+		end := bytes.IndexByte(buf.Bytes(), 30)
+		if end < 0 {
+			return nil, false, io.EOF
+		}
+		data := buf.Next(end + 1)
+		request := handshakeRequest{}
+		if err := json.Unmarshal(data[:end], &request); err != nil {
+			return nil, true, err
+		}
+		return request, true, nil
+	}
+	length, size, err := readVarInt(buf.Bytes())
+	if err != nil {
+		// The start of the next message can not be found
+		buf.Reset()
+		return nil, true, err
+	}
+	if size == 0 || buf.Len() < size+length {
+		return nil, false, io.EOF
+	}
+	data := buf.Next(size + length)[size:]
+	decoder := msgpackDecoder{data: data}
+	value, err := decoder.decode()
+	if err != nil {
+		return nil, true, err
+	}
+	_ = m.dbg.Log(evt, "read", msg, fmt.Sprintf("%v", value))
+	fields, ok := value.([]interface{})
+	if !ok || len(fields) == 0 {
+		return nil, true, &msgpackError{fmt.Sprint(value), errors.New("message is not an array")}
+	}
+	messageType, ok := fields[0].(int64)
+	if !ok {
+		return nil, true, &msgpackError{fmt.Sprint(value), errors.New("message type is not an integer")}
+	}
+	message, err := m.parseMessage(int(messageType), fields)
+	if err != nil {
+		err = &msgpackError{fmt.Sprint(value), err}
+	}
+	return message, true, err
+}
+
+func (m *MessagePackHubProtocol) parseMessage(messageType int, fields []interface{}) (interface{}, error) {
+	field := func(i int) interface{} {
+		if i < len(fields) {
+			return fields[i]
+		}
+		return nil
+	}
+	switch messageType {
+	case 1, 4:
+		if len(fields) < 5 {
+			return nil, errors.New("invocation has too few fields")
+		}
+		target, ok := field(3).(string)
+		if !ok {
+			return nil, errors.New("target is not a string")
+		}
+		arguments, ok := field(4).([]interface{})
+		if !ok {
+			return nil, errors.New("arguments are not an array")
+		}
+		var streamIds []string
+		if ids, ok := field(5).([]interface{}); ok {
+			for _, id := range ids {
+				streamIds = append(streamIds, fmt.Sprint(id))
+			}
+		}
+		return invocationMessage{
+			Type:         messageType,
+			Headers:      msgpackHeaders(field(1)),
+			InvocationID: msgpackString(field(2)),
+			Target:       target,
+			Arguments:    arguments,
+			StreamIds:    streamIds,
+		}, nil
+	case 2:
+		if len(fields) < 4 {
+			return nil, errors.New("stream item has too few fields")
+		}
+		item, err := jsonCompatible(field(3))
+		return streamItemMessage{
+			Type:         2,
+			Headers:      msgpackHeaders(field(1)),
+			InvocationID: msgpackString(field(2)),
+			Item:         item,
+		}, err
+	case 3:
+		if len(fields) < 4 {
+			return nil, errors.New("completion has too few fields")
+		}
+		completion := completionMessage{
+			Type:         3,
+			Headers:      msgpackHeaders(field(1)),
+			InvocationID: msgpackString(field(2)),
+		}
+		switch field(3) {
+		case int64(msgpackResultError):
+			completion.Error = msgpackString(field(4))
+		case int64(msgpackResultVoid):
+		case int64(msgpackResultNonVoid):
+			var err error
+			if completion.Result, err = jsonCompatible(field(4)); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("invalid completion result kind %v", field(3))
+		}
+		return completion, nil
+	case 5:
+		return cancelInvocationMessage{Type: 5, InvocationID: msgpackString(field(2))}, nil
+	case 6:
+		return pingMessage{Type: 6}, nil
+	case 7:
+		allowReconnect, _ := field(2).(bool)
+		return closeMessage{Type: 7, Error: msgpackString(field(1)), AllowReconnect: allowReconnect}, nil
+	default:
+		return hubMessage{Type: messageType}, nil
+	}
+}
+
+// WriteMessage writes a message as MessagePack to the specified writer
+func (m *MessagePackHubProtocol) WriteMessage(message interface{}, writer io.Writer) error {
+	var fields []interface{}
+	switch message := message.(type) {
+	case invocationMessage:
+		fields = []interface{}{message.Type, message.Headers, msgpackOptionalString(message.InvocationID), message.Target, message.Arguments}
+		if message.Arguments == nil {
+			fields[4] = []interface{}{}
+		}
+		if len(message.StreamIds) > 0 {
+			fields = append(fields, message.StreamIds)
+		}
+	case streamItemMessage:
+		fields = []interface{}{2, message.Headers, message.InvocationID, message.Item}
+	case completionMessage:
+		switch {
+		case message.Error != "":
+			fields = []interface{}{3, message.Headers, message.InvocationID, msgpackResultError, message.Error}
+		case message.Result == nil:
+			fields = []interface{}{3, message.Headers, message.InvocationID, msgpackResultVoid}
+		default:
+			fields = []interface{}{3, message.Headers, message.InvocationID, msgpackResultNonVoid, message.Result}
+		}
+	case cancelInvocationMessage:
+		fields = []interface{}{5, map[string]string{}, message.InvocationID}
+	case pingMessage:
+		fields = []interface{}{6}
+	case closeMessage:
+		fields = []interface{}{7, msgpackOptionalString(message.Error), message.AllowReconnect}
+	default:
+		return fmt.Errorf("message %v of type %T can not be written with the messagepack protocol", message, message)
+	}
+	if len(fields) > 1 {
+		if headers, ok := fields[1].(map[string]string); ok && headers == nil {
+			fields[1] = map[string]string{}
+		}
+	}
+	var encoder msgpackEncoder
+	if err := encoder.encode(fields); err != nil {
+		return err
+	}
+	_ = m.dbg.Log(evt, "write", msg, fmt.Sprintf("%v", fields))
+	data := appendVarInt(make([]byte, 0, encoder.buf.Len()+5), encoder.buf.Len())
+	data = append(data, encoder.buf.Bytes()...)
+	_, err := writer.Write(data)
+	return err
+}
+
+func (m *MessagePackHubProtocol) setDebugLogger(dbg StructuredLogger) {
+	m.dbg = log.WithPrefix(dbg, "ts", log.DefaultTimestampUTC, "protocol", "MessagePack")
+}
+
+func (m *MessagePackHubProtocol) isBinary() bool {
+	return true
+}
+
+// readVarInt reads the length prefix of a message. If data does not contain the whole prefix, size is 0
+func readVarInt(data []byte) (length int, size int, err error) {
+	for i := 0; i < 5; i++ {
+		if i == len(data) {
+			return 0, 0, nil
+		}
+		length |= int(data[i]&0x7f) << (7 * uint(i))
+		if data[i]&0x80 == 0 {
+			return length, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("message length prefix exceeds 5 bytes")
+}
+
+func appendVarInt(data []byte, length int) []byte {
+	for length > 0x7f {
+		data = append(data, byte(length&0x7f)|0x80)
+		length >>= 7
+	}
+	return append(data, byte(length))
+}
+
+func msgpackString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return ""
+}
+
+func msgpackOptionalString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func msgpackHeaders(value interface{}) map[string]string {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) == 0 {
+		return nil
+	}
+	headers := make(map[string]string, len(m))
+	for key, value := range m {
+		headers[key] = fmt.Sprint(value)
+	}
+	return headers
+}
+
+// jsonCompatible converts a decoded MessagePack value to the value the JSON protocol would have decoded,
+// so stream items and results are processed the same way for both protocols
+func jsonCompatible(value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var compatible interface{}
+	err = json.Unmarshal(data, &compatible)
+	return compatible, err
+}
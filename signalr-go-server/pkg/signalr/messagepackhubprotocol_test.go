@@ -0,0 +1,152 @@
+package signalr
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"time"
+)
+
+var _ = Describe("MessagePackHubProtocol", func() {
+	protocol := &MessagePackHubProtocol{}
+	protocol.setDebugLogger(log.NewNopLogger())
+	roundTrip := func(message interface{}) interface{} {
+		var buf bytes.Buffer
+		Expect(protocol.WriteMessage(message, &buf)).To(BeNil())
+		read, complete, err := protocol.ReadMessage(&buf)
+		Expect(err).To(BeNil())
+		Expect(complete).To(BeTrue())
+		Expect(buf.Len()).To(Equal(0))
+		return read
+	}
+
+	Context("When a message is written and read", func() {
+		It("should return the same message", func() {
+			Expect(roundTrip(invocationMessage{Type: 1, InvocationID: "1", Target: "add", Arguments: []interface{}{"a", true},
+				StreamIds: []string{"s1"}, Headers: map[string]string{"h": "v"}})).To(Equal(
+				invocationMessage{Type: 1, InvocationID: "1", Target: "add", Arguments: []interface{}{"a", true},
+					StreamIds: []string{"s1"}, Headers: map[string]string{"h": "v"}}))
+			Expect(roundTrip(streamItemMessage{Type: 2, InvocationID: "2", Item: 5})).To(Equal(
+				streamItemMessage{Type: 2, InvocationID: "2", Item: float64(5)}))
+			Expect(roundTrip(completionMessage{Type: 3, InvocationID: "3", Error: "failed"})).To(Equal(
+				completionMessage{Type: 3, InvocationID: "3", Error: "failed"}))
+			Expect(roundTrip(completionMessage{Type: 3, InvocationID: "3"})).To(Equal(
+				completionMessage{Type: 3, InvocationID: "3"}))
+			Expect(roundTrip(completionMessage{Type: 3, InvocationID: "3", Result: struct {
+				A int    `json:"a"`
+				B string `json:"b"`
+			}{-300, "x"}})).To(Equal(
+				completionMessage{Type: 3, InvocationID: "3", Result: map[string]interface{}{"a": float64(-300), "b": "x"}}))
+			Expect(roundTrip(cancelInvocationMessage{Type: 5, InvocationID: "4"})).To(Equal(
+				cancelInvocationMessage{Type: 5, InvocationID: "4"}))
+			Expect(roundTrip(pingMessage{Type: 6})).To(Equal(pingMessage{Type: 6}))
+			Expect(roundTrip(closeMessage{Type: 7, Error: "bye", AllowReconnect: true})).To(Equal(
+				closeMessage{Type: 7, Error: "bye", AllowReconnect: true}))
+		})
+	})
+	Context("When an invocation is written", func() {
+		It("should be encoded as described in the SignalR MessagePack protocol", func() {
+			var buf bytes.Buffer
+			Expect(protocol.WriteMessage(invocationMessage{Type: 1, InvocationID: "xyz", Target: "method",
+				Arguments: []interface{}{42}}, &buf)).To(BeNil())
+			Expect(buf.Bytes()).To(Equal([]byte{0x10, 0x95, 0x01, 0x80, 0xa3, 'x', 'y', 'z',
+				0xa6, 'm', 'e', 't', 'h', 'o', 'd', 0x91, 0x2a}))
+		})
+	})
+	Context("When the buffer contains only a part of a message", func() {
+		It("should return an incomplete message and leave the buffer unchanged", func() {
+			var buf bytes.Buffer
+			Expect(protocol.WriteMessage(pingMessage{Type: 6}, &buf)).To(BeNil())
+			Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "1", Result: "abc"}, &buf)).To(BeNil())
+			data := buf.Bytes()
+			partial := bytes.NewBuffer(append([]byte(nil), data[:len(data)-1]...))
+			message, complete, err := protocol.ReadMessage(partial)
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message).To(Equal(pingMessage{Type: 6}))
+			length := partial.Len()
+			_, complete, _ = protocol.ReadMessage(partial)
+			Expect(complete).To(BeFalse())
+			Expect(partial.Len()).To(Equal(length))
+			partial.WriteByte(data[len(data)-1])
+			message, complete, err = protocol.ReadMessage(partial)
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message).To(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "abc"}))
+		})
+	})
+	Context("When the client sends the handshake again", func() {
+		It("should read it as handshake request", func() {
+			var buf bytes.Buffer
+			buf.WriteString(`{"protocol":"json","version":1}`)
+			_, complete, _ := protocol.ReadMessage(&buf)
+			Expect(complete).To(BeFalse())
+			buf.WriteByte(30)
+			Expect(protocol.WriteMessage(pingMessage{Type: 6}, &buf)).To(BeNil())
+			message, complete, err := protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message).To(Equal(handshakeRequest{Protocol: "json", Version: 1}))
+			message, _, err = protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(pingMessage{Type: 6}))
+		})
+	})
+	Context("When values of all MessagePack types are encoded", func() {
+		It("should decode them again", func() {
+			long := string(bytes.Repeat([]byte("s"), 70000))
+			values := []interface{}{nil, true, false, int64(0), int64(-1), int64(-33), int64(200), int64(-200), int64(70000),
+				int64(-70000), int64(1 << 40), int64(-1 << 40), uint64(1 << 63), 1.5, "short", long, []byte{1, 2, 3},
+				[]interface{}{int64(1), "a"}, map[string]interface{}{"k": int64(1)}}
+			var encoder msgpackEncoder
+			Expect(encoder.encode(values)).To(BeNil())
+			decoder := msgpackDecoder{data: encoder.buf.Bytes()}
+			Expect(decoder.decode()).To(Equal(values))
+		})
+	})
+	Context("When an argument is unmarshaled", func() {
+		It("should convert it to the type of the value", func() {
+			var p struct {
+				Name  string
+				Count int
+			}
+			Expect(protocol.UnmarshalArgument(map[string]interface{}{"Name": "n", "Count": int64(3)}, &p)).To(BeNil())
+			Expect(p.Name).To(Equal("n"))
+			Expect(p.Count).To(Equal(3))
+		})
+	})
+	Context("When a websocket client requests the messagepack protocol", func() {
+		It("should answer in binary frames", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			}()
+			waitForPort(port)
+			ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "", "http://127.0.0.1")
+			Expect(err).To(BeNil())
+			defer func() {
+				_ = ws.Close()
+			}()
+			Expect(websocket.Message.Send(ws, `{"protocol":"messagepack","version":1}`+"\u001e")).To(BeNil())
+			var f frame
+			Expect(frameCodec.Receive(ws, &f)).To(BeNil())
+			Expect(string(f.data)).To(Equal("{}\u001e"))
+			var buf bytes.Buffer
+			Expect(protocol.WriteMessage(invocationMessage{Type: 1, InvocationID: "1", Target: "add2", Arguments: []interface{}{1}}, &buf)).To(BeNil())
+			Expect(websocket.Message.Send(ws, buf.Bytes())).To(BeNil())
+			_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+			Expect(frameCodec.Receive(ws, &f)).To(BeNil())
+			Expect(f.payloadType).To(Equal(byte(websocket.BinaryFrame)))
+			message, complete, err := protocol.ReadMessage(bytes.NewBuffer(f.data))
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message).To(Equal(completionMessage{Type: 3, InvocationID: "1", Result: float64(3)}))
+		})
+	})
+})
@@ -0,0 +1,164 @@
+package signalr
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// SignatureHeader is the header which holds the signature of a signed message
+const SignatureHeader = "signature"
+
+// MessageSigner signs the messages sent to a connection.
+// The signed payload is the canonical JSON encoding of the message without headers: no whitespace, the keys of all
+// objects sorted, numbers in their shortest form like with JSON.stringify in JavaScript and <, > and & not escaped,
+// e.g. {"arguments":[1,2.5],"target":"update","type":1}. Messages of the MessagePack protocol are signed in the
+// same form. The signature is sent in the SignatureHeader of the message.
+type MessageSigner interface {
+	Sign(connectionID string, payload []byte) (string, error)
+}
+
+// MessageVerifier verifies the signatures of the invocations received from a connection.
+// Verify returns an error if signature is not a valid signature of payload, which is built like the payload of a MessageSigner.
+// Asymmetric signatures can be supported by a MessageVerifier holding the public keys of the clients.
+type MessageVerifier interface {
+	Verify(connectionID string, payload []byte, signature string) error
+}
+
+// HMACKeyFunc returns the HMAC key shared with the client of a connection
+type HMACKeyFunc func(connectionID string) ([]byte, error)
+
+// HMACSigner is a MessageSigner and MessageVerifier which uses HMAC-SHA256 signatures in base64 encoding
+type HMACSigner struct {
+	keyFunc HMACKeyFunc
+}
+
+// NewHMACSigner creates a HMACSigner with the keys returned by keyFunc
+func NewHMACSigner(keyFunc HMACKeyFunc) *HMACSigner {
+	return &HMACSigner{keyFunc: keyFunc}
+}
+
+func (h *HMACSigner) mac(connectionID string, payload []byte) ([]byte, error) {
+	key, err := h.keyFunc(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+// Sign returns the HMAC of payload
+func (h *HMACSigner) Sign(connectionID string, payload []byte) (string, error) {
+	sum, err := h.mac(connectionID, payload)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sum), nil
+}
+
+// Verify checks that signature is the HMAC of payload
+func (h *HMACSigner) Verify(connectionID string, payload []byte, signature string) error {
+	expected, err := h.mac(connectionID, payload)
+	if err != nil {
+		return err
+	}
+	actual, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, actual) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
+
+// signingInterceptor sets the SignatureHeader of the outbound invocations, stream items and completions
+type signingInterceptor struct {
+	signer MessageSigner
+}
+
+func (s *signingInterceptor) Inbound(connectionID string, message interface{}) (interface{}, bool) {
+	return message, true
+}
+
+func (s *signingInterceptor) Outbound(connectionID string, message interface{}) (interface{}, bool) {
+	switch m := message.(type) {
+	case invocationMessage:
+		headers := m.Headers
+		m.Headers = nil
+		m.Headers = s.sign(connectionID, m, headers)
+		return m, true
+	case streamItemMessage:
+		headers := m.Headers
+		m.Headers = nil
+		m.Headers = s.sign(connectionID, m, headers)
+		return m, true
+	case completionMessage:
+		headers := m.Headers
+		m.Headers = nil
+		m.Headers = s.sign(connectionID, m, headers)
+		return m, true
+	}
+	return message, true
+}
+
+// sign returns the headers of the message with the signature of message, which must have no headers.
+// The signature does not cover the headers, e.g. the trace context.
+// If the message can not be signed, it is sent without signature, which the client should reject
+func (s *signingInterceptor) sign(connectionID string, message interface{}, headers map[string]string) map[string]string {
+	payload, err := signedPayload(message)
+	if err != nil {
+		return headers
+	}
+	signature, err := s.signer.Sign(connectionID, payload)
+	if err != nil {
+		return headers
+	}
+	signed := map[string]string{SignatureHeader: signature}
+	for key, value := range headers {
+		if key != SignatureHeader {
+			signed[key] = value
+		}
+	}
+	return signed
+}
+
+// verifySignature checks the signature in the SignatureHeader of the invocation.
+// The payload is built from the received invocation in the canonical form, so it does not depend on how the
+// client formatted the message
+func verifySignature(verifier MessageVerifier, connectionID string, invocation invocationMessage) error {
+	signature, ok := invocation.Headers[SignatureHeader]
+	if !ok {
+		return errors.New("missing signature")
+	}
+	invocation.Headers = nil
+	payload, err := signedPayload(invocation)
+	if err != nil {
+		return err
+	}
+	return verifier.Verify(connectionID, payload, signature)
+}
+
+// signedPayload returns the canonical JSON encoding of message, see MessageSigner.
+// Decoding into the generic JSON types sorts the object keys when encoded again and brings numbers in their shortest form
+func signedPayload(message interface{}) ([]byte, error) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err = json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
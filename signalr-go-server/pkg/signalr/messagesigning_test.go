@@ -0,0 +1,91 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"os"
+)
+
+var testHMACSigner = NewHMACSigner(func(connectionID string) ([]byte, error) {
+	if connectionID == "nokey" {
+		return nil, errors.New("no key")
+	}
+	return []byte("secret-" + connectionID), nil
+})
+
+func connectSigned(connectionID string) *testingConnection {
+	server, err := NewServer(SimpleHubFactory(&invocationHub{}),
+		Logger(log.NewLogfmtLogger(os.Stderr), false),
+		SignMessages(testHMACSigner, testHMACSigner))
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	conn.connectionID = connectionID
+	go server.Run(context.TODO(), conn)
+	return conn
+}
+
+func signedInvocation(connectionID string, invocation invocationMessage) string {
+	payload, _ := signedPayload(invocation)
+	signature, _ := testHMACSigner.Sign(connectionID, payload)
+	invocation.Headers = map[string]string{SignatureHeader: signature}
+	data, _ := json.Marshal(invocation)
+	return string(data)
+}
+
+var _ = Describe("MessageSigning", func() {
+	Context("When a signed invocation is received", func() {
+		It("should invoke the hub method and sign the completion", func() {
+			conn := connectSigned("sig")
+			conn.ClientSend(signedInvocation("sig", invocationMessage{Type: 1, InvocationID: "1", Target: "simpleint", Arguments: []interface{}{5}}))
+			Expect(<-invocationQueue).To(Equal("SimpleInt(5)"))
+			completion := (<-conn.received).(completionMessage)
+			Expect(completion.Error).To(Equal(""))
+			Expect(completion.Headers).To(HaveKey(SignatureHeader))
+			signature := completion.Headers[SignatureHeader]
+			payload := `{"invocationId":"1","result":6,"type":3}`
+			Expect(testHMACSigner.Verify("sig", []byte(payload), signature)).To(Succeed())
+		})
+	})
+	Context("When a signed invocation is formatted differently than its canonical form", func() {
+		It("should verify the signature over the canonical form", func() {
+			conn := connectSigned("sig")
+			signature, _ := testHMACSigner.Sign("sig", []byte(`{"arguments":[5],"invocationId":"1","target":"simpleint","type":1}`))
+			conn.ClientSend(`{ "target": "simpleint", "type": 1, "arguments": [ 5 ], "invocationId": "1", ` +
+				`"headers": {"` + SignatureHeader + `": "` + signature + `"} }`)
+			Expect(<-invocationQueue).To(Equal("SimpleInt(5)"))
+			Expect((<-conn.received).(completionMessage).Error).To(Equal(""))
+		})
+	})
+	Context("When the signed payload of a message is built", func() {
+		It("should sort the keys of all objects, shorten numbers and not escape HTML characters", func() {
+			payload, err := signedPayload(invocationMessage{Type: 1, Target: "update",
+				Arguments: []interface{}{json.RawMessage(`{ "b": 1.50, "a": "<&>" }`), 2e3}})
+			Expect(err).To(BeNil())
+			Expect(string(payload)).To(Equal(`{"arguments":[{"a":"<&>","b":1.5},2000],"target":"update","type":1}`))
+		})
+	})
+	Context("When an invocation has a wrong signature", func() {
+		It("should complete the invocation with an error", func() {
+			conn := connectSigned("sig")
+			conn.ClientSend(signedInvocation("other", invocationMessage{Type: 1, InvocationID: "1", Target: "simpleint", Arguments: []interface{}{5}}))
+			Expect((<-conn.received).(completionMessage).Error).To(Equal("invalid signature"))
+		})
+	})
+	Context("When an invocation has no signature", func() {
+		It("should complete the invocation with an error", func() {
+			conn := connectSigned("sig")
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"simpleint","arguments":[5]}`)
+			Expect((<-conn.received).(completionMessage).Error).To(Equal("invalid signature"))
+		})
+	})
+	Context("When the SignMessages option has neither signer nor verifier", func() {
+		It("should fail", func() {
+			_, err := NewServer(SimpleHubFactory(&invocationHub{}), SignMessages(nil, nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,103 @@
+package signalr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+)
+
+// OutboxMessage is a durable invocation of a client method, which is kept in the Outbox until the client acknowledges it.
+// The invocation is sent with ID as invocation id. The client acknowledges it by sending a completion with this id.
+type OutboxMessage struct {
+	ID           string
+	ConnectionID string
+	Target       string
+	Args         []interface{}
+}
+
+// Outbox persists durable sends until they are acknowledged by the client.
+// Put() stores a message before it is sent
+// Pending() returns the unacknowledged messages of a connection, oldest first
+// Ack() removes an acknowledged message
+type Outbox interface {
+	Put(message OutboxMessage) error
+	Pending(connectionID string) ([]OutboxMessage, error)
+	Ack(connectionID string, id string) error
+}
+
+// NewMemoryOutbox creates an Outbox which keeps the messages in memory.
+// The messages are lost when the process ends, so it is only useful for tests and to retry sends on flaky connections.
+func NewMemoryOutbox() Outbox {
+	return &memoryOutbox{messages: make(map[string][]OutboxMessage)}
+}
+
+type memoryOutbox struct {
+	messages map[string][]OutboxMessage
+	mx       sync.Mutex
+}
+
+func (m *memoryOutbox) Put(message OutboxMessage) error {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	m.messages[message.ConnectionID] = append(m.messages[message.ConnectionID], message)
+	return nil
+}
+
+func (m *memoryOutbox) Pending(connectionID string) ([]OutboxMessage, error) {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	return append([]OutboxMessage(nil), m.messages[connectionID]...), nil
+}
+
+func (m *memoryOutbox) Ack(connectionID string, id string) error {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	messages := m.messages[connectionID]
+	for i, message := range messages {
+		if message.ID == id {
+			messages = append(messages[:i], messages[i+1:]...)
+			break
+		}
+	}
+	if len(messages) == 0 {
+		delete(m.messages, connectionID)
+	} else {
+		m.messages[connectionID] = messages
+	}
+	return nil
+}
+
+func newOutboxMessageID() string {
+	bytes := make([]byte, 16)
+	// rand.Read only fails when the systems random number generator fails. Rare case, ignore
+	_, _ = rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// resendOutbox sends the pending messages of the connection in the background.
+// If the last resend has not finished yet, it does nothing
+func (sl *serverLoop) resendOutbox() {
+	if !atomic.CompareAndSwapInt32(&sl.outboxResending, 0, 1) {
+		return
+	}
+	sl.goSafe(func() {
+		defer atomic.StoreInt32(&sl.outboxResending, 0)
+		sl.sendPendingOutboxMessages()
+	})
+}
+
+func (sl *serverLoop) sendPendingOutboxMessages() {
+	pending, err := sl.server.outbox.Pending(sl.hubConn.ConnectionID())
+	if err != nil {
+		_ = sl.info.Log(evt, "outbox pending", "error", err, react, "retry later")
+		return
+	}
+	for _, message := range pending {
+		m := message
+		sendMessageAndLog(func() (interface{}, error) {
+			return sl.hubConn.SendInvocationWithID(context.Background(), m.ID, m.Target, m.Args...)
+		}, sl.info)
+	}
+}
@@ -0,0 +1,37 @@
+package signalr
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// PanicError is passed to the OnError handler when a goroutine of the server panics.
+// Value is the value passed to panic, Stack the stack trace of the panicking goroutine.
+type PanicError struct {
+	ConnectionID string
+	Value        interface{}
+	Stack        []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic in connection %v: %v", p.ConnectionID, p.Value)
+}
+
+// goSafe runs f in a new goroutine. If f panics, the panic is recovered and passed to onPanic.
+// If onPanic is nil, the panic is not recovered.
+func goSafe(connectionID string, f func(), onPanic func(err *PanicError)) {
+	go runSafe(connectionID, f, onPanic)
+}
+
+// runSafe runs f. If f panics, the panic is recovered and passed to onPanic.
+// If onPanic is nil, the panic is not recovered.
+func runSafe(connectionID string, f func(), onPanic func(err *PanicError)) {
+	if onPanic != nil {
+		defer func() {
+			if r := recover(); r != nil {
+				onPanic(&PanicError{ConnectionID: connectionID, Value: r, Stack: debug.Stack()})
+			}
+		}()
+	}
+	f()
+}
@@ -0,0 +1,124 @@
+package signalr
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PayloadCipher encrypts the arguments of invocations sent to clients and decrypts the arguments
+// of invocations received from clients. The key exchange with the clients is up to the application.
+// Encrypt is called with the group of the invocation if it is sent to a group, else group is "".
+// Encrypted invocations have one argument: the base64 encoded ciphertext of the JSON array of the arguments.
+type PayloadCipher interface {
+	Encrypt(connectionID string, group string, plaintext []byte) ([]byte, error)
+	Decrypt(connectionID string, ciphertext []byte) ([]byte, error)
+}
+
+// PayloadKeyFunc returns the key for the payloads of a connection, or of a group if group is not ""
+type PayloadKeyFunc func(connectionID string, group string) ([]byte, error)
+
+// NewAESGCMCipher creates a PayloadCipher which uses AES-GCM with the keys returned by keyFunc.
+// The keys must have 16, 24 or 32 bytes. The random nonce is prepended to the ciphertext.
+func NewAESGCMCipher(keyFunc PayloadKeyFunc) PayloadCipher {
+	return &aesGCMCipher{keyFunc: keyFunc}
+}
+
+type aesGCMCipher struct {
+	keyFunc PayloadKeyFunc
+}
+
+func (a *aesGCMCipher) aead(connectionID string, group string) (cipher.AEAD, error) {
+	key, err := a.keyFunc(connectionID, group)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (a *aesGCMCipher) Encrypt(connectionID string, group string, plaintext []byte) ([]byte, error) {
+	aead, err := a.aead(connectionID, group)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (a *aesGCMCipher) Decrypt(connectionID string, ciphertext []byte) ([]byte, error) {
+	aead, err := a.aead(connectionID, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+}
+
+// payloadEncryption holds the PayloadCipher and the targets whose payloads are encrypted
+type payloadEncryption struct {
+	cipher PayloadCipher
+	// targets holds the lower case names of the encrypted targets. If it is empty, all targets are encrypted
+	targets map[string]bool
+}
+
+func (p *payloadEncryption) applies(target string) bool {
+	return p != nil && (len(p.targets) == 0 || p.targets[strings.ToLower(target)])
+}
+
+// encrypt returns the argument of the encrypted invocation
+func (p *payloadEncryption) encrypt(connectionID string, group string, args []interface{}) ([]interface{}, error) {
+	if args == nil {
+		args = make([]interface{}, 0)
+	}
+	plaintext, err := json.Marshal(args)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := p.cipher.Encrypt(connectionID, group, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{base64.StdEncoding.EncodeToString(ciphertext)}, nil
+}
+
+// decrypt replaces the encrypted argument of the invocation by the decrypted arguments
+func (p *payloadEncryption) decrypt(connectionID string, protocol HubProtocol, invocation invocationMessage) (invocationMessage, error) {
+	if len(invocation.Arguments) != 1 {
+		return invocation, fmt.Errorf("encrypted invocation of %v must have exactly one argument", invocation.Target)
+	}
+	var encoded string
+	if err := protocol.UnmarshalArgument(invocation.Arguments[0], &encoded); err != nil {
+		return invocation, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return invocation, err
+	}
+	plaintext, err := p.cipher.Decrypt(connectionID, ciphertext)
+	if err != nil {
+		return invocation, err
+	}
+	var args []json.RawMessage
+	if err = json.Unmarshal(plaintext, &args); err != nil {
+		return invocation, err
+	}
+	invocation.Arguments = make([]interface{}, len(args))
+	for i, arg := range args {
+		invocation.Arguments[i] = arg
+	}
+	return invocation, nil
+}
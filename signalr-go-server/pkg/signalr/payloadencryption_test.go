@@ -0,0 +1,112 @@
+package signalr
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"os"
+	"time"
+)
+
+var encryptionHubQueue = make(chan string, 10)
+
+type encryptionHub struct {
+	Hub
+}
+
+func (e *encryptionHub) Secret(text string, n int) {
+	encryptionHubQueue <- text
+	e.Clients().Caller().Send("secret", text, n)
+}
+
+func (e *encryptionHub) Public(text string) {
+	e.Clients().Caller().Send("public", text)
+}
+
+var testPayloadKey = []byte("0123456789abcdef")
+
+var testPayloadCipher = NewAESGCMCipher(func(connectionID string, group string) ([]byte, error) {
+	if connectionID == "nokey" {
+		return nil, errors.New("no key")
+	}
+	return testPayloadKey, nil
+})
+
+func encryptTestPayload(args ...interface{}) string {
+	plaintext, _ := json.Marshal(args)
+	ciphertext, _ := testPayloadCipher.Encrypt("", "", plaintext)
+	return base64.StdEncoding.EncodeToString(ciphertext)
+}
+
+func decryptTestPayload(arg interface{}) []interface{} {
+	ciphertext, err := base64.StdEncoding.DecodeString(arg.(string))
+	Expect(err).To(BeNil())
+	plaintext, err := testPayloadCipher.Decrypt("", ciphertext)
+	Expect(err).To(BeNil())
+	var args []interface{}
+	Expect(json.Unmarshal(plaintext, &args)).To(BeNil())
+	return args
+}
+
+func connectEncrypted(connectionID string) *testingConnection {
+	server, err := NewServer(SimpleHubFactory(&encryptionHub{}),
+		Logger(log.NewLogfmtLogger(os.Stderr), false),
+		EncryptPayloads(testPayloadCipher, "secret"))
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	conn.connectionID = connectionID
+	go server.Run(context.TODO(), conn)
+	return conn
+}
+
+var _ = Describe("PayloadEncryption", func() {
+	Context("When an encrypted target is invoked", func() {
+		It("should decrypt the arguments and encrypt the arguments of the client invocation", func() {
+			conn := connectEncrypted("enc")
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"secret","arguments":["` + encryptTestPayload("hush", 5) + `"]}`)
+			Expect(<-encryptionHubQueue).To(Equal("hush"))
+			message := <-conn.received
+			invocation, ok := message.(invocationMessage)
+			Expect(ok).To(BeTrue())
+			Expect(invocation.Target).To(Equal("secret"))
+			Expect(len(invocation.Arguments)).To(Equal(1))
+			Expect(decryptTestPayload(invocation.Arguments[0])).To(Equal([]interface{}{"hush", float64(5)}))
+		})
+	})
+	Context("When a target which is not encrypted is invoked", func() {
+		It("should pass the arguments unchanged", func() {
+			conn := connectEncrypted("enc")
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"public","arguments":["open"]}`)
+			message := <-conn.received
+			Expect(message.(invocationMessage).Arguments).To(Equal([]interface{}{"open"}))
+		})
+	})
+	Context("When the payload can not be decrypted", func() {
+		It("should complete the invocation with an error", func() {
+			conn := connectEncrypted("enc")
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"secret","arguments":["bm90IGVuY3J5cHRlZA=="]}`)
+			select {
+			case message := <-conn.received:
+				Expect(message.(completionMessage).Error).To(Equal("invalid encrypted payload"))
+			case <-time.After(time.Second):
+				Fail("no completion")
+			}
+		})
+		It("should complete the invocation with an error when there is no key", func() {
+			conn := connectEncrypted("nokey")
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"secret","arguments":["` + encryptTestPayload("hush", 5) + `"]}`)
+			message := <-conn.received
+			Expect(message.(completionMessage).Error).To(Equal("invalid encrypted payload"))
+		})
+	})
+	Context("When the EncryptPayloads option has no cipher", func() {
+		It("should fail", func() {
+			_, err := NewServer(SimpleHubFactory(&encryptionHub{}), EncryptPayloads(nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,36 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type pingHub struct {
+	Hub
+}
+
+func (p *pingHub) Probe(connectionID string) bool {
+	return p.Ping(connectionID) == nil
+}
+
+var _ = Describe("Ping", func() {
+	Context("When a connection is pinged", func() {
+		It("should report if the ping could be written", func() {
+			server, err := NewServer(SimpleHubFactory(&pingHub{}))
+			Expect(err).To(BeNil())
+			prober := newTestingConnection()
+			go server.Run(context.TODO(), prober)
+			pinged := newTestingConnection()
+			pinged.connectionID = "pinged"
+			go server.Run(context.TODO(), pinged)
+			Eventually(func() error { return server.Ping("pinged") }).Should(BeNil())
+			prober.ClientSend(`{"type":1,"invocationId":"1","target":"probe","arguments":["pinged"]}`)
+			Eventually(prober.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: true})))
+			prober.ClientSend(`{"type":1,"invocationId":"2","target":"probe","arguments":["unknown"]}`)
+			Eventually(prober.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Result: false})))
+			pinged.SetFailWrite("broken pipe")
+			Expect(server.Ping("pinged")).To(MatchError("broken pipe"))
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package signalr
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+)
+
+// preparedInvocation is an invocation which is sent to many connections. It is encoded only once per protocol type
+type preparedInvocation struct {
+	message invocationMessage
+	mx      sync.Mutex
+	encoded map[reflect.Type]encodedMessage
+}
+
+// encodedMessage is a message which has already been written by the protocol of the connection
+type encodedMessage []byte
+
+func newPreparedInvocation(target string, args []interface{}, headers map[string]string) *preparedInvocation {
+	if args == nil {
+		// Clients expect an array, even if there are no arguments
+		args = make([]interface{}, 0)
+	}
+	return &preparedInvocation{
+		message: invocationMessage{Type: 1, Target: target, Arguments: args, Headers: headers},
+		encoded: make(map[reflect.Type]encodedMessage),
+	}
+}
+
+func (p *preparedInvocation) encode(protocol HubProtocol) (encodedMessage, error) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	protocolType := reflect.TypeOf(protocol)
+	if data, ok := p.encoded[protocolType]; ok {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	if err := protocol.WriteMessage(p.message, &buf); err != nil {
+		return nil, err
+	}
+	p.encoded[protocolType] = buf.Bytes()
+	return buf.Bytes(), nil
+}
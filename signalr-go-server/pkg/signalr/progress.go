@@ -0,0 +1,21 @@
+package signalr
+
+// Progress reports the progress of a long running hub method to the caller.
+// A hub method which declares a *Progress parameter gets it injected by the server.
+// The client does not send an argument for it. Each call of Report is sent to the caller
+// as StreamItem of the invocation, the return value of the method is sent as Completion.
+// Report should not be called after the hub method has returned.
+type Progress struct {
+	conn         hubConnection
+	invocationID string
+}
+
+// Report sends value as progress update to the caller.
+// If the caller does not wait for the result of the invocation, Report does nothing.
+func (p *Progress) Report(value interface{}) error {
+	if p.invocationID == "" {
+		return nil
+	}
+	_, err := p.conn.StreamItem(p.invocationID, value)
+	return err
+}
@@ -0,0 +1,200 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewProxyProtocolListener wraps a net.Listener which is placed behind a load balancer speaking the PROXY protocol
+// (see https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt).
+// Only connections from the trustedProxies, given as CIDR like "10.0.0.0/8", may send a PROXY protocol v1 or v2 header.
+// The header is stripped and RemoteAddr() returns the address of the client announced in it.
+// Connections of trusted proxies without header and connections of all other peers are passed through unchanged,
+// so clients connecting directly can not announce an address which is trusted by an IPFilter.
+// Trusted proxies must send the header within ten seconds. The listener can be used for raw TCP connections
+// as well as with http.Serve.
+func NewProxyProtocolListener(listener net.Listener, trustedProxies ...string) (net.Listener, error) {
+	if len(trustedProxies) == 0 {
+		return nil, errors.New("no trusted proxies")
+	}
+	networks, err := parseCIDRs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolListener{Listener: listener, trustedProxies: networks, headerTimeout: 10 * time.Second}, nil
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	trustedProxies []*net.IPNet
+	headerTimeout  time.Duration
+}
+
+func (p *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := p.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ip := parseAddrIP(conn.RemoteAddr().String())
+	if ip == nil || !containsIP(p.trustedProxies, ip) {
+		return conn, nil
+	}
+	return &proxyProtocolConn{Conn: conn, reader: bufio.NewReader(conn), headerTimeout: p.headerTimeout}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+	once          sync.Once
+	remoteAddr    net.Addr
+	headerErr     error
+	mx            sync.Mutex
+	readDeadline  time.Time
+}
+
+// readHeader reads the header with a deadline of headerTimeout, or the earlier read deadline set by the user of the
+// connection. Afterwards, the read deadline of the user is restored
+func (p *proxyProtocolConn) readHeader() {
+	p.once.Do(func() {
+		deadline := time.Now().Add(p.headerTimeout)
+		p.mx.Lock()
+		if !p.readDeadline.IsZero() && p.readDeadline.Before(deadline) {
+			deadline = p.readDeadline
+		}
+		_ = p.Conn.SetReadDeadline(deadline)
+		p.mx.Unlock()
+		p.remoteAddr, p.headerErr = readProxyProtocolHeader(p.reader)
+		p.mx.Lock()
+		_ = p.Conn.SetReadDeadline(p.readDeadline)
+		p.mx.Unlock()
+	})
+}
+
+func (p *proxyProtocolConn) Read(b []byte) (int, error) {
+	p.readHeader()
+	if p.headerErr != nil {
+		return 0, p.headerErr
+	}
+	return p.reader.Read(b)
+}
+
+func (p *proxyProtocolConn) RemoteAddr() net.Addr {
+	p.readHeader()
+	if p.remoteAddr != nil {
+		return p.remoteAddr
+	}
+	return p.Conn.RemoteAddr()
+}
+
+func (p *proxyProtocolConn) SetDeadline(t time.Time) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.readDeadline = t
+	return p.Conn.SetDeadline(t)
+}
+
+func (p *proxyProtocolConn) SetReadDeadline(t time.Time) error {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.readDeadline = t
+	return p.Conn.SetReadDeadline(t)
+}
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// readProxyProtocolHeader reads a PROXY protocol header from reader and returns the source address.
+// If reader does not start with a header, nothing is read and the returned address is nil.
+func readProxyProtocolHeader(reader *bufio.Reader) (net.Addr, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	switch first[0] {
+	case 'P':
+		if prefix, err := reader.Peek(6); err == nil && string(prefix) == "PROXY " {
+			return readProxyProtocolV1Header(reader)
+		}
+	case '\r':
+		if prefix, err := reader.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(prefix, proxyProtocolV2Signature) {
+			return readProxyProtocolV2Header(reader)
+		}
+	}
+	return nil, nil
+}
+
+func readProxyProtocolV1Header(reader *bufio.Reader) (net.Addr, error) {
+	// The v1 header is at most 107 bytes long, including CRLF
+	var line []byte
+	for len(line) < 107 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errors.New("PROXY protocol v1 header too long")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", string(line))
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.Atoi(fields[4])
+	if ip == nil || err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header %q", string(line))
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func readProxyProtocolV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %v", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	// LOCAL command, the connection was not proxied
+	if header[12]&0x0f == 0 {
+		return nil, nil
+	}
+	switch header[13] >> 4 {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, errors.New("PROXY protocol v2 header too short for IPv4 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, errors.New("PROXY protocol v2 header too short for IPv6 addresses")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// AF_UNSPEC or AF_UNIX, keep the address of the connection
+		return nil, nil
+	}
+}
@@ -0,0 +1,159 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("PROXY protocol", func() {
+	Context("When a v1 header is sent", func() {
+		It("should return the source address and strip the header", func() {
+			reader := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 192.0.2.1 198.51.100.1 4711 443\r\n{}"))
+			addr, err := readProxyProtocolHeader(reader)
+			Expect(err).To(BeNil())
+			Expect(addr.String()).To(Equal("192.0.2.1:4711"))
+			rest, _ := ioutil.ReadAll(reader)
+			Expect(string(rest)).To(Equal("{}"))
+		})
+	})
+	Context("When a v2 header is sent", func() {
+		It("should return the source address and strip the header", func() {
+			header := append([]byte{}, proxyProtocolV2Signature...)
+			header = append(header, 0x21, 0x11, 0, 12, 192, 0, 2, 1, 198, 51, 100, 1, 0x12, 0x67, 1, 187)
+			reader := bufio.NewReader(bytes.NewBuffer(append(header, []byte("{}")...)))
+			addr, err := readProxyProtocolHeader(reader)
+			Expect(err).To(BeNil())
+			Expect(addr.String()).To(Equal("192.0.2.1:4711"))
+			rest, _ := ioutil.ReadAll(reader)
+			Expect(string(rest)).To(Equal("{}"))
+		})
+	})
+	Context("When no header is sent", func() {
+		It("should return no address and read nothing", func() {
+			reader := bufio.NewReader(bytes.NewBufferString(`{"protocol":"json"}`))
+			addr, err := readProxyProtocolHeader(reader)
+			Expect(err).To(BeNil())
+			Expect(addr).To(BeNil())
+			rest, _ := ioutil.ReadAll(reader)
+			Expect(string(rest)).To(Equal(`{"protocol":"json"}`))
+		})
+	})
+	Context("When an invalid v1 header is sent", func() {
+		It("should return an error", func() {
+			reader := bufio.NewReader(bytes.NewBufferString("PROXY TCP4 nonsense\r\n"))
+			_, err := readProxyProtocolHeader(reader)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
+
+var _ = Describe("PROXY protocol listener", func() {
+	// accept connects to listener, sends data and returns the accepted and the client connection
+	accept := func(listener net.Listener, data string) (net.Conn, net.Conn) {
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := listener.Accept()
+			Expect(err).To(BeNil())
+			accepted <- conn
+		}()
+		client, err := net.Dial("tcp", listener.Addr().String())
+		Expect(err).To(BeNil())
+		_, err = client.Write([]byte(data))
+		Expect(err).To(BeNil())
+		var conn net.Conn
+		Eventually(accepted).Should(Receive(&conn))
+		return conn, client
+	}
+	listen := func(trustedProxies ...string) net.Listener {
+		tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		listener, err := NewProxyProtocolListener(tcpListener, trustedProxies...)
+		Expect(err).To(BeNil())
+		return listener
+	}
+	Context("When no trusted proxies are given", func() {
+		It("should return an error", func() {
+			_, err := NewProxyProtocolListener(nil)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+	Context("When a peer which is no trusted proxy sends a header", func() {
+		It("should ignore the header", func() {
+			listener := listen("10.0.0.0/8")
+			defer func() { _ = listener.Close() }()
+			conn, client := accept(listener, "PROXY TCP4 192.0.2.1 198.51.100.1 4711 443\r\n")
+			defer func() { _ = conn.Close(); _ = client.Close() }()
+			Expect(conn.RemoteAddr().String()).NotTo(Equal("192.0.2.1:4711"))
+			header := make([]byte, 6)
+			_, err := conn.Read(header)
+			Expect(err).To(BeNil())
+			Expect(string(header)).To(Equal("PROXY "))
+		})
+	})
+	Context("When a trusted proxy sends a header", func() {
+		It("should return the source address and strip the header", func() {
+			listener := listen("127.0.0.0/8")
+			defer func() { _ = listener.Close() }()
+			conn, client := accept(listener, "PROXY TCP4 192.0.2.1 198.51.100.1 4711 443\r\n{}")
+			defer func() { _ = conn.Close(); _ = client.Close() }()
+			Expect(conn.RemoteAddr().String()).To(Equal("192.0.2.1:4711"))
+			rest := make([]byte, 2)
+			_, err := conn.Read(rest)
+			Expect(err).To(BeNil())
+			Expect(string(rest)).To(Equal("{}"))
+		})
+	})
+	Context("When a trusted proxy sends nothing", func() {
+		It("should stop waiting for the header after the header timeout", func() {
+			listener := listen("127.0.0.0/8")
+			defer func() { _ = listener.Close() }()
+			listener.(*proxyProtocolListener).headerTimeout = 100 * time.Millisecond
+			conn, client := accept(listener, "")
+			defer func() { _ = conn.Close(); _ = client.Close() }()
+			addr := make(chan string, 1)
+			go func() { addr <- conn.RemoteAddr().String() }()
+			Eventually(addr, time.Second).Should(Receive(HavePrefix("127.0.0.1:")))
+			_, err := conn.Read(make([]byte, 1))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Forwarded headers", func() {
+	newRequest := func(remoteAddr string, header map[string]string) *http.Request {
+		req, _ := http.NewRequest("GET", "http://localhost/hub", nil)
+		req.RemoteAddr = remoteAddr
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		return req
+	}
+	server, _ := NewServer(UseHub(&invocationHub{}), TrustedProxies("10.0.0.0/8"))
+	Context("When the request comes from an untrusted address", func() {
+		It("should ignore the headers", func() {
+			req := newRequest("192.0.2.1:4711", map[string]string{"X-Forwarded-For": "198.51.100.1"})
+			Expect(server.requestRemoteAddr(req)).To(Equal("192.0.2.1:4711"))
+		})
+	})
+	Context("When the request comes from a trusted proxy with X-Forwarded-For", func() {
+		It("should return the first untrusted address from the right", func() {
+			req := newRequest("10.0.0.1:4711", map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.1, 10.0.0.2"})
+			Expect(server.requestRemoteAddr(req)).To(Equal("198.51.100.1"))
+		})
+	})
+	Context("When the request comes from a trusted proxy with Forwarded", func() {
+		It("should prefer the Forwarded header", func() {
+			req := newRequest("10.0.0.1:4711", map[string]string{
+				"Forwarded":       `for="[2001:db8::1]:4711";proto=https`,
+				"X-Forwarded-For": "198.51.100.1"})
+			Expect(server.requestRemoteAddr(req)).To(Equal("[2001:db8::1]:4711"))
+		})
+	})
+})
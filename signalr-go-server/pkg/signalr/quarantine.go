@@ -0,0 +1,33 @@
+package signalr
+
+// quarantinedError is the completion error of the invocations of a quarantined connection
+const quarantinedError = "Connection quarantined"
+
+// Quarantine puts the connection with the given connectionID into quarantine, e.g. while its abusive behavior
+// is investigated or it has to authenticate again. The connection stays open, but its invocations are rejected
+// with the completion error "Connection quarantined" and it gets no broadcasts, group or user sends.
+// Sends to the connection itself still reach it, so the server can tell the client what to do.
+// It returns false if the connection is not connected to the server.
+func (s *Server) Quarantine(connectionID string) bool {
+	if conn, ok := s.connection(connectionID); ok {
+		conn.SetQuarantined(true)
+		return true
+	}
+	return false
+}
+
+// ReleaseQuarantine releases the connection with the given connectionID from quarantine.
+// It returns false if the connection is not connected to the server.
+func (s *Server) ReleaseQuarantine(connectionID string) bool {
+	if conn, ok := s.connection(connectionID); ok {
+		conn.SetQuarantined(false)
+		return true
+	}
+	return false
+}
+
+// Quarantined tells if the connection with the given connectionID is in quarantine
+func (s *Server) Quarantined(connectionID string) bool {
+	conn, ok := s.connection(connectionID)
+	return ok && conn.Quarantined()
+}
@@ -0,0 +1,44 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("Quarantine", func() {
+	Context("When a connection is quarantined", func() {
+		It("should reject its invocations and send it no broadcasts until it is released", func() {
+			server, err := NewServer(SimpleHubFactory(&groupHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "suspect"
+			go server.Run(context.TODO(), conn)
+			<-groupHubOnConnectMsg
+			Expect(server.Quarantine("suspect")).To(BeTrue())
+			Expect(server.Quarantined("suspect")).To(BeTrue())
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"isadmin"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Error: "Connection quarantined"})))
+			Expect(server.lifetimeManager.InvokeAll(context.TODO(), "broadcast", nil)).To(BeNil())
+			Expect(server.lifetimeManager.InvokeClient(context.TODO(), "suspect", "reauthenticate", nil)).To(BeNil())
+			Expect((<-conn.ReceiveChan()).(invocationMessage).Target).To(Equal("reauthenticate"))
+			Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			Expect(server.ReleaseQuarantine("suspect")).To(BeTrue())
+			Expect(server.Quarantined("suspect")).To(BeFalse())
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"isadmin"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Result: false})))
+			Expect(server.lifetimeManager.InvokeAll(context.TODO(), "broadcast", nil)).To(BeNil())
+			Expect((<-conn.ReceiveChan()).(invocationMessage).Target).To(Equal("broadcast"))
+		})
+	})
+	Context("When the connection is not connected", func() {
+		It("should return false", func() {
+			server, err := NewServer(SimpleHubFactory(&groupHub{}))
+			Expect(err).To(BeNil())
+			Expect(server.Quarantine("unknown")).To(BeFalse())
+			Expect(server.ReleaseQuarantine("unknown")).To(BeFalse())
+			Expect(server.Quarantined("unknown")).To(BeFalse())
+		})
+	})
+})
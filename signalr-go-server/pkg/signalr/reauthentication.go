@@ -0,0 +1,129 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ReauthenticateTarget is the target of the invocation a client sends to submit a new access token over its connection.
+// Hub methods can not have this name, so it does not hide a hub method.
+const ReauthenticateTarget = "$reauthenticate"
+
+// ExpiringPrincipal can be implemented by the principal returned by a TokenAuthenticatorFunc whose credentials expire,
+// e.g. the claims of a JWT. ExpiresAt returns the time the credentials expire.
+type ExpiringPrincipal interface {
+	ExpiresAt() time.Time
+}
+
+// Reauthentication lets clients refresh the credentials of their connections without reconnecting.
+// A client invokes ReauthenticateTarget with the new access token as only argument, see Client.Reauthenticate.
+// The token is validated by the TokenAuthenticatorFunc of the TokenAuthentication option, which gets the request
+// which established the connection, or nil. If the server has a UserIDProvider, it is called with the connection
+// and the new principal, see ConnectionPrincipal, and the token is rejected if it belongs to another user than
+// the connection. If the token is accepted, the principal of the connection is replaced,
+// which is seen by PrincipalFromContext in the following invocations and by the HubCallerContext. If the token is
+// rejected, the invocation is completed with an error and the connection keeps its principal.
+// Connections whose principal implements ExpiringPrincipal are closed gracePeriod after their credentials expired,
+// unless they have been refreshed in the meantime. The clients are allowed to reconnect with new credentials.
+func Reauthentication(gracePeriod time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if gracePeriod < 0 {
+			return errors.New("Reauthentication needs a grace period which is not negative")
+		}
+		s.reauthentication = true
+		s.reauthenticationGrace = gracePeriod
+		return nil
+	}
+}
+
+// handleReauthentication validates the token of a ReauthenticateTarget invocation and replaces the principal
+func (sl *serverLoop) handleReauthentication(invocation invocationMessage) {
+	if sl.server.tokenAuthenticator == nil {
+		sl.complete(invocation, nil, errors.New("Reauthentication needs TokenAuthentication"))
+		return
+	}
+	var token string
+	if len(invocation.Arguments) == 1 {
+		if err := sl.protocol.UnmarshalArgument(invocation.Arguments[0], &token); err != nil {
+			token = ""
+		}
+	}
+	if token == "" {
+		sl.complete(invocation, nil, errors.New("Reauthentication needs an access token as argument"))
+		return
+	}
+	var req *http.Request
+	if httpConn, ok := sl.conn.(HTTPConnection); ok {
+		req = httpConn.Request()
+	}
+	principal, err := sl.server.tokenAuthenticator(token, req)
+	if err != nil || principal == nil {
+		_ = sl.info.Log(evt, "reauthenticate", "error", err, react, "keep principal")
+		sl.complete(invocation, nil, errors.New("Reauthentication failed"))
+		return
+	}
+	// The connection stays bound to its user, in the groups and for user sends
+	if sl.server.userIDProvider != nil {
+		if userID := sl.server.userIDProvider(withPrincipal(sl.conn, principal)); userID != sl.hubConn.UserID() {
+			_ = sl.info.Log(evt, "reauthenticate", "error", "token of another user", "userId", sl.hubConn.UserID(), react, "keep principal")
+			sl.complete(invocation, nil, errors.New("Reauthentication failed: token of another user"))
+			return
+		}
+	}
+	caller := hubCallerFromItems(sl.hubConn.Items())
+	caller.principal = principal
+	sl.hubConn.Items().Store(hubCallerKey{}, caller)
+	sl.watchCredentials(principal)
+	_ = sl.dbg.Log(evt, "reauthenticate", react, "replace principal")
+	sl.complete(invocation, nil, nil)
+}
+
+// watchCredentials lets the message loop close the connection gracePeriod after the credentials of principal expired
+func (sl *serverLoop) watchCredentials(principal interface{}) {
+	sl.credentialsExpired = nil
+	if expiring, ok := principal.(ExpiringPrincipal); ok && sl.server.reauthentication {
+		deadline := expiring.ExpiresAt().Add(sl.server.reauthenticationGrace)
+		sl.credentialsExpired = sl.server.clock.After(deadline.Sub(sl.server.clock.Now()))
+	}
+}
+
+// withPrincipal returns conn with principal as result of ConnectionPrincipal
+func withPrincipal(conn Connection, principal interface{}) Connection {
+	if httpConn, ok := conn.(HTTPConnection); ok && httpConn.Request() != nil {
+		req := httpConn.Request()
+		return &principalHTTPConnection{Connection: conn, request: req.WithContext(context.WithValue(req.Context(), principalContextKey{}, principal))}
+	}
+	return &principalConnection{Connection: conn, principal: principal}
+}
+
+// principalConnection is a Connection without request whose principal has been replaced
+type principalConnection struct {
+	Connection
+	principal interface{}
+}
+
+// principalHTTPConnection is an HTTPConnection whose request carries a replaced principal
+type principalHTTPConnection struct {
+	Connection
+	request *http.Request
+}
+
+func (p *principalHTTPConnection) Request() *http.Request {
+	return p.request
+}
+
+// principalContext returns sl.ctx with the current principal of the connection, which might have been refreshed
+func (sl *serverLoop) principalContext() context.Context {
+	if principal := hubCallerFromItems(sl.hubConn.Items()).principal; principal != nil {
+		return context.WithValue(sl.ctx, principalContextKey{}, principal)
+	}
+	return sl.ctx
+}
+
+// Reauthenticate submits a new access token for the connection to a server with the Reauthentication option.
+// It returns an error if the server rejected the token.
+func (c *Client) Reauthenticate(ctx context.Context, token string) error {
+	return c.Invoke(ctx, nil, ReauthenticateTarget, token)
+}
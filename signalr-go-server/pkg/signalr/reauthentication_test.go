@@ -0,0 +1,120 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+type expiringTestPrincipal struct {
+	name    string
+	expires time.Time
+}
+
+func (e expiringTestPrincipal) ExpiresAt() time.Time {
+	return e.expires
+}
+
+func (e expiringTestPrincipal) String() string {
+	return e.name
+}
+
+// httpTestingConnection is a testingConnection which has been established by request
+type httpTestingConnection struct {
+	*testingConnection
+	request *http.Request
+}
+
+func (h *httpTestingConnection) Request() *http.Request {
+	return h.request
+}
+
+var _ = Describe("Reauthentication option", func() {
+	var clock *ManualClock
+	var server *Server
+	BeforeEach(func() {
+		clock = NewManualClock(time.Now())
+		var err error
+		server, err = NewServer(SimpleHubFactory(&authenticationHub{}),
+			UseClock(clock), ClientTimeoutInterval(48*time.Hour), KeepAliveInterval(24*time.Hour),
+			TokenAuthentication(func(token string, req *http.Request) (interface{}, error) {
+				if strings.HasPrefix(token, "valid-") {
+					return expiringTestPrincipal{name: strings.TrimPrefix(token, "valid-"), expires: clock.Now().Add(2 * time.Hour)}, nil
+				}
+				return nil, errors.New("invalid token")
+			}),
+			Reauthentication(time.Minute))
+		Expect(err).To(BeNil())
+	})
+	run := func() *testingConnection {
+		conn := newTestingConnection()
+		ctx := context.WithValue(context.TODO(), principalContextKey{},
+			expiringTestPrincipal{name: "alice", expires: clock.Now().Add(time.Hour)})
+		go server.Run(ctx, conn)
+		conn.ClientSend(`{"type":1,"invocationId":"1","target":"whoami"}`)
+		Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "alice"})))
+		return conn
+	}
+	Context("When the client submits a valid token", func() {
+		It("should replace the principal and extend the expiry", func() {
+			conn := run()
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"$reauthenticate","arguments":["valid-bob"]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2"})))
+			conn.ClientSend(`{"type":1,"invocationId":"3","target":"whoami"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "3", Result: "bob"})))
+			clock.Advance(time.Hour + 2*time.Minute)
+			Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			clock.Advance(time.Hour)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(closeMessage{Type: 7, Error: "credentials expired", AllowReconnect: true})))
+		})
+	})
+	Context("When the client submits an invalid token", func() {
+		It("should keep the principal and close the connection after the grace period", func() {
+			conn := run()
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"$reauthenticate","arguments":["forged"]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Error: "Reauthentication failed"})))
+			conn.ClientSend(`{"type":1,"invocationId":"3","target":"whoami"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "3", Result: "alice"})))
+			clock.Advance(time.Hour)
+			Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			clock.Advance(time.Minute)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(closeMessage{Type: 7, Error: "credentials expired", AllowReconnect: true})))
+		})
+	})
+	Context("When the client submits a valid token of another user", func() {
+		It("should reject the token and keep the principal", func() {
+			server, err := NewServer(SimpleHubFactory(&authenticationHub{}),
+				TokenAuthentication(func(token string, req *http.Request) (interface{}, error) {
+					return strings.TrimPrefix(token, "valid-"), nil
+				}),
+				UserIDProvider(func(conn Connection) string {
+					principal, _ := ConnectionPrincipal(conn)
+					return fmt.Sprint(principal)
+				}),
+				Reauthentication(time.Minute))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			req := httptest.NewRequest("GET", "/hub", nil)
+			req = req.WithContext(context.WithValue(req.Context(), principalContextKey{}, "alice"))
+			go server.Run(context.WithValue(context.TODO(), principalContextKey{}, "alice"), &httpTestingConnection{conn, req})
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"$reauthenticate","arguments":["valid-bob"]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Error: "Reauthentication failed: token of another user"})))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"whoami"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Result: "alice"})))
+			conn.ClientSend(`{"type":1,"invocationId":"3","target":"$reauthenticate","arguments":["valid-alice"]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "3"})))
+		})
+	})
+	Context("When the grace period is negative", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&authenticationHub{}), Reauthentication(-time.Second))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
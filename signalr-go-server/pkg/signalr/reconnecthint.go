@@ -0,0 +1,67 @@
+package signalr
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// ReconnectHint tells a client where to reconnect to. URL is the endpoint of the preferred server,
+// AccessToken is passed to the client with the negotiate redirect and used by the client to connect to URL.
+type ReconnectHint struct {
+	URL         string
+	AccessToken string
+}
+
+// NegotiateRedirectFunc is called on each negotiate request. If it returns a hint, the client is redirected
+// to the hint URL, e.g. to move clients to another region or from the blue to the green deployment.
+type NegotiateRedirectFunc func(req *http.Request) *ReconnectHint
+
+// NegotiateMetadataFunc is called on each negotiate request. The returned fields are added to the negotiate response,
+// e.g. feature flags, the region or the server version, so clients can adapt before connecting.
+type NegotiateMetadataFunc func(req *http.Request) map[string]interface{}
+
+// CloseWithReconnectHint closes the connection with the given connectionID with reconnect allowed
+// and the hint URL in the close error. When the client negotiates again with the resume token of the connection
+// as "resumeToken" query parameter, it is redirected to the hint URL with the hint AccessToken.
+// Connection ids are chosen by the clients and prove nothing, so the hint is only kept if the server issued
+// a resume token for the connection, see UseResumeStore. The Client of this package sends the token when it reconnects.
+// It returns false if the connection is not connected to the server.
+func (s *Server) CloseWithReconnectHint(connectionID string, hint ReconnectHint) bool {
+	conn, ok := s.connection(connectionID)
+	if !ok {
+		return false
+	}
+	if resumeToken := conn.ResumeToken(); resumeToken != "" {
+		s.handoffMx.Lock()
+		if s.reconnectHints == nil {
+			s.reconnectHints = make(map[string]ReconnectHint)
+		}
+		s.reconnectHints[resumeToken] = hint
+		s.handoffMx.Unlock()
+	}
+	conn.AbortWithError(fmt.Errorf("reconnect to %v", hint.URL))
+	return true
+}
+
+// negotiateRedirect returns where the client of the negotiate request should be redirected to, or nil
+func (s *Server) negotiateRedirect(req *http.Request) *ReconnectHint {
+	resumeToken := req.URL.Query().Get("resumeToken")
+	s.handoffMx.Lock()
+	handoffURL := s.handoffURL
+	hint, ok := s.reconnectHints[resumeToken]
+	if ok {
+		// The hint is for the next attempt only
+		delete(s.reconnectHints, resumeToken)
+	}
+	s.handoffMx.Unlock()
+	switch {
+	case handoffURL != "":
+		return &ReconnectHint{URL: handoffURL}
+	case ok:
+		return &hint
+	case s.negotiateRedirectFunc != nil:
+		return s.negotiateRedirectFunc(req)
+	default:
+		return nil
+	}
+}
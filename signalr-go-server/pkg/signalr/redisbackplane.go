@@ -0,0 +1,502 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RedisBackplaneConfig configures a RedisBackplane.
+// Addr is the address of the Redis server, e.g. "localhost:6379". Password is sent with AUTH if it is not empty.
+// Channel is the pub/sub channel shared by the servers of one hub, servers of different hubs need different channels.
+// Default is "signalr". ReconnectDelay is the delay before the backplane subscribes again after the connection
+// to Redis has been lost. Default is one second. CommandTimeout is the deadline of each dial and command to Redis,
+// sends fail earlier when their context is done. Default is five seconds.
+// A failed publish is retried PublishRetries times. The first retry waits RetryDelay, each further retry twice as long,
+// and each delay is varied randomly by up to 50 percent. Default RetryDelay is 50 milliseconds.
+// If all attempts failed and FallbackAddr is set, the message is published once over FallbackAddr, which must be
+// another endpoint of the same Redis deployment, e.g. another node of a Redis Cluster, so the subscribers receive it.
+// OnError is called with a *RedisPublishError when a message could not be published at all.
+// Shards spreads the group, user and client sends over the channels "<Channel>:0" to "<Channel>:<Shards-1>",
+// chosen by a consistent hash of the group, user or connection id, so a Redis Cluster can distribute them
+// over its nodes instead of carrying them all on one hot channel. Broadcasts stay on Channel.
+// All servers of a hub need the same Shards. Default is 0, which sends everything on Channel.
+type RedisBackplaneConfig struct {
+	Addr           string
+	Password       string
+	Channel        string
+	ReconnectDelay time.Duration
+	CommandTimeout time.Duration
+	PublishRetries int
+	RetryDelay     time.Duration
+	FallbackAddr   string
+	OnError        func(err error)
+	Shards         int
+}
+
+// RedisPublishError is passed to the OnError handler of a RedisBackplane when a message could not be published.
+// Kind is "all", "allExcept", "group", "user", "client", "disconnectUser", "abort" or "drain",
+// Key the excluded connection, group, user or connection
+type RedisPublishError struct {
+	Kind   string
+	Key    string
+	Target string
+	Err    error
+}
+
+func (e *RedisPublishError) Error() string {
+	return fmt.Sprintf("redis publish of %v %v failed: %v", e.Kind, e.Target, e.Err)
+}
+
+// Unwrap returns the error of the last attempt
+func (e *RedisPublishError) Unwrap() error {
+	return e.Err
+}
+
+// RedisBackplane returns a factory for UseHubLifetimeManager which routes the broadcasts, group sends, user sends
+// and sends to single connections of the server over Redis pub/sub to all servers using the same channel,
+// so they reach clients connected to other server instances. Each server delivers to its own connections.
+// The backplane is an Extension: Server.Start subscribes to the channel and fails if Redis is not reachable,
+// Server.Stop unsubscribes. While the connection to Redis is lost, messages reach only the connections
+// of the sending server, and the backplane reconnects in the background. Sends return an error when they could
+// not be published, after they have been delivered to the connections of the sending server.
+// Server.DisconnectUser is routed to all servers. Server.AbortConnection, Server.Drain and the sends of
+// HubClients.SendToConnections to connections which are not connected to the sending server are routed
+// to the other servers, which can not report back: they return no error if the message has been published,
+// even if no server has the connection, and Drain does not wait for the queue of a remote connection.
+// Durable sends, sends with acknowledgement, sends to tagged connections and group membership stay local.
+// The arguments of routed messages must be marshalable to JSON and are received as the generic JSON types.
+func RedisBackplane(config RedisBackplaneConfig) func(local HubLifetimeManager) HubLifetimeManager {
+	if config.Channel == "" {
+		config.Channel = "signalr"
+	}
+	if config.ReconnectDelay <= 0 {
+		config.ReconnectDelay = time.Second
+	}
+	if config.CommandTimeout <= 0 {
+		config.CommandTimeout = 5 * time.Second
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = 50 * time.Millisecond
+	}
+	return func(local HubLifetimeManager) HubLifetimeManager {
+		var info StructuredLogger = log.NewNopLogger()
+		if d, ok := local.(*defaultHubLifetimeManager); ok {
+			info = log.WithPrefix(d.info, "class", "RedisBackplane")
+		}
+		return &redisBackplane{
+			HubLifetimeManager: local,
+			config:             config,
+			serverID:           getConnectionID(),
+			info:               info,
+		}
+	}
+}
+
+// redisBackplane is the HubLifetimeManager of a RedisBackplane. Local is the embedded HubLifetimeManager
+type redisBackplane struct {
+	HubLifetimeManager
+	config     RedisBackplaneConfig
+	serverID   string
+	info       StructuredLogger
+	mx         sync.Mutex
+	publisher  *redisConn
+	fallback   *redisConn
+	subscriber *redisConn
+	stopped    chan struct{}
+	done       chan struct{}
+}
+
+var errRedisBackplaneNotStarted = errors.New("redis backplane not started")
+
+// redisMessage is a message routed over the backplane. Key is the excluded connection, group, user or connection
+// of the send, depending on Kind
+type redisMessage struct {
+	ServerID string        `json:"serverId"`
+	Kind     string        `json:"kind"`
+	Key      string        `json:"key,omitempty"`
+	Target   string        `json:"target"`
+	Args     []interface{} `json:"args"`
+}
+
+// Kinds of redisMessage
+const (
+	redisAll       = "all"
+	redisAllExcept = "allExcept"
+	redisGroup     = "group"
+	redisUser      = "user"
+	redisClient    = "client"
+	// Args of redisDisconnectUser are the reason, of redisAbort the reason and allowReconnect,
+	// of redisDrain the timeout in nanoseconds
+	redisDisconnectUser = "disconnectUser"
+	redisAbort          = "abort"
+	redisDrain          = "drain"
+)
+
+func (r *redisBackplane) Start(ctx context.Context) error {
+	subscriber, err := r.subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	stopped, done := make(chan struct{}), make(chan struct{})
+	r.mx.Lock()
+	r.subscriber = subscriber
+	r.stopped, r.done = stopped, done
+	r.mx.Unlock()
+	go r.receiveLoop(subscriber, stopped, done)
+	return nil
+}
+
+func (r *redisBackplane) Stop(ctx context.Context) error {
+	r.mx.Lock()
+	if r.stopped == nil {
+		r.mx.Unlock()
+		return nil
+	}
+	close(r.stopped)
+	done := r.done
+	r.stopped = nil
+	r.closeConnections()
+	r.mx.Unlock()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeConnections closes the connections to Redis. r.mx must be locked
+func (r *redisBackplane) closeConnections() {
+	if r.subscriber != nil {
+		_ = r.subscriber.Close()
+		r.subscriber = nil
+	}
+	if r.publisher != nil {
+		_ = r.publisher.Close()
+		r.publisher = nil
+	}
+	if r.fallback != nil {
+		_ = r.fallback.Close()
+		r.fallback = nil
+	}
+}
+
+// subscribe connects to Redis and subscribes to the channel and its shards
+func (r *redisBackplane) subscribe(ctx context.Context) (*redisConn, error) {
+	conn, err := dialRedis(ctx, r.config.Addr, r.config.Password, r.config.CommandTimeout)
+	if err != nil {
+		return nil, err
+	}
+	channels := []string{r.config.Channel}
+	for shard := 0; shard < r.config.Shards; shard++ {
+		channels = append(channels, r.shardChannel(shard))
+	}
+	// One channel per SUBSCRIBE, as Redis confirms each channel with a reply of its own
+	for _, channel := range channels {
+		if _, err = conn.do(ctx, "SUBSCRIBE", channel); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (r *redisBackplane) shardChannel(shard int) string {
+	return fmt.Sprintf("%v:%v", r.config.Channel, shard)
+}
+
+// channel returns the channel of a message. Broadcasts are sent on Channel, all other messages on the shard of their key
+func (r *redisBackplane) channel(kind string, key string) string {
+	if r.config.Shards <= 0 || kind == redisAll || kind == redisAllExcept {
+		return r.config.Channel
+	}
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(key))
+	return r.shardChannel(jumpHash(hash.Sum64(), r.config.Shards))
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach. When the number of buckets changes from n to n+1,
+// only the keys moving to the new bucket change their bucket
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// receiveLoop delivers the messages of the other servers to the local connections.
+// When the connection to Redis is lost, it subscribes again until the backplane is stopped. done is closed when it ends
+func (r *redisBackplane) receiveLoop(subscriber *redisConn, stopped chan struct{}, done chan struct{}) {
+	defer close(done)
+	for {
+		reply, err := subscriber.receive()
+		if err == nil {
+			if fields, ok := reply.([]interface{}); ok && len(fields) == 3 && fields[0] == "message" {
+				if payload, ok := fields[2].(string); ok {
+					r.deliver(payload)
+				}
+			}
+			continue
+		}
+		select {
+		case <-stopped:
+			return
+		default:
+		}
+		_ = r.info.Log(evt, "receive", "error", err, react, "subscribe again")
+		if subscriber = r.resubscribe(stopped); subscriber == nil {
+			return
+		}
+	}
+}
+
+// resubscribe subscribes again after ReconnectDelay until it succeeds. It returns nil if the backplane has been stopped
+func (r *redisBackplane) resubscribe(stopped chan struct{}) *redisConn {
+	for {
+		select {
+		case <-time.After(r.config.ReconnectDelay):
+		case <-stopped:
+			return nil
+		}
+		subscriber, err := r.subscribe(context.Background())
+		if err != nil {
+			_ = r.info.Log(evt, "subscribe", "error", err)
+			continue
+		}
+		r.mx.Lock()
+		select {
+		case <-stopped:
+			r.mx.Unlock()
+			_ = subscriber.Close()
+			return nil
+		default:
+		}
+		r.subscriber = subscriber
+		r.mx.Unlock()
+		return subscriber
+	}
+}
+
+// deliver sends a message of another server to the local connections
+func (r *redisBackplane) deliver(payload string) {
+	var message redisMessage
+	if err := json.Unmarshal([]byte(payload), &message); err != nil {
+		_ = r.info.Log(evt, "deliver", "error", err, react, "drop message")
+		return
+	}
+	if message.ServerID == r.serverID {
+		return
+	}
+	ctx := context.Background()
+	var err error
+	switch message.Kind {
+	case redisAll:
+		err = r.HubLifetimeManager.InvokeAll(ctx, message.Target, message.Args)
+	case redisAllExcept:
+		err = r.HubLifetimeManager.InvokeAllExcept(ctx, message.Key, message.Target, message.Args)
+	case redisGroup:
+		err = r.HubLifetimeManager.InvokeGroup(ctx, message.Key, message.Target, message.Args)
+	case redisUser:
+		err = r.HubLifetimeManager.InvokeUser(ctx, message.Key, message.Target, message.Args)
+	case redisClient:
+		err = r.HubLifetimeManager.InvokeClient(ctx, message.Key, message.Target, message.Args)
+	case redisDisconnectUser, redisAbort, redisDrain:
+		err = r.deliverControl(message)
+	default:
+		err = errors.New("unknown kind " + message.Kind)
+	}
+	if err != nil {
+		_ = r.info.Log(evt, "deliver", "kind", message.Kind, "target", message.Target, "error", err)
+	}
+}
+
+// deliverControl applies a DisconnectUser, AbortConnection or Drain of another server to the local connections.
+// Only the server of the connection has it, so the others ignore AbortConnection and Drain
+func (r *redisBackplane) deliverControl(message redisMessage) error {
+	var reason string
+	var allowReconnect bool
+	var timeout float64
+	var ok bool
+	switch message.Kind {
+	case redisDisconnectUser:
+		if len(message.Args) == 1 {
+			reason, ok = message.Args[0].(string)
+		}
+		if ok {
+			r.HubLifetimeManager.DisconnectUser(message.Key, reason)
+		}
+	case redisAbort:
+		if len(message.Args) == 2 {
+			if reason, ok = message.Args[0].(string); ok {
+				allowReconnect, ok = message.Args[1].(bool)
+			}
+		}
+		if ok {
+			_ = r.HubLifetimeManager.AbortConnection(message.Key, reason, allowReconnect)
+		}
+	case redisDrain:
+		if len(message.Args) == 1 {
+			timeout, ok = message.Args[0].(float64)
+		}
+		if ok {
+			// Drain waits for the queue of the connection, which must not hold up the other messages
+			go func() { _ = r.HubLifetimeManager.Drain(message.Key, time.Duration(timeout)) }()
+		}
+	}
+	if !ok {
+		return fmt.Errorf("invalid arguments %v", message.Args)
+	}
+	return nil
+}
+
+// publish sends the message to the other servers. Failed attempts are retried as configured by PublishRetries and
+// RetryDelay, then FallbackAddr is tried. If all fail, OnError is called
+func (r *redisBackplane) publish(ctx context.Context, kind string, key string, target string, args []interface{}) error {
+	payload, err := json.Marshal(redisMessage{ServerID: r.serverID, Kind: kind, Key: key, Target: target, Args: args})
+	if err != nil {
+		return err
+	}
+	channel := r.channel(kind, key)
+	for attempt := 0; attempt <= r.config.PublishRetries; attempt++ {
+		if attempt > 0 {
+			_ = r.info.Log(evt, "publish", "kind", kind, "target", target, "error", err, react, "retry", "attempt", attempt)
+			timer := time.NewTimer(r.retryDelay(attempt))
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return r.publishFailed(kind, key, target, ctx.Err())
+			}
+		}
+		if err = r.publishTo(ctx, &r.publisher, r.config.Addr, channel, payload); err == nil || err == errRedisBackplaneNotStarted {
+			return err
+		}
+	}
+	if r.config.FallbackAddr != "" && ctx.Err() == nil {
+		_ = r.info.Log(evt, "publish", "kind", kind, "target", target, "error", err, react, "publish to fallback")
+		if err = r.publishTo(ctx, &r.fallback, r.config.FallbackAddr, channel, payload); err == nil {
+			return nil
+		}
+	}
+	return r.publishFailed(kind, key, target, err)
+}
+
+// retryDelay returns the delay before the retry attempt. It doubles with each attempt and varies by up to 50 percent
+func (r *redisBackplane) retryDelay(attempt int) time.Duration {
+	delay := r.config.RetryDelay << uint(attempt-1)
+	return time.Duration(float64(delay) * (0.5 + rand.Float64()))
+}
+
+// publishFailed passes the error of a message which could not be published to OnError and returns it
+func (r *redisBackplane) publishFailed(kind string, key string, target string, err error) error {
+	publishErr := &RedisPublishError{Kind: kind, Key: key, Target: target, Err: err}
+	if r.config.OnError != nil {
+		r.config.OnError(publishErr)
+	}
+	return publishErr
+}
+
+// publishTo publishes payload on channel over the idle connection in idle, or a new connection to addr. The connection is taken
+// out of r.mx for the dial and the PUBLISH, so a slow Redis server blocks neither Stop nor sends with an earlier
+// deadline. Concurrent publishes dial their own connection, only one is kept in idle for the next publish
+func (r *redisBackplane) publishTo(ctx context.Context, idle **redisConn, addr string, channel string, payload []byte) error {
+	r.mx.Lock()
+	if r.stopped == nil {
+		r.mx.Unlock()
+		return errRedisBackplaneNotStarted
+	}
+	publisher := *idle
+	*idle = nil
+	r.mx.Unlock()
+	var err error
+	if publisher == nil {
+		if publisher, err = dialRedis(ctx, addr, r.config.Password, r.config.CommandTimeout); err != nil {
+			return err
+		}
+	}
+	if _, err = publisher.do(ctx, "PUBLISH", channel, string(payload)); err != nil {
+		_ = publisher.Close()
+		return err
+	}
+	r.mx.Lock()
+	if r.stopped != nil && *idle == nil {
+		*idle, publisher = publisher, nil
+	}
+	r.mx.Unlock()
+	if publisher != nil {
+		_ = publisher.Close()
+	}
+	return nil
+}
+
+// invoke sends to the local connections and publishes the message to the other servers
+func (r *redisBackplane) invoke(ctx context.Context, localErr error, kind string, key string, target string, args []interface{}) error {
+	if err := r.publish(ctx, kind, key, target, args); err != nil {
+		_ = r.info.Log(evt, "publish", "kind", kind, "target", target, "error", err)
+		if localErr == nil {
+			return err
+		}
+	}
+	return localErr
+}
+
+func (r *redisBackplane) InvokeAll(ctx context.Context, target string, args []interface{}) error {
+	return r.invoke(ctx, r.HubLifetimeManager.InvokeAll(ctx, target, args), redisAll, "", target, args)
+}
+
+func (r *redisBackplane) InvokeAllExcept(ctx context.Context, excludedID string, target string, args []interface{}) error {
+	return r.invoke(ctx, r.HubLifetimeManager.InvokeAllExcept(ctx, excludedID, target, args), redisAllExcept, excludedID, target, args)
+}
+
+func (r *redisBackplane) InvokeGroup(ctx context.Context, groupName string, target string, args []interface{}) error {
+	return r.invoke(ctx, r.HubLifetimeManager.InvokeGroup(ctx, groupName, target, args), redisGroup, groupName, target, args)
+}
+
+func (r *redisBackplane) InvokeUser(ctx context.Context, userID string, target string, args []interface{}) error {
+	return r.invoke(ctx, r.HubLifetimeManager.InvokeUser(ctx, userID, target, args), redisUser, userID, target, args)
+}
+
+func (r *redisBackplane) InvokeClient(ctx context.Context, connectionID string, target string, args []interface{}) error {
+	return r.invoke(ctx, r.HubLifetimeManager.InvokeClient(ctx, connectionID, target, args), redisClient, connectionID, target, args)
+}
+
+func (r *redisBackplane) InvokeConnections(ctx context.Context, connectionIDs []string, target string, args []interface{}) map[string]error {
+	results := r.HubLifetimeManager.InvokeConnections(ctx, connectionIDs, target, args)
+	for connectionID, err := range results {
+		if err == errNotConnected {
+			// The connection might be connected to another server
+			results[connectionID] = r.invoke(ctx, nil, redisClient, connectionID, target, args)
+		}
+	}
+	return results
+}
+
+func (r *redisBackplane) DisconnectUser(userID string, reason string) {
+	r.HubLifetimeManager.DisconnectUser(userID, reason)
+	_ = r.invoke(context.Background(), nil, redisDisconnectUser, userID, "", []interface{}{reason})
+}
+
+func (r *redisBackplane) AbortConnection(connectionID string, reason string, allowReconnect bool) error {
+	if err := r.HubLifetimeManager.AbortConnection(connectionID, reason, allowReconnect); err == nil {
+		return nil
+	}
+	return r.invoke(context.Background(), nil, redisAbort, connectionID, "", []interface{}{reason, allowReconnect})
+}
+
+func (r *redisBackplane) Drain(connectionID string, timeout time.Duration) error {
+	err := r.HubLifetimeManager.Drain(connectionID, timeout)
+	if _, ok := err.(*notConnectedError); !ok {
+		return err
+	}
+	return r.invoke(context.Background(), nil, redisDrain, connectionID, "", []interface{}{float64(timeout)})
+}
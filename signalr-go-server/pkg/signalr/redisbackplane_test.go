@@ -0,0 +1,423 @@
+package signalr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net"
+	"sync"
+	"time"
+)
+
+// fakeRedis is a Redis server which knows only AUTH, SUBSCRIBE, PUBLISH, RPUSH, LRANGE with the whole list and LREM.
+// While stallPublish is set, PUBLISH is never answered. The next failPublishes PUBLISH commands fail
+type fakeRedis struct {
+	listener      net.Listener
+	mx            sync.Mutex
+	conns         map[*redisConn]bool
+	subscribers   map[string][]*redisConn
+	lists         map[string][]string
+	stallPublish  bool
+	failPublishes int
+}
+
+func startFakeRedis() *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	f := &fakeRedis{listener: listener, conns: make(map[*redisConn]bool), subscribers: make(map[string][]*redisConn),
+		lists: make(map[string][]string)}
+	go f.accept(listener)
+	return f
+}
+
+// accept serves the connections of listener
+func (f *fakeRedis) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go f.serve(&redisConn{conn: conn, reader: bufio.NewReader(conn)})
+	}
+}
+
+// listenNode starts another listener for the server, like another node of a Redis Cluster
+func (f *fakeRedis) listenNode() net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	go f.accept(listener)
+	return listener
+}
+
+func (f *fakeRedis) serve(conn *redisConn) {
+	f.mx.Lock()
+	f.conns[conn] = true
+	f.mx.Unlock()
+	defer func() {
+		f.mx.Lock()
+		delete(f.conns, conn)
+		f.mx.Unlock()
+		_ = conn.Close()
+	}()
+	for {
+		reply, err := conn.receive()
+		if err != nil {
+			return
+		}
+		command, _ := reply.([]interface{})
+		if len(command) == 0 {
+			return
+		}
+		switch command[0] {
+		case "AUTH":
+			_, _ = conn.conn.Write([]byte("+OK\r\n"))
+		case "SUBSCRIBE":
+			channel := command[1].(string)
+			f.mx.Lock()
+			f.subscribers[channel] = append(f.subscribers[channel], conn)
+			f.mx.Unlock()
+			_, _ = fmt.Fprintf(conn.conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(channel), channel)
+		case "PUBLISH":
+			channel, payload := command[1].(string), command[2].(string)
+			f.mx.Lock()
+			if f.stallPublish {
+				f.mx.Unlock()
+				continue
+			}
+			if f.failPublishes > 0 {
+				f.failPublishes--
+				f.mx.Unlock()
+				_, _ = conn.conn.Write([]byte("-ERR publish failed\r\n"))
+				continue
+			}
+			subscribers := f.subscribers[channel]
+			for _, subscriber := range subscribers {
+				_, _ = fmt.Fprintf(subscriber.conn, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n",
+					len(channel), channel, len(payload), payload)
+			}
+			f.mx.Unlock()
+			_, _ = fmt.Fprintf(conn.conn, ":%d\r\n", len(subscribers))
+		case "RPUSH":
+			f.mx.Lock()
+			key := command[1].(string)
+			for _, value := range command[2:] {
+				f.lists[key] = append(f.lists[key], value.(string))
+			}
+			_, _ = fmt.Fprintf(conn.conn, ":%d\r\n", len(f.lists[key]))
+			f.mx.Unlock()
+		case "LRANGE":
+			f.mx.Lock()
+			list := f.lists[command[1].(string)]
+			_, _ = fmt.Fprintf(conn.conn, "*%d\r\n", len(list))
+			for _, value := range list {
+				_, _ = fmt.Fprintf(conn.conn, "$%d\r\n%s\r\n", len(value), value)
+			}
+			f.mx.Unlock()
+		case "LREM":
+			f.mx.Lock()
+			key, removed := command[1].(string), 0
+			list := f.lists[key]
+			for i, value := range list {
+				if value == command[3].(string) {
+					f.lists[key] = append(list[:i:i], list[i+1:]...)
+					removed = 1
+					break
+				}
+			}
+			_, _ = fmt.Fprintf(conn.conn, ":%d\r\n", removed)
+			f.mx.Unlock()
+		default:
+			_, _ = conn.conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+	}
+}
+
+// dropConnections closes all connections, like a restarting Redis server
+func (f *fakeRedis) dropConnections() {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for conn := range f.conns {
+		_ = conn.Close()
+	}
+	f.subscribers = make(map[string][]*redisConn)
+}
+
+func (f *fakeRedis) setStallPublish(stall bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.stallPublish = stall
+}
+
+func (f *fakeRedis) setFailPublishes(n int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.failPublishes = n
+}
+
+func (f *fakeRedis) subscriberCount(channel string) int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return len(f.subscribers[channel])
+}
+
+type redisHub struct {
+	Hub
+}
+
+func (r *redisHub) OnConnected(connectionID string) {
+	r.Groups().AddToGroup("members", connectionID)
+}
+
+func (r *redisHub) Broadcast(text string) {
+	r.Clients().All().Send("all", text)
+}
+
+func (r *redisHub) SendGroup(text string) {
+	r.Clients().Group("members").Send("group", text)
+}
+
+func (r *redisHub) SendUser(userID string, text string) {
+	r.Clients().User(userID).Send("user", text)
+}
+
+var _ = Describe("RedisBackplane", func() {
+	var redis *fakeRedis
+	var server1, server2 *Server
+	var conn1, conn2 *testingConnection
+	BeforeEach(func() {
+		redis = startFakeRedis()
+		config := RedisBackplaneConfig{Addr: redis.listener.Addr().String(), Password: "secret", ReconnectDelay: 10 * time.Millisecond,
+			CommandTimeout: time.Second}
+		var err error
+		server1, err = NewServer(SimpleHubFactory(&redisHub{}), UseHubLifetimeManager(RedisBackplane(config)))
+		Expect(err).To(BeNil())
+		server2, err = NewServer(SimpleHubFactory(&redisHub{}), UseHubLifetimeManager(RedisBackplane(config)),
+			UserIDProvider(func(conn Connection) string { return "bob" }))
+		Expect(err).To(BeNil())
+		Expect(server1.Start(context.TODO())).To(BeNil())
+		Expect(server2.Start(context.TODO())).To(BeNil())
+		conn1 = newTestingConnection()
+		conn1.connectionID = "conn1"
+		go server1.Run(context.TODO(), conn1)
+		conn2 = newTestingConnection()
+		conn2.connectionID = "conn2"
+		go server2.Run(context.TODO(), conn2)
+		Eventually(func() error { return server2.Ping("conn2") }).Should(BeNil())
+	})
+	AfterEach(func() {
+		Expect(server1.Stop(context.TODO())).To(BeNil())
+		Expect(server2.Stop(context.TODO())).To(BeNil())
+		_ = redis.listener.Close()
+	})
+	receive := func(conn *testingConnection) interface{} {
+		var message interface{}
+		Eventually(conn.ReceiveChan()).Should(Receive(&message))
+		return message
+	}
+	Context("When a hub sends to all, a group or a user", func() {
+		It("should reach the clients of both servers", func() {
+			conn1.ClientSend(`{"type":1,"target":"broadcast","arguments":["hello"]}`)
+			Expect(receive(conn1)).To(Equal(invocationMessage{Type: 1, Target: "all", Arguments: []interface{}{"hello"}}))
+			Expect(receive(conn2)).To(Equal(invocationMessage{Type: 1, Target: "all", Arguments: []interface{}{"hello"}}))
+			conn1.ClientSend(`{"type":1,"target":"sendgroup","arguments":["hi members"]}`)
+			Expect(receive(conn1)).To(Equal(invocationMessage{Type: 1, Target: "group", Arguments: []interface{}{"hi members"}}))
+			Expect(receive(conn2)).To(Equal(invocationMessage{Type: 1, Target: "group", Arguments: []interface{}{"hi members"}}))
+			conn1.ClientSend(`{"type":1,"target":"senduser","arguments":["bob","hi bob"]}`)
+			Expect(receive(conn2)).To(Equal(invocationMessage{Type: 1, Target: "user", Arguments: []interface{}{"hi bob"}}))
+			Consistently(conn1.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+	Context("When a server sends to connections of both servers", func() {
+		It("should reach them and publish the sends to the connections of the other servers", func() {
+			results := server1.lifetimeManager.InvokeConnections(context.TODO(), []string{"conn1", "conn2"}, "some", []interface{}{"hi"})
+			Expect(results).To(Equal(map[string]error{"conn1": nil, "conn2": nil}))
+			Expect(receive(conn1)).To(Equal(invocationMessage{Type: 1, Target: "some", Arguments: []interface{}{"hi"}}))
+			Expect(receive(conn2)).To(Equal(invocationMessage{Type: 1, Target: "some", Arguments: []interface{}{"hi"}}))
+		})
+	})
+	Context("When a server disconnects a user", func() {
+		It("should close the connections of the user on the other servers", func() {
+			server1.DisconnectUser("bob", "banned")
+			Eventually(conn2.ReceiveChan()).Should(Receive(Equal(closeMessage{Type: 7, Error: "banned", AllowReconnect: false})))
+		})
+	})
+	Context("When a server aborts a connection of another server", func() {
+		It("should close it there", func() {
+			Expect(server1.AbortConnection("conn2", "kicked", true)).To(BeNil())
+			Eventually(conn2.ReceiveChan()).Should(Receive(Equal(closeMessage{Type: 7, Error: "kicked", AllowReconnect: true})))
+		})
+	})
+	Context("When a server drains a connection of another server", func() {
+		It("should drain it there", func() {
+			Expect(server1.Drain("conn2", time.Second)).To(BeNil())
+			Eventually(conn2.ReceiveChan()).Should(Receive(BeAssignableToTypeOf(closeMessage{})))
+		})
+	})
+	Context("When the connection to Redis is lost", func() {
+		It("should subscribe again and route the following messages", func() {
+			redis.dropConnections()
+			Eventually(func() int { return redis.subscriberCount("signalr") }, time.Second).Should(Equal(2))
+			Eventually(func() error {
+				return server1.lifetimeManager.InvokeAll(context.TODO(), "all", []interface{}{"again"})
+			}).Should(BeNil())
+			Expect(receive(conn2)).To(Equal(invocationMessage{Type: 1, Target: "all", Arguments: []interface{}{"again"}}))
+		})
+	})
+	Context("When Redis does not answer a PUBLISH", func() {
+		It("should end the send with its context and not block Stop", func() {
+			redis.setStallPublish(true)
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			Expect(errors.Is(server1.lifetimeManager.InvokeAll(ctx, "all", []interface{}{"stalled"}), context.DeadlineExceeded)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically("<", 500*time.Millisecond))
+			published := make(chan error, 1)
+			go func() {
+				published <- server1.lifetimeManager.InvokeAll(context.TODO(), "all", []interface{}{"stalled"})
+			}()
+			time.Sleep(100 * time.Millisecond)
+			stopped := make(chan error, 1)
+			go func() { stopped <- server1.Stop(context.TODO()) }()
+			Eventually(stopped, 500*time.Millisecond).Should(Receive(BeNil()))
+			Eventually(published, 2*time.Second).Should(Receive(HaveOccurred()))
+		})
+	})
+})
+
+var _ = Describe("RedisBackplane publish hedging", func() {
+	var redis *fakeRedis
+	var node net.Listener
+	var sender, receiver *Server
+	var conn *testingConnection
+	var publishErrors chan error
+	start := func(config RedisBackplaneConfig) {
+		config.Addr = redis.listener.Addr().String()
+		config.RetryDelay = time.Millisecond
+		config.OnError = func(err error) { publishErrors <- err }
+		var err error
+		sender, err = NewServer(SimpleHubFactory(&redisHub{}), UseHubLifetimeManager(RedisBackplane(config)))
+		Expect(err).To(BeNil())
+		receiver, err = NewServer(SimpleHubFactory(&redisHub{}), UseHubLifetimeManager(RedisBackplane(config)))
+		Expect(err).To(BeNil())
+		Expect(sender.Start(context.TODO())).To(BeNil())
+		Expect(receiver.Start(context.TODO())).To(BeNil())
+		conn = newTestingConnection()
+		conn.connectionID = "receiver"
+		go receiver.Run(context.TODO(), conn)
+		Eventually(func() error { return receiver.Ping("receiver") }).Should(BeNil())
+	}
+	BeforeEach(func() {
+		redis = startFakeRedis()
+		node = redis.listenNode()
+		publishErrors = make(chan error, 10)
+	})
+	AfterEach(func() {
+		Expect(sender.Stop(context.TODO())).To(BeNil())
+		Expect(receiver.Stop(context.TODO())).To(BeNil())
+		_ = redis.listener.Close()
+		_ = node.Close()
+	})
+	broadcast := func() error {
+		return sender.lifetimeManager.InvokeAll(context.TODO(), "all", []interface{}{"hedged"})
+	}
+	Context("When a publish fails less often than it is retried", func() {
+		It("should deliver the message", func() {
+			start(RedisBackplaneConfig{PublishRetries: 2})
+			redis.setFailPublishes(2)
+			Expect(broadcast()).To(BeNil())
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(invocationMessage{Type: 1, Target: "all", Arguments: []interface{}{"hedged"}})))
+			Expect(publishErrors).NotTo(Receive())
+		})
+	})
+	Context("When all attempts fail and a fallback is set", func() {
+		It("should deliver the message over the fallback", func() {
+			start(RedisBackplaneConfig{PublishRetries: 1, FallbackAddr: node.Addr().String()})
+			redis.setFailPublishes(2)
+			Expect(broadcast()).To(BeNil())
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(invocationMessage{Type: 1, Target: "all", Arguments: []interface{}{"hedged"}})))
+			Expect(publishErrors).NotTo(Receive())
+		})
+	})
+	Context("When all attempts fail", func() {
+		It("should pass the error to OnError", func() {
+			start(RedisBackplaneConfig{PublishRetries: 1, FallbackAddr: node.Addr().String()})
+			redis.setFailPublishes(3)
+			err := broadcast()
+			Expect(err).NotTo(BeNil())
+			var publishErr error
+			Expect(publishErrors).To(Receive(&publishErr))
+			Expect(publishErr).To(Equal(err))
+			Expect(publishErr.(*RedisPublishError).Kind).To(Equal("all"))
+			Expect(publishErr.(*RedisPublishError).Target).To(Equal("all"))
+			Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
+
+var _ = Describe("RedisBackplane sharding", func() {
+	var redis *fakeRedis
+	var sender, receiver *Server
+	var conn *testingConnection
+	BeforeEach(func() {
+		redis = startFakeRedis()
+		config := RedisBackplaneConfig{Addr: redis.listener.Addr().String(), Shards: 4}
+		var err error
+		sender, err = NewServer(SimpleHubFactory(&redisHub{}), UseHubLifetimeManager(RedisBackplane(config)))
+		Expect(err).To(BeNil())
+		receiver, err = NewServer(SimpleHubFactory(&redisHub{}), UseHubLifetimeManager(RedisBackplane(config)),
+			UserIDProvider(func(conn Connection) string { return "bob" }))
+		Expect(err).To(BeNil())
+		Expect(sender.Start(context.TODO())).To(BeNil())
+		Expect(receiver.Start(context.TODO())).To(BeNil())
+		conn = newTestingConnection()
+		conn.connectionID = "receiver"
+		go receiver.Run(context.TODO(), conn)
+		Eventually(func() error { return receiver.Ping("receiver") }).Should(BeNil())
+	})
+	AfterEach(func() {
+		Expect(sender.Stop(context.TODO())).To(BeNil())
+		Expect(receiver.Stop(context.TODO())).To(BeNil())
+		_ = redis.listener.Close()
+	})
+	Context("When the backplane has shards", func() {
+		It("should subscribe to all shards and route group, user and client sends over them", func() {
+			for shard := 0; shard < 4; shard++ {
+				Expect(redis.subscriberCount(fmt.Sprintf("signalr:%v", shard))).To(Equal(2))
+			}
+			ctx := context.TODO()
+			Expect(sender.lifetimeManager.InvokeGroup(ctx, "members", "group", []interface{}{"to group"})).To(BeNil())
+			Expect(sender.lifetimeManager.InvokeUser(ctx, "bob", "user", []interface{}{"to bob"})).To(BeNil())
+			Expect(sender.lifetimeManager.InvokeClient(ctx, "receiver", "client", []interface{}{"to receiver"})).To(BeNil())
+			Expect(sender.lifetimeManager.InvokeAll(ctx, "all", []interface{}{"to all"})).To(BeNil())
+			for _, target := range []string{"group", "user", "client", "all"} {
+				var message interface{}
+				Eventually(conn.ReceiveChan()).Should(Receive(&message))
+				Expect(message.(invocationMessage).Target).To(Equal(target))
+			}
+		})
+	})
+	Context("When the shard of a key is computed", func() {
+		It("should be stable and move few keys when shards are added", func() {
+			backplane := &redisBackplane{config: RedisBackplaneConfig{Channel: "signalr", Shards: 8}}
+			resized := &redisBackplane{config: RedisBackplaneConfig{Channel: "signalr", Shards: 9}}
+			used := make(map[string]bool)
+			moved := 0
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("group%v", i)
+				channel := backplane.channel(redisGroup, key)
+				Expect(backplane.channel(redisGroup, key)).To(Equal(channel))
+				used[channel] = true
+				if resized.channel(redisGroup, key) != channel {
+					Expect(resized.channel(redisGroup, key)).To(Equal("signalr:8"))
+					moved++
+				}
+			}
+			Expect(used).To(HaveLen(8))
+			Expect(moved).To(BeNumerically("<", 200))
+			Expect(backplane.channel(redisAll, "")).To(Equal("signalr"))
+		})
+	})
+})
@@ -0,0 +1,155 @@
+package signalr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisConn is a connection to a Redis server which speaks RESP, the Redis serialization protocol.
+// It knows only what the RedisBackplane and the Redis Outbox need: commands, pub/sub replies and errors.
+// Timeout is the deadline of each command
+type redisConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+}
+
+// redisError is an error reply of the Redis server
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// dialRedis connects to addr and authenticates with password. Dial and AUTH end when ctx is done or timeout has passed
+func dialRedis(ctx context.Context, addr string, password string, timeout time.Duration) (*redisConn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn), timeout: timeout}
+	if password != "" {
+		if _, err := c.do(ctx, "AUTH", password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// send writes the command args as array of bulk strings
+func (c *redisConn) send(args ...string) error {
+	w := bufio.NewWriter(c.conn)
+	_, _ = fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		_, _ = fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	return w.Flush()
+}
+
+// receive reads one reply. Simple and bulk strings are returned as string, integers as int64, arrays as []interface{},
+// errors as redisError and null replies as nil
+func (c *redisConn) receive() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("empty redis reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("invalid redis reply %q", line)
+	}
+}
+
+// do sends the command args and returns its reply. An error reply is returned as error.
+// The command fails when ctx is done or c.timeout has passed. Afterwards, the connection has no deadline again
+func (c *redisConn) do(ctx context.Context, args ...string) (interface{}, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return nil, err
+	}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		_ = c.conn.SetDeadline(time.Time{})
+	}()
+	if err := c.send(args...); err != nil {
+		return nil, c.contextError(ctx, err)
+	}
+	reply, err := c.receive()
+	if err != nil {
+		return nil, c.contextError(ctx, err)
+	}
+	if e, ok := reply.(redisError); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// contextError returns the error of ctx if the command failed because ctx is done
+func (c *redisConn) contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("invalid redis line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,130 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// RedisOutboxConfig configures an Outbox in Redis.
+// Addr is the address of the Redis server, e.g. "localhost:6379". Password is sent with AUTH if it is not empty.
+// The messages of a connection are kept in order in the list "<KeyPrefix>:<connection id>". Default KeyPrefix
+// is "signalr:outbox". CommandTimeout is the deadline of each dial and command to Redis. Default is five seconds.
+type RedisOutboxConfig struct {
+	Addr           string
+	Password       string
+	KeyPrefix      string
+	CommandTimeout time.Duration
+}
+
+// NewRedisOutbox creates an Outbox which stores the messages in Redis. The arguments are stored as JSON and
+// Pending returns them as the generic JSON types. The connection to Redis is opened with the first command
+// and opened again after a command failed.
+func NewRedisOutbox(config RedisOutboxConfig) Outbox {
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = "signalr:outbox"
+	}
+	if config.CommandTimeout <= 0 {
+		config.CommandTimeout = 5 * time.Second
+	}
+	return &redisOutbox{config: config}
+}
+
+type redisOutbox struct {
+	config RedisOutboxConfig
+	mx     sync.Mutex
+	conn   *redisConn
+}
+
+// redisOutboxMessage is an OutboxMessage in the list of its connection
+type redisOutboxMessage struct {
+	ID     string        `json:"id"`
+	Target string        `json:"target"`
+	Args   []interface{} `json:"args"`
+}
+
+func (r *redisOutbox) key(connectionID string) string {
+	return r.config.KeyPrefix + ":" + connectionID
+}
+
+// do sends the command args over the connection to Redis, which is dialed if needed.
+// A connection which failed with another than an error reply is closed, so the next command dials again
+func (r *redisOutbox) do(args ...string) (interface{}, error) {
+	defer r.mx.Unlock()
+	r.mx.Lock()
+	if r.conn == nil {
+		conn, err := dialRedis(context.Background(), r.config.Addr, r.config.Password, r.config.CommandTimeout)
+		if err != nil {
+			return nil, err
+		}
+		r.conn = conn
+	}
+	reply, err := r.conn.do(context.Background(), args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+func (r *redisOutbox) Put(message OutboxMessage) error {
+	data, err := json.Marshal(redisOutboxMessage{ID: message.ID, Target: message.Target, Args: message.Args})
+	if err != nil {
+		return err
+	}
+	_, err = r.do("RPUSH", r.key(message.ConnectionID), string(data))
+	return err
+}
+
+func (r *redisOutbox) Pending(connectionID string) ([]OutboxMessage, error) {
+	entries, err := r.entries(connectionID)
+	if err != nil {
+		return nil, err
+	}
+	var messages []OutboxMessage
+	for _, entry := range entries {
+		messages = append(messages, OutboxMessage{ID: entry.ID, ConnectionID: connectionID, Target: entry.Target, Args: entry.Args})
+	}
+	return messages, nil
+}
+
+func (r *redisOutbox) Ack(connectionID string, id string) error {
+	entries, err := r.entries(connectionID)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.ID == id {
+			// LREM removes by value, so the entry is removed as it is stored
+			_, err = r.do("LREM", r.key(connectionID), "1", entry.raw)
+			return err
+		}
+	}
+	return nil
+}
+
+// redisOutboxEntry is a stored message and its raw value in the list
+type redisOutboxEntry struct {
+	redisOutboxMessage
+	raw string
+}
+
+// entries returns the stored messages of the connection, oldest first
+func (r *redisOutbox) entries(connectionID string) ([]redisOutboxEntry, error) {
+	reply, err := r.do("LRANGE", r.key(connectionID), "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	values, _ := reply.([]interface{})
+	entries := make([]redisOutboxEntry, 0, len(values))
+	for _, value := range values {
+		raw, _ := value.(string)
+		entry := redisOutboxEntry{raw: raw}
+		if err = json.Unmarshal([]byte(raw), &entry.redisOutboxMessage); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
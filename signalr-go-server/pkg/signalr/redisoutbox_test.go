@@ -0,0 +1,59 @@
+package signalr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("RedisOutbox", func() {
+	var redis *fakeRedis
+	var outbox Outbox
+	BeforeEach(func() {
+		redis = startFakeRedis()
+		outbox = NewRedisOutbox(RedisOutboxConfig{Addr: redis.listener.Addr().String(), Password: "secret", CommandTimeout: time.Second})
+	})
+	AfterEach(func() {
+		_ = redis.listener.Close()
+		redis.dropConnections()
+	})
+	Context("When messages are put", func() {
+		It("should return the pending messages of the connection in the order they were put", func() {
+			Expect(outbox.Put(OutboxMessage{ID: "1", ConnectionID: "conn1", Target: "first", Args: []interface{}{1.0}})).To(BeNil())
+			Expect(outbox.Put(OutboxMessage{ID: "2", ConnectionID: "conn2", Target: "other"})).To(BeNil())
+			Expect(outbox.Put(OutboxMessage{ID: "3", ConnectionID: "conn1", Target: "second", Args: []interface{}{"two"}})).To(BeNil())
+			messages, err := outbox.Pending("conn1")
+			Expect(err).To(BeNil())
+			Expect(messages).To(Equal([]OutboxMessage{
+				{ID: "1", ConnectionID: "conn1", Target: "first", Args: []interface{}{1.0}},
+				{ID: "3", ConnectionID: "conn1", Target: "second", Args: []interface{}{"two"}}}))
+			redis.mx.Lock()
+			defer redis.mx.Unlock()
+			Expect(redis.lists).To(HaveKey("signalr:outbox:conn1"))
+		})
+	})
+	Context("When a message is acknowledged", func() {
+		It("should not be pending anymore", func() {
+			Expect(outbox.Put(OutboxMessage{ID: "1", ConnectionID: "conn", Target: "first"})).To(BeNil())
+			Expect(outbox.Put(OutboxMessage{ID: "2", ConnectionID: "conn", Target: "second"})).To(BeNil())
+			Expect(outbox.Ack("conn", "1")).To(BeNil())
+			Expect(outbox.Ack("conn", "unknown")).To(BeNil())
+			messages, err := outbox.Pending("conn")
+			Expect(err).To(BeNil())
+			Expect(messages).To(Equal([]OutboxMessage{{ID: "2", ConnectionID: "conn", Target: "second"}}))
+		})
+	})
+	Context("When the connection to Redis is lost", func() {
+		It("should fail the command and connect again for the next one", func() {
+			Expect(outbox.Put(OutboxMessage{ID: "1", ConnectionID: "conn", Target: "first"})).To(BeNil())
+			redis.dropConnections()
+			Eventually(func() error {
+				_, err := outbox.Pending("conn")
+				return err
+			}).Should(BeNil())
+			messages, err := outbox.Pending("conn")
+			Expect(err).To(BeNil())
+			Expect(messages).To(HaveLen(1))
+		})
+	})
+})
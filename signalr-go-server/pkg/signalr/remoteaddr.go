@@ -0,0 +1,68 @@
+package signalr
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ConnectionInfo can be implemented by a Connection which knows the network address of its client.
+// RemoteAddr() returns the address of the client, e.g. "192.0.2.1:4711"
+type ConnectionInfo interface {
+	RemoteAddr() string
+}
+
+func remoteAddr(conn Connection) string {
+	if info, ok := conn.(ConnectionInfo); ok {
+		return info.RemoteAddr()
+	}
+	return ""
+}
+
+func (s *Server) isTrustedProxy(addr string) bool {
+	ip := parseAddrIP(addr)
+	return ip != nil && containsIP(s.trustedProxies, ip)
+}
+
+// requestRemoteAddr returns the address of the client which sent req.
+// The proxy chain from the Forwarded or X-Forwarded-For header is walked backwards
+// as long as the hops are trusted proxies.
+func (s *Server) requestRemoteAddr(req *http.Request) string {
+	addr := req.RemoteAddr
+	if !s.isTrustedProxy(addr) {
+		return addr
+	}
+	chain := forwardedFor(req.Header)
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr = chain[i]
+		if !s.isTrustedProxy(addr) {
+			break
+		}
+	}
+	return addr
+}
+
+// forwardedFor returns the client addresses from the Forwarded header (RFC 7239),
+// or if there is none, from the X-Forwarded-For header
+func forwardedFor(header http.Header) []string {
+	var chain []string
+	for _, forwarded := range header["Forwarded"] {
+		for _, element := range strings.Split(forwarded, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				if kv := strings.SplitN(strings.TrimSpace(pair), "=", 2); len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					chain = append(chain, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+	}
+	if len(chain) > 0 {
+		return chain
+	}
+	for _, forwarded := range header["X-Forwarded-For"] {
+		for _, addr := range strings.Split(forwarded, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				chain = append(chain, addr)
+			}
+		}
+	}
+	return chain
+}
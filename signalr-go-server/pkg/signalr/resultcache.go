@@ -0,0 +1,120 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resultCache holds the results of idempotent hub methods, keyed by target and normalized arguments
+type resultCache struct {
+	ttl time.Duration
+	// targets holds the lower case names of the hub methods whose results are cached
+	targets   map[string]bool
+	mx        sync.Mutex
+	entries   map[string]cachedResult
+	nextSweep time.Time
+}
+
+type cachedResult struct {
+	target  string
+	result  []reflect.Value
+	expires time.Time
+}
+
+// applies tells if the result of the invocation can be taken from the cache.
+// Invocations without id get no completion and client streaming invocations depend on more than their arguments
+func (r *resultCache) applies(invocation invocationMessage) bool {
+	return r != nil && invocation.Type == 1 && invocation.InvocationID != "" && len(invocation.StreamIds) == 0 &&
+		r.targets[strings.ToLower(invocation.Target)]
+}
+
+// key builds the cache key of the invocation of target with the arguments the method is called with.
+// Injected Progress and context.Context parameters are not part of the key. Arguments which are equal
+// JSON values get the same key, regardless of whitespace and the order of object keys
+func (r *resultCache) key(target string, args []reflect.Value) (string, bool) {
+	values := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		if t := arg.Type(); t == reflect.TypeOf(&Progress{}) || t.Implements(reflect.TypeOf((*context.Context)(nil)).Elem()) {
+			continue
+		}
+		data, err := json.Marshal(arg.Interface())
+		if err != nil {
+			return "", false
+		}
+		var value interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err = decoder.Decode(&value); err != nil {
+			return "", false
+		}
+		values = append(values, value)
+	}
+	normalized, err := json.Marshal(values)
+	if err != nil {
+		return "", false
+	}
+	return strings.ToLower(target) + "\u001f" + string(normalized), true
+}
+
+func (r *resultCache) get(key string, now time.Time) ([]reflect.Value, bool) {
+	defer r.mx.Unlock()
+	r.mx.Lock()
+	entry, ok := r.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(entry.expires) {
+		delete(r.entries, key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put caches the result of a hub method. Futures and channels are not cached, their values are not known yet
+func (r *resultCache) put(key string, target string, result []reflect.Value, now time.Time) {
+	if len(result) == 0 {
+		return
+	}
+	if len(result) == 1 && (result[0].Kind() == reflect.Chan || result[0].Type() == reflect.TypeOf(&Future{})) {
+		return
+	}
+	defer r.mx.Unlock()
+	r.mx.Lock()
+	// Remove the expired entries from time to time, so results of arguments which are not used again do not pile up
+	if !now.Before(r.nextSweep) {
+		for k, entry := range r.entries {
+			if !now.Before(entry.expires) {
+				delete(r.entries, k)
+			}
+		}
+		r.nextSweep = now.Add(r.ttl)
+	}
+	r.entries[key] = cachedResult{target: strings.ToLower(target), result: result, expires: now.Add(r.ttl)}
+}
+
+// invalidate removes the results of the targets, or all results if no targets are given
+func (r *resultCache) invalidate(targets ...string) {
+	if r == nil {
+		return
+	}
+	defer r.mx.Unlock()
+	r.mx.Lock()
+	if len(targets) == 0 {
+		r.entries = make(map[string]cachedResult)
+		return
+	}
+	invalid := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		invalid[strings.ToLower(target)] = true
+	}
+	for key, entry := range r.entries {
+		if invalid[entry.target] {
+			delete(r.entries, key)
+		}
+	}
+}
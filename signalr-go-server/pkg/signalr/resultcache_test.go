@@ -0,0 +1,139 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var _ = Describe("CacheResults", func() {
+	var clock *ManualClock
+	var server *Server
+	var conn *testingConnection
+	call := func(id string, arguments string) {
+		conn.ClientSend(`{"type":1,"invocationId":"` + id + `","target":"simpleint","arguments":` + arguments + `}`)
+		Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: id, Result: float64(2)})))
+	}
+
+	BeforeEach(func() {
+		clock = NewManualClock(time.Now())
+		var err error
+		server, err = NewServer(UseHub(&invocationHub{}), UseClock(clock), CacheResults(time.Second, "SimpleInt"))
+		Expect(err).To(BeNil())
+		conn = newTestingConnection()
+		go server.Run(context.TODO(), conn)
+	})
+
+	Context("When a cached method is invoked twice with the same arguments", func() {
+		It("should call the hub method only once", func() {
+			call("1", `[1]`)
+			Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+			call("2", `[ 1 ]`)
+			Consistently(invocationQueue, 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+	Context("When the ttl has passed", func() {
+		It("should call the hub method again", func() {
+			call("1", `[1]`)
+			Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+			clock.Advance(time.Second)
+			call("2", `[1]`)
+			Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+		})
+	})
+	Context("When the results are invalidated", func() {
+		It("should call the hub method again", func() {
+			call("1", `[1]`)
+			Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+			server.InvalidateResults("simpleInt")
+			call("2", `[1]`)
+			Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+		})
+	})
+	Context("When a HubFilter rejects an invocation of a cached method", func() {
+		It("should not answer it from the cache", func() {
+			authorize := func(invocation *HubInvocationContext, next HubInvoker) ([]interface{}, error) {
+				if strings.HasPrefix(invocation.ConnectionID, "mallory") {
+					return nil, errors.New("not authorized")
+				}
+				return next(invocation)
+			}
+			server, err := NewServer(UseHub(&invocationHub{}), UseClock(clock), CacheResults(time.Second, "SimpleInt"),
+				HubFilters(authorize))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"simpleint","arguments":[1]}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: float64(2)})))
+			Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+			mallory := newNamedConnection("mallory-1")
+			go server.Run(context.TODO(), mallory)
+			mallory.ClientSend(`{"type":1,"invocationId":"2","target":"simpleint","arguments":[1]}`)
+			Eventually(mallory.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Error: "not authorized"})))
+		})
+	})
+	Context("When a HubFilter changes the arguments of a cached method", func() {
+		It("should build the key from the changed arguments", func() {
+			scope := func(invocation *HubInvocationContext, next HubInvoker) ([]interface{}, error) {
+				invocation.Args[0] = len(strings.Split(invocation.ConnectionID, "-")[0])
+				return next(invocation)
+			}
+			server, err := NewServer(UseHub(&invocationHub{}), UseClock(clock), CacheResults(time.Second, "SimpleInt"),
+				HubFilters(scope))
+			Expect(err).To(BeNil())
+			for i, prefix := range []string{"ab", "abc"} {
+				conn := newNamedConnection(prefix + "-1")
+				go server.Run(context.TODO(), conn)
+				id := fmt.Sprint(i)
+				conn.ClientSend(`{"type":1,"invocationId":"` + id + `","target":"simpleint","arguments":[1]}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: id, Result: float64(len(prefix) + 1)})))
+				Expect(<-invocationQueue).To(Equal(fmt.Sprintf("SimpleInt(%v)", len(prefix))))
+			}
+		})
+	})
+	Context("When the option is used without ttl or targets", func() {
+		It("should return an error", func() {
+			_, err := NewServer(UseHub(&invocationHub{}), CacheResults(0, "SimpleInt"))
+			Expect(err).NotTo(BeNil())
+			_, err = NewServer(UseHub(&invocationHub{}), CacheResults(time.Second))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
+
+func newNamedConnection(connectionID string) *testingConnection {
+	conn := newTestingConnectionBeforeHandshake()
+	conn.connectionID = connectionID
+	go receiveLoop(conn)()
+	conn.ClientSend(`{"protocol": "json","version": 1}`)
+	conn.SetConnected(true)
+	return conn
+}
+
+var _ = Describe("resultCache", func() {
+	Context("When arguments differ only in whitespace, the order of object keys or the protocol", func() {
+		It("should build the same key", func() {
+			cache := &resultCache{}
+			key := func(target string, args ...interface{}) string {
+				values := make([]reflect.Value, len(args))
+				for i, arg := range args {
+					values[i] = reflect.ValueOf(arg)
+				}
+				key, ok := cache.key(target, values)
+				Expect(ok).To(BeTrue())
+				return key
+			}
+			key1 := key("Query", json.RawMessage(`{ "b":[2], "a":1 }`))
+			Expect(key("query", json.RawMessage(`{"a":1,"b":[2]}`))).To(Equal(key1))
+			Expect(key("QUERY", map[string]interface{}{"b": []interface{}{int64(2)}, "a": int64(1)})).To(Equal(key1))
+			Expect(key("query", context.TODO(), json.RawMessage(`{"a":1,"b":[2]}`), &Progress{})).To(Equal(key1))
+			Expect(key("query", json.RawMessage(`{"a":2,"b":[2]}`))).NotTo(Equal(key1))
+		})
+	})
+})
@@ -0,0 +1,115 @@
+package signalr
+
+import (
+	"sync"
+	"time"
+)
+
+// ConnectionState is the state of a connection which is restored when the client reconnects.
+// UserID is the user of the connection. The state is only restored for a connection of the same user.
+type ConnectionState struct {
+	UserID string
+	Groups []string
+	Items  map[interface{}]interface{}
+}
+
+// ResumeStore keeps the state of disconnected connections under the resume token the server issued for the connection.
+// When a client reconnects with the resume token, the server rejoins the new connection to the groups and restores its items.
+// Save() stores the state of a connection which has been disconnected
+// Load() returns the state stored under a resume token and removes it from the store
+type ResumeStore interface {
+	Save(resumeToken string, state ConnectionState)
+	Load(resumeToken string) (ConnectionState, bool)
+}
+
+// NewMemoryResumeStore creates a ResumeStore which keeps the states in memory for ttl
+func NewMemoryResumeStore(ttl time.Duration) ResumeStore {
+	return &memoryResumeStore{ttl: ttl, states: make(map[string]storedConnectionState)}
+}
+
+type storedConnectionState struct {
+	state   ConnectionState
+	expires time.Time
+}
+
+type memoryResumeStore struct {
+	ttl    time.Duration
+	states map[string]storedConnectionState
+	mx     sync.Mutex
+}
+
+func (m *memoryResumeStore) Save(resumeToken string, state ConnectionState) {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	now := time.Now()
+	for id, stored := range m.states {
+		if now.After(stored.expires) {
+			delete(m.states, id)
+		}
+	}
+	m.states[resumeToken] = storedConnectionState{state: state, expires: now.Add(m.ttl)}
+}
+
+func (m *memoryResumeStore) Load(resumeToken string) (ConnectionState, bool) {
+	defer m.mx.Unlock()
+	m.mx.Lock()
+	stored, ok := m.states[resumeToken]
+	if !ok {
+		return ConnectionState{}, false
+	}
+	delete(m.states, resumeToken)
+	if time.Now().After(stored.expires) {
+		return ConnectionState{}, false
+	}
+	return stored.state, true
+}
+
+// saveConnectionState stores the state of conn under the resume token which has been issued for it
+func (s *Server) saveConnectionState(conn hubConnection, resumeToken string) {
+	state := ConnectionState{
+		UserID: conn.UserID(),
+		Items:  make(map[interface{}]interface{}),
+		Groups: s.localLifetimeManager.groupsOf(conn.ConnectionID()),
+	}
+	conn.Items().Range(func(key, value interface{}) bool {
+		if !isInternalItem(key) {
+			state.Items[key] = value
+		}
+		return true
+	})
+	s.resumeStore.Save(resumeToken, state)
+}
+
+// restoreConnectionState restores the state stored under the resume token the client sent in the handshake.
+// The connection id is chosen by the client and can not be trusted, so state is only restored with the secret token
+func (s *Server) restoreConnectionState(conn hubConnection, resumeFrom string) {
+	if resumeFrom == "" {
+		return
+	}
+	if state, ok := s.resumeStore.Load(resumeFrom); ok {
+		if state.UserID != conn.UserID() {
+			_ = s.info.Log(evt, "resume", "connectionId", conn.ConnectionID(), "error", "state belongs to another user", react, "do not restore")
+			return
+		}
+		for key, value := range state.Items {
+			if !isInternalItem(key) {
+				conn.Items().Store(key, value)
+			}
+		}
+		for _, groupName := range state.Groups {
+			// Errors are logged by the GroupManager
+			_ = s.groupManager.AddToGroup(groupName, conn.ConnectionID())
+		}
+	}
+}
+
+// isInternalItem tells if key is the key of an item the server stores for each connection,
+// like its principal. These items belong to the connection and are never saved or restored
+func isInternalItem(key interface{}) bool {
+	switch key.(type) {
+	case hubCallerKey, connectionMetadataKey:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,21 @@
+package signalr
+
+import "time"
+
+// ScheduledSend is the handle of a send which has been scheduled by ClientProxy.SendAfter
+// Cancel() stops the send. It returns false if the send has already been done or canceled.
+type ScheduledSend interface {
+	Cancel() bool
+}
+
+type timerScheduledSend struct {
+	timer *time.Timer
+}
+
+func (t *timerScheduledSend) Cancel() bool {
+	return t.timer.Stop()
+}
+
+func scheduleSend(delay time.Duration, send func()) ScheduledSend {
+	return &timerScheduledSend{timer: time.AfterFunc(delay, send)}
+}
@@ -13,15 +13,26 @@ import (
 	"fmt"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"net"
 	"os"
 	"reflect"
+	"sync"
 	"time"
 )
 
 // Server is a SignalR server for one type of hub
 type Server struct {
-	newHub                    func() HubInterface
-	lifetimeManager           HubLifetimeManager
+	newHub          func() HubInterface
+	lifetimeManager HubLifetimeManager
+	// localLifetimeManager is the in-process HubLifetimeManager, which knows the connections of this server
+	localLifetimeManager      *defaultHubLifetimeManager
+	lifetimeManagerFactory    func(local HubLifetimeManager) HubLifetimeManager
+	extensions                []Extension
+	groupSendBatchWindow      time.Duration
+	binaryHandshake           bool
+	extensionsMx              sync.Mutex
+	started                   bool
+	stopping                  int32
 	defaultHubClients         *defaultHubClients
 	groupManager              GroupManager
 	info                      log.Logger
@@ -29,10 +40,69 @@ type Server struct {
 	hubChanReceiveTimeout     time.Duration
 	clientTimeoutInterval     time.Duration
 	handshakeTimeout          time.Duration
+	clock                     Clock
 	keepAliveInterval         time.Duration
 	enableDetailedErrors      bool
 	streamBufferCapacity      uint
 	maximumReceiveMessageSize uint
+	protocolMap               map[string]HubProtocol
+	appName                   string
+	groupJoinAuthorizer       GroupJoinAuthorizerFunc
+	groupMembershipChanged    func(event GroupMembershipEvent)
+	groupExpiration           GroupExpirationFunc
+	groupExpired              func(groupName string)
+	pingTimestamps            bool
+	messageInterceptors       []MessageInterceptor
+	frameInspectors           []FrameInspector
+	handshakeValidator        HandshakeValidatorFunc
+	sharedHub                 bool
+	hubPerConnection          bool
+	features                  []string
+	invocationTransformers    map[string][]InvocationTransformerFunc
+	broadcastThrottles        map[string]time.Duration
+	resumeStore               ResumeStore
+	unsentQueueGracePeriod    time.Duration
+	unsentQueues              unsentQueues
+	bulkSendParallelism       int
+	repeatedHandshakePolicy   RepeatedHandshakePolicy
+	trustedProxies            []*net.IPNet
+	ipFilter                  *IPFilter
+	userIDProvider            func(conn Connection) string
+	tokenAuthenticator        TokenAuthenticatorFunc
+	reauthentication          bool
+	reauthenticationGrace     time.Duration
+	hubPolicies               []AuthorizationPolicy
+	methodPolicies            map[string][]AuthorizationPolicy
+	onError                   func(err error)
+	groupReplayBuffer         GroupReplayBuffer
+	outbox                    Outbox
+	outboxRetryInterval       time.Duration
+	ackRetry                  *ackRetryPolicy
+	handoffURL                string
+	reconnectHints            map[string]ReconnectHint
+	handoffMx                 sync.Mutex
+	negotiateRedirectFunc     NegotiateRedirectFunc
+	negotiateMetadataFunc     NegotiateMetadataFunc
+	invocationTimeout         time.Duration
+	invocationGracePeriod     time.Duration
+	stuckInvocations          sync.Map
+	scheduler                 *fairScheduler
+	invocationWorkers         int
+	invocationQueueLimit      int
+	statsD                    *StatsDEmitter
+	payloadEncryption         *payloadEncryption
+	resultCache               *resultCache
+	messageSigner             MessageSigner
+	watchdogThreshold         time.Duration
+	watchdogCancel            bool
+	messageVerifier           MessageVerifier
+	statsDInterval            time.Duration
+	statsDMethodsOnce         sync.Once
+	statsDMethods             map[string]bool
+	argumentLimits            map[string]argumentLimits
+	sheddingThreshold         int
+	completionShaper          CompletionShaperFunc
+	hubFilters                []HubFilter
 }
 
 // NewServer creates a new server for one type of hub
@@ -41,14 +111,12 @@ func NewServer(options ...func(*Server) error) (*Server, error) {
 	info, dbg := buildInfoDebugLogger(log.NewLogfmtLogger(os.Stderr), false)
 	lifetimeManager := newLifeTimeManager(info)
 	server := &Server{
-		lifetimeManager: &lifetimeManager,
+		lifetimeManager:      &lifetimeManager,
+		localLifetimeManager: &lifetimeManager,
 		defaultHubClients: &defaultHubClients{
 			lifetimeManager: &lifetimeManager,
 			allCache:        allClientProxy{lifetimeManager: &lifetimeManager},
 		},
-		groupManager: &defaultGroupManager{
-			lifetimeManager: &lifetimeManager,
-		},
 		info:                      info,
 		dbg:                       dbg,
 		hubChanReceiveTimeout:     time.Second * 5,
@@ -57,7 +125,15 @@ func NewServer(options ...func(*Server) error) (*Server, error) {
 		keepAliveInterval:         time.Second * 15,
 		enableDetailedErrors:      false,
 		streamBufferCapacity:      10,
+		bulkSendParallelism:       16,
 		maximumReceiveMessageSize: 1 << 15, // 32KB
+		protocolMap:               protocolMap,
+		clock:                     systemClock{},
+		invocationQueueLimit:      100,
+	}
+	server.groupManager = &defaultGroupManager{
+		lifetimeManager: &lifetimeManager,
+		server:          server,
 	}
 	for _, option := range options {
 		if option != nil {
@@ -66,29 +142,194 @@ func NewServer(options ...func(*Server) error) (*Server, error) {
 			}
 		}
 	}
+	if server.hubPerConnection && server.sharedHub {
+		return nil, errors.New("HubPerConnection can not be used with UseHub, all connections would share one hub")
+	}
+	if server.hubPerConnection && server.invocationWorkers > 0 {
+		return nil, errors.New("HubPerConnection can not be used with InvocationWorkers, which run invocations of a connection in parallel")
+	}
+	if server.messageSigner != nil {
+		// Sign after all other interceptors changed the message
+		server.messageInterceptors = append(server.messageInterceptors, &signingInterceptor{signer: server.messageSigner})
+	}
+	lifetimeManager.groupMembershipEvent = server.groupMembershipChanged
+	lifetimeManager.groupExpiration = server.groupExpiration
+	lifetimeManager.groupExpired = server.groupExpired
+	lifetimeManager.transformers = server.invocationTransformers
+	lifetimeManager.throttle = newBroadcastThrottle(server.broadcastThrottles)
+	lifetimeManager.batcher = newGroupBatcher(server.groupSendBatchWindow)
+	lifetimeManager.encryption = server.payloadEncryption
+	lifetimeManager.replayBuffer = server.groupReplayBuffer
+	lifetimeManager.outbox = server.outbox
+	lifetimeManager.bulkSendParallelism = server.bulkSendParallelism
+	if server.ackRetry != nil {
+		lifetimeManager.ackRetry = *server.ackRetry
+	}
+	if server.lifetimeManagerFactory != nil {
+		if err := server.useLifetimeManager(server.lifetimeManagerFactory(&lifetimeManager)); err != nil {
+			return nil, err
+		}
+	}
+	if server.statsD != nil {
+		go server.emitStatsD(server.statsDInterval)
+	}
 	if server.newHub == nil {
 		return server, errors.New("cannot determine hub type. Neither UseHub, HubFactory or SimpleHubFactory given as option")
 	}
+	if server.invocationWorkers > 0 {
+		server.scheduler = newFairScheduler(server.invocationWorkers, server.invocationQueueLimit)
+		server.scheduler.start()
+	}
 	return server, nil
 }
 
 // Run runs the server on one connection. The same server might be run on different connections in parallel
 func (s *Server) Run(parentContext context.Context, conn Connection) {
-	if protocol, err := s.processHandshake(conn); err != nil {
+	if s.ipFilter != nil && !s.ipFilter.Allowed(remoteAddr(conn)) {
+		info, _ := s.prefixLogger()
+		_ = info.Log(evt, "ipFilter", "connectionId", conn.ConnectionID(), "remoteAddr", remoteAddr(conn), react, "do not connect")
+		closeTransport(conn, ClosePolicyViolation, "address not allowed")
+	} else if s.isStopping() {
+		info, _ := s.prefixLogger()
+		_ = info.Log(evt, "run", "connectionId", conn.ConnectionID(), "error", "server stopping", react, "do not connect")
+		closeTransport(conn, CloseGoingAway, "server stopping")
+	} else if handshake, err := s.processHandshake(conn); err != nil {
 		info, _ := s.prefixLogger()
 		_ = info.Log(evt, "processHandshake", "connectionId", conn.ConnectionID(), "error", err, react, "do not connect")
+		closeTransport(conn, ClosePolicyViolation, err.Error())
 	} else {
-		s.newServerLoop(parentContext, conn, protocol).Run()
+		if bp, ok := handshake.protocol.(binaryProtocol); ok && bp.isBinary() {
+			if bc, ok := conn.(binaryFramesConnection); ok {
+				bc.useBinaryFrames()
+			}
+		}
+		s.newServerLoop(parentContext, conn, handshake).Run()
+	}
+}
+
+// Groups returns the GroupManager of the server, which can be used to manage the groups from outside of hub methods
+func (s *Server) Groups() GroupManager {
+	return s.groupManager
+}
+
+// DisconnectUser closes all connections of the user with the given userID. reason is sent as close error
+// and the clients are not allowed to reconnect. Use it for bans or forced logouts.
+func (s *Server) DisconnectUser(userID string, reason string) {
+	s.lifetimeManager.DisconnectUser(userID, reason)
+}
+
+// AbortConnection closes the connection with the given connectionID and sends reason as close error,
+// e.g. to kick a banned user or to enforce the expiry of its token. allowReconnect is sent in the close message
+// and tells the client if it may reconnect. It returns an error if the connection is not connected.
+func (s *Server) AbortConnection(connectionID string, reason string, allowReconnect bool) error {
+	return s.lifetimeManager.AbortConnection(connectionID, reason, allowReconnect)
+}
+
+// SendWithAck sends an invocation to the connection with the given connectionID and resends it
+// with exponential backoff until the client acknowledges it. The returned Delivery tracks the status.
+func (s *Server) SendWithAck(connectionID string, target string, args ...interface{}) *Delivery {
+	return s.lifetimeManager.InvokeClientWithAck(connectionID, target, args)
+}
+
+// PauseReading stops reading messages from the connection with the given connectionID until ResumeReading is called.
+// The message which is currently received is still processed. As the transport is not read, the backpressure
+// reaches the client. The client is not timed out while reading is paused.
+// It returns false if the connection is not connected to the server.
+func (s *Server) PauseReading(connectionID string) bool {
+	if conn, ok := s.connection(connectionID); ok {
+		conn.PauseReading()
+		return true
+	}
+	return false
+}
+
+// Drain stops sending broadcasts to the connection with the given connectionID, waits up to timeout until
+// its queued messages are sent and closes it. The client is allowed to reconnect, e.g. to another server.
+// It returns an error if the connection is not connected or its queue could not be sent within timeout.
+func (s *Server) Drain(connectionID string, timeout time.Duration) error {
+	return s.lifetimeManager.Drain(connectionID, timeout)
+}
+
+// Ping sends a ping to the connection with the given connectionID, e.g. as cheap liveness probe before an expensive
+// targeted operation. It returns an error if the connection is not connected or the ping could not be written.
+func (s *Server) Ping(connectionID string) error {
+	return s.lifetimeManager.Ping(connectionID)
+}
+
+// InvalidateResults removes the results cached with the CacheResults option for the targets,
+// or all cached results if no targets are given. Use it when the data behind the cached methods has changed.
+func (s *Server) InvalidateResults(targets ...string) {
+	s.resultCache.invalidate(targets...)
+}
+
+// Tags returns the tags of the connection with the given connectionID.
+// It returns false if the connection is not connected to the server.
+func (s *Server) Tags(connectionID string) (*TagSet, bool) {
+	if conn, ok := s.connection(connectionID); ok {
+		return conn.Tags(), true
+	}
+	return nil, false
+}
+
+// ResumeReading resumes reading messages from the connection with the given connectionID.
+// It returns false if the connection is not connected to the server.
+func (s *Server) ResumeReading(connectionID string) bool {
+	if conn, ok := s.connection(connectionID); ok {
+		conn.ResumeReading()
+		return true
+	}
+	return false
+}
+
+// ConnectionStats returns the statistics of the connection with the given connectionID.
+// If the connection is not connected to the server, ok is false
+func (s *Server) ConnectionStats(connectionID string) (stats ConnectionStats, ok bool) {
+	if conn, ok := s.connection(connectionID); ok {
+		return conn.Stats(), true
+	}
+	return ConnectionStats{}, false
+}
+
+// useLifetimeManager lets the server, its HubClients and its GroupManager route messages with manager
+func (s *Server) useLifetimeManager(manager HubLifetimeManager) error {
+	if manager == nil {
+		return errors.New("UseHubLifetimeManager factory returned no HubLifetimeManager")
+	}
+	s.lifetimeManager = manager
+	s.defaultHubClients = &defaultHubClients{
+		lifetimeManager: manager,
+		allCache:        allClientProxy{lifetimeManager: manager},
+	}
+	s.groupManager = &defaultGroupManager{
+		lifetimeManager: manager,
+		server:          s,
+	}
+	if extension, ok := manager.(Extension); ok {
+		s.extensions = append(s.extensions, extension)
 	}
+	return nil
+}
+
+func (s *Server) connection(connectionID string) (hubConnection, bool) {
+	if conn, ok := s.localLifetimeManager.clients.Load(connectionID); ok {
+		return conn.(hubConnection), true
+	}
+	return nil, false
 }
 
 func (s *Server) prefixLogger() (info log.Logger, debug log.Logger) {
-	return log.WithPrefix(s.info, "ts", log.DefaultTimestampUTC,
-			"class", "Server",
-			"hub", reflect.ValueOf(s.newHub()).Elem().Type()),
-		log.WithPrefix(s.dbg, "ts", log.DefaultTimestampUTC,
-			"class", "Server",
-			"hub", reflect.ValueOf(s.newHub()).Elem().Type())
+	keyvals := []interface{}{"ts", log.DefaultTimestampUTC,
+		"class", "Server",
+		"hub", reflect.ValueOf(s.newHub()).Elem().Type()}
+	if s.appName != "" {
+		keyvals = append(keyvals, "app", s.appName)
+	}
+	return log.WithPrefix(s.info, keyvals...), log.WithPrefix(s.dbg, keyvals...)
+}
+
+// AppName returns the name of the Application the server belongs to, or "" if it was created without Application
+func (s *Server) AppName() string {
+	return s.appName
 }
 
 func buildInfoDebugLogger(logger log.Logger, debug bool) (log.Logger, log.Logger) {
@@ -106,8 +347,9 @@ func (s *Server) newConnectionHubContext(conn hubConnection) HubContext {
 			defaultHubClients: s.defaultHubClients,
 			connectionID:      conn.ConnectionID(),
 		},
-		groups:     s.groupManager,
-		connection: conn,
+		groups:          s.groupManager,
+		connection:      conn,
+		lifetimeManager: s.lifetimeManager,
 	}
 }
 
@@ -117,60 +359,191 @@ func (s *Server) getHub(conn hubConnection) HubInterface {
 	return hub
 }
 
-func (s *Server) processHandshake(conn Connection) (HubProtocol, error) {
-	var err error
-	var protocol HubProtocol
-	var ok bool
-	const handshakeResponse = "{}\u001e"
-	const errorHandshakeResponse = "{\"error\":\"%s\"}\u001e"
-	info, dbg := s.prefixLogger()
+// getTracedHub creates a hub whose Clients() send with the TraceContext of the invocation
+func (s *Server) getTracedHub(conn hubConnection, trace TraceContext) HubInterface {
+	hubContext := s.newConnectionHubContext(conn).(*connectionHubContext)
+	hubContext.clients = &tracedHubClients{clients: hubContext.clients, trace: trace}
+	hub := s.newHub()
+	hub.Initialize(hubContext)
+	return hub
+}
+
+// getSequenceHub creates the hub of a connection with HubPerConnection.
+// Its Clients() send with the TraceContext which the invocations set in the returned sequenceHubClients
+func (s *Server) getSequenceHub(conn hubConnection) (HubInterface, *sequenceHubClients) {
+	hubContext := s.newConnectionHubContext(conn).(*connectionHubContext)
+	clients := &sequenceHubClients{clients: hubContext.clients}
+	hubContext.clients = clients
+	hub := s.newHub()
+	hub.Initialize(hubContext)
+	return hub, clients
+}
+
+// processHandshake reads the handshake. The handshake fails when it is not complete after the handshake timeout
+func (s *Server) processHandshake(conn Connection) (handshake, error) {
+	if _, ok := s.clock.(systemClock); ok || s.handshakeTimeout <= 0 {
+		// The connection enforces the handshake timeout in real time
+		defer conn.SetTimeout(0)
+		conn.SetTimeout(s.handshakeTimeout)
+		return s.readHandshake(conn)
+	}
+	type handshakeResult struct {
+		handshake handshake
+		err       error
+	}
+	timeout := make(chan struct{})
+	timer := s.clock.AfterFunc(s.handshakeTimeout, func() { close(timeout) })
+	defer timer.Stop()
+	done := make(chan handshakeResult, 1)
+	go func() {
+		handshake, err := s.readHandshake(conn)
+		done <- handshakeResult{handshake, err}
+	}()
+	select {
+	case result := <-done:
+		return result.handshake, result.err
+	case <-timeout:
+		err := fmt.Errorf("handshake timeout (%v) elapsed", s.handshakeTimeout)
+		// The reader must not use the connection after the handshake failed.
+		// Closing the transport ends its Read, other connections can not be stopped
+		if _, ok := conn.(ClosableConnection); ok {
+			closeTransport(conn, ClosePolicyViolation, err.Error())
+			<-done
+		}
+		return handshake{}, err
+	}
+}
+
+// handshake is the outcome of a successful handshake
+type handshake struct {
+	// protocol is the requested protocol
+	protocol HubProtocol
+	// features are the features accepted by the client
+	features []string
+	metadata ConnectionMetadata
+	// resumeFrom is the resume token the client sent to resume the state of its former connection
+	resumeFrom string
+	// resumeToken is the resume token issued for the connection, if the server has a ResumeStore
+	resumeToken string
+}
 
-	defer conn.SetTimeout(0)
-	conn.SetTimeout(s.handshakeTimeout)
+func (s *Server) readHandshake(conn Connection) (handshake, error) {
+	var result handshake
+	info, dbg := s.prefixLogger()
+	request, binaryRequest, err := s.readHandshakeRequest(conn, dbg)
+	if err != nil {
+		return result, err
+	}
+	protocol, ok := s.protocolMap[request.Protocol]
+	if !ok {
+		err = fmt.Errorf("protocol %v not supported", request.Protocol)
+		_ = info.Log(evt, "protocol requested", "error", err)
+	} else if s.handshakeValidator != nil {
+		if err = s.handshakeValidator(conn, request.Protocol, request.Version); err != nil {
+			_ = info.Log(evt, "handshake validation", "error", err, react, "do not connect")
+		}
+	}
+	if err == nil {
+		result.protocol = protocol
+		result.metadata = connectionMetadata(conn, request.Metadata)
+		if len(s.features) > 0 {
+			result.features = s.acceptedFeatures(request.Features)
+		}
+		if s.issuesResumeTokens() {
+			result.resumeFrom = request.ResumeToken
+			result.resumeToken = getConnectionID()
+		}
+		// Send the handshake response
+		response := s.handshakeResponse(binaryRequest, "", result.features, result.resumeToken)
+		if _, err = conn.Write(response); err != nil {
+			_ = dbg.Log(evt, "handshake sent", "error", err)
+		} else {
+			_ = dbg.Log(evt, "handshake sent", "msg", string(response))
+		}
+		return result, err
+	}
+	if _, respErr := conn.Write(s.handshakeResponse(binaryRequest, err.Error(), nil, "")); respErr != nil {
+		_ = dbg.Log(evt, "handshake sent", "error", respErr)
+		err = respErr
+	}
+	return handshake{}, err
+}
 
+// readHandshakeRequest reads the handshake request byte by byte, so no data of the messages following it is consumed here.
+// binaryRequest tells if the client sent a binary handshake, see BinaryHandshake
+func (s *Server) readHandshakeRequest(conn Connection, dbg StructuredLogger) (request handshakeRequest, binaryRequest bool, err error) {
 	var buf bytes.Buffer
-	data := make([]byte, 1<<12)
+	var scanner recordSeparatorScanner
+	data := make([]byte, 1)
 	for {
 		var n int
 		if n, err = conn.Read(data); err != nil {
-			break
-		} else {
-			buf.Write(data[:n])
-			var rawHandshake []byte
-			if rawHandshake, err = parseTextMessageFormat(&buf); err != nil {
-				// Partial message, read more data
-				buf.Write(data[:n])
-			} else {
-				_ = dbg.Log(evt, "handshake received", "msg", string(rawHandshake))
-				request := handshakeRequest{}
-				if err = json.Unmarshal(rawHandshake, &request); err != nil {
-					// Malformed handshake
-					break
-				}
-				if protocol, ok = protocolMap[request.Protocol]; ok {
-					// Send the handshake response
-					if _, err = conn.Write([]byte(handshakeResponse)); err != nil {
-						_ = dbg.Log(evt, "handshake sent", "error", err)
-					} else {
-						_ = dbg.Log(evt, "handshake sent", "msg", handshakeResponse)
-					}
-				} else {
-					err = fmt.Errorf("protocol %v not supported", request.Protocol)
-					_ = info.Log(evt, "protocol requested", "error", err)
-					if _, respErr := conn.Write([]byte(fmt.Sprintf(errorHandshakeResponse, err))); respErr != nil {
-						_ = dbg.Log(evt, "handshake sent", "error", respErr)
-						err = respErr
-					}
-				}
+			return request, false, err
+		} else if uint(buf.Len()) >= s.maximumReceiveMessageSize {
+			return request, false, errors.New("handshake exceeds maximum receive message size")
+		}
+		if s.binaryHandshake && buf.Len() == 0 && n == 1 && data[0] == binaryHandshakeMarker {
+			request, err = s.readBinaryHandshake(conn)
+			_ = dbg.Log(evt, "handshake received", "msg", fmt.Sprintf("%+v", request))
+			return request, true, err
+		}
+		buf.Write(data[:n])
+		if rawHandshake, complete := scanner.next(&buf); complete {
+			_ = dbg.Log(evt, "handshake received", "msg", string(rawHandshake))
+			// A malformed handshake is not answered
+			err = json.Unmarshal(rawHandshake, &request)
+			return request, false, err
+		}
+	}
+}
+
+// handshakeResponse returns the handshake response in the format of the request
+// issuesResumeTokens tells if the server issues a secret resume token for each connection in the handshake
+func (s *Server) issuesResumeTokens() bool {
+	return s.resumeStore != nil || s.unsentQueueGracePeriod > 0
+}
+
+func (s *Server) handshakeResponse(binaryRequest bool, errorMessage string, features []string, resumeToken string) []byte {
+	if binaryRequest {
+		return encodeBinaryHandshakeResponse(errorMessage, features, resumeToken)
+	}
+	if errorMessage != "" {
+		// json.Marshal of a string does not fail
+		errMsg, _ := json.Marshal(errorMessage)
+		return []byte(fmt.Sprintf("{\"error\":%s}\u001e", errMsg))
+	}
+	// Clients which do not know features or resume tokens ignore the unknown fields
+	fields := make(map[string]interface{})
+	if len(s.features) > 0 {
+		fields["features"] = features
+	}
+	if resumeToken != "" {
+		fields["resumeToken"] = resumeToken
+	}
+	if len(fields) > 0 {
+		rawFields, _ := json.Marshal(fields)
+		return append(rawFields, 30)
+	}
+	return []byte("{}\u001e")
+}
+
+// acceptedFeatures returns the features offered by the server which the client requested, in the order of the server
+func (s *Server) acceptedFeatures(requested []string) []string {
+	accepted := make([]string, 0, len(s.features))
+	for _, feature := range s.features {
+		for _, r := range requested {
+			if r == feature {
+				accepted = append(accepted, feature)
 				break
 			}
 		}
 	}
-	return protocol, err
+	return accepted
 }
 
 var protocolMap = map[string]HubProtocol{
-	"json": &JSONHubProtocol{},
+	"json":        &JSONHubProtocol{},
+	"messagepack": &MessagePackHubProtocol{},
 }
 
 // const for logging
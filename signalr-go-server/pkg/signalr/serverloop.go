@@ -2,6 +2,7 @@ package signalr
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"runtime/debug"
@@ -18,48 +19,115 @@ type serverLoop struct {
 	allowReconnect bool
 	streamer       *streamer
 	streamClient   *streamClient
+	// outboxResending is 1 while pending outbox messages are resent
+	outboxResending int32
+	// conn is the transport of hubConn
+	conn Connection
+	// ctx is the parent of the invocation contexts and canceled when the connection ends
+	ctx    context.Context
+	cancel context.CancelFunc
+	// hub is the hub instance of the connection if the server uses HubPerConnection
+	hub HubInterface
+	// hubClients are the Clients() of hub, invocations set their TraceContext in them
+	hubClients *sequenceHubClients
+	// sequence runs the lifecycle events and invocations of the connection in order if the server uses HubPerConnection
+	sequence chan func()
+	// resumeFrom and resumeToken are the resume tokens of the former and of this connection, see UseResumeStore
+	resumeFrom  string
+	resumeToken string
+	// credentialsExpired fires when the grace period after the expiry of the credentials of the connection has elapsed
+	credentialsExpired <-chan time.Time
 }
 
-func (s *Server) newServerLoop(parentContext context.Context, conn Connection, protocol HubProtocol) *serverLoop {
-	protocol = reflect.New(reflect.ValueOf(protocol).Elem().Type()).Interface().(HubProtocol)
-	protocol.setDebugLogger(s.dbg)
+func (s *Server) newServerLoop(parentContext context.Context, conn Connection, handshake handshake) *serverLoop {
+	protocol := reflect.New(reflect.ValueOf(handshake.protocol).Elem().Type()).Interface().(HubProtocol)
+	if dp, ok := protocol.(debugLoggingProtocol); ok {
+		dp.setDebugLogger(s.dbg)
+	}
 	info, dbg := s.prefixLogger()
-	hubConn := newHubConnection(parentContext, conn, protocol, s.maximumReceiveMessageSize)
-	return &serverLoop{
+	var userID string
+	if s.userIDProvider != nil {
+		userID = s.userIDProvider(conn)
+	}
+	sl := &serverLoop{
 		server:         s,
 		protocol:       protocol,
-		hubConn:        hubConn,
 		allowReconnect: true,
-		streamer:       newStreamer(hubConn, s.info),
-		streamClient:   s.newStreamClient(),
+		streamClient:   s.newStreamClient(protocol),
 		info:           info,
 		dbg:            dbg,
+		resumeFrom:     handshake.resumeFrom,
+		resumeToken:    handshake.resumeToken,
+	}
+	sl.ctx, sl.cancel = context.WithCancel(parentContext)
+	sl.conn = conn
+	sl.hubConn = newHubConnection(parentContext, newInspectedConnection(conn, s.frameInspectors), protocol, s.maximumReceiveMessageSize, userID, sl.reportPanic, s.messageInterceptors...)
+	sl.hubConn.SetFeatures(handshake.features)
+	sl.hubConn.SetClock(s.clock)
+	sl.hubConn.SetResumeToken(handshake.resumeToken)
+	sl.hubConn.Items().Store(connectionMetadataKey{}, handshake.metadata)
+	sl.hubConn.Items().Store(hubCallerKey{}, newHubCaller(parentContext, conn))
+	if s.unsentQueueGracePeriod > 0 {
+		sl.hubConn.KeepUnsent()
+	}
+	sl.streamer = newStreamer(sl.hubConn, s.info, sl.goSafe)
+	if s.hubPerConnection {
+		sl.sequence = make(chan func(), 64)
 	}
+	return sl
 }
 
 func (sl *serverLoop) Run() {
 	sl.hubConn.Start()
+	sl.server.statsD.count("connections.opened", 1)
+	if sl.server.unsentQueueGracePeriod > 0 {
+		if err := sl.server.requeueUnsent(sl.hubConn, sl.protocol, sl.resumeFrom); err != nil {
+			_ = sl.info.Log(evt, "requeue unsent messages", "error", err)
+		}
+	}
 	sl.server.lifetimeManager.OnConnected(sl.hubConn)
-	go func() {
-		defer sl.recoverHubLifeCyclePanic()
-		sl.server.getHub(sl.hubConn).OnConnected(sl.hubConn.ConnectionID())
-	}()
+	if sl.server.resumeStore != nil {
+		sl.server.restoreConnectionState(sl.hubConn, sl.resumeFrom)
+	}
+	if sl.sequence != nil {
+		sl.hub, sl.hubClients = sl.server.getSequenceHub(sl.hubConn)
+		go sl.runSequence()
+	}
+	sl.dispatchLifeCycle(func() {
+		sl.getHub().OnConnected(sl.hubConn.ConnectionID())
+	})
+	sl.watchCredentials(hubCallerFromItems(sl.hubConn.Items()).principal)
+	var outboxRetry <-chan time.Time
+	if sl.server.outbox != nil {
+		sl.resendOutbox()
+		if sl.server.outboxRetryInterval > 0 {
+			outboxRetry = sl.server.clock.After(sl.server.outboxRetryInterval)
+		}
+	}
 	// Process messages
 	var err error
+	var mch chan interface{}
+	var ech chan error
+	clientWatchdog := sl.server.clock.After(sl.server.clientTimeoutInterval)
+	keepAliveWatchdog := sl.server.clock.After(sl.server.keepAliveInterval)
 loop:
 	for {
-		mch := make(chan interface{}, 1)
-		ech := make(chan error, 1)
-		clientWatchdog := time.After(sl.server.clientTimeoutInterval)
-		keepAliveWatchdog := time.After(sl.server.keepAliveInterval)
-		go func() {
-			message, err := sl.receive()
-			ech <- err
-			mch <- message
-		}()
+		// Only one receive at a time, other events must not start another one
+		if mch == nil {
+			m, e := make(chan interface{}, 1), make(chan error, 1)
+			sl.goSafe(func() {
+				message, err := sl.receive()
+				e <- err
+				m <- message
+			})
+			mch, ech = m, e
+		}
 		select {
 		case message := <-mch:
 			err = <-ech
+			mch = nil
+			clientWatchdog = sl.server.clock.After(sl.server.clientTimeoutInterval)
+			keepAliveWatchdog = sl.server.clock.After(sl.server.keepAliveInterval)
 			if err == nil {
 				switch message := message.(type) {
 				case invocationMessage:
@@ -74,6 +142,8 @@ loop:
 				case closeMessage:
 					_ = sl.dbg.Log(evt, msgRecv, msg, fmtMsg(message))
 					break loop
+				case handshakeRequest:
+					err = sl.handleRepeatedHandshake(message)
 				case hubMessage:
 					err = sl.handleOtherMessage(message)
 				}
@@ -82,88 +152,267 @@ loop:
 				break loop
 			}
 		case <-clientWatchdog:
+			if sl.hubConn.ReadingPaused() {
+				// The client can not be heard while reading is paused
+				clientWatchdog = sl.server.clock.After(sl.server.clientTimeoutInterval)
+				continue
+			}
 			err = fmt.Errorf("client timeout interval elapsed (%v)", sl.server.clientTimeoutInterval)
 			break loop
+		case <-outboxRetry:
+			sl.resendOutbox()
+			outboxRetry = sl.server.clock.After(sl.server.outboxRetryInterval)
+		case <-sl.credentialsExpired:
+			err = errors.New("credentials expired")
+			_ = sl.info.Log(evt, "reauthenticate", "error", err, react, "close connection")
+			break loop
 		case <-keepAliveWatchdog:
-			sendMessageAndLog(func() (interface{}, error) { return sl.hubConn.Ping() }, sl.info)
+			// Stream items flowing to the client keep the connection alive as well as pings do
+			if idle := sl.server.clock.Now().Sub(sl.hubConn.LastStreamItemSent()); idle < sl.server.keepAliveInterval {
+				keepAliveWatchdog = sl.server.clock.After(sl.server.keepAliveInterval - idle)
+				continue
+			}
+			sendMessageAndLog(func() (interface{}, error) { return sl.hubConn.Ping(sl.server.pingTimestamps) }, sl.info)
+			keepAliveWatchdog = sl.server.clock.After(sl.server.keepAliveInterval)
 		case err = <-sl.hubConn.Aborted():
+			switch abortErr := err.(type) {
+			case *disconnectUserError:
+				sl.allowReconnect = false
+			case *abortConnectionError:
+				sl.allowReconnect = abortErr.allowReconnect
+			}
 			break loop
 		}
 	}
-	go func() {
-		defer sl.recoverHubLifeCyclePanic()
-		sl.server.getHub(sl.hubConn).OnDisconnected(sl.hubConn.ConnectionID())
-	}()
+	disconnectErr := err
+	onDisconnected := func() {
+		hub := sl.getHub()
+		if handler, ok := hub.(DisconnectErrorHandler); ok {
+			handler.OnDisconnectedWithError(sl.hubConn.ConnectionID(), disconnectErr)
+		} else {
+			hub.OnDisconnected(sl.hubConn.ConnectionID())
+		}
+	}
+	if sl.sequence != nil {
+		// The sequence might be full, do not block closing the connection
+		go func() {
+			sl.dispatchLifeCycle(onDisconnected)
+			close(sl.sequence)
+		}()
+	} else {
+		sl.dispatchLifeCycle(onDisconnected)
+	}
+	if sl.server.scheduler != nil {
+		// Nobody waits for the results anymore
+		sl.server.scheduler.dropConnection(sl.hubConn.ConnectionID())
+	}
+	if sl.server.resumeStore != nil {
+		sl.server.saveConnectionState(sl.hubConn, sl.resumeToken)
+	}
 	sl.server.lifetimeManager.OnDisconnected(sl.hubConn)
 	sendMessageAndLog(func() (interface{}, error) {
 		return sl.hubConn.Close(fmt.Sprintf("%v", err), sl.allowReconnect)
 	}, sl.info)
+	reason := ""
+	if err != nil {
+		reason = err.Error()
+	}
+	closeTransport(sl.conn, closeCode(err), reason)
+	if sl.server.unsentQueueGracePeriod > 0 {
+		sl.server.keepUnsent(sl.hubConn, sl.protocol)
+	}
+	sl.server.statsD.count("connections.closed", 1)
+	sl.cancel()
+	// Release a pending receive which waits for ResumeReading
+	sl.hubConn.ResumeReading()
 	_ = sl.dbg.Log(evt, "message loop ended")
 }
 
+// closeCode returns the status code to close the transport after the message loop ended with err
+func closeCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return CloseNormal
+	case *disconnectUserError:
+		return ClosePolicyViolation
+	case *abortConnectionError:
+		if !e.allowReconnect {
+			return ClosePolicyViolation
+		}
+		return CloseNormal
+	case *PanicError:
+		return CloseInternalError
+	default:
+		return CloseNormal
+	}
+}
+
 func (sl *serverLoop) receive() (message interface{}, err error) {
 	if message, err = sl.hubConn.Receive(); err != nil {
 		_ = sl.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(message), react, "close connection")
+	} else if intercepted, ok := interceptInbound(sl.server.messageInterceptors, sl.hubConn.ConnectionID(), message); ok {
+		message = intercepted
+	} else {
+		_ = sl.dbg.Log(evt, msgRecv, msg, fmtMsg(message), react, "dropped by interceptor")
+		message = nil
 	}
 	return message, err
 }
 
 func (sl *serverLoop) handleInvocationMessage(invocation invocationMessage) {
 	_ = sl.dbg.Log(evt, msgRecv, msg, fmtMsg(invocation))
+	sl.server.statsD.count("invocations", 1, sl.server.statsDTarget(invocation.Target))
+	if sl.hubConn.Quarantined() {
+		_ = sl.info.Log(evt, "quarantine", "name", invocation.Target, react, "send completion with error")
+		sl.complete(invocation, nil, errors.New(quarantinedError))
+		return
+	}
+	if sl.server.isStopping() {
+		_ = sl.info.Log(evt, "server stopping", "name", invocation.Target, react, "send completion with error")
+		sl.complete(invocation, nil, errors.New("Server stopping"))
+		return
+	}
+	if sl.server.reauthentication && invocation.Target == ReauthenticateTarget {
+		sl.handleReauthentication(invocation)
+		return
+	}
+	trace, traced := parseTraceContext(invocation.Headers)
+	if traced && invocation.InvocationID != "" {
+		sl.hubConn.TraceInvocation(invocation.InvocationID, trace.headers())
+	}
+	if sl.server.messageVerifier != nil {
+		if err := verifySignature(sl.server.messageVerifier, sl.hubConn.ConnectionID(), invocation); err != nil {
+			_ = sl.info.Log(evt, "verify signature", "error", err, "name", invocation.Target, react, "send completion with error")
+			sl.complete(invocation, nil, errors.New("invalid signature"))
+			return
+		}
+	}
+	if sl.server.payloadEncryption.applies(invocation.Target) {
+		var err error
+		if invocation, err = sl.server.payloadEncryption.decrypt(sl.hubConn.ConnectionID(), sl.protocol, invocation); err != nil {
+			_ = sl.info.Log(evt, "decrypt payload", "error", err, "name", invocation.Target, react, "send completion with error")
+			sl.complete(invocation, nil, errors.New("invalid encrypted payload"))
+			return
+		}
+	}
+	if limitErr := checkArgumentLimits(sl.server.argumentLimits, invocation); limitErr != "" {
+		_ = sl.info.Log(evt, "check argument limits", "error", limitErr, "name", invocation.Target, react, "send completion with error")
+		sl.complete(invocation, nil, errors.New(limitErr))
+		return
+	}
+	if err := sl.authorize(invocation); err != nil {
+		sl.server.statsD.count("invocations.unauthorized", 1, sl.server.statsDTarget(invocation.Target))
+		_ = sl.info.Log(evt, "authorize", "error", err, "name", invocation.Target, react, "send completion with error")
+		sl.complete(invocation, nil, err)
+		return
+	}
+	if threshold := sl.server.sheddingThreshold; threshold > 0 && sl.hubConn.PendingInvocations() >= int64(threshold) {
+		sl.server.statsD.count("invocations.shed", 1, sl.server.statsDTarget(invocation.Target))
+		_ = sl.info.Log(evt, "shed invocation", "pending", sl.hubConn.PendingInvocations(), "name", invocation.Target, react, "send completion with error")
+		sl.complete(invocation, nil, errors.New("Server busy"))
+		return
+	}
 	// Transient hub, dispatch invocation here
-	if method, ok := getMethod(sl.server.getHub(sl.hubConn), invocation.Target); !ok {
+	var hub HubInterface
+	if traced && sl.hub == nil {
+		hub = sl.server.getTracedHub(sl.hubConn, trace)
+	} else {
+		hub = sl.getHub()
+	}
+	// ctx is passed to hub methods with a context.Context parameter and canceled when the invocation ends
+	ctx, cancel := sl.invocationContext()
+	if traced {
+		ctx = withTraceContext(ctx, trace)
+	}
+	cancel = sl.watch(invocation, cancel)
+	if method, ok := getMethod(hub, invocation.Target); !ok {
+		cancel()
+		if handler, ok := hub.(InvocationHandler); ok {
+			// The hub dispatches the invocation itself
+			sl.handleInvocationManually(handler, invocation)
+			return
+		}
 		// Unable to find the method
 		_ = sl.info.Log(evt, "getMethod", "error", "missing method", "name", invocation.Target, react, "send completion with error")
-		sendMessageAndLog(func() (interface{}, error) {
-			return sl.hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("Unknown method %s", invocation.Target))
-		}, sl.info)
-	} else if in, clientStreaming, err := buildMethodArguments(method, invocation, sl.streamClient, sl.protocol); err != nil {
+		sl.complete(invocation, nil, fmt.Errorf("Unknown method %s", invocation.Target))
+	} else if in, clientStreaming, err := buildMethodArguments(ctx, method, invocation, sl.streamClient, sl.protocol, sl.hubConn); err != nil {
+		cancel()
 		// argument build failed
 		_ = sl.info.Log(evt, "buildMethodArguments", "error", err, "name", invocation.Target, react, "send completion with error")
-		sendMessageAndLog(func() (interface{}, error) {
-			return sl.hubConn.Completion(invocation.InvocationID, nil, err.Error())
-		}, sl.info)
+		sl.complete(invocation, nil, err)
 	} else if clientStreaming {
 		// let the receiving method run independently
-		go func() {
+		sl.goSafe(func() {
+			defer cancel()
 			defer sl.recoverInvocationPanic(invocation)
-			method.Call(in)
-		}()
+			if _, err := sl.invokeHubMethod(ctx, hub, invocation, method, in); err != nil && invocation.InvocationID != "" {
+				sl.complete(invocation, nil, err)
+			}
+		})
 	} else {
 		// hub method might take a long time
-		go func() {
-			result := func() []reflect.Value {
+		dispatched := sl.dispatchInvocation(invocation, func() {
+			var filterErr error
+			result, ok := sl.callHubMethod(invocation, func() []reflect.Value {
 				defer sl.recoverInvocationPanic(invocation)
-				return method.Call(in)
-			}()
-			sl.returnInvocationResult(invocation, result)
-		}()
+				if sl.hubClients != nil {
+					sl.hubClients.setTrace(trace, traced)
+					defer sl.hubClients.setTrace(TraceContext{}, false)
+				}
+				var result []reflect.Value
+				result, filterErr = sl.invokeHubMethod(ctx, hub, invocation, method, in)
+				return result
+			})
+			switch {
+			case !ok:
+				cancel()
+			case filterErr != nil:
+				// A filter rejected the invocation
+				cancel()
+				if invocation.InvocationID != "" {
+					sl.complete(invocation, nil, filterErr)
+				}
+			default:
+				sl.returnInvocationResult(invocation, result, cancel)
+			}
+		})
+		if !dispatched {
+			cancel()
+		}
 	}
 }
 
-func (sl *serverLoop) returnInvocationResult(invocation invocationMessage, result []reflect.Value) {
+func (sl *serverLoop) returnInvocationResult(invocation invocationMessage, result []reflect.Value, cancel context.CancelFunc) {
 	// No invocation id, no completion
-	if invocation.InvocationID != "" {
+	if invocation.InvocationID == "" {
+		cancel()
+	} else {
+		// if the hub method returns a Future, it should be considered asynchronous.
 		// if the hub method returns a chan, it should be considered asynchronous or source for a stream
-		if len(result) == 1 && result[0].Kind() == reflect.Chan {
+		if len(result) == 1 && result[0].Type() == reflect.TypeOf(&Future{}) {
+			sl.goSafe(func() {
+				defer cancel()
+				sl.awaitFuture(invocation, result[0].Interface().(*Future))
+			})
+		} else if len(result) == 1 && result[0].Kind() == reflect.Chan {
 			switch invocation.Type {
 			// Simple invocation
 			case 1:
-				go func() {
+				sl.goSafe(func() {
+					defer cancel()
 					// Recv might block, so run continue in a goroutine
 					if chanResult, ok := result[0].Recv(); ok {
 						sl.invokeConnection(invocation, completion, []reflect.Value{chanResult})
 					} else {
-						sendMessageAndLog(func() (interface{}, error) {
-							return sl.hubConn.Completion(invocation.InvocationID, nil, "hub func returned closed chan")
-						}, sl.info)
+						sl.complete(invocation, nil, errors.New("hub func returned closed chan"))
 					}
-				}()
+				})
 			// StreamInvocation
 			case 4:
-				sl.streamer.Start(invocation.InvocationID, result[0])
+				sl.streamer.Start(invocation.InvocationID, result[0], cancel)
 			}
 		} else {
+			defer cancel()
 			switch invocation.Type {
 			// Simple invocation
 			case 1:
@@ -172,22 +421,40 @@ func (sl *serverLoop) returnInvocationResult(invocation invocationMessage, resul
 				// Stream invocation of method with no stream result.
 				// Return a single StreamItem and an empty Completion
 				sl.invokeConnection(invocation, streamItem, result)
-				sendMessageAndLog(func() (interface{}, error) {
-					return sl.hubConn.Completion(invocation.InvocationID, nil, "")
-				}, sl.info)
+				sl.complete(invocation, nil, nil)
 			}
 		}
 	}
 }
 
+func (sl *serverLoop) awaitFuture(invocation invocationMessage, future *Future) {
+	if future == nil {
+		sl.complete(invocation, nil, errors.New("hub func returned nil Future"))
+		return
+	}
+	value, err := future.Result()
+	if err != nil {
+		sl.complete(invocation, nil, err)
+		return
+	}
+	result := []reflect.Value{reflect.ValueOf(&value).Elem()}
+	switch invocation.Type {
+	// Simple invocation
+	case 1:
+		sl.invokeConnection(invocation, completion, result)
+	// StreamInvocation, return a single StreamItem and an empty Completion
+	case 4:
+		sl.invokeConnection(invocation, streamItem, result)
+		sl.complete(invocation, nil, nil)
+	}
+}
+
 func (sl *serverLoop) handleStreamItemMessage(streamItemMessage streamItemMessage) error {
 	_ = sl.dbg.Log(evt, msgRecv, msg, fmtMsg(streamItemMessage))
 	if err := sl.streamClient.receiveStreamItem(streamItemMessage); err != nil {
 		switch t := err.(type) {
 		case *hubChanTimeoutError:
-			sendMessageAndLog(func() (interface{}, error) {
-				return sl.hubConn.Completion(streamItemMessage.InvocationID, nil, t.Error())
-			}, sl.info)
+			sl.complete(invocationMessage{InvocationID: streamItemMessage.InvocationID}, nil, t)
 		default:
 			_ = sl.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(streamItemMessage), react, "close connection")
 			return err
@@ -198,6 +465,17 @@ func (sl *serverLoop) handleStreamItemMessage(streamItemMessage streamItemMessag
 
 func (sl *serverLoop) handleCompletionMessage(message completionMessage) error {
 	_ = sl.dbg.Log(evt, msgRecv, msg, fmtMsg(message))
+	if !sl.streamClient.isUpstream(message.InvocationID) && sl.server.lifetimeManager.Acknowledge(message.InvocationID, message.Error) {
+		// The client acknowledges a SendWithAck
+		return nil
+	}
+	if sl.server.outbox != nil && !sl.streamClient.isUpstream(message.InvocationID) {
+		// The client acknowledges a durable send
+		if err := sl.server.outbox.Ack(sl.hubConn.ConnectionID(), message.InvocationID); err != nil {
+			_ = sl.info.Log(evt, "outbox ack", "error", err, msg, fmtMsg(message), react, "resend later")
+		}
+		return nil
+	}
 	var err error
 	if err = sl.streamClient.receiveCompletionItem(message); err != nil {
 		_ = sl.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(message), react, "close connection")
@@ -205,6 +483,27 @@ func (sl *serverLoop) handleCompletionMessage(message completionMessage) error {
 	return err
 }
 
+// handleRepeatedHandshake handles a handshake sent after the connection has started as the RepeatedHandshakePolicy
+// of the server says. The protocol of the connection is never switched.
+func (sl *serverLoop) handleRepeatedHandshake(request handshakeRequest) error {
+	_ = sl.dbg.Log(evt, msgRecv, msg, fmtMsg(request))
+	switch sl.server.repeatedHandshakePolicy {
+	case RepeatedHandshakeIgnore:
+		_ = sl.info.Log(evt, msgRecv, "error", "repeated handshake", msg, fmtMsg(request), react, "ignore")
+		return nil
+	case RepeatedHandshakeError:
+		_ = sl.info.Log(evt, msgRecv, "error", "repeated handshake", msg, fmtMsg(request), react, "send handshake response with error")
+		sendMessageAndLog(func() (interface{}, error) {
+			return request, sl.hubConn.HandshakeResponse("handshake has already been completed")
+		}, sl.info)
+		return nil
+	default:
+		err := errors.New("handshake repeated after the connection has been started")
+		_ = sl.info.Log(evt, msgRecv, "error", err, msg, fmtMsg(request), react, "close connection")
+		return err
+	}
+}
+
 func (sl *serverLoop) handleOtherMessage(hubMessage hubMessage) error {
 	_ = sl.dbg.Log(evt, msgRecv, msg, fmtMsg(hubMessage))
 	// Not Ping
@@ -221,13 +520,12 @@ func (sl *serverLoop) recoverInvocationPanic(invocation invocationMessage) {
 		_ = sl.info.Log(evt, "panic in hub method", "error", err, "name", invocation.Target, react, "send completion with error")
 		stack := string(debug.Stack())
 		_ = sl.dbg.Log(evt, "panic in hub method", "error", err, "name", invocation.Target, react, "send completion with error", "stack", stack)
+		sl.notifyError(&PanicError{ConnectionID: sl.hubConn.ConnectionID(), Value: err, Stack: []byte(stack)})
 		if invocation.InvocationID != "" {
 			if !sl.server.enableDetailedErrors {
 				stack = ""
 			}
-			sendMessageAndLog(func() (interface{}, error) {
-				return sl.hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("%v\n%v", err, stack))
-			}, sl.info)
+			sl.complete(invocation, nil, fmt.Errorf("%v\n%v", err, stack))
 		}
 	}
 }
@@ -236,19 +534,119 @@ func (sl *serverLoop) recoverHubLifeCyclePanic() {
 	if err := recover(); err != nil {
 		sl.allowReconnect = false
 		_ = sl.info.Log(evt, "panic in hub lifecycle", "error", err, react, "close connection, allow no reconnect")
-		_ = sl.dbg.Log(evt, "panic in hub lifecycle", "error", err, react, "close connection, allow no reconnect", "stack", string(debug.Stack()))
+		stack := debug.Stack()
+		_ = sl.dbg.Log(evt, "panic in hub lifecycle", "error", err, react, "close connection, allow no reconnect", "stack", string(stack))
+		sl.notifyError(&PanicError{ConnectionID: sl.hubConn.ConnectionID(), Value: err, Stack: stack})
 		sl.hubConn.Abort()
 	}
 }
 
-func buildMethodArguments(method reflect.Value, invocation invocationMessage,
-	streamClient *streamClient, protocol HubProtocol) (arguments []reflect.Value, clientStreaming bool, err error) {
+// getHub returns the hub of the connection if the server uses HubPerConnection, else a new hub from the hub factory
+func (sl *serverLoop) getHub() HubInterface {
+	if sl.hub != nil {
+		return sl.hub
+	}
+	return sl.server.getHub(sl.hubConn)
+}
+
+// dispatchLifeCycle runs the hub lifecycle event f in a new goroutine,
+// or in the sequence of the connection if the server uses HubPerConnection
+func (sl *serverLoop) dispatchLifeCycle(f func()) {
+	run := func() {
+		defer sl.recoverHubLifeCyclePanic()
+		f()
+	}
+	if sl.sequence != nil {
+		sl.sequence <- run
+	} else {
+		go run()
+	}
+}
+
+// runSequence runs the tasks of the sequence one after another until the sequence is closed
+func (sl *serverLoop) runSequence() {
+	for task := range sl.sequence {
+		task()
+	}
+}
+
+// goSafe runs f in a new goroutine. A panic in f is reported and aborts the connection
+func (sl *serverLoop) goSafe(f func()) {
+	goSafe(sl.hubConn.ConnectionID(), f, sl.abortOnPanic)
+}
+
+// dispatchInvocation runs f on the invocation workers of the server or, if there are none, in a new goroutine.
+// If the workers reject the invocation, it is completed with the error and dispatchInvocation returns false
+func (sl *serverLoop) dispatchInvocation(invocation invocationMessage, f func()) bool {
+	sl.hubConn.AddPendingInvocations(1)
+	g := f
+	f = func() {
+		defer sl.hubConn.AddPendingInvocations(-1)
+		g()
+	}
+	if sl.sequence != nil {
+		connectionID := sl.hubConn.ConnectionID()
+		sl.sequence <- func() {
+			runSafe(connectionID, f, sl.abortOnPanic)
+		}
+		return true
+	}
+	if sl.server.scheduler == nil {
+		sl.goSafe(f)
+		return true
+	}
+	connectionID := sl.hubConn.ConnectionID()
+	err := sl.server.scheduler.schedule(connectionID, func() {
+		runSafe(connectionID, f, sl.abortOnPanic)
+	}, func() {
+		sl.hubConn.AddPendingInvocations(-1)
+	})
+	if err != nil {
+		sl.hubConn.AddPendingInvocations(-1)
+		sl.server.statsD.count("invocations.shed", 1, sl.server.statsDTarget(invocation.Target))
+		_ = sl.info.Log(evt, "schedule invocation", "error", err, "name", invocation.Target, react, "send completion with error")
+		if invocation.InvocationID != "" {
+			sl.complete(invocation, nil, err)
+		}
+		return false
+	}
+	return true
+}
+
+func (sl *serverLoop) abortOnPanic(err *PanicError) {
+	sl.reportPanic(err)
+	sl.hubConn.AbortWithError(err)
+}
+
+func (sl *serverLoop) reportPanic(err *PanicError) {
+	_ = sl.info.Log(evt, "panic in goroutine", "error", err.Value, react, "close connection")
+	_ = sl.dbg.Log(evt, "panic in goroutine", "error", err.Value, react, "close connection", "stack", string(err.Stack))
+	sl.notifyError(err)
+}
+
+func (sl *serverLoop) notifyError(err error) {
+	sl.server.statsD.count("errors", 1)
+	if sl.server.onError != nil {
+		sl.server.onError(err)
+	}
+}
+
+func buildMethodArguments(ctx context.Context, method reflect.Value, invocation invocationMessage,
+	streamClient *streamClient, protocol HubProtocol, conn hubConnection) (arguments []reflect.Value, clientStreaming bool, err error) {
 	arguments = make([]reflect.Value, method.Type().NumIn())
 	chanCount := 0
+	injectedCount := 0
 	for i := 0; i < method.Type().NumIn(); i++ {
 		t := method.Type().In(i)
-		// Is it a channel for client streaming?
-		if arg, clientStreaming, err := streamClient.buildChannelArgument(invocation, t, chanCount); err != nil {
+		// Does the method want to report progress?
+		if t == reflect.TypeOf(&Progress{}) {
+			injectedCount++
+			arguments[i] = reflect.ValueOf(&Progress{conn: conn, invocationID: invocation.InvocationID})
+		} else if t == reflect.TypeOf((*context.Context)(nil)).Elem() {
+			// or does it want to know when the invocation ends?
+			injectedCount++
+			arguments[i] = reflect.ValueOf(ctx)
+		} else if arg, clientStreaming, err := streamClient.buildChannelArgument(invocation, t, chanCount); err != nil {
 			// it is, but channel count in invocation and method mismatch
 			return nil, false, err
 		} else if clientStreaming {
@@ -258,7 +656,7 @@ func buildMethodArguments(method reflect.Value, invocation invocationMessage,
 		} else {
 			// it is not, so do the normal thing
 			arg := reflect.New(t)
-			if err := protocol.UnmarshalArgument(invocation.Arguments[i-chanCount], arg.Interface()); err != nil {
+			if err := protocol.UnmarshalArgument(invocation.Arguments[i-chanCount-injectedCount], arg.Interface()); err != nil {
 				return arguments, chanCount > 0, err
 			}
 			arguments[i] = arg.Elem()
@@ -270,12 +668,14 @@ func buildMethodArguments(method reflect.Value, invocation invocationMessage,
 	return arguments, chanCount > 0, nil
 }
 
+// getMethod returns the hub method with the case insensitive name.
+// Methods of Hub, HubInterface and InvocationHandler can not be invoked by clients
 func getMethod(hub HubInterface, name string) (reflect.Value, bool) {
 	hubType := reflect.TypeOf(hub)
 	hubValue := reflect.ValueOf(hub)
 	name = strings.ToLower(name)
 	for i := 0; i < hubType.NumMethod(); i++ {
-		if m := hubType.Method(i); strings.ToLower(m.Name) == name {
+		if m := hubType.Method(i); strings.ToLower(m.Name) == name && !isBaseHubMethod(m) {
 			return hubValue.Method(i), true
 		}
 	}
@@ -289,9 +689,7 @@ func (sl *serverLoop) invokeConnection(invocation invocationMessage, connFunc co
 	}
 	switch len(result) {
 	case 0:
-		sendMessageAndLog(func() (interface{}, error) {
-			return sl.hubConn.Completion(invocation.InvocationID, nil, "")
-		}, sl.info)
+		sl.complete(invocation, nil, nil)
 	case 1:
 		connFunc(sl, invocation, values[0])
 	default:
@@ -302,9 +700,7 @@ func (sl *serverLoop) invokeConnection(invocation invocationMessage, connFunc co
 type connFunc func(sl *serverLoop, invocation invocationMessage, value interface{})
 
 func completion(sl *serverLoop, invocation invocationMessage, value interface{}) {
-	sendMessageAndLog(func() (interface{}, error) {
-		return sl.hubConn.Completion(invocation.InvocationID, value, "")
-	}, sl.info)
+	sl.complete(invocation, value, nil)
 }
 
 func streamItem(sl *serverLoop, invocation invocationMessage, value interface{}) {
@@ -2,7 +2,10 @@ package signalr
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -10,6 +13,7 @@ import (
 func UseHub(hub HubInterface) func(*Server) error {
 	return func(s *Server) error {
 		s.newHub = func() HubInterface { return hub }
+		s.sharedHub = true
 		return nil
 	}
 }
@@ -21,6 +25,7 @@ func UseHub(hub HubInterface) func(*Server) error {
 func HubFactory(factoryFunc func() HubInterface) func(*Server) error {
 	return func(s *Server) error {
 		s.newHub = factoryFunc
+		s.sharedHub = false
 		return nil
 	}
 }
@@ -34,6 +39,39 @@ func SimpleHubFactory(hubProto HubInterface) func(*Server) error {
 		})
 }
 
+// HubConstructor sets a HubFactory which calls constructor on each hub method invocation.
+// constructor must be a func which returns a HubInterface, e.g. func(repo Repository, log Logger) *ChatHub.
+// Each parameter of constructor is filled with the first of deps which is assignable to the parameter type.
+// DI frameworks like uber/fx or google/wire can provide deps by injecting them into the func
+// which creates the server.
+func HubConstructor(constructor interface{}, deps ...interface{}) func(*Server) error {
+	return func(s *Server) error {
+		ctor := reflect.ValueOf(constructor)
+		if ctor.Kind() != reflect.Func {
+			return fmt.Errorf("HubConstructor: %T is not a func", constructor)
+		}
+		ctorType := ctor.Type()
+		if ctorType.NumOut() != 1 || !ctorType.Out(0).Implements(reflect.TypeOf((*HubInterface)(nil)).Elem()) {
+			return fmt.Errorf("HubConstructor: %v does not return a HubInterface", ctorType)
+		}
+		in := make([]reflect.Value, ctorType.NumIn())
+	params:
+		for i := range in {
+			for _, dep := range deps {
+				if dep != nil && reflect.TypeOf(dep).AssignableTo(ctorType.In(i)) {
+					in[i] = reflect.ValueOf(dep)
+					continue params
+				}
+			}
+			return fmt.Errorf("HubConstructor: no dependency for parameter %v of type %v", i, ctorType.In(i))
+		}
+		s.newHub = func() HubInterface {
+			return ctor.Call(in)[0].Interface().(HubInterface)
+		}
+		return nil
+	}
+}
+
 // ClientTimeoutInterval is the interval the server will consider the client disconnected
 // if it hasn't received a message (including keep-alive) in it.
 // The recommended value is double the KeepAliveInterval value.
@@ -45,6 +83,18 @@ func ClientTimeoutInterval(timeout time.Duration) func(*Server) error {
 	}
 }
 
+// UseClock sets the Clock used for the handshake timeout, the keep alive interval, the client timeout
+// and the timeout for hub methods receiving client streams. Tests can pass a ManualClock.
+func UseClock(clock Clock) func(*Server) error {
+	return func(s *Server) error {
+		if clock == nil {
+			return errors.New("UseClock needs a Clock")
+		}
+		s.clock = clock
+		return nil
+	}
+}
+
 // HandshakeTimeout is the interval if the client doesn't send an initial handshake message within,
 // the connection is closed. This is an advanced setting that should only be modified
 // if handshake timeout errors are occurring due to severe network latency.
@@ -57,8 +107,57 @@ func HandshakeTimeout(timeout time.Duration) func(*Server) error {
 	}
 }
 
+// HandshakeValidatorFunc is called with the connection and the protocol and version requested by the client in the handshake.
+// If it returns an error, the handshake is answered with the error message and the connection is not started.
+type HandshakeValidatorFunc func(conn Connection, protocol string, version int) error
+
+// HandshakeValidator sets a HandshakeValidatorFunc which can reject connections based on the handshake request,
+// e.g. to phase out old protocol versions.
+func HandshakeValidator(validator HandshakeValidatorFunc) func(*Server) error {
+	return func(s *Server) error {
+		s.handshakeValidator = validator
+		return nil
+	}
+}
+
+// RepeatedHandshakePolicy tells the server how to handle a client which sends another handshake
+// after its connection has been started. The protocol of a connection is never switched.
+type RepeatedHandshakePolicy int
+
+const (
+	// RepeatedHandshakeClose closes the connection with an error, like the ASP.NET Core server does. It is the default
+	RepeatedHandshakeClose RepeatedHandshakePolicy = iota
+	// RepeatedHandshakeIgnore ignores the handshake and keeps the connection
+	RepeatedHandshakeIgnore
+	// RepeatedHandshakeError answers the handshake with an error handshake response and keeps the connection
+	RepeatedHandshakeError
+)
+
+// RepeatedHandshake sets the RepeatedHandshakePolicy of the server
+func RepeatedHandshake(policy RepeatedHandshakePolicy) func(*Server) error {
+	return func(s *Server) error {
+		if policy < RepeatedHandshakeClose || policy > RepeatedHandshakeError {
+			return fmt.Errorf("invalid RepeatedHandshakePolicy %v", policy)
+		}
+		s.repeatedHandshakePolicy = policy
+		return nil
+	}
+}
+
+// Features sets optional capabilities like "compression", "clientResults" or "statefulReconnect" which the server offers.
+// They are advertised in the negotiate response. Clients request the ones they support with a "features" array
+// in the handshake request and the server answers with the accepted ones in the handshake response.
+// Hubs can check the features of their connection with Hub.Features(), so older clients can be served without them.
+func Features(names ...string) func(*Server) error {
+	return func(s *Server) error {
+		s.features = names
+		return nil
+	}
+}
+
 // KeepAliveInterval is the interval if the server hasn't sent a message within,
 // a ping message is sent automatically to keep the connection open.
+// No pings are sent while stream items are flowing to the client, as they keep the connection open as well.
 // When changing KeepAliveInterval, change the ServerTimeout/serverTimeoutInMilliseconds setting on the client.
 // The recommended ServerTimeout/serverTimeoutInMilliseconds value is double the KeepAliveInterval value.
 // Default is 15 seconds.
@@ -69,6 +168,17 @@ func KeepAliveInterval(timeout time.Duration) func(*Server) error {
 	}
 }
 
+// PingTimestamps - if true, keep-alive pings carry the server time in milliseconds since the epoch.
+// The time until the next client message arrives is used as round trip time estimate of the connection,
+// see Server.ConnectionStats.
+// Default is false.
+func PingTimestamps(enable bool) func(*Server) error {
+	return func(s *Server) error {
+		s.pingTimestamps = enable
+		return nil
+	}
+}
+
 // EnableDetailedErrors - if true, detailed exception messages are returned to clients when an exception is thrown in a Hub method.
 // The default is false, as these exception messages can contain sensitive information.
 func EnableDetailedErrors(enable bool) func(*Server) error {
@@ -92,6 +202,8 @@ func StreamBufferCapacity(capacity uint) func(*Server) error {
 }
 
 // MaximumReceiveMessageSize is the maximum size of a single incoming hub message.
+// Messages sent in more than one WebSocket frame are reassembled up to this size.
+// WebSocket frames which are larger are rejected before they are read.
 // Default is 32KB
 func MaximumReceiveMessageSize(size uint) func(*Server) error {
 	return func(s *Server) error {
@@ -114,6 +226,492 @@ func HubChanReceiveTimeout(timeout time.Duration) func(*Server) error {
 	}
 }
 
+// Protocols restricts the hub protocols a client can request in the handshake to the named ones.
+// Supported names are "json", "messagepack" and the names of protocols added with CustomProtocol before.
+// Default is all supported protocols.
+func Protocols(names ...string) func(*Server) error {
+	return func(s *Server) error {
+		if len(names) == 0 {
+			return errors.New("at least one protocol must be given")
+		}
+		pm := make(map[string]HubProtocol)
+		for _, name := range names {
+			protocol, ok := s.protocolMap[name]
+			if !ok {
+				return fmt.Errorf("protocol %v not supported", name)
+			}
+			pm[name] = protocol
+		}
+		s.protocolMap = pm
+		return nil
+	}
+}
+
+// CustomProtocol adds protocol with the given name to the protocols a client can request in the handshake.
+// Each connection uses the protocol requested by its client, so one server can serve clients of different protocols.
+func CustomProtocol(name string, protocol HubProtocol) func(*Server) error {
+	return func(s *Server) error {
+		if name == "" || protocol == nil || reflect.ValueOf(protocol).Kind() != reflect.Ptr {
+			return errors.New("CustomProtocol needs a name and a protocol which is a pointer")
+		}
+		pm := make(map[string]HubProtocol, len(s.protocolMap)+1)
+		for n, p := range s.protocolMap {
+			pm[n] = p
+		}
+		pm[name] = protocol
+		s.protocolMap = pm
+		return nil
+	}
+}
+
+// GroupJoinAuthorizer sets a GroupJoinAuthorizerFunc which is called before any connection is added to a group,
+// whether AddToGroup is called from hub code or from the GroupManager of the server.
+func GroupJoinAuthorizer(authorizer GroupJoinAuthorizerFunc) func(*Server) error {
+	return func(s *Server) error {
+		s.groupJoinAuthorizer = authorizer
+		return nil
+	}
+}
+
+// GroupMembershipChanged sets a function which is called each time a connection is added to or removed from a group.
+// When a connection disconnects, it is removed from all its groups and the function is called for each of them.
+func GroupMembershipChanged(handler func(event GroupMembershipEvent)) func(*Server) error {
+	return func(s *Server) error {
+		s.groupMembershipChanged = handler
+		return nil
+	}
+}
+
+// GroupExpiration sets the function which returns the GroupExpirationPolicy of each new group.
+// When a group expires, all its members are removed with GroupMemberExpired events and expired is called, if not nil.
+// Adding a member to an expired group creates a new group.
+func GroupExpiration(policy GroupExpirationFunc, expired func(groupName string)) func(*Server) error {
+	return func(s *Server) error {
+		if policy == nil {
+			return errors.New("GroupExpiration needs a GroupExpirationFunc")
+		}
+		s.groupExpiration = policy
+		s.groupExpired = expired
+		return nil
+	}
+}
+
+// MessageInterceptors adds MessageInterceptors which see every inbound and outbound hub message.
+// The interceptors are called in the given order.
+func MessageInterceptors(interceptors ...MessageInterceptor) func(*Server) error {
+	return func(s *Server) error {
+		s.messageInterceptors = append(s.messageInterceptors, interceptors...)
+		return nil
+	}
+}
+
+// FrameInspectors adds FrameInspectors which see the raw data read from and written to each connection.
+// The inspectors are called in the given order for inbound and outbound data.
+func FrameInspectors(inspectors ...FrameInspector) func(*Server) error {
+	return func(s *Server) error {
+		s.frameInspectors = append(s.frameInspectors, inspectors...)
+		return nil
+	}
+}
+
+// InvocationTransformerFunc transforms the arguments of an invocation before it is sent to a single connection.
+// items are the items of the receiving connection, so the arguments can be tailored per recipient,
+// e.g. localized or stripped of fields the recipient is not allowed to see.
+// args are shared by all recipients, so the transformer must return a new slice instead of modifying args.
+type InvocationTransformerFunc func(connectionID string, items *sync.Map, args []interface{}) []interface{}
+
+// InvocationTransformer registers an InvocationTransformerFunc for invocations of target on the clients.
+// If more than one transformer is registered for a target, they are applied in the order of registration.
+func InvocationTransformer(target string, transformer InvocationTransformerFunc) func(*Server) error {
+	return func(s *Server) error {
+		if transformer == nil {
+			return errors.New("InvocationTransformer must not be nil")
+		}
+		if s.invocationTransformers == nil {
+			s.invocationTransformers = make(map[string][]InvocationTransformerFunc)
+		}
+		s.invocationTransformers[target] = append(s.invocationTransformers[target], transformer)
+		return nil
+	}
+}
+
+// BroadcastThrottle limits broadcasts of target to all connections or to a group to one per interval,
+// e.g. 100ms for at most 10 messages per second per group. Broadcasts sent faster are not queued:
+// only the latest one is sent at the end of the interval, so clients always get the latest value.
+// Use it for high-frequency sources like telemetry, whose producers send faster than clients can render.
+func BroadcastThrottle(target string, interval time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("BroadcastThrottle needs interval > 0")
+		}
+		if s.broadcastThrottles == nil {
+			s.broadcastThrottles = make(map[string]time.Duration)
+		}
+		s.broadcastThrottles[target] = interval
+		return nil
+	}
+}
+
+// GroupSendBatching collects the sends to a group during window, e.g. 5ms, and sends them to each member
+// of the group with one write to its transport. This smoothes CPU spikes of bursty producers, but delays each group send
+// by up to window. The members of the group at the end of the window get the batch. Sends of targets which have
+// an InvocationTransformer or are encrypted with EncryptPayloads are not batched.
+func GroupSendBatching(window time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if window <= 0 {
+			return errors.New("GroupSendBatching needs window > 0")
+		}
+		s.groupSendBatchWindow = window
+		return nil
+	}
+}
+
+// ArgumentLimits limits the invocations of the hub method target to maxCount arguments, streams from the client included,
+// and to maxSize bytes of serialized arguments. Invocations exceeding a limit are not dispatched, the client gets
+// a completion with an error instead. Use it to protect hub methods whose arguments decode into expensive structures.
+// A limit of 0 is not checked.
+func ArgumentLimits(target string, maxCount int, maxSize int) func(*Server) error {
+	return func(s *Server) error {
+		if maxCount < 0 || maxSize < 0 {
+			return errors.New("ArgumentLimits must not be negative")
+		}
+		if s.argumentLimits == nil {
+			s.argumentLimits = make(map[string]argumentLimits)
+		}
+		s.argumentLimits[strings.ToLower(target)] = argumentLimits{maxCount: maxCount, maxSize: maxSize}
+		return nil
+	}
+}
+
+// UseResumeStore sets the ResumeStore used to keep the state of disconnected connections.
+// The server issues a secret resume token for each connection and sends it as "resumeToken" in the handshake response.
+// When a client reconnects and sends the token as "resumeToken" in its handshake request, the connection is rejoined
+// to its previous groups and its items are restored, before OnConnected is called. Each token can be used once.
+// The Client of this package sends the token when it reconnects.
+func UseResumeStore(store ResumeStore) func(*Server) error {
+	return func(s *Server) error {
+		s.resumeStore = store
+		return nil
+	}
+}
+
+// UnsentQueueGracePeriod keeps the messages which were queued for a connection but not written when its
+// transport was lost for gracePeriod. The server issues a secret resume token for each connection and sends it
+// as "resumeToken" in the handshake response. When the client reconnects within gracePeriod and sends the token
+// as "resumeToken" in its handshake request, the kept messages are sent to it before any new messages.
+// Messages are only sent to a connection of the same user with the same protocol.
+// The Client of this package sends the token when it reconnects. The default 0 drops the unsent messages.
+func UnsentQueueGracePeriod(gracePeriod time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if gracePeriod < 0 {
+			return errors.New("UnsentQueueGracePeriod must not be negative")
+		}
+		s.unsentQueueGracePeriod = gracePeriod
+		return nil
+	}
+}
+
+// BulkSendParallelism sets the number of connections HubClients.SendToConnections sends to in parallel.
+// A slow connection only holds up one of them. Default is 16
+func BulkSendParallelism(parallelism int) func(*Server) error {
+	return func(s *Server) error {
+		if parallelism < 1 {
+			return errors.New("BulkSendParallelism must be at least 1")
+		}
+		s.bulkSendParallelism = parallelism
+		return nil
+	}
+}
+
+// GroupReplay sets the GroupReplayBuffer which retains the messages sent to groups.
+// Connections which join a group get the retained messages of the group replayed,
+// e.g. to give dashboards joining a telemetry group the recent context.
+func GroupReplay(buffer GroupReplayBuffer) func(*Server) error {
+	return func(s *Server) error {
+		s.groupReplayBuffer = buffer
+		return nil
+	}
+}
+
+// UseOutbox sets the Outbox which stores durable sends, see ClientProxy.SendDurable.
+// Unacknowledged invocations are resent when the client reconnects with the same connection id
+// and every retryInterval. If retryInterval is 0, they are only resent on reconnect.
+func UseOutbox(outbox Outbox, retryInterval time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		s.outbox = outbox
+		s.outboxRetryInterval = retryInterval
+		return nil
+	}
+}
+
+// AckRetry sets how invocations sent with SendWithAck are resent. The first resend happens after initialInterval,
+// the interval doubles with each resend up to maxInterval. After maxAttempts sends, the delivery fails.
+// Default is 1 second, 30 seconds and 5 attempts.
+func AckRetry(initialInterval time.Duration, maxInterval time.Duration, maxAttempts int) func(*Server) error {
+	return func(s *Server) error {
+		if initialInterval <= 0 || maxInterval < initialInterval || maxAttempts < 1 {
+			return errors.New("AckRetry needs initialInterval > 0, maxInterval >= initialInterval and maxAttempts >= 1")
+		}
+		s.ackRetry = &ackRetryPolicy{
+			initialInterval: initialInterval,
+			maxInterval:     maxInterval,
+			maxAttempts:     maxAttempts,
+		}
+		return nil
+	}
+}
+
+// InvocationWatchdog logs the stacks of all goroutines when a hub method invocation or the stream it returned
+// is still running after threshold, which helps to find deadlocks in hub methods.
+// The stuck invocations are counted by the StatsD metric watchdog.stuck.
+// If cancelStuck is true, the context of the invocation is canceled and a stream is stopped.
+func InvocationWatchdog(threshold time.Duration, cancelStuck bool) func(*Server) error {
+	return func(s *Server) error {
+		if threshold <= 0 {
+			return errors.New("InvocationWatchdog threshold must be greater than 0")
+		}
+		s.watchdogThreshold = threshold
+		s.watchdogCancel = cancelStuck
+		return nil
+	}
+}
+
+// InvocationTimeout sets the deadline of hub method invocations. Hub methods which declare a context.Context
+// parameter get a context which is canceled after timeout. If the hub method has not returned
+// gracePeriod after the deadline, the invocation is completed with an error and the method is
+// reported as stuck, see Server.StuckInvocations and OnError.
+// Default is 0, which means no timeout.
+func InvocationTimeout(timeout time.Duration, gracePeriod time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if timeout < 0 || gracePeriod < 0 {
+			return errors.New("InvocationTimeout needs timeout >= 0 and gracePeriod >= 0")
+		}
+		s.invocationTimeout = timeout
+		s.invocationGracePeriod = gracePeriod
+		return nil
+	}
+}
+
+// HubPerConnection lets the server create one hub instance per connection with the hub factory, instead of one per
+// invocation, so hubs can keep state per connection in their fields. OnConnected, the invocations and OnDisconnected
+// of a connection are run one after another on one goroutine, in the order they were received.
+// Methods with client streams still run in parallel, as they need the stream items received while they run.
+// A hub method which waits for another invocation of the same connection blocks the connection.
+// HubPerConnection can not be combined with UseHub or InvocationWorkers, NewServer returns an error then.
+func HubPerConnection() func(*Server) error {
+	return func(s *Server) error {
+		s.hubPerConnection = true
+		return nil
+	}
+}
+
+// InvocationWorkers sets the number of workers which execute the hub method invocations of all connections.
+// Pending invocations are queued per connection and the workers serve the connections round-robin,
+// so a client sending many invocations can not starve the other clients.
+// Invocations of methods with client streams are not executed by the workers.
+// The queue of each connection is bounded, see InvocationQueueLimit. The queued invocations of a connection
+// are dropped when it disconnects. The workers start with NewServer and Server.Start and end with Server.Stop.
+// Default is 0, which means each invocation is executed in its own goroutine.
+func InvocationWorkers(workers int) func(*Server) error {
+	return func(s *Server) error {
+		if workers < 0 {
+			return errors.New("InvocationWorkers needs workers >= 0")
+		}
+		s.invocationWorkers = workers
+		return nil
+	}
+}
+
+// InvocationQueueLimit sets how many invocations of a connection the InvocationWorkers queue.
+// Invocations beyond the limit are rejected with the completion error "Server busy".
+// Default is 100.
+func InvocationQueueLimit(limit int) func(*Server) error {
+	return func(s *Server) error {
+		if limit <= 0 {
+			return errors.New("InvocationQueueLimit needs a positive limit")
+		}
+		s.invocationQueueLimit = limit
+		return nil
+	}
+}
+
+// InvocationShedding rejects invocations of a connection which already has threshold pending invocations,
+// queued or running, with the completion error "Server busy", so latency stays bounded under overload instead of
+// queues growing without limit. The number of pending invocations is in the PendingInvocations of the ConnectionStats.
+// Default is 0, which sheds no invocations.
+func InvocationShedding(threshold int) func(*Server) error {
+	return func(s *Server) error {
+		if threshold < 0 {
+			return errors.New("InvocationShedding threshold must not be negative")
+		}
+		s.sheddingThreshold = threshold
+		return nil
+	}
+}
+
+// HubFilters adds HubFilters which are called around every invocation of a hub method, e.g. for logging,
+// authorization, metrics or validation without changing each hub. The first filter added is the outermost.
+func HubFilters(filters ...HubFilter) func(*Server) error {
+	return func(s *Server) error {
+		for _, filter := range filters {
+			if filter == nil {
+				return errors.New("HubFilter must not be nil")
+			}
+		}
+		s.hubFilters = append(s.hubFilters, filters...)
+		return nil
+	}
+}
+
+// CompletionShaper sets a CompletionShaperFunc which shapes the completions of all invocations before they are sent,
+// e.g. to wrap all results in an envelope with the server time and the invocation id or to map error types to error codes,
+// so all hubs of a server follow the same response contract. Completions which end stream invocations successfully
+// are not shaped.
+func CompletionShaper(shaper CompletionShaperFunc) func(*Server) error {
+	return func(s *Server) error {
+		s.completionShaper = shaper
+		return nil
+	}
+}
+
+// NegotiateRedirect sets a NegotiateRedirectFunc which can redirect negotiating clients to another server.
+func NegotiateRedirect(redirect NegotiateRedirectFunc) func(*Server) error {
+	return func(s *Server) error {
+		s.negotiateRedirectFunc = redirect
+		return nil
+	}
+}
+
+// NegotiateMetadata sets a NegotiateMetadataFunc which adds fields to the negotiate response.
+// Fields of the protocol, e.g. connectionId or url, can not be replaced.
+func NegotiateMetadata(metadata NegotiateMetadataFunc) func(*Server) error {
+	return func(s *Server) error {
+		s.negotiateMetadataFunc = metadata
+		return nil
+	}
+}
+
+// StatsDMetrics sends the metrics of the server with emitter. The gauges are sent every interval.
+func StatsDMetrics(emitter *StatsDEmitter, interval time.Duration) func(*Server) error {
+	return func(s *Server) error {
+		if emitter == nil || interval <= 0 {
+			return errors.New("StatsDMetrics needs an emitter and interval > 0")
+		}
+		s.statsD = emitter
+		s.statsDInterval = interval
+		return nil
+	}
+}
+
+// EncryptPayloads encrypts the arguments of invocations of the targets with cipher. Targets are the names of client
+// methods, whose invocations are encrypted, and of hub methods, whose invocations are decrypted before the hub
+// method is called. If no targets are given, all invocations are encrypted.
+func EncryptPayloads(cipher PayloadCipher, targets ...string) func(*Server) error {
+	return func(s *Server) error {
+		if cipher == nil {
+			return errors.New("EncryptPayloads needs a PayloadCipher")
+		}
+		s.payloadEncryption = &payloadEncryption{cipher: cipher, targets: make(map[string]bool)}
+		for _, target := range targets {
+			s.payloadEncryption.targets[strings.ToLower(target)] = true
+		}
+		return nil
+	}
+}
+
+// CacheResults caches the results of the hub methods named by targets for ttl. Invocations of these methods with
+// the same arguments are answered from the cache, on all connections, without calling the hub method.
+// The cache is consulted after the HubFilters, with the arguments they pass on, so filters still reject invocations.
+// Use it only for idempotent read-style methods whose result does not depend on the caller.
+// Results returned as Future or chan are not cached. Server.InvalidateResults removes cached results.
+func CacheResults(ttl time.Duration, targets ...string) func(*Server) error {
+	return func(s *Server) error {
+		if ttl <= 0 {
+			return errors.New("CacheResults needs a positive ttl")
+		}
+		if len(targets) == 0 {
+			return errors.New("CacheResults needs at least one target")
+		}
+		s.resultCache = &resultCache{ttl: ttl, targets: make(map[string]bool), entries: make(map[string]cachedResult)}
+		for _, target := range targets {
+			s.resultCache.targets[strings.ToLower(target)] = true
+		}
+		return nil
+	}
+}
+
+// SignMessages signs the invocations, stream items and completions sent to the clients with signer and rejects
+// invocations from the clients which have no valid signature by verifier. Each of signer and verifier might be nil.
+func SignMessages(signer MessageSigner, verifier MessageVerifier) func(*Server) error {
+	return func(s *Server) error {
+		if signer == nil && verifier == nil {
+			return errors.New("SignMessages needs a MessageSigner or a MessageVerifier")
+		}
+		s.messageSigner = signer
+		s.messageVerifier = verifier
+		return nil
+	}
+}
+
+// TrustedProxies sets the networks of the proxies in front of the server, e.g. "10.0.0.0/8".
+// If a request comes from a trusted proxy, the client address is taken from the Forwarded or X-Forwarded-For header.
+// Default is no trusted proxies, so these headers are ignored.
+func TrustedProxies(cidrs ...string) func(*Server) error {
+	return func(s *Server) error {
+		networks, err := parseCIDRs(cidrs)
+		if err != nil {
+			return err
+		}
+		s.trustedProxies = append(s.trustedProxies, networks...)
+		return nil
+	}
+}
+
+// UseIPFilter sets an IPFilter which is checked before the handshake.
+// Connections of rejected clients are not started. Their connection should implement ConnectionInfo.
+func UseIPFilter(filter *IPFilter) func(*Server) error {
+	return func(s *Server) error {
+		s.ipFilter = filter
+		return nil
+	}
+}
+
+// UserIDProvider sets the function which determines the user of a connection when the connection is started.
+// If the connection has been established by an http request, it implements HTTPConnection
+// and the user can be taken from the request. Hubs send to all connections of a user with Clients().User(userID).
+func UserIDProvider(provider func(conn Connection) string) func(*Server) error {
+	return func(s *Server) error {
+		s.userIDProvider = provider
+		return nil
+	}
+}
+
+// UseHubLifetimeManager replaces the HubLifetimeManager which routes the messages of the server, e.g. to scale out
+// over several servers with a backplane. factory gets the in-process HubLifetimeManager of the server as local,
+// which delivers messages to the connections of this server. A backplane manager typically publishes the invocations
+// to the other servers and passes them, and the invocations received from the other servers, to local.
+// The manager must pass OnConnected and OnDisconnected to local, otherwise the server does not know its connections
+// and features like Ping, Drain, AllConnectionStats and the admin hub do not see them.
+func UseHubLifetimeManager(factory func(local HubLifetimeManager) HubLifetimeManager) func(*Server) error {
+	return func(s *Server) error {
+		if factory == nil {
+			return errors.New("UseHubLifetimeManager needs a factory")
+		}
+		s.lifetimeManagerFactory = factory
+		return nil
+	}
+}
+
+// OnError sets the handler which is called when a goroutine of the server panics.
+// The handler gets a *PanicError. If the panic happened outside of a hub method, the connection is closed.
+// Panics in hub methods are also passed to the handler and are sent as error completion to the client, as before.
+func OnError(handler func(err error)) func(*Server) error {
+	return func(s *Server) error {
+		s.onError = handler
+		return nil
+	}
+}
+
 // StructuredLogger is the simplest logging interface for structured logging.
 // See github.com/go-kit/kit/log
 type StructuredLogger interface {
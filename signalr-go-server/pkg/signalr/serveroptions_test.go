@@ -2,12 +2,17 @@ package signalr
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-kit/kit/log"
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,6 +40,102 @@ func (s *singleHub) initUUID() {
 
 var singleHubMsg = make(chan string, 100)
 
+type groupHub struct {
+	Hub
+}
+
+func (g *groupHub) OnConnected(connectionID string) {
+	groupHubOnConnectMsg <- connectionID
+}
+
+func (g *groupHub) SetAdmin() {
+	g.Items().Store("admin", true)
+}
+
+func (g *groupHub) IsAdmin() bool {
+	_, ok := g.Items().Load("admin")
+	return ok
+}
+
+func (g *groupHub) SendCommand() {
+	_ = g.Clients().Caller().SendDurable("command", 1)
+}
+
+func (g *groupHub) NotifyUser(userID string) {
+	g.Clients().User(userID).Send("notified", userID)
+}
+
+var groupHubOnConnectMsg = make(chan string, 10)
+
+type greeter interface {
+	Greet(name string) string
+}
+
+type politeGreeter struct{}
+
+func (p *politeGreeter) Greet(name string) string {
+	return "Hello " + name
+}
+
+type injectedHub struct {
+	Hub
+	greeter greeter
+}
+
+func newInjectedHub(greeter greeter) *injectedHub {
+	return &injectedHub{greeter: greeter}
+}
+
+func (i *injectedHub) Greet(name string) string {
+	return i.greeter.Greet(name)
+}
+
+type timeoutHub struct {
+	Hub
+}
+
+var timeoutHubRelease = make(chan struct{})
+
+func (t *timeoutHub) Cooperative(ctx context.Context) string {
+	<-ctx.Done()
+	return ctx.Err().Error()
+}
+
+func (t *timeoutHub) Stuck() {
+	<-timeoutHubRelease
+}
+
+// countingProtocol is the json protocol, but counts how many invocations it has written
+type countingProtocol struct {
+	JSONHubProtocol
+}
+
+var countingProtocolInvocations int32
+
+func (c *countingProtocol) WriteMessage(message interface{}, writer io.Writer) error {
+	if _, ok := message.(invocationMessage); ok {
+		atomic.AddInt32(&countingProtocolInvocations, 1)
+	}
+	return c.JSONHubProtocol.WriteMessage(message, writer)
+}
+
+type panickingItem struct{}
+
+func (p panickingItem) MarshalJSON() ([]byte, error) {
+	panic("can not marshal")
+}
+
+type panicHub struct {
+	Hub
+}
+
+func (p *panicHub) PanickingStream() chan panickingItem {
+	items := make(chan panickingItem, 1)
+	items <- panickingItem{}
+	close(items)
+	return items
+}
+
 var _ = Describe("Server options", func() {
 
 	Describe("UseHub option", func() {
@@ -267,6 +368,117 @@ var _ = Describe("Server options", func() {
 		})
 	})
 
+	Describe("HandshakeValidator option", func() {
+		Context("When the HandshakeValidator rejects the handshake", func() {
+			It("should send the error in the handshake response", func() {
+				server, err := NewServer(UseHub(&invocationHub{}),
+					HandshakeValidator(func(conn Connection, protocol string, version int) error {
+						if version < 2 {
+							return fmt.Errorf("version %v is "+"\"outdated\"", version)
+						}
+						return nil
+					}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal(`{"error":"version 1 is \"outdated\""}`))
+			})
+		})
+		Context("When the HandshakeValidator accepts the handshake", func() {
+			It("should send an empty handshake response", func() {
+				server, err := NewServer(UseHub(&invocationHub{}),
+					HandshakeValidator(func(conn Connection, protocol string, version int) error {
+						return nil
+					}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal("{}"))
+			})
+		})
+	})
+
+	Describe("Features option", func() {
+		Context("When the client requests some of the offered features", func() {
+			It("should answer and record the accepted features", func() {
+				hub := &featureHub{features: make(chan []string, 1)}
+				server, err := NewServer(UseHub(hub), Features("compression", "clientResults", "statefulReconnect"))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1,"features":["statefulReconnect","unknown","compression"]}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal(`{"features":["compression","statefulReconnect"]}`))
+				Expect(<-hub.features).To(Equal([]string{"compression", "statefulReconnect"}))
+			})
+		})
+		Context("When the client does not know features", func() {
+			It("should accept no features", func() {
+				hub := &featureHub{features: make(chan []string, 1)}
+				server, err := NewServer(UseHub(hub), Features("compression"))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal(`{"features":[]}`))
+				Expect(<-hub.features).To(BeEmpty())
+			})
+		})
+	})
+
+	Describe("RepeatedHandshake option", func() {
+		Context("When no RepeatedHandshakePolicy is set and the client sends the handshake again", func() {
+			It("should close the connection", func() {
+				server, err := NewServer(SimpleHubFactory(&counterHub{}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "messagepack","version": 1}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(closeMessage{
+					Type: 7, Error: "handshake repeated after the connection has been started", AllowReconnect: true})))
+			})
+		})
+		Context("When RepeatedHandshakeIgnore is set", func() {
+			It("should ignore the handshake and keep the connection and its protocol", func() {
+				server, err := NewServer(SimpleHubFactory(&counterHub{}), RepeatedHandshake(RepeatedHandshakeIgnore))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "messagepack","version": 1}`)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"increment"}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: float64(1)})))
+			})
+		})
+		Context("When RepeatedHandshakeError is set", func() {
+			It("should answer with an error and keep the connection", func() {
+				server, err := NewServer(SimpleHubFactory(&counterHub{}), RepeatedHandshake(RepeatedHandshakeError))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal(`{}`))
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ = conn.ClientReceive()
+				Expect(hr).To(Equal(`{"error":"handshake has already been completed"}`))
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"increment"}`)
+				completion, _ := conn.ClientReceive()
+				Expect(completion).To(MatchJSON(`{"type":3,"invocationId":"1","result":1}`))
+			})
+		})
+		Context("When an invalid RepeatedHandshakePolicy is given", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), RepeatedHandshake(RepeatedHandshakePolicy(7)))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
 	Describe("KeepAliveInterval option", func() {
 		Context("When the KeepAliveInterval has expired without any server message", func() {
 			It("a ping should have been sent", func() {
@@ -296,6 +508,70 @@ var _ = Describe("Server options", func() {
 				}
 			})
 		})
+		Context("When stream items are sent", func() {
+			It("should send no pings until the stream is idle", func() {
+				server, err := NewServer(SimpleHubFactory(&tickerHub{}), KeepAliveInterval(100*time.Millisecond))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal("{}"))
+				conn.ClientSend(`{"type":4,"invocationId":"ticks","target":"tick","arguments":[10]}`)
+				for {
+					m, _ := conn.ClientReceive()
+					Expect(m).NotTo(ContainSubstring(`"type":6`))
+					if strings.Contains(m, `"type":3`) {
+						break
+					}
+				}
+				m, _ := conn.ClientReceive()
+				Expect(m).To(Equal("{\"type\":6}\n"))
+			})
+		})
+	})
+
+	Describe("PingTimestamps option", func() {
+		Context("When PingTimestamps are enabled", func() {
+			It("should send pings with timestamp and measure the round trip time", func() {
+				server, err := NewServer(UseHub(&invocationHub{}), KeepAliveInterval(50*time.Millisecond), PingTimestamps(true))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal("{}"))
+				m, _ := conn.ClientReceive()
+				Expect(m).To(MatchRegexp(`^{"type":6,"timestamp":\d+}\n$`))
+				stats, ok := server.ConnectionStats(conn.ConnectionID())
+				Expect(ok).To(BeTrue())
+				Expect(stats.RoundTripTime).To(Equal(time.Duration(0)))
+				conn.ClientSend(`{"type":6}`)
+				Eventually(func() time.Duration {
+					stats, _ := server.ConnectionStats(conn.ConnectionID())
+					return stats.RoundTripTime
+				}).Should(BeNumerically(">", 0))
+			})
+		})
+	})
+
+	Describe("MessageInterceptors option", func() {
+		Context("When a MessageInterceptor is given", func() {
+			It("should be able to drop inbound and to change outbound messages", func() {
+				server, err := NewServer(UseHub(&invocationHub{}), MessageInterceptors(&stampingInterceptor{}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal("{}"))
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"dropme"}`)
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"simple"}`)
+				Expect(<-invocationQueue).To(Equal("Simple()"))
+				m, _ := conn.ClientReceive()
+				Expect(m).To(Equal(fmt.Sprintf(`{"type":3,"invocationId":"2","headers":{"connection":"%v"}}`+"\n", conn.ConnectionID())))
+			})
+		})
 	})
 
 	Describe("StreamBufferCapacity option", func() {
@@ -307,6 +583,839 @@ var _ = Describe("Server options", func() {
 		})
 	})
 
+	Describe("Protocols option", func() {
+		Context("When an unknown protocol is given", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), Protocols("json", "xml"))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+		Context("When no protocol is given", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), Protocols())
+				Expect(err).NotTo(BeNil())
+			})
+		})
+		Context("When the json protocol is given", func() {
+			It("should accept json handshakes", func() {
+				server, err := NewServer(UseHub(&invocationHub{}), Protocols("json"))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"protocol": "json","version": 1}`)
+				hr, _ := conn.ClientReceive()
+				Expect(hr).To(Equal("{}"))
+			})
+		})
+	})
+
+	Describe("HubConstructor option", func() {
+		Context("When all dependencies are given", func() {
+			It("should create the hub with the dependencies", func() {
+				server, err := NewServer(HubConstructor(newInjectedHub, 42, &politeGreeter{}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"greet","arguments":["Bob"]}`)
+				msg := <-conn.ReceiveChan()
+				Expect(msg).To(BeAssignableToTypeOf(completionMessage{}))
+				Expect(msg.(completionMessage).Result).To(Equal("Hello Bob"))
+			})
+		})
+		Context("When a dependency is missing", func() {
+			It("should return an error", func() {
+				_, err := NewServer(HubConstructor(newInjectedHub, 42))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+		Context("When the constructor does not return a hub", func() {
+			It("should return an error", func() {
+				_, err := NewServer(HubConstructor(func() int { return 0 }))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("GroupJoinAuthorizer option", func() {
+		Context("When the authorizer denies the group", func() {
+			It("should not add the connection to the group", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					GroupJoinAuthorizer(func(groupName string, connectionID string, items *sync.Map) error {
+						if _, ok := items.Load("admin"); !ok && groupName == "admins" {
+							return errors.New("not an admin")
+						}
+						return nil
+					}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				connectionID := <-groupHubOnConnectMsg
+				Expect(server.Groups().AddToGroup("admins", connectionID)).NotTo(BeNil())
+				Expect(server.Groups().AddToGroup("users", connectionID)).To(BeNil())
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"setadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(BeAssignableToTypeOf(completionMessage{}))
+				Expect(server.Groups().AddToGroup("admins", connectionID)).To(BeNil())
+			})
+		})
+	})
+
+	Describe("GroupMembershipChanged option", func() {
+		Context("When connections join and leave groups", func() {
+			It("should raise events, including on disconnect", func() {
+				events := make(chan GroupMembershipEvent, 10)
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					GroupMembershipChanged(func(event GroupMembershipEvent) {
+						events <- event
+					}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				connectionID := <-groupHubOnConnectMsg
+				Expect(server.Groups().AddToGroup("a", connectionID)).To(BeNil())
+				Expect(<-events).To(Equal(GroupMembershipEvent{GroupName: "a", ConnectionID: connectionID, Change: GroupMemberAdded}))
+				Expect(server.Groups().AddToGroup("b", connectionID)).To(BeNil())
+				Expect(<-events).To(Equal(GroupMembershipEvent{GroupName: "b", ConnectionID: connectionID, Change: GroupMemberAdded}))
+				server.Groups().RemoveFromGroup("a", connectionID)
+				Expect(<-events).To(Equal(GroupMembershipEvent{GroupName: "a", ConnectionID: connectionID, Change: GroupMemberRemoved}))
+				conn.ClientSend(`{"type":7}`)
+				select {
+				case event := <-events:
+					Expect(event).To(Equal(GroupMembershipEvent{GroupName: "b", ConnectionID: connectionID, Change: GroupMemberDisconnected}))
+				case <-time.After(500 * time.Millisecond):
+					Fail("timed out")
+				}
+			})
+		})
+	})
+
+	Describe("GroupExpiration option", func() {
+		newExpiringServer := func(policy GroupExpirationPolicy, events chan GroupMembershipEvent, expired chan string) (*Server, string) {
+			server, err := NewServer(SimpleHubFactory(&groupHub{}),
+				GroupMembershipChanged(func(event GroupMembershipEvent) {
+					events <- event
+				}),
+				GroupExpiration(func(groupName string) GroupExpirationPolicy {
+					if groupName == "lobby" {
+						return policy
+					}
+					return GroupExpirationPolicy{}
+				}, func(groupName string) {
+					expired <- groupName
+				}))
+			Expect(err).To(BeNil())
+			go server.Run(context.TODO(), newTestingConnection())
+			return server, <-groupHubOnConnectMsg
+		}
+		Context("When a group has a TTL", func() {
+			It("should remove the members after the TTL and raise the events", func() {
+				events := make(chan GroupMembershipEvent, 10)
+				expired := make(chan string, 10)
+				server, connectionID := newExpiringServer(GroupExpirationPolicy{TTL: 100 * time.Millisecond}, events, expired)
+				Expect(server.Groups().AddToGroup("lobby", connectionID)).To(BeNil())
+				Expect(server.Groups().AddToGroup("forever", connectionID)).To(BeNil())
+				Expect((<-events).Change).To(Equal(GroupMemberAdded))
+				Expect((<-events).Change).To(Equal(GroupMemberAdded))
+				select {
+				case event := <-events:
+					Expect(event).To(Equal(GroupMembershipEvent{GroupName: "lobby", ConnectionID: connectionID, Change: GroupMemberExpired}))
+				case <-time.After(time.Second):
+					Fail("group did not expire")
+				}
+				Expect(<-expired).To(Equal("lobby"))
+				Consistently(expired, 200*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+		Context("When a group has a max idle time", func() {
+			It("should not expire while messages are sent to the group", func() {
+				events := make(chan GroupMembershipEvent, 10)
+				expired := make(chan string, 10)
+				server, connectionID := newExpiringServer(GroupExpirationPolicy{MaxIdle: 150 * time.Millisecond}, events, expired)
+				Expect(server.Groups().AddToGroup("lobby", connectionID)).To(BeNil())
+				for i := 0; i < 5; i++ {
+					time.Sleep(50 * time.Millisecond)
+					Expect(server.lifetimeManager.InvokeGroup(context.TODO(), "lobby", "ping", nil)).To(BeNil())
+				}
+				Expect(expired).NotTo(Receive())
+				Eventually(expired, time.Second).Should(Receive(Equal("lobby")))
+			})
+		})
+		Context("When the last member leaves the group", func() {
+			It("should not raise the expiration", func() {
+				events := make(chan GroupMembershipEvent, 10)
+				expired := make(chan string, 10)
+				server, connectionID := newExpiringServer(GroupExpirationPolicy{TTL: 100 * time.Millisecond}, events, expired)
+				Expect(server.Groups().AddToGroup("lobby", connectionID)).To(BeNil())
+				server.Groups().RemoveFromGroup("lobby", connectionID)
+				Consistently(expired, 300*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+	})
+
+	Describe("UseResumeStore option", func() {
+		Context("When a client reconnects with the resume token", func() {
+			It("should restore groups and items", func() {
+				events := make(chan GroupMembershipEvent, 10)
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					UseResumeStore(NewMemoryResumeStore(time.Minute)),
+					GroupMembershipChanged(func(event GroupMembershipEvent) {
+						events <- event
+					}))
+				Expect(err).To(BeNil())
+				conn, resumeToken := newResumingConnection(context.TODO(), server, "resumed", "")
+				Expect(resumeToken).NotTo(BeEmpty())
+				Expect(server.Groups().AddToGroup("a", "resumed")).To(BeNil())
+				Expect((<-events).Change).To(Equal(GroupMemberAdded))
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"setadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(BeAssignableToTypeOf(completionMessage{}))
+				conn.ClientSend(`{"type":7}`)
+				Expect((<-events).Change).To(Equal(GroupMemberDisconnected))
+				conn, nextToken := newResumingConnection(context.TODO(), server, "reconnected", resumeToken)
+				Expect(nextToken).NotTo(Equal(resumeToken))
+				Expect(<-events).To(Equal(GroupMembershipEvent{GroupName: "a", ConnectionID: "reconnected", Change: GroupMemberAdded}))
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"isadmin"}`)
+				msg := <-conn.ReceiveChan()
+				Expect(msg).To(BeAssignableToTypeOf(completionMessage{}))
+				Expect(msg.(completionMessage).Result).To(Equal(true))
+			})
+		})
+		Context("When a client reconnects with the connection id of another client, but without its resume token", func() {
+			It("should not restore the state of the other connection", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					UseResumeStore(NewMemoryResumeStore(time.Minute)))
+				Expect(err).To(BeNil())
+				conn, resumeToken := newResumingConnection(context.TODO(), server, "victim", "")
+				Expect(server.Groups().AddToGroup("a", "victim")).To(BeNil())
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"setadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(BeAssignableToTypeOf(completionMessage{}))
+				conn.ClientSend(`{"type":7}`)
+				Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(0))
+				for _, guessed := range []string{"", "victim", "forged"} {
+					conn, _ = newResumingConnection(context.TODO(), server, "victim", guessed)
+					conn.ClientSend(`{"type":1,"invocationId":"2","target":"isadmin"}`)
+					Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "2", Result: false}))
+					Expect(server.localLifetimeManager.groupsOf("victim")).To(BeEmpty())
+					conn.ClientSend(`{"type":7}`)
+					Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(0))
+				}
+				conn, _ = newResumingConnection(context.TODO(), server, "victim", resumeToken)
+				conn.ClientSend(`{"type":1,"invocationId":"3","target":"isadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "3", Result: true}))
+			})
+		})
+		Context("When a connection of another user or with another principal resumes", func() {
+			It("should restore the state only for the same user and never restore the principal", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					UseResumeStore(NewMemoryResumeStore(time.Minute)),
+					UserIDProvider(func(conn Connection) string { return strings.Split(conn.ConnectionID(), "-")[0] }))
+				Expect(err).To(BeNil())
+				principal := func(name string) context.Context {
+					return context.WithValue(context.TODO(), principalContextKey{}, name)
+				}
+				conn, resumeToken := newResumingConnection(principal("alice"), server, "alice-1", "")
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"setadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(BeAssignableToTypeOf(completionMessage{}))
+				conn.ClientSend(`{"type":7}`)
+				Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(0))
+				conn, _ = newResumingConnection(principal("mallory"), server, "mallory-1", resumeToken)
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"isadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "2", Result: false}))
+				conn.ClientSend(`{"type":7}`)
+				Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(0))
+				conn, resumeToken = newResumingConnection(principal("alice"), server, "alice-2", "")
+				conn.ClientSend(`{"type":1,"invocationId":"3","target":"setadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(BeAssignableToTypeOf(completionMessage{}))
+				conn.ClientSend(`{"type":7}`)
+				Eventually(func() int { return len(server.AllConnectionStats()) }).Should(Equal(0))
+				conn, _ = newResumingConnection(principal("alice, refreshed"), server, "alice-3", resumeToken)
+				conn.ClientSend(`{"type":1,"invocationId":"4","target":"isadmin"}`)
+				Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "4", Result: true}))
+				resumed, ok := server.connection("alice-3")
+				Expect(ok).To(BeTrue())
+				Expect(hubCallerFromItems(resumed.Items()).principal).To(Equal("alice, refreshed"))
+			})
+		})
+	})
+
+	Describe("OnError option", func() {
+		Context("When an internal goroutine panics", func() {
+			It("should pass the panic to the handler", func() {
+				errs := make(chan error, 10)
+				server, err := NewServer(SimpleHubFactory(&panicHub{}),
+					OnError(func(err error) {
+						errs <- err
+					}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":4,"invocationId":"1","target":"panickingstream"}`)
+				select {
+				case err := <-errs:
+					Expect(err).To(BeAssignableToTypeOf(&PanicError{}))
+					Expect(err.(*PanicError).Value).To(Equal("can not marshal"))
+				case <-time.After(time.Second):
+					Fail("timed out")
+				}
+			})
+		})
+	})
+
+	Describe("GroupReplay option", func() {
+		Context("When a connection joins a group", func() {
+			It("should replay the retained messages of the group", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					GroupReplay(NewMemoryGroupReplayBuffer(2, time.Minute)))
+				Expect(err).To(BeNil())
+				for i := 1; i <= 3; i++ {
+					Expect(server.lifetimeManager.InvokeGroup(context.TODO(), "telemetry", "value", []interface{}{i})).To(BeNil())
+				}
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				connectionID := <-groupHubOnConnectMsg
+				Expect(server.Groups().AddToGroup("telemetry", connectionID)).To(BeNil())
+				for i := 2; i <= 3; i++ {
+					msg := (<-conn.ReceiveChan()).(invocationMessage)
+					Expect(msg.Target).To(Equal("value"))
+					Expect(msg.Arguments).To(Equal([]interface{}{float64(i)}))
+				}
+			})
+		})
+	})
+
+	Describe("CustomProtocol option", func() {
+		Context("When clients with different protocols receive a broadcast", func() {
+			It("should encode the broadcast once per protocol", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					CustomProtocol("counting", &countingProtocol{}),
+					Protocols("json", "counting"))
+				Expect(err).To(BeNil())
+				atomic.StoreInt32(&countingProtocolInvocations, 0)
+				var conns []*testingConnection
+				for _, protocol := range []string{"counting", "counting", "counting", "json"} {
+					conn := newTestingConnectionBeforeHandshake()
+					go receiveLoop(conn)()
+					conn.ClientSend(fmt.Sprintf(`{"protocol":"%v","version":1}`, protocol))
+					conn.SetConnected(true)
+					go server.Run(context.TODO(), conn)
+					<-groupHubOnConnectMsg
+					conns = append(conns, conn)
+				}
+				Expect(server.lifetimeManager.InvokeAll(context.TODO(), "value", []interface{}{1})).To(BeNil())
+				for _, conn := range conns {
+					Expect((<-conn.ReceiveChan()).(invocationMessage).Target).To(Equal("value"))
+				}
+				Expect(atomic.LoadInt32(&countingProtocolInvocations)).To(Equal(int32(1)))
+			})
+		})
+		Context("When the protocol has no name", func() {
+			It("should return an error", func() {
+				_, err := NewServer(SimpleHubFactory(&groupHub{}), CustomProtocol("", &countingProtocol{}))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("BroadcastThrottle option", func() {
+		Context("When a group is sent to faster than the interval", func() {
+			It("should send the first and the latest value", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					BroadcastThrottle("value", 100*time.Millisecond))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				connectionID := <-groupHubOnConnectMsg
+				Expect(server.Groups().AddToGroup("telemetry", connectionID)).To(BeNil())
+				for i := 1; i <= 5; i++ {
+					Expect(server.lifetimeManager.InvokeGroup(context.TODO(), "telemetry", "value", []interface{}{i})).To(BeNil())
+				}
+				Expect((<-conn.ReceiveChan()).(invocationMessage).Arguments).To(Equal([]interface{}{float64(1)}))
+				Expect((<-conn.ReceiveChan()).(invocationMessage).Arguments).To(Equal([]interface{}{float64(5)}))
+				select {
+				case msg := <-conn.ReceiveChan():
+					Fail(fmt.Sprintf("received %v", msg))
+				case <-time.After(200 * time.Millisecond):
+				}
+			})
+		})
+		Context("When groups are not sent to anymore", func() {
+			It("should evict their slots", func() {
+				throttle := newBroadcastThrottle(map[string]time.Duration{"value": 20 * time.Millisecond})
+				for i := 0; i < 10; i++ {
+					Expect(throttle.throttled(throttleKey{group: fmt.Sprint(i), target: "value"}, nil, func([]interface{}) {})).To(BeFalse())
+				}
+				Expect(throttle.throttled(throttleKey{group: "0", target: "value"}, nil, func([]interface{}) {})).To(BeTrue())
+				time.Sleep(100 * time.Millisecond)
+				Expect(throttle.throttled(throttleKey{group: "new", target: "value"}, nil, func([]interface{}) {})).To(BeFalse())
+				throttle.mx.Lock()
+				defer throttle.mx.Unlock()
+				Expect(throttle.slots).To(HaveLen(1))
+				Expect(throttle.slots).To(HaveKey(throttleKey{group: "new", target: "value"}))
+			})
+		})
+	})
+
+	Describe("UseOutbox option", func() {
+		Context("When a durable send is not acknowledged", func() {
+			It("should resend it until the client acknowledges it", func() {
+				outbox := NewMemoryOutbox()
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					UseOutbox(outbox, 50*time.Millisecond))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				connectionID := <-groupHubOnConnectMsg
+				conn.ClientSend(`{"type":1,"target":"sendcommand"}`)
+				var ids []string
+				for len(ids) < 2 {
+					if invocation, ok := (<-conn.ReceiveChan()).(invocationMessage); ok {
+						Expect(invocation.Target).To(Equal("command"))
+						ids = append(ids, invocation.InvocationID)
+					}
+				}
+				Expect(ids[0]).NotTo(Equal(""))
+				Expect(ids[1]).To(Equal(ids[0]))
+				conn.ClientSend(fmt.Sprintf(`{"type":3,"invocationId":"%v"}`, ids[0]))
+				Eventually(func() int {
+					pending, _ := outbox.Pending(connectionID)
+					return len(pending)
+				}).Should(Equal(0))
+			})
+		})
+		Context("When the server has no Outbox", func() {
+			It("should return an error", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}))
+				Expect(err).To(BeNil())
+				Expect(server.lifetimeManager.InvokeAllDurable(context.TODO(), "command", nil)).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("AckRetry option", func() {
+		Context("When the client acknowledges a resent invocation", func() {
+			It("should complete the Delivery as acknowledged", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					AckRetry(20*time.Millisecond, 40*time.Millisecond, 5))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				connectionID := <-groupHubOnConnectMsg
+				delivery := server.SendWithAck(connectionID, "command", 1)
+				for i := 0; i < 2; i++ {
+					invocation := (<-conn.ReceiveChan()).(invocationMessage)
+					Expect(invocation.InvocationID).To(Equal(delivery.ID()))
+				}
+				Expect(delivery.Status()).To(Equal(DeliveryPending))
+				conn.ClientSend(fmt.Sprintf(`{"type":3,"invocationId":"%v"}`, delivery.ID()))
+				Eventually(delivery.Done()).Should(BeClosed())
+				Expect(delivery.Status()).To(Equal(DeliveryAcknowledged))
+				Expect(delivery.Err()).To(BeNil())
+			})
+		})
+		Context("When the client does not acknowledge the invocation", func() {
+			It("should fail the Delivery after the maximum attempts", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					AckRetry(10*time.Millisecond, 20*time.Millisecond, 3))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				connectionID := <-groupHubOnConnectMsg
+				delivery := server.SendWithAck(connectionID, "command")
+				Eventually(delivery.Done()).Should(BeClosed())
+				Expect(delivery.Status()).To(Equal(DeliveryFailed))
+				Expect(delivery.Attempts()).To(Equal(3))
+			})
+		})
+	})
+
+	Describe("UserIDProvider option", func() {
+		Context("When a user is disconnected", func() {
+			It("should close all connections of the user and no other", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					UserIDProvider(func(conn Connection) string {
+						if conn.ConnectionID() == "other" {
+							return "bob"
+						}
+						return "alice"
+					}))
+				Expect(err).To(BeNil())
+				conns := make([]*testingConnection, 3)
+				for i, id := range []string{"first", "second", "other"} {
+					conns[i] = newTestingConnection()
+					conns[i].connectionID = id
+					go server.Run(context.TODO(), conns[i])
+					<-groupHubOnConnectMsg
+				}
+				server.DisconnectUser("alice", "banned")
+				for _, conn := range conns[:2] {
+					select {
+					case msg := <-conn.ReceiveChan():
+						Expect(msg).To(Equal(closeMessage{Type: 7, Error: "banned", AllowReconnect: false}))
+					case <-time.After(500 * time.Millisecond):
+						Fail("timed out")
+					}
+				}
+				select {
+				case msg := <-conns[2].ReceiveChan():
+					Fail(fmt.Sprintf("received %v", msg))
+				case <-time.After(100 * time.Millisecond):
+				}
+			})
+		})
+		Context("When a hub sends to a user", func() {
+			It("should send to all connections of the user and no other", func() {
+				server, err := NewServer(SimpleHubFactory(&groupHub{}),
+					UserIDProvider(func(conn Connection) string {
+						if conn.ConnectionID() == "bobs" {
+							return "bob"
+						}
+						return "alice"
+					}))
+				Expect(err).To(BeNil())
+				conns := make([]*testingConnection, 3)
+				for i, id := range []string{"alices", "alicesPhone", "bobs"} {
+					conns[i] = newTestingConnection()
+					conns[i].connectionID = id
+					go server.Run(context.TODO(), conns[i])
+					<-groupHubOnConnectMsg
+				}
+				conns[2].ClientSend(`{"type":1,"target":"notifyuser","arguments":["alice"]}`)
+				for _, conn := range conns[:2] {
+					Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+						invocationMessage{Type: 1, Target: "notified", Arguments: []interface{}{"alice"}})))
+				}
+				Consistently(conns[2].ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+	})
+
+	Describe("InvocationTimeout option", func() {
+		Context("When the hub method returns after the deadline", func() {
+			It("should cancel the context of the hub method", func() {
+				server, err := NewServer(SimpleHubFactory(&timeoutHub{}), InvocationTimeout(50*time.Millisecond, time.Second))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"cooperative"}`)
+				Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "context deadline exceeded"}))
+			})
+		})
+		Context("When the hub method ignores the deadline", func() {
+			It("should complete the invocation with an error and report the method as stuck", func() {
+				errs := make(chan error, 10)
+				server, err := NewServer(SimpleHubFactory(&timeoutHub{}), InvocationTimeout(50*time.Millisecond, 50*time.Millisecond),
+					OnError(func(err error) {
+						errs <- err
+					}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"stuck"}`)
+				msg := (<-conn.ReceiveChan()).(completionMessage)
+				Expect(msg.InvocationID).To(Equal("1"))
+				Expect(msg.Error).NotTo(Equal(""))
+				Expect((<-errs).(*InvocationTimeoutError).Target).To(Equal("stuck"))
+				Expect(server.StuckInvocations()).To(HaveLen(1))
+				timeoutHubRelease <- struct{}{}
+				Eventually(server.StuckInvocations).Should(BeEmpty())
+				select {
+				case msg := <-conn.ReceiveChan():
+					Fail(fmt.Sprintf("received %v", msg))
+				case <-time.After(100 * time.Millisecond):
+				}
+			})
+		})
+	})
+
+	Describe("InvocationWatchdog option", func() {
+		watchdogLogger := func(stacks chan string) StructuredLogger {
+			return log.LoggerFunc(func(keyvals ...interface{}) error {
+				values := make(map[interface{}]interface{})
+				for i := 0; i+1 < len(keyvals); i += 2 {
+					values[keyvals[i]] = keyvals[i+1]
+				}
+				if values[evt] == "watchdog" {
+					stacks <- values["stack"].(string)
+				}
+				return nil
+			})
+		}
+		Context("When a hub method runs longer than the threshold", func() {
+			It("should log the stacks of all goroutines", func() {
+				stacks := make(chan string, 10)
+				server, err := NewServer(SimpleHubFactory(&timeoutHub{}), Logger(watchdogLogger(stacks), false),
+					InvocationWatchdog(50*time.Millisecond, false))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"stuck"}`)
+				select {
+				case stack := <-stacks:
+					Expect(stack).To(ContainSubstring("timeoutHub).Stuck"))
+				case <-time.After(time.Second):
+					Fail("watchdog did not log")
+				}
+				timeoutHubRelease <- struct{}{}
+				Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "1"}))
+			})
+		})
+		Context("When stuck invocations should be canceled", func() {
+			It("should cancel the context of the hub method", func() {
+				stacks := make(chan string, 10)
+				server, err := NewServer(SimpleHubFactory(&timeoutHub{}), Logger(watchdogLogger(stacks), false),
+					InvocationWatchdog(50*time.Millisecond, true))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"cooperative"}`)
+				Expect(<-conn.ReceiveChan()).To(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "context canceled"}))
+				Expect(stacks).To(Receive())
+			})
+		})
+		Context("When a hub method returns in time", func() {
+			It("should not log", func() {
+				stacks := make(chan string, 10)
+				server, err := NewServer(SimpleHubFactory(&invocationHub{}), Logger(watchdogLogger(stacks), false),
+					InvocationWatchdog(50*time.Millisecond, true))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"simpleint","arguments":[1]}`)
+				Expect(<-invocationQueue).To(Equal("SimpleInt(1)"))
+				Expect(<-conn.ReceiveChan()).To(BeAssignableToTypeOf(completionMessage{}))
+				Consistently(stacks, 100*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+	})
+
+	Describe("InvocationWorkers option", func() {
+		Context("When invocations are executed by the workers", func() {
+			It("should return the results", func() {
+				server, err := NewServer(HubConstructor(newInjectedHub, &politeGreeter{}), InvocationWorkers(2))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				for i := 0; i < 5; i++ {
+					conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"%v","target":"greet","arguments":["%v"]}`, i, i))
+				}
+				ids := make(map[string]bool)
+				for i := 0; i < 5; i++ {
+					ids[(<-conn.ReceiveChan()).(completionMessage).InvocationID] = true
+				}
+				Expect(ids).To(HaveLen(5))
+			})
+		})
+		Context("When the number of workers is negative", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), InvocationWorkers(-1))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+		Context("When the queue of a connection is full", func() {
+			It("should reject further invocations with server busy", func() {
+				server, err := NewServer(SimpleHubFactory(&sheddingHub{}), InvocationWorkers(1), InvocationQueueLimit(1))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"block"}`)
+				Eventually(sheddingHubBlocked).Should(Receive())
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"block"}`)
+				conn.ClientSend(`{"type":1,"invocationId":"3","target":"block"}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "3", Error: "Server busy"})))
+				sheddingHubRelease <- struct{}{}
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1"})))
+				Eventually(sheddingHubBlocked).Should(Receive())
+				sheddingHubRelease <- struct{}{}
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2"})))
+			})
+		})
+		Context("When the server is stopped", func() {
+			It("should drop the queued invocations and end the workers", func() {
+				server, err := NewServer(SimpleHubFactory(&sheddingHub{}), InvocationWorkers(1))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"block"}`)
+				Eventually(sheddingHubBlocked).Should(Receive())
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"block"}`)
+				Eventually(func() int64 { return server.AllConnectionStats()[conn.ConnectionID()].PendingInvocations }).Should(Equal(int64(2)))
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				Expect(server.Stop(ctx)).NotTo(BeNil())
+				sheddingHubRelease <- struct{}{}
+				Consistently(sheddingHubBlocked, 100*time.Millisecond).ShouldNot(Receive())
+				Expect(server.scheduler.schedule("conn", func() {}, func() {})).To(Equal(errSchedulerStopped))
+			})
+		})
+		Context("When the queue limit is not positive", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), InvocationQueueLimit(0))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("UnsentQueueGracePeriod option", func() {
+		Context("When the client reconnects within the grace period", func() {
+			It("should send the unsent messages of the previous connection first", func() {
+				clock := NewManualClock(time.Now())
+				server, err := NewServer(SimpleHubFactory(&groupHub{}), UnsentQueueGracePeriod(time.Minute), UseClock(clock),
+					UserIDProvider(func(conn Connection) string { return strings.Split(conn.ConnectionID(), "-")[0] }))
+				Expect(err).To(BeNil())
+				server.unsentQueues.keep("token", "alice", &JSONHubProtocol{}, []interface{}{
+					invocationMessage{Type: 1, Target: "unsent", Arguments: []interface{}{"1"}},
+				}, clock.Now().Add(time.Minute), clock.Now())
+				conn, resumeToken := newResumingConnection(context.TODO(), server, "alice-2", "token")
+				Expect(resumeToken).NotTo(BeEmpty())
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+					invocationMessage{Type: 1, Target: "unsent", Arguments: []interface{}{"1"}})))
+			})
+		})
+		Context("When a client reconnects with the connection id but not the resume token, or as another user", func() {
+			It("should not send the unsent messages to it", func() {
+				clock := NewManualClock(time.Now())
+				server, err := NewServer(SimpleHubFactory(&groupHub{}), UnsentQueueGracePeriod(time.Minute), UseClock(clock),
+					UserIDProvider(func(conn Connection) string { return strings.Split(conn.ConnectionID(), "-")[0] }))
+				Expect(err).To(BeNil())
+				messages := []interface{}{invocationMessage{Type: 1, Target: "unsent", Arguments: []interface{}{"1"}}}
+				server.unsentQueues.keep("token", "alice", &JSONHubProtocol{}, messages, clock.Now().Add(time.Minute), clock.Now())
+				conn, _ := newResumingConnection(context.TODO(), server, "alice-1", "")
+				Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+				conn, _ = newResumingConnection(context.TODO(), server, "mallory-1", "token")
+				Consistently(conn.ReceiveChan(), 100*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+		Context("When the grace period has expired or the client reconnects with another protocol", func() {
+			It("should drop the unsent messages", func() {
+				queues := unsentQueues{}
+				now := time.Now()
+				messages := []interface{}{invocationMessage{Type: 1, Target: "unsent"}}
+				queues.keep("expired", "", &JSONHubProtocol{}, messages, now.Add(time.Second), now)
+				Expect(queues.take("expired", "", &JSONHubProtocol{}, now.Add(2*time.Second))).To(BeEmpty())
+				queues.keep("other", "", &JSONHubProtocol{}, messages, now.Add(time.Second), now)
+				Expect(queues.take("other", "", &MessagePackHubProtocol{}, now)).To(BeEmpty())
+				queues.keep("same", "", &JSONHubProtocol{}, messages, now.Add(time.Second), now)
+				Expect(queues.take("same", "", &JSONHubProtocol{}, now)).To(Equal(messages))
+				Expect(queues.take("same", "", &JSONHubProtocol{}, now)).To(BeEmpty())
+			})
+		})
+		Context("When the grace period is negative", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), UnsentQueueGracePeriod(-time.Second))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("HubPerConnection option", func() {
+		Context("When a hub keeps state in its fields", func() {
+			It("should run the invocations of a connection in order on the same hub", func() {
+				server, err := NewServer(HubFactory(func() HubInterface { return &counterHub{} }), HubPerConnection())
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				for i := 1; i <= 5; i++ {
+					conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"%v","target":"increment"}`, i))
+				}
+				for i := 1; i <= 5; i++ {
+					completion := (<-conn.ReceiveChan()).(completionMessage)
+					Expect(completion.InvocationID).To(Equal(fmt.Sprint(i)))
+					Expect(completion.Result).To(Equal(float64(i)))
+				}
+			})
+		})
+		Context("When HubPerConnection is combined with UseHub", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&counterHub{}), HubPerConnection())
+				Expect(err).NotTo(BeNil())
+			})
+		})
+		Context("When HubPerConnection is combined with InvocationWorkers", func() {
+			It("should return an error", func() {
+				_, err := NewServer(SimpleHubFactory(&counterHub{}), HubPerConnection(), InvocationWorkers(2))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("ArgumentLimits option", func() {
+		Context("When an invocation exceeds the limits of its target", func() {
+			It("should send a completion with an error and not invoke the method", func() {
+				server, err := NewServer(SimpleHubFactory(&argumentsHub{}), ArgumentLimits("Store", 1, 16))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"store","arguments":[["a","b"],["c"]]}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1",
+					Error: "Invocation of store rejected: 2 arguments exceed the limit of 1 arguments"})))
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"store","arguments":[["aaaaaaaa","bbbbbbbb"]]}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2",
+					Error: "Invocation of store rejected: arguments of 23 bytes exceed the limit of 16 bytes"})))
+				conn.ClientSend(`{"type":1,"invocationId":"3","target":"store","arguments":[["a","b"]]}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "3", Result: float64(2)})))
+			})
+		})
+		Context("When the limits are negative", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), ArgumentLimits("Store", -1, 0))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("InvocationShedding option", func() {
+		Context("When a connection has as many pending invocations as the threshold", func() {
+			It("should reject further invocations with server busy", func() {
+				server, err := NewServer(SimpleHubFactory(&sheddingHub{}), InvocationShedding(1))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"block"}`)
+				Eventually(sheddingHubBlocked).Should(Receive())
+				Expect(server.AllConnectionStats()[conn.ConnectionID()].PendingInvocations).To(Equal(int64(1)))
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"block"}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Error: "Server busy"})))
+				sheddingHubRelease <- struct{}{}
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1"})))
+				Eventually(func() int64 { return server.AllConnectionStats()[conn.ConnectionID()].PendingInvocations }).Should(Equal(int64(0)))
+				conn.ClientSend(`{"type":1,"invocationId":"3","target":"block"}`)
+				Eventually(sheddingHubBlocked).Should(Receive())
+				sheddingHubRelease <- struct{}{}
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "3"})))
+			})
+		})
+		Context("When the threshold is negative", func() {
+			It("should return an error", func() {
+				_, err := NewServer(UseHub(&singleHub{}), InvocationShedding(-1))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("CompletionShaper option", func() {
+		Context("When a CompletionShaper is set", func() {
+			It("should send the completions it shapes", func() {
+				server, err := NewServer(SimpleHubFactory(&shapingHub{}), CompletionShaper(func(info CompletionInfo) (interface{}, string) {
+					if coded, ok := info.Error.(*codedError); ok {
+						return nil, fmt.Sprintf("E%v", coded.code)
+					}
+					if info.Error != nil {
+						return nil, info.Error.Error()
+					}
+					return map[string]interface{}{"invocationId": info.InvocationID, "target": info.Target, "data": info.Result}, ""
+				}))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(context.TODO(), conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"answer"}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1",
+					Result: map[string]interface{}{"invocationId": "1", "target": "answer", "data": float64(42)}})))
+				conn.ClientSend(`{"type":1,"invocationId":"2","target":"fail"}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Error: "E42"})))
+				conn.ClientSend(`{"type":1,"invocationId":"3","target":"unknown"}`)
+				Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "3", Error: "Unknown method unknown"})))
+			})
+		})
+	})
+
 	Describe("MaximumReceiveMessageSize option", func() {
 		Context("When the MaximumReceiveMessageSize is 0", func() {
 			It("should return an error", func() {
@@ -317,6 +1426,23 @@ var _ = Describe("Server options", func() {
 	})
 })
 
+type stampingInterceptor struct{}
+
+func (s *stampingInterceptor) Inbound(connectionID string, message interface{}) (interface{}, bool) {
+	if invocation, ok := message.(InvocationMessage); ok && invocation.Target == "dropme" {
+		return nil, false
+	}
+	return message, true
+}
+
+func (s *stampingInterceptor) Outbound(connectionID string, message interface{}) (interface{}, bool) {
+	if completion, ok := message.(CompletionMessage); ok {
+		completion.Headers = map[string]string{"connection": connectionID}
+		return completion, true
+	}
+	return message, true
+}
+
 type channelWriter struct {
 	channel chan []byte
 }
@@ -347,3 +1473,99 @@ func newChannelWriter() *channelWriter {
 //	m.c <- true
 //	return nil
 //}
+
+type featureHub struct {
+	Hub
+	features chan []string
+}
+
+func (f *featureHub) OnConnected(string) {
+	f.features <- f.Features()
+}
+
+type counterHub struct {
+	Hub
+	count int
+}
+
+func (c *counterHub) Increment() int {
+	c.count++
+	return c.count
+}
+
+type argumentsHub struct {
+	Hub
+}
+
+func (a *argumentsHub) Store(items []string) int {
+	return len(items)
+}
+
+type sheddingHub struct {
+	Hub
+}
+
+var sheddingHubBlocked = make(chan struct{}, 1)
+var sheddingHubRelease = make(chan struct{})
+
+func (s *sheddingHub) Block() {
+	sheddingHubBlocked <- struct{}{}
+	<-sheddingHubRelease
+}
+
+type shapingHub struct {
+	Hub
+}
+
+type codedError struct {
+	code int
+}
+
+func (c *codedError) Error() string {
+	return fmt.Sprintf("failed with code %v", c.code)
+}
+
+func (s *shapingHub) Answer() int {
+	return 42
+}
+
+func (s *shapingHub) Fail() *Future {
+	future := NewFuture()
+	future.Reject(&codedError{code: 42})
+	return future
+}
+
+type tickerHub struct {
+	Hub
+}
+
+func (t *tickerHub) Tick(count int) <-chan int {
+	ticks := make(chan int)
+	go func() {
+		defer close(ticks)
+		for i := 0; i < count; i++ {
+			time.Sleep(40 * time.Millisecond)
+			ticks <- i
+		}
+	}()
+	return ticks
+}
+
+// newResumingConnection connects a testingConnection with connectionID and ctx to the groupHub of server.
+// The handshake sends resumeFrom as resume token. It returns the resume token of the handshake response
+func newResumingConnection(ctx context.Context, server *Server, connectionID string, resumeFrom string) (*testingConnection, string) {
+	conn := newTestingConnectionBeforeHandshake()
+	conn.connectionID = connectionID
+	go server.Run(ctx, conn)
+	conn.ClientSend(fmt.Sprintf(`{"protocol":"json","version":1,"resumeToken":%q}`, resumeFrom))
+	rawResponse, err := conn.ClientReceive()
+	Expect(err).To(BeNil())
+	var response struct {
+		ResumeToken string `json:"resumeToken"`
+	}
+	Expect(json.Unmarshal([]byte(rawResponse), &response)).To(BeNil())
+	go receiveLoop(conn)()
+	conn.SetConnected(true)
+	<-groupHubOnConnectMsg
+	return conn, response.ResumeToken
+}
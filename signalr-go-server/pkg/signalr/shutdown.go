@@ -0,0 +1,70 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// stopPollInterval is the interval in which Stop checks if the in-flight invocations have completed
+const stopPollInterval = 10 * time.Millisecond
+
+// Stop shuts the server down gracefully. New connections are rejected and new invocations are answered
+// with an error. Stop waits until the invocations in flight have completed, but not longer than ctx allows.
+// Then all connections are closed and the clients are allowed to reconnect, e.g. to another server,
+// and the InvocationWorkers end after their running invocations.
+// Last, the extensions are stopped in the reverse order of their start, if the server has been started.
+// All extensions are stopped, even if some of them fail. Stop returns an error if ctx was done before
+// the in-flight invocations completed, else the first error of the extensions.
+func (s *Server) Stop(ctx context.Context) error {
+	defer s.extensionsMx.Unlock()
+	s.extensionsMx.Lock()
+	atomic.StoreInt32(&s.stopping, 1)
+	waitErr := s.waitForInvocations(ctx)
+	if waitErr != nil {
+		_ = s.info.Log(evt, "stop", "error", waitErr, react, "close connections")
+	}
+	for _, conn := range s.localLifetimeManager.allConnections() {
+		conn.AbortWithError(errors.New("server stopped"))
+	}
+	if s.scheduler != nil {
+		if err := s.scheduler.stop(ctx); err != nil && waitErr == nil {
+			waitErr = fmt.Errorf("stop invocation workers: %v", err)
+		}
+	}
+	if !s.started {
+		return waitErr
+	}
+	s.started = false
+	if err := s.stopExtensions(ctx, s.extensions); waitErr == nil {
+		return err
+	}
+	return waitErr
+}
+
+// isStopping tells if Stop is shutting the server down or has shut it down. s.stopping is accessed atomically
+func (s *Server) isStopping() bool {
+	return atomic.LoadInt32(&s.stopping) == 1
+}
+
+// waitForInvocations blocks until no connection has pending invocations or ctx is done
+func (s *Server) waitForInvocations(ctx context.Context) error {
+	ticker := time.NewTicker(stopPollInterval)
+	defer ticker.Stop()
+	for {
+		var pending int64
+		for _, conn := range s.localLifetimeManager.allConnections() {
+			pending += conn.PendingInvocations()
+		}
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%v invocations in flight: %v", pending, ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+type shutdownHub struct {
+	Hub
+}
+
+var shutdownHubRelease chan struct{}
+
+func (s *shutdownHub) Work() string {
+	<-shutdownHubRelease
+	return "done"
+}
+
+var _ = Describe("Server.Stop", func() {
+	Context("When invocations are in flight", func() {
+		It("should reject new connections and invocations, wait for the invocations and close the connections", func() {
+			shutdownHubRelease = make(chan struct{})
+			server, err := NewServer(SimpleHubFactory(&shutdownHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "busy"
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"work"}`)
+			Eventually(func() int64 { return server.AllConnectionStats()["busy"].PendingInvocations }).Should(Equal(int64(1)))
+			stopped := make(chan error, 1)
+			go func() { stopped <- server.Stop(context.TODO()) }()
+			Eventually(server.isStopping).Should(BeTrue())
+			rejected := newTestingConnection()
+			ran := make(chan struct{})
+			go func() {
+				server.Run(context.TODO(), rejected)
+				close(ran)
+			}()
+			Eventually(ran).Should(BeClosed())
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"work"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "2", Error: "Server stopping"})))
+			Consistently(stopped, 100*time.Millisecond).ShouldNot(Receive())
+			close(shutdownHubRelease)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(completionMessage{Type: 3, InvocationID: "1", Result: "done"})))
+			closed := (<-conn.ReceiveChan()).(closeMessage)
+			Expect(closed.AllowReconnect).To(BeTrue())
+			Expect(<-stopped).To(BeNil())
+		})
+	})
+	Context("When the invocations do not complete before the deadline", func() {
+		It("should close the connections and return an error", func() {
+			shutdownHubRelease = make(chan struct{})
+			defer close(shutdownHubRelease)
+			server, err := NewServer(SimpleHubFactory(&shutdownHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			conn.connectionID = "busy"
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"work"}`)
+			Eventually(func() int64 { return server.AllConnectionStats()["busy"].PendingInvocations }).Should(Equal(int64(1)))
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			Expect(server.Stop(ctx)).NotTo(BeNil())
+			closed := (<-conn.ReceiveChan()).(closeMessage)
+			Expect(closed.AllowReconnect).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,79 @@
+package signalr
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// NewSQLOutbox creates an Outbox which stores the messages in the table of db.
+// The table needs the columns id VARCHAR(32) UNIQUE, connection_id VARCHAR(64), target VARCHAR(255), args TEXT
+// and seq, which orders the messages and must be filled by the database with increasing numbers, e.g.
+// seq BIGSERIAL PRIMARY KEY in PostgreSQL, seq BIGINT AUTO_INCREMENT PRIMARY KEY in MySQL
+// or seq INTEGER PRIMARY KEY AUTOINCREMENT in SQLite. The arguments are stored as JSON.
+// placeholder returns the parameter placeholder for the nth (1-based) parameter of a statement,
+// e.g. "$1" for PostgreSQL. If placeholder is nil, "?" is used.
+func NewSQLOutbox(db *sql.DB, table string, placeholder func(n int) string) Outbox {
+	if placeholder == nil {
+		placeholder = func(int) string { return "?" }
+	}
+	return &sqlOutbox{db: db, table: table, placeholder: placeholder}
+}
+
+type sqlOutbox struct {
+	db          *sql.DB
+	table       string
+	placeholder func(n int) string
+}
+
+// statement replaces the ? in query with the placeholders of the database
+func (s *sqlOutbox) statement(query string) string {
+	parts := strings.Split(fmt.Sprintf(query, s.table), "?")
+	var b strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			b.WriteString(s.placeholder(i))
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+func (s *sqlOutbox) Put(message OutboxMessage) error {
+	args, err := json.Marshal(message.Args)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.statement(
+		"INSERT INTO %s (id, connection_id, target, args) VALUES (?, ?, ?, ?)"),
+		message.ID, message.ConnectionID, message.Target, string(args))
+	return err
+}
+
+func (s *sqlOutbox) Pending(connectionID string) ([]OutboxMessage, error) {
+	rows, err := s.db.Query(s.statement(
+		"SELECT id, target, args FROM %s WHERE connection_id = ? ORDER BY seq"), connectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var messages []OutboxMessage
+	for rows.Next() {
+		message := OutboxMessage{ConnectionID: connectionID}
+		var args string
+		if err := rows.Scan(&message.ID, &message.Target, &args); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(args), &message.Args); err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, rows.Err()
+}
+
+func (s *sqlOutbox) Ack(connectionID string, id string) error {
+	_, err := s.db.Exec(s.statement("DELETE FROM %s WHERE connection_id = ? AND id = ?"), connectionID, id)
+	return err
+}
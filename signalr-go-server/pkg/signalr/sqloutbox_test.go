@@ -0,0 +1,199 @@
+package signalr
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// fakeOutboxDriver is a database/sql driver which knows only the statements of the sqlOutbox for the table "outbox".
+// Like an autoincrement column, seq is filled by the database. Each data source name is a database of its own
+var fakeOutboxDriver = &outboxDriver{tables: make(map[string]*outboxTable)}
+
+func init() {
+	sql.Register("fakeoutbox", fakeOutboxDriver)
+}
+
+type outboxDriver struct {
+	mx     sync.Mutex
+	tables map[string]*outboxTable
+}
+
+type outboxRow struct {
+	seq                            int64
+	id, connectionID, target, args string
+}
+
+type outboxTable struct {
+	mx      sync.Mutex
+	lastSeq int64
+	rows    []outboxRow
+}
+
+func (d *outboxDriver) Open(name string) (driver.Conn, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	table, ok := d.tables[name]
+	if !ok {
+		table = &outboxTable{}
+		d.tables[name] = table
+	}
+	return &outboxDriverConn{table: table}, nil
+}
+
+type outboxDriverConn struct {
+	table *outboxTable
+}
+
+func (c *outboxDriverConn) Prepare(query string) (driver.Stmt, error) {
+	return &outboxStmt{table: c.table, query: regexp.MustCompile(`\$\d+`).ReplaceAllString(query, "?")}, nil
+}
+
+func (c *outboxDriverConn) Close() error {
+	return nil
+}
+
+func (c *outboxDriverConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+type outboxStmt struct {
+	table *outboxTable
+	query string
+}
+
+func (s *outboxStmt) Close() error {
+	return nil
+}
+
+func (s *outboxStmt) NumInput() int {
+	return -1
+}
+
+func (s *outboxStmt) Exec(args []driver.Value) (driver.Result, error) {
+	t := s.table
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	switch s.query {
+	case "INSERT INTO outbox (id, connection_id, target, args) VALUES (?, ?, ?, ?)":
+		for _, row := range t.rows {
+			if row.id == args[0] {
+				return nil, fmt.Errorf("duplicate id %v", args[0])
+			}
+		}
+		t.lastSeq++
+		t.rows = append(t.rows, outboxRow{seq: t.lastSeq, id: args[0].(string), connectionID: args[1].(string),
+			target: args[2].(string), args: args[3].(string)})
+		return driver.RowsAffected(1), nil
+	case "DELETE FROM outbox WHERE connection_id = ? AND id = ?":
+		rows := t.rows[:0]
+		for _, row := range t.rows {
+			if row.connectionID != args[0] || row.id != args[1] {
+				rows = append(rows, row)
+			}
+		}
+		affected := len(t.rows) - len(rows)
+		t.rows = rows
+		return driver.RowsAffected(affected), nil
+	}
+	return nil, fmt.Errorf("unsupported statement %q", s.query)
+}
+
+func (s *outboxStmt) Query(args []driver.Value) (driver.Rows, error) {
+	t := s.table
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	if s.query != "SELECT id, target, args FROM outbox WHERE connection_id = ? ORDER BY seq" {
+		return nil, fmt.Errorf("unsupported query %q", s.query)
+	}
+	var rows []outboxRow
+	for _, row := range t.rows {
+		if row.connectionID == args[0] {
+			rows = append(rows, row)
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].seq < rows[j].seq })
+	return &outboxRows{rows: rows}, nil
+}
+
+type outboxRows struct {
+	rows []outboxRow
+}
+
+func (r *outboxRows) Columns() []string {
+	return []string{"id", "target", "args"}
+}
+
+func (r *outboxRows) Close() error {
+	return nil
+}
+
+func (r *outboxRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	dest[0], dest[1], dest[2] = r.rows[0].id, r.rows[0].target, r.rows[0].args
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var _ = Describe("SQLOutbox", func() {
+	var db *sql.DB
+	BeforeEach(func() {
+		var err error
+		db, err = sql.Open("fakeoutbox", getConnectionID())
+		Expect(err).To(BeNil())
+	})
+	AfterEach(func() {
+		Expect(db.Close()).To(BeNil())
+	})
+	Context("When messages are put", func() {
+		It("should return the pending messages of the connection in the order they were put", func() {
+			outbox := NewSQLOutbox(db, "outbox", nil)
+			Expect(outbox.Put(OutboxMessage{ID: "1", ConnectionID: "conn1", Target: "first", Args: []interface{}{1.0}})).To(BeNil())
+			Expect(outbox.Put(OutboxMessage{ID: "2", ConnectionID: "conn2", Target: "other"})).To(BeNil())
+			Expect(outbox.Put(OutboxMessage{ID: "3", ConnectionID: "conn1", Target: "second", Args: []interface{}{"two"}})).To(BeNil())
+			messages, err := outbox.Pending("conn1")
+			Expect(err).To(BeNil())
+			Expect(messages).To(Equal([]OutboxMessage{
+				{ID: "1", ConnectionID: "conn1", Target: "first", Args: []interface{}{1.0}},
+				{ID: "3", ConnectionID: "conn1", Target: "second", Args: []interface{}{"two"}}}))
+		})
+	})
+	Context("When messages are put concurrently", func() {
+		It("should store all of them with PostgreSQL placeholders", func() {
+			outbox := NewSQLOutbox(db, "outbox", func(n int) string { return fmt.Sprintf("$%d", n) })
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					Expect(outbox.Put(OutboxMessage{ID: fmt.Sprint(i), ConnectionID: "conn", Target: "target"})).To(BeNil())
+				}(i)
+			}
+			wg.Wait()
+			messages, err := outbox.Pending("conn")
+			Expect(err).To(BeNil())
+			Expect(messages).To(HaveLen(20))
+		})
+	})
+	Context("When a message is acknowledged", func() {
+		It("should not be pending anymore", func() {
+			outbox := NewSQLOutbox(db, "outbox", nil)
+			Expect(outbox.Put(OutboxMessage{ID: "1", ConnectionID: "conn", Target: "first"})).To(BeNil())
+			Expect(outbox.Put(OutboxMessage{ID: "2", ConnectionID: "conn", Target: "second"})).To(BeNil())
+			Expect(outbox.Ack("conn", "1")).To(BeNil())
+			messages, err := outbox.Pending("conn")
+			Expect(err).To(BeNil())
+			Expect(messages).To(Equal([]OutboxMessage{{ID: "2", ConnectionID: "conn", Target: "second"}}))
+		})
+	})
+})
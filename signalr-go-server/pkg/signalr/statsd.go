@@ -0,0 +1,110 @@
+package signalr
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsDEmitter pushes metrics of a server to a StatsD server over UDP. Tags are sent in the DogStatsD format.
+// The server sends the counters connections.opened, connections.closed, invocations (tagged with the target)
+// and errors when they happen. Targets are tagged with the lower case name of the hub method, or with
+// "target:unknown" if the hub has no such method, so clients can not add tag values at will. The gauges connections and bytes.received.rate, bytes.sent.rate,
+// messages.received.rate and messages.sent.rate (summed over all connections) are sent periodically.
+type StatsDEmitter struct {
+	conn      net.Conn
+	prefix    string
+	tags      []string
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStatsDEmitter creates a StatsDEmitter which sends to address, e.g. "127.0.0.1:8125".
+// prefix is prepended to all metric names, e.g. "signalr.". tags like "env:prod" are added to all metrics.
+func NewStatsDEmitter(address string, prefix string, tags ...string) (*StatsDEmitter, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDEmitter{conn: conn, prefix: prefix, tags: tags, done: make(chan struct{})}, nil
+}
+
+// Close stops sending the periodic gauges and closes the connection to the StatsD server
+func (e *StatsDEmitter) Close() error {
+	var err error
+	e.closeOnce.Do(func() {
+		close(e.done)
+		err = e.conn.Close()
+	})
+	return err
+}
+
+func (e *StatsDEmitter) count(name string, value int64, tags ...string) {
+	e.send(name, strconv.FormatInt(value, 10), "c", tags)
+}
+
+func (e *StatsDEmitter) gauge(name string, value float64, tags ...string) {
+	e.send(name, strconv.FormatFloat(value, 'f', -1, 64), "g", tags)
+}
+
+// send sends one metric. Metrics are lost when sending fails, as StatsD over UDP does not guarantee delivery anyway
+func (e *StatsDEmitter) send(name string, value string, metricType string, tags []string) {
+	if e == nil {
+		return
+	}
+	line := fmt.Sprintf("%v%v:%v|%v", e.prefix, name, value, metricType)
+	if allTags := append(append([]string{}, e.tags...), tags...); len(allTags) > 0 {
+		line += "|#" + strings.Join(allTags, ",")
+	}
+	_, _ = e.conn.Write([]byte(line))
+}
+
+// statsDTarget returns the target tag of an invocation of target
+func (s *Server) statsDTarget(target string) string {
+	if s.statsD == nil {
+		return ""
+	}
+	s.statsDMethodsOnce.Do(func() {
+		s.statsDMethods = make(map[string]bool)
+		hubType := reflect.TypeOf(s.newHub())
+		for i := 0; i < hubType.NumMethod(); i++ {
+			if m := hubType.Method(i); !isBaseHubMethod(m) {
+				s.statsDMethods[strings.ToLower(m.Name)] = true
+			}
+		}
+	})
+	if name := strings.ToLower(target); s.statsDMethods[name] {
+		return "target:" + name
+	}
+	return "target:unknown"
+}
+
+// emitStatsD sends the gauges of the server every interval until the StatsDEmitter is closed
+func (s *Server) emitStatsD(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			all := s.AllConnectionStats()
+			var total ConnectionStats
+			for _, stats := range all {
+				total.BytesReceivedRate += stats.BytesReceivedRate
+				total.BytesSentRate += stats.BytesSentRate
+				total.MessagesReceivedRate += stats.MessagesReceivedRate
+				total.MessagesSentRate += stats.MessagesSentRate
+			}
+			s.statsD.gauge("connections", float64(len(all)))
+			s.statsD.gauge("bytes.received.rate", total.BytesReceivedRate)
+			s.statsD.gauge("bytes.sent.rate", total.BytesSentRate)
+			s.statsD.gauge("messages.received.rate", total.MessagesReceivedRate)
+			s.statsD.gauge("messages.sent.rate", total.MessagesSentRate)
+		case <-s.statsD.done:
+			return
+		}
+	}
+}
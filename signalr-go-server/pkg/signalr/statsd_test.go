@@ -0,0 +1,51 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net"
+	"time"
+)
+
+var _ = Describe("StatsDEmitter", func() {
+	Context("When a server sends metrics with StatsD", func() {
+		It("should send counters and gauges with the tags", func() {
+			udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			defer func() { _ = udpConn.Close() }()
+			emitter, err := NewStatsDEmitter(udpConn.LocalAddr().String(), "signalr.", "env:test")
+			Expect(err).To(BeNil())
+			defer func() { _ = emitter.Close() }()
+			server, err := NewServer(SimpleHubFactory(&invocationHub{}), StatsDMetrics(emitter, 50*time.Millisecond))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"unknownFunc"}`)
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"Simple"}`)
+			Eventually(invocationQueue).Should(Receive(Equal("Simple()")))
+			expected := map[string]bool{
+				"signalr.connections.opened:1|c|#env:test":         false,
+				"signalr.invocations:1|c|#env:test,target:unknown": false,
+				"signalr.invocations:1|c|#env:test,target:simple":  false,
+				"signalr.connections:1|g|#env:test":                false,
+			}
+			_ = udpConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			buf := make([]byte, 1024)
+			for missing := len(expected); missing > 0; {
+				n, _, err := udpConn.ReadFrom(buf)
+				Expect(err).To(BeNil())
+				if seen, ok := expected[string(buf[:n])]; ok && !seen {
+					expected[string(buf[:n])] = true
+					missing--
+				}
+			}
+		})
+	})
+	Context("When the StatsDMetrics option has no emitter", func() {
+		It("should return an error", func() {
+			_, err := NewServer(SimpleHubFactory(&invocationHub{}), StatsDMetrics(nil, time.Second))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -6,19 +6,23 @@ import (
 	"time"
 )
 
-func (s *Server) newStreamClient() *streamClient {
+func (s *Server) newStreamClient(protocol HubProtocol) *streamClient {
 	return &streamClient{
+		protocol:              protocol,
 		upstreamChannels:      make(map[string]reflect.Value),
 		runningStreams:        make(map[string]bool),
 		hubChanReceiveTimeout: s.hubChanReceiveTimeout,
+		clock:                 s.clock,
 		streamBufferCapacity:  s.streamBufferCapacity,
 	}
 }
 
 type streamClient struct {
+	protocol              HubProtocol
 	upstreamChannels      map[string]reflect.Value
 	runningStreams        map[string]bool
 	hubChanReceiveTimeout time.Duration
+	clock                 Clock
 	streamBufferCapacity  uint
 }
 
@@ -52,34 +56,11 @@ func (c *streamClient) receiveStreamItem(streamItem streamItemMessage) error {
 			}
 			return c.sendChanValSave(upChan, chanVal)
 		}
-		// Are stream item and channel type both slices/arrays?
-		switch reflect.TypeOf(streamItem.Item).Kind() {
-		case reflect.Slice, reflect.Array:
-			switch reflect.TypeOf(chanElm).Kind() {
-			case reflect.Slice:
-				if sis, ok := streamItem.Item.([]interface{}); ok {
-					chanElmElmType := upChan.Type().Elem().Elem() // The type of the array elements in the channel
-					chanElm = reflect.Indirect(reflect.New(chanElmElmType)).Interface()
-					chanVals := make([]reflect.Value, len(sis))
-					for i, si := range sis {
-						if f, ok := si.(float64); ok {
-							chanVal, err := convertNumberToChannelType(chanElm, f)
-							if err != nil {
-								return err
-							}
-							chanVals[i] = chanVal
-						}
-					}
-					chanSlice := reflect.Indirect(reflect.New(reflect.SliceOf(chanElmElmType)))
-					chanSlice = reflect.Append(chanSlice, chanVals...)
-					return c.sendChanValSave(upChan, chanSlice)
-				}
-			default:
-				return fmt.Errorf("stream item of kind %v paired with channel of type %v", reflect.TypeOf(streamItem.Item).Kind(), reflect.TypeOf(chanElm))
-			}
-		default:
-			return c.sendChanValSave(upChan, reflect.ValueOf(streamItem.Item))
+		chanVal, err := c.decodeStreamItem(upChan.Type().Elem(), streamItem.Item)
+		if err != nil {
+			return err
 		}
+		return c.sendChanValSave(upChan, chanVal)
 	}
 	return fmt.Errorf(`unknown stream id "%v"`, streamItem.InvocationID)
 }
@@ -98,7 +79,7 @@ func (c *streamClient) sendChanValSave(upChan reflect.Value, chanVal reflect.Val
 	select {
 	case err := <-done:
 		return err
-	case <-time.After(c.hubChanReceiveTimeout):
+	case <-c.clock.After(c.hubChanReceiveTimeout):
 		return &hubChanTimeoutError{fmt.Sprintf("timeout (%v) waiting for hub to receive client streamed value", c.hubChanReceiveTimeout)}
 	}
 }
@@ -144,6 +125,28 @@ func convertNumberToChannelType(chanElm interface{}, number float64) (chanVal re
 	}
 }
 
+// decodeStreamItem converts a stream item into a value of chanElmType. Items which are not assignable to chanElmType,
+// e.g. objects for struct or map channels, slices of objects or nested containers, are decoded with the protocol
+// of the connection, like the arguments of an invocation
+func (c *streamClient) decodeStreamItem(chanElmType reflect.Type, item interface{}) (reflect.Value, error) {
+	if item == nil {
+		return reflect.Zero(chanElmType), nil
+	}
+	if reflect.TypeOf(item).AssignableTo(chanElmType) {
+		return reflect.ValueOf(item), nil
+	}
+	chanVal := reflect.New(chanElmType)
+	if err := c.protocol.UnmarshalArgument(item, chanVal.Interface()); err != nil {
+		return reflect.Value{}, fmt.Errorf("stream item of kind %v paired with channel of type %v: %v", reflect.TypeOf(item).Kind(), chanElmType, err)
+	}
+	return chanVal.Elem(), nil
+}
+
+func (c *streamClient) isUpstream(invocationID string) bool {
+	_, ok := c.upstreamChannels[invocationID]
+	return ok
+}
+
 func (c *streamClient) receiveCompletionItem(completion completionMessage) error {
 	if channel, ok := c.upstreamChannels[completion.InvocationID]; ok {
 		var err error
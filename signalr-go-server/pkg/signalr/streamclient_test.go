@@ -1,9 +1,12 @@
 package signalr
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/go-kit/kit/log"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"reflect"
 	"strings"
 	"time"
 )
@@ -25,12 +28,16 @@ func (c *clientStreamHub) UploadStreamSmoke(upload1 <-chan int, factor float64,
 		case u1, ok1 = <-upload1:
 			if ok1 {
 				clientStreamingInvocationQueue <- fmt.Sprintf("u1: %v", u1)
+			} else {
+				// Don't spin on the closed channel
+				upload1 = nil
 			}
 		case u2, ok2 = <-upload2:
 			if ok2 {
 				clientStreamingInvocationQueue <- fmt.Sprintf("u2: %v", u2)
+			} else {
+				upload2 = nil
 			}
-		default:
 		}
 		if !ok1 && !ok2 {
 			clientStreamingInvocationQueue <- "Finished"
@@ -103,6 +110,18 @@ func (c *clientStreamHub) UploadArray(u <-chan []int) {
 	clientStreamingInvocationQueue <- "UploadArray finished"
 }
 
+type clientStreamPoint struct {
+	X int
+	Y int
+}
+
+func (c *clientStreamHub) UploadNested(u <-chan map[string][]clientStreamPoint) {
+	for r := range u {
+		clientStreamingInvocationQueue <- fmt.Sprintf("received %v", r)
+	}
+	clientStreamingInvocationQueue <- "UploadNested finished"
+}
+
 func (c *clientStreamHub) UploadError(u <-chan error) {
 	clientStreamingInvocationQueue <- "UploadError start"
 	for range u {
@@ -344,6 +363,53 @@ var _ = Describe("ClientStreaming", func() {
 		})
 	})
 
+	Describe("Stream client with map and struct channels", func() {
+		Context("When a func with a channel of maps of struct slices is invoked by the client and stream items are sent", func() {
+			It("should decode the items into the channel type", func() {
+				conn := connect(&clientStreamHub{})
+				conn.ClientSend(`{"type":1,"invocationId":"UPN","target":"uploadnested","streamids":["nnn"]}`)
+				conn.ClientSend(`{"type":2,"invocationId":"nnn","item":{"a":[{"X":1,"Y":2},{"X":3,"Y":4}],"b":[]}}`)
+				conn.ClientSend(`{"type":3,"invocationId":"nnn"}`)
+				Expect(<-clientStreamingInvocationQueue).To(Equal("received map[a:[{1 2} {3 4}] b:[]]"))
+				Expect(<-clientStreamingInvocationQueue).To(Equal("UploadNested finished"))
+			})
+		})
+		for _, protocol := range []HubProtocol{&JSONHubProtocol{}, &MessagePackHubProtocol{}} {
+			protocol := protocol
+			Context(fmt.Sprintf("When stream items are read with the %T", protocol), func() {
+				It("should decode them into map, struct slice and nested channel types", func() {
+					protocol.(debugLoggingProtocol).setDebugLogger(log.NewNopLogger())
+					server, err := NewServer(UseHub(&clientStreamHub{}))
+					Expect(err).To(BeNil())
+					client := server.newStreamClient(protocol)
+					send := func(chanType reflect.Type, item interface{}) interface{} {
+						upChan, _, err := client.buildChannelArgument(invocationMessage{StreamIds: []string{"s"}}, chanType, 0)
+						Expect(err).To(BeNil())
+						var buf bytes.Buffer
+						Expect(protocol.WriteMessage(streamItemMessage{Type: 2, InvocationID: "s", Item: item}, &buf)).To(BeNil())
+						message, _, err := protocol.ReadMessage(&buf)
+						Expect(err).To(BeNil())
+						go func() {
+							defer GinkgoRecover()
+							Expect(client.receiveStreamItem(message.(streamItemMessage))).To(BeNil())
+						}()
+						received, ok := upChan.Recv()
+						Expect(ok).To(BeTrue())
+						return received.Interface()
+					}
+					Expect(send(reflect.TypeOf(make(chan map[string]int)), map[string]int{"a": 1, "b": 2})).To(
+						Equal(map[string]int{"a": 1, "b": 2}))
+					Expect(send(reflect.TypeOf(make(chan []clientStreamPoint)), []clientStreamPoint{{1, 2}, {3, 4}})).To(
+						Equal([]clientStreamPoint{{1, 2}, {3, 4}}))
+					Expect(send(reflect.TypeOf(make(chan map[string][]clientStreamPoint)), map[string][]clientStreamPoint{"p": {{5, 6}}})).To(
+						Equal(map[string][]clientStreamPoint{"p": {{5, 6}}}))
+					Expect(send(reflect.TypeOf(make(chan [][]int)), [][]int{{1}, {2, 3}})).To(
+						Equal([][]int{{1}, {2, 3}}))
+				})
+			})
+		}
+	})
+
 	Describe("Client sending invalid streamitems", func() {
 		Context("When an invalid streamitem message with missing id and item is sent", func() {
 			It("should end the connection with an error", func() {
@@ -6,51 +6,57 @@ import (
 	"sync"
 )
 
-func newStreamer(conn hubConnection, info StructuredLogger) *streamer {
+func newStreamer(conn hubConnection, info StructuredLogger, goSafe func(f func())) *streamer {
 	info = log.WithPrefix(info, "ts", log.DefaultTimestampUTC,
 		"class", "streamer",
 		"connection", conn.ConnectionID())
-	return &streamer{make(map[string]chan bool), sync.Mutex{}, conn, info}
+	return &streamer{make(map[string]func()), sync.Mutex{}, conn, info, goSafe}
 }
 
 type streamer struct {
-	streamCancelChans map[string]chan bool
-	sccMutex          sync.Mutex
-	conn              hubConnection
-	info              StructuredLogger
+	streamStops map[string]func()
+	sccMutex    sync.Mutex
+	conn        hubConnection
+	info        StructuredLogger
+	goSafe      func(f func())
 }
 
-func (s *streamer) Start(invocationID string, reflectedChannel reflect.Value) {
-	cancelChan := make(chan bool)
+// Start sends the values received from reflectedChannel as stream items. cancel is called when the stream ends
+// or is stopped
+func (s *streamer) Start(invocationID string, reflectedChannel reflect.Value, cancel func()) {
+	stopped := make(chan struct{})
+	var once sync.Once
 	s.sccMutex.Lock()
 	defer s.sccMutex.Unlock()
-	s.streamCancelChans[invocationID] = cancelChan
-	go func(cancelChan chan bool) {
+	s.streamStops[invocationID] = func() {
+		once.Do(func() {
+			close(stopped)
+			// Tell the hub method that the stream is not read anymore
+			cancel()
+		})
+	}
+	s.goSafe(func() {
+		defer cancel()
 		defer func() {
 			s.sccMutex.Lock()
 			defer s.sccMutex.Unlock()
-			delete(s.streamCancelChans, invocationID)
-			close(cancelChan)
+			delete(s.streamStops, invocationID)
 		}()
+		cases := []reflect.SelectCase{
+			{Dir: reflect.SelectRecv, Chan: reflectedChannel},
+			{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(stopped)},
+		}
 		for {
-			// Waits for channel, so might hang
-			if chanResult, ok := reflectedChannel.Recv(); ok {
-				if s.conn.IsConnected() {
-					select {
-					case <-cancelChan:
-						sendMessageAndLog(func() (i interface{}, err error) {
-							return s.conn.Completion(invocationID, nil, "")
-						}, s.info)
-						return
-					default:
-					}
-					sendMessageAndLog(func() (i interface{}, err error) {
-						return s.conn.StreamItem(invocationID, chanResult.Interface())
-					}, s.info)
-				} else {
-					return
-				}
-			} else {
+			// Waits for channel or stop, so might hang
+			chosen, chanResult, ok := reflect.Select(cases)
+			select {
+			case <-stopped:
+				// Stop wins over an item which was ready at the same time
+				chosen = 1
+			default:
+			}
+			if chosen == 1 || !ok {
+				// Stopped or the hub method closed the channel
 				if s.conn.IsConnected() {
 					sendMessageAndLog(func() (i interface{}, err error) {
 						return s.conn.Completion(invocationID, nil, "")
@@ -58,17 +64,23 @@ func (s *streamer) Start(invocationID string, reflectedChannel reflect.Value) {
 				}
 				return
 			}
+			if !s.conn.IsConnected() {
+				return
+			}
+			sendMessageAndLog(func() (i interface{}, err error) {
+				return s.conn.StreamItem(invocationID, chanResult.Interface())
+			}, s.info)
 		}
-	}(cancelChan)
+	})
 }
 
+// Stop stops sending the stream items of the invocation and cancels the context of the hub method.
+// The hub method should stop sending to its channel then, as the channel is not read anymore.
 func (s *streamer) Stop(invocationID string) {
-	// in goroutine, because cancel might not be read when stream producer hangs
-	go func() {
-		s.sccMutex.Lock()
-		defer s.sccMutex.Unlock()
-		if cancel, ok := s.streamCancelChans[invocationID]; ok {
-			cancel <- true
-		}
-	}()
+	s.sccMutex.Lock()
+	stop, ok := s.streamStops[invocationID]
+	s.sccMutex.Unlock()
+	if ok {
+		stop()
+	}
 }
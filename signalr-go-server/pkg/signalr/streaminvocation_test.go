@@ -1,6 +1,7 @@
 package signalr
 
 import (
+	"context"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"time"
@@ -36,6 +37,22 @@ func (s *streamHub) EndlessStream() <-chan int {
 	return r
 }
 
+func (s *streamHub) CancelableStream(ctx context.Context) <-chan int {
+	r := make(chan int)
+	go func() {
+		defer close(r)
+		for i := 1; ; i++ {
+			select {
+			case r <- i:
+			case <-ctx.Done():
+				streamInvocationQueue <- "CancelableStream() canceled"
+				return
+			}
+		}
+	}()
+	return r
+}
+
 func (s *streamHub) SliceStream() <-chan []int {
 	r := make(chan []int)
 	go func() {
@@ -132,6 +149,25 @@ var _ = Describe("Streaminvocation", func() {
 		})
 	})
 
+	Describe("Cancel stream invocation", func() {
+		Context("When the client cancels a stream of a hub method waiting for its context", func() {
+			It("should cancel the context and send a completion", func() {
+				conn := connect(&streamHub{})
+				conn.ClientSend(`{"type":4,"invocationId": "cancel","target":"cancelablestream"}`)
+				Expect((<-conn.received).(streamItemMessage).InvocationID).To(Equal("cancel"))
+				conn.ClientSend(`{"type":5,"invocationId": "cancel"}`)
+				Eventually(streamInvocationQueue).Should(Receive(Equal("CancelableStream() canceled")))
+				for {
+					if completion, ok := (<-conn.received).(completionMessage); ok {
+						Expect(completion).To(Equal(completionMessage{Type: 3, InvocationID: "cancel"}))
+						break
+					}
+				}
+				Consistently(conn.received, 100*time.Millisecond).ShouldNot(Receive())
+			})
+		})
+	})
+
 	Describe("Invalid CancelInvocation", func() {
 		Context("When invoked by the client and receiving an invalid CancelInvocation", func() {
 			It("should close the connection with an error", func() {
@@ -18,6 +18,7 @@ type testingConnection struct {
 	srvReader    io.Reader
 	cliWriter    io.Writer
 	cliReader    io.Reader
+	cliReceived  bytes.Buffer
 	received     chan interface{}
 	cnMutex      sync.Mutex
 	connected    bool
@@ -159,20 +160,19 @@ func (t *testingConnection) ClientSend(message string) {
 }
 
 func (t *testingConnection) ClientReceive() (string, error) {
-	var buf bytes.Buffer
 	var data = make([]byte, 1<<15) // 32K
-	var n int
 	for {
-		if message, err := buf.ReadString(30); err != nil {
-			buf.Write(data[:n])
-			if n, err = t.cliReader.Read(data); err == nil {
-				buf.Write(data[:n])
-			} else {
-				return "", err
-			}
-		} else {
+		message, err := t.cliReceived.ReadString(30)
+		if err == nil {
 			return message[:len(message)-1], nil
 		}
+		// Keep the incomplete message, and messages following it in the same write for the next call
+		t.cliReceived.WriteString(message)
+		n, err := t.cliReader.Read(data)
+		if err != nil {
+			return "", err
+		}
+		t.cliReceived.Write(data[:n])
 	}
 }
 
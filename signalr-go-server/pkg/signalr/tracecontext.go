@@ -0,0 +1,239 @@
+package signalr
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header names of the W3C trace context and baggage
+const (
+	TraceParentHeader = "traceparent"
+	TraceStateHeader  = "tracestate"
+	BaggageHeader     = "baggage"
+)
+
+// TraceContext is the W3C trace context of an invocation, taken from the traceparent, tracestate and baggage
+// headers of the invocation message. Hub methods with a context.Context parameter get it with TraceContextFrom.
+// The completion and the stream items of the invocation and the messages the hub sends with Clients()
+// or with ClientProxy.SendContext(ctx) during the invocation carry the same headers, so tracing systems
+// can follow the causality chain across the hub. SendDurable sends are not stamped.
+// With HubPerConnection, Clients() sends with the TraceContext of the invocation the connection runs at the moment.
+// Methods with client streams run beside the other invocations, they should send with ClientProxy.SendContext(ctx).
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+	Baggage     map[string]string
+}
+
+var traceParentPattern = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// parseTraceContext takes the trace context from the headers of an invocation.
+// An invalid traceparent is ignored, as W3C requires. ok is false if there is neither a valid traceparent nor baggage
+func parseTraceContext(headers map[string]string) (trace TraceContext, ok bool) {
+	if traceParent := headers[TraceParentHeader]; traceParentPattern.MatchString(traceParent) &&
+		!strings.HasPrefix(traceParent, "ff") &&
+		traceParent[3:35] != strings.Repeat("0", 32) && traceParent[36:52] != strings.Repeat("0", 16) {
+		trace.TraceParent = traceParent
+		trace.TraceState = headers[TraceStateHeader]
+	}
+	trace.Baggage = parseBaggage(headers[BaggageHeader])
+	return trace, trace.TraceParent != "" || len(trace.Baggage) > 0
+}
+
+// parseBaggage parses the list of key=value members of a baggage header. Properties of the members are dropped
+func parseBaggage(header string) map[string]string {
+	var baggage map[string]string
+	for _, member := range strings.Split(header, ",") {
+		member = strings.SplitN(member, ";", 2)[0]
+		keyValue := strings.SplitN(member, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(keyValue[0])
+		value, err := url.PathUnescape(strings.TrimSpace(keyValue[1]))
+		if key == "" || err != nil {
+			continue
+		}
+		if baggage == nil {
+			baggage = make(map[string]string)
+		}
+		baggage[key] = value
+	}
+	return baggage
+}
+
+// headers returns the trace context as message headers
+func (t TraceContext) headers() map[string]string {
+	headers := make(map[string]string)
+	if t.TraceParent != "" {
+		headers[TraceParentHeader] = t.TraceParent
+		if t.TraceState != "" {
+			headers[TraceStateHeader] = t.TraceState
+		}
+	}
+	if len(t.Baggage) > 0 {
+		members := make([]string, 0, len(t.Baggage))
+		for key, value := range t.Baggage {
+			members = append(members, key+"="+url.PathEscape(value))
+		}
+		sort.Strings(members)
+		headers[BaggageHeader] = strings.Join(members, ",")
+	}
+	return headers
+}
+
+type traceContextKey struct{}
+
+// TraceContextFrom returns the TraceContext of the invocation ctx has been created for
+func TraceContextFrom(ctx context.Context) (TraceContext, bool) {
+	trace, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return trace, ok
+}
+
+func withTraceContext(ctx context.Context, trace TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, trace)
+}
+
+// traceHeaders returns the headers of the TraceContext of ctx, or nil if it has none
+func traceHeaders(ctx context.Context) map[string]string {
+	if trace, ok := TraceContextFrom(ctx); ok {
+		return trace.headers()
+	}
+	return nil
+}
+
+// tracedClientProxy sends with the TraceContext of the invocation in which the hub uses it
+type tracedClientProxy struct {
+	proxy ClientProxy
+	trace TraceContext
+}
+
+func (t *tracedClientProxy) Send(target string, args ...interface{}) {
+	_ = t.proxy.SendContext(withTraceContext(context.Background(), t.trace), target, args...)
+}
+
+func (t *tracedClientProxy) SendContext(ctx context.Context, target string, args ...interface{}) error {
+	if _, ok := TraceContextFrom(ctx); !ok {
+		ctx = withTraceContext(ctx, t.trace)
+	}
+	return t.proxy.SendContext(ctx, target, args...)
+}
+
+func (t *tracedClientProxy) SendDurable(target string, args ...interface{}) error {
+	return t.proxy.SendDurable(target, args...)
+}
+
+func (t *tracedClientProxy) SendAfter(delay time.Duration, target string, args ...interface{}) ScheduledSend {
+	return scheduleSend(delay, func() { t.Send(target, args...) })
+}
+
+// tracedHubClients returns ClientProxies which send with the TraceContext of the invocation
+type tracedHubClients struct {
+	clients HubClients
+	trace   TraceContext
+}
+
+func (t *tracedHubClients) All() ClientProxy {
+	return &tracedClientProxy{proxy: t.clients.All(), trace: t.trace}
+}
+
+func (t *tracedHubClients) Caller() ClientProxy {
+	return &tracedClientProxy{proxy: t.clients.Caller(), trace: t.trace}
+}
+
+func (t *tracedHubClients) Others() ClientProxy {
+	return &tracedClientProxy{proxy: t.clients.Others(), trace: t.trace}
+}
+
+func (t *tracedHubClients) Client(connectionID string) ClientProxy {
+	return &tracedClientProxy{proxy: t.clients.Client(connectionID), trace: t.trace}
+}
+
+func (t *tracedHubClients) Group(groupName string) ClientProxy {
+	return &tracedClientProxy{proxy: t.clients.Group(groupName), trace: t.trace}
+}
+
+func (t *tracedHubClients) User(userID string) ClientProxy {
+	return &tracedClientProxy{proxy: t.clients.User(userID), trace: t.trace}
+}
+
+func (t *tracedHubClients) Tagged(expression string) ClientProxy {
+	return &tracedClientProxy{proxy: t.clients.Tagged(expression), trace: t.trace}
+}
+
+func (t *tracedHubClients) SendToConnections(connectionIDs []string, target string, args ...interface{}) map[string]error {
+	return t.clients.SendToConnectionsContext(withTraceContext(context.Background(), t.trace), connectionIDs, target, args...)
+}
+
+func (t *tracedHubClients) SendToConnectionsContext(ctx context.Context, connectionIDs []string, target string, args ...interface{}) map[string]error {
+	if _, ok := TraceContextFrom(ctx); !ok {
+		ctx = withTraceContext(ctx, t.trace)
+	}
+	return t.clients.SendToConnectionsContext(ctx, connectionIDs, target, args...)
+}
+
+// sequenceHubClients are the HubClients of the hub of a connection with HubPerConnection.
+// The connection runs its invocations one after another, each sets its TraceContext while it runs
+type sequenceHubClients struct {
+	clients HubClients
+	mx      sync.Mutex
+	trace   TraceContext
+	traced  bool
+}
+
+// setTrace sets the TraceContext of the invocation which runs now
+func (s *sequenceHubClients) setTrace(trace TraceContext, traced bool) {
+	defer s.mx.Unlock()
+	s.mx.Lock()
+	s.trace, s.traced = trace, traced
+}
+
+func (s *sequenceHubClients) current() HubClients {
+	defer s.mx.Unlock()
+	s.mx.Lock()
+	if !s.traced {
+		return s.clients
+	}
+	return &tracedHubClients{clients: s.clients, trace: s.trace}
+}
+
+func (s *sequenceHubClients) All() ClientProxy {
+	return s.current().All()
+}
+
+func (s *sequenceHubClients) Caller() ClientProxy {
+	return s.current().Caller()
+}
+
+func (s *sequenceHubClients) Others() ClientProxy {
+	return s.current().Others()
+}
+
+func (s *sequenceHubClients) Client(connectionID string) ClientProxy {
+	return s.current().Client(connectionID)
+}
+
+func (s *sequenceHubClients) Group(groupName string) ClientProxy {
+	return s.current().Group(groupName)
+}
+
+func (s *sequenceHubClients) User(userID string) ClientProxy {
+	return s.current().User(userID)
+}
+
+func (s *sequenceHubClients) Tagged(expression string) ClientProxy {
+	return s.current().Tagged(expression)
+}
+
+func (s *sequenceHubClients) SendToConnections(connectionIDs []string, target string, args ...interface{}) map[string]error {
+	return s.current().SendToConnections(connectionIDs, target, args...)
+}
+
+func (s *sequenceHubClients) SendToConnectionsContext(ctx context.Context, connectionIDs []string, target string, args ...interface{}) map[string]error {
+	return s.current().SendToConnectionsContext(ctx, connectionIDs, target, args...)
+}
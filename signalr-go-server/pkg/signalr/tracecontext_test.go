@@ -0,0 +1,101 @@
+package signalr
+
+import (
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type tracedHub struct {
+	Hub
+}
+
+func (t *tracedHub) Notify(ctx context.Context) string {
+	t.Clients().Caller().Send("notified")
+	trace, _ := TraceContextFrom(ctx)
+	return trace.Baggage["tenant"]
+}
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+var _ = Describe("TraceContext", func() {
+	Context("When the headers of an invocation are parsed", func() {
+		It("should take a valid traceparent and the baggage", func() {
+			trace, ok := parseTraceContext(map[string]string{
+				TraceParentHeader: testTraceParent,
+				TraceStateHeader:  "vendor=abc",
+				BaggageHeader:     "tenant=acme, user%20name=Jane%20Doe;secret, invalid",
+			})
+			Expect(ok).To(BeTrue())
+			Expect(trace).To(Equal(TraceContext{
+				TraceParent: testTraceParent,
+				TraceState:  "vendor=abc",
+				Baggage:     map[string]string{"tenant": "acme", "user%20name": "Jane Doe"},
+			}))
+			Expect(trace.headers()).To(Equal(map[string]string{
+				TraceParentHeader: testTraceParent,
+				TraceStateHeader:  "vendor=abc",
+				BaggageHeader:     "tenant=acme,user%20name=Jane%20Doe",
+			}))
+		})
+		It("should ignore invalid traceparents", func() {
+			for _, traceParent := range []string{
+				"",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+				"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+				"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+				"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+				"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			} {
+				_, ok := parseTraceContext(map[string]string{TraceParentHeader: traceParent, TraceStateHeader: "vendor=abc"})
+				Expect(ok).To(BeFalse(), traceParent)
+			}
+		})
+	})
+	Context("When an invocation has a trace context", func() {
+		It("should pass it to the hub method and stamp it on the messages sent during the invocation", func() {
+			server, err := NewServer(SimpleHubFactory(&tracedHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"notify","headers":{"traceparent":"` + testTraceParent + `","baggage":"tenant=acme"}}`)
+			headers := map[string]string{TraceParentHeader: testTraceParent, BaggageHeader: "tenant=acme"}
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				invocationMessage{Type: 1, Target: "notified", Arguments: []interface{}{}, Headers: headers})))
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				completionMessage{Type: 3, InvocationID: "1", Result: "acme", Headers: headers})))
+		})
+	})
+	Context("When the server uses HubPerConnection", func() {
+		It("should stamp the messages sent with Clients() with the trace context of the running invocation", func() {
+			server, err := NewServer(SimpleHubFactory(&tracedHub{}), HubPerConnection())
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"notify","headers":{"traceparent":"` + testTraceParent + `","baggage":"tenant=acme"}}`)
+			headers := map[string]string{TraceParentHeader: testTraceParent, BaggageHeader: "tenant=acme"}
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				invocationMessage{Type: 1, Target: "notified", Arguments: []interface{}{}, Headers: headers})))
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				completionMessage{Type: 3, InvocationID: "1", Result: "acme", Headers: headers})))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"notify"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				invocationMessage{Type: 1, Target: "notified", Arguments: []interface{}{}})))
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				completionMessage{Type: 3, InvocationID: "2", Result: ""})))
+		})
+	})
+	Context("When an invocation has no trace context", func() {
+		It("should send the messages without headers", func() {
+			server, err := NewServer(SimpleHubFactory(&tracedHub{}))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(context.TODO(), conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"notify"}`)
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				invocationMessage{Type: 1, Target: "notified", Arguments: []interface{}{}})))
+			Eventually(conn.ReceiveChan()).Should(Receive(Equal(
+				completionMessage{Type: 3, InvocationID: "1", Result: ""})))
+		})
+	})
+})
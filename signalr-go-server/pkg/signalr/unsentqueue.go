@@ -0,0 +1,74 @@
+package signalr
+
+import (
+	"reflect"
+	"sync"
+	"time"
+)
+
+// unsentQueues keeps the messages which were queued but never written when a connection was closed,
+// under the resume token of the connection until the client reconnects with it or the grace period has expired.
+// Connection ids are chosen by the clients, so they can not be used to find the messages of a connection
+type unsentQueues struct {
+	mx     sync.Mutex
+	queues map[string]unsentQueue
+}
+
+type unsentQueue struct {
+	// protocol is the type of the protocol the messages were queued for. Prepared invocations are already encoded with it
+	protocol reflect.Type
+	// userID is the user of the connection, the messages are only sent to a connection of the same user
+	userID   string
+	messages []interface{}
+	expires  time.Time
+}
+
+func (u *unsentQueues) keep(resumeToken string, userID string, protocol HubProtocol, messages []interface{}, expires time.Time, now time.Time) {
+	defer u.mx.Unlock()
+	u.mx.Lock()
+	for id, queue := range u.queues {
+		if now.After(queue.expires) {
+			delete(u.queues, id)
+		}
+	}
+	if len(messages) == 0 || resumeToken == "" {
+		return
+	}
+	if u.queues == nil {
+		u.queues = make(map[string]unsentQueue)
+	}
+	u.queues[resumeToken] = unsentQueue{protocol: reflect.TypeOf(protocol), userID: userID, messages: messages, expires: expires}
+}
+
+// take removes the messages kept under the resume token and returns them if they have not expired,
+// were queued for the same type of protocol and for a connection of the same user
+func (u *unsentQueues) take(resumeToken string, userID string, protocol HubProtocol, now time.Time) []interface{} {
+	defer u.mx.Unlock()
+	u.mx.Lock()
+	queue, ok := u.queues[resumeToken]
+	if !ok {
+		return nil
+	}
+	delete(u.queues, resumeToken)
+	if now.After(queue.expires) || queue.protocol != reflect.TypeOf(protocol) || queue.userID != userID {
+		return nil
+	}
+	return queue.messages
+}
+
+// keepUnsent keeps the unsent messages of the closed connection for the UnsentQueueGracePeriod
+func (s *Server) keepUnsent(conn hubConnection, protocol HubProtocol) {
+	now := s.clock.Now()
+	s.unsentQueues.keep(conn.ResumeToken(), conn.UserID(), protocol, conn.Unsent(), now.Add(s.unsentQueueGracePeriod), now)
+}
+
+// requeueUnsent sends the messages kept from the previous connection whose resume token the client sent in the handshake
+func (s *Server) requeueUnsent(conn hubConnection, protocol HubProtocol, resumeFrom string) error {
+	if resumeFrom == "" {
+		return nil
+	}
+	if messages := s.unsentQueues.take(resumeFrom, conn.UserID(), protocol, s.clock.Now()); len(messages) > 0 {
+		return conn.Requeue(messages)
+	}
+	return nil
+}
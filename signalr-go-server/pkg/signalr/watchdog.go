@@ -0,0 +1,52 @@
+package signalr
+
+import (
+	"context"
+	"runtime"
+	"time"
+)
+
+// watch lets the watchdog observe the invocation until the returned CancelFunc is called, which also calls cancel.
+// When the invocation is still running after the watchdog threshold, the stacks of all goroutines are logged
+// and, if the watchdog should cancel stuck invocations, cancel is called and the stream of the invocation is stopped.
+func (sl *serverLoop) watch(invocation invocationMessage, cancel context.CancelFunc) context.CancelFunc {
+	if sl.server.watchdogThreshold <= 0 {
+		return cancel
+	}
+	started := time.Now()
+	timer := time.AfterFunc(sl.server.watchdogThreshold, func() {
+		sl.reportStuck(invocation, started, cancel)
+	})
+	return func() {
+		timer.Stop()
+		cancel()
+	}
+}
+
+func (sl *serverLoop) reportStuck(invocation invocationMessage, started time.Time, cancel context.CancelFunc) {
+	sl.server.statsD.count("watchdog.stuck", 1, sl.server.statsDTarget(invocation.Target))
+	reaction := "log stacks"
+	if sl.server.watchdogCancel {
+		reaction = "log stacks and cancel invocation"
+	}
+	_ = sl.info.Log(evt, "watchdog", "name", invocation.Target, "invocationId", invocation.InvocationID,
+		"running", time.Since(started), "stack", allStacks(), react, reaction)
+	if sl.server.watchdogCancel {
+		cancel()
+		if invocation.Type == 4 {
+			sl.streamer.Stop(invocation.InvocationID)
+		}
+	}
+}
+
+// allStacks returns the stacks of all goroutines, truncated to 1MB
+func allStacks() string {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 1<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
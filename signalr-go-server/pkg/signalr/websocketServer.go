@@ -10,27 +10,65 @@ import (
 	"net/http"
 )
 
-// MapHub used to register a SignalR Hub with the specified ServeMux
-func MapHub(mux *http.ServeMux, path string, hubProto HubInterface) *Server {
-	mux.HandleFunc(fmt.Sprintf("%s/negotiate", path), negotiateHandler)
-	server, _ := NewServer(SimpleHubFactory(hubProto))
-	mux.Handle(path, websocket.Handler(func(ws *websocket.Conn) {
+// MapHub used to register a SignalR Hub with the specified ServeMux.
+// The options are applied to the server of this hub only, so each mapped hub can have its own settings.
+func MapHub(mux *http.ServeMux, path string, hubProto HubInterface, options ...func(*Server) error) (*Server, error) {
+	server, err := NewServer(append([]func(*Server) error{SimpleHubFactory(hubProto)}, options...)...)
+	if err != nil {
+		return nil, err
+	}
+	server.MapHTTP(mux, path)
+	return server, nil
+}
+
+// MapHTTP registers the negotiate endpoint and the websocket transport of the server at path with the specified ServeMux,
+// so clients can connect to a server created with NewServer
+func (s *Server) MapHTTP(mux *http.ServeMux, path string) {
+	mux.HandleFunc(fmt.Sprintf("%s/negotiate", path), s.negotiateHandler)
+	mux.Handle(path, s.webSocketHandler())
+}
+
+// webSocketHandler returns the handler for the websocket connections of the server.
+// Requests are authenticated before they are upgraded
+func (s *Server) webSocketHandler() http.Handler {
+	handler := websocket.Handler(func(ws *websocket.Conn) {
 		connectionID := ws.Request().URL.Query().Get("id")
 		if len(connectionID) == 0 {
 			// Support websocket connection without negotiateWebSocketTestServer
 			connectionID = getConnectionID()
 		}
-		server.Run(context.TODO(), &webSocketConnection{ws, connectionID, 0})
-	}))
-	return server
+		// A frame larger than a message is not read into memory.
+		// Messages fragmented into continuation frames are reassembled by the hubConnection
+		ws.MaxPayloadBytes = int(s.maximumReceiveMessageSize)
+		ctx := context.TODO()
+		if principal, ok := PrincipalFromContext(ws.Request().Context()); ok {
+			ctx = context.WithValue(ctx, principalContextKey{}, principal)
+		}
+		s.Run(ctx, &webSocketConnection{
+			conn:         ws,
+			connectionID: connectionID,
+			remoteAddr:   s.requestRemoteAddr(ws.Request()),
+		})
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req, ok := s.authenticate(w, req); ok {
+			handler.ServeHTTP(w, req)
+		}
+	})
 }
 
-func negotiateHandler(w http.ResponseWriter, req *http.Request) {
+func (s *Server) negotiateHandler(w http.ResponseWriter, req *http.Request) {
 	if req.Method != "POST" {
 		w.WriteHeader(400)
+	} else if _, ok := s.authenticate(w, req); !ok {
+		return
+	} else if hint := s.negotiateRedirect(req); hint != nil && hint.URL != "" {
+		// Redirect the client to the preferred server
+		s.writeNegotiateResponse(w, req, negotiateResponse{URL: hint.URL, AccessToken: hint.AccessToken})
 	} else {
 		response := negotiateResponse{
 			ConnectionID: getConnectionID(),
+			Features:     s.features,
 			AvailableTransports: []availableTransport{
 				{
 					Transport:       "WebSockets",
@@ -38,10 +76,46 @@ func negotiateHandler(w http.ResponseWriter, req *http.Request) {
 				},
 			},
 		}
+		s.writeNegotiateResponse(w, req, response)
+	}
+}
+
+// writeNegotiateResponse writes response with the fields of the NegotiateMetadataFunc added.
+// Metadata fields can not replace the fields of the protocol
+func (s *Server) writeNegotiateResponse(w http.ResponseWriter, req *http.Request, response negotiateResponse) {
+	var metadata map[string]interface{}
+	if s.negotiateMetadataFunc != nil {
+		metadata = s.negotiateMetadataFunc(req)
+	}
+	if len(metadata) == 0 {
 		_ = json.NewEncoder(w).Encode(response) // Can't imagine an error when encoding
+		return
+	}
+	fields := make(map[string]interface{})
+	data, _ := json.Marshal(response)
+	_ = json.Unmarshal(data, &fields)
+	for key, value := range metadata {
+		if _, ok := fields[key]; !ok && !negotiateProtocolFields[key] {
+			fields[key] = value
+		}
+	}
+	if err := json.NewEncoder(w).Encode(fields); err != nil {
+		_ = s.info.Log(evt, "negotiate", "error", err, react, "metadata not marshalable")
 	}
 }
 
+// negotiateProtocolFields are the fields of the negotiate response which have a meaning for the clients
+var negotiateProtocolFields = map[string]bool{
+	"connectionId":        true,
+	"connectionToken":     true,
+	"negotiateVersion":    true,
+	"availableTransports": true,
+	"url":                 true,
+	"accessToken":         true,
+	"error":               true,
+	"features":            true,
+}
+
 func getConnectionID() string {
 	bytes := make([]byte, 16)
 	// rand.Read only fails when the systems random number generator fails. Rare case, ignore
@@ -55,6 +129,9 @@ type availableTransport struct {
 }
 
 type negotiateResponse struct {
-	ConnectionID        string               `json:"connectionId"`
-	AvailableTransports []availableTransport `json:"availableTransports"`
+	ConnectionID        string               `json:"connectionId,omitempty"`
+	AvailableTransports []availableTransport `json:"availableTransports,omitempty"`
+	URL                 string               `json:"url,omitempty"`
+	AccessToken         string               `json:"accessToken,omitempty"`
+	Features            []string             `json:"features,omitempty"`
 }
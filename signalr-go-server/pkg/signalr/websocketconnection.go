@@ -2,7 +2,9 @@ package signalr
 
 import (
 	"bytes"
+	"encoding/binary"
 	"golang.org/x/net/websocket"
+	"net/http"
 	"time"
 )
 
@@ -10,6 +12,26 @@ type webSocketConnection struct {
 	conn         *websocket.Conn
 	connectionID string
 	timeout      time.Duration
+	remoteAddr   string
+	// pending holds the part of the last received websocket message which did not fit into the buffer of Read
+	pending *bytes.Reader
+}
+
+// useBinaryFrames lets all following writes send binary frames instead of text frames
+func (w *webSocketConnection) useBinaryFrames() {
+	w.conn.PayloadType = websocket.BinaryFrame
+}
+
+func (w *webSocketConnection) Request() *http.Request {
+	return w.conn.Request()
+}
+
+func (w *webSocketConnection) Transport() string {
+	return "WebSockets"
+}
+
+func (w *webSocketConnection) RemoteAddr() string {
+	return w.remoteAddr
 }
 
 func (w *webSocketConnection) SetTimeout(timeout time.Duration) {
@@ -33,6 +55,9 @@ func (w *webSocketConnection) Write(p []byte) (n int, err error) {
 }
 
 func (w *webSocketConnection) Read(p []byte) (n int, err error) {
+	if w.pending != nil && w.pending.Len() > 0 {
+		return w.pending.Read(p)
+	}
 	if w.timeout > 0 {
 		defer func() { _ = w.conn.SetReadDeadline(time.Time{}) }()
 		_ = w.conn.SetReadDeadline(time.Now().Add(w.timeout))
@@ -41,5 +66,20 @@ func (w *webSocketConnection) Read(p []byte) (n int, err error) {
 	if err = websocket.Message.Receive(w.conn, &data); err != nil {
 		return 0, err
 	}
-	return bytes.NewReader(data).Read(p)
+	w.pending = bytes.NewReader(data)
+	return w.pending.Read(p)
+}
+
+// Close sends a close frame with code and reason. The transport is closed when the websocket handler returns
+func (w *webSocketConnection) Close(code int, reason string) error {
+	// The payload of control frames must not exceed 125 bytes
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	w.conn.PayloadType = websocket.CloseFrame
+	_, err := w.conn.Write(payload)
+	return err
 }
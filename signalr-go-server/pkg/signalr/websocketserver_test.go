@@ -3,6 +3,7 @@ package signalr
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"github.com/go-kit/kit/log"
@@ -10,10 +11,13 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"golang.org/x/net/websocket"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -25,6 +29,10 @@ func (w *webSocketHub) Add2(i int) int {
 	return i + 2
 }
 
+func (w *webSocketHub) Ban(userID string) {
+	w.DisconnectUser(userID, "banned")
+}
+
 var _ = Describe("Websocket server", func() {
 
 	Context("A correct negotiation request is sent", func() {
@@ -52,6 +60,102 @@ var _ = Describe("Websocket server", func() {
 		})
 	})
 
+	Context("A negotiation request is sent after a handoff", func() {
+		It("should redirect to the handoff url and close existing connections with reconnect allowed", func() {
+			router := http.NewServeMux()
+			server, err := MapHub(router, "/hub", &webSocketHub{})
+			Expect(err).To(BeNil())
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			}()
+			conn := newTestingConnection()
+			connectionID := conn.ConnectionID()
+			go server.Run(context.TODO(), conn)
+			Eventually(func() bool {
+				_, ok := server.ConnectionStats(connectionID)
+				return ok
+			}).Should(BeTrue())
+			server.Handoff("http://other:5000/hub")
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp["url"]).To(Equal("http://other:5000/hub"))
+			Expect(negResp["connectionId"]).To(BeNil())
+			msg := (<-conn.ReceiveChan()).(closeMessage)
+			Expect(msg.AllowReconnect).To(BeTrue())
+			Expect(msg.Error).To(ContainSubstring("http://other:5000/hub"))
+		})
+	})
+
+	Context("A connection is closed with a reconnect hint", func() {
+		It("should redirect the next negotiation with the resume token of the connection to the hint url", func() {
+			router := http.NewServeMux()
+			server, err := MapHub(router, "/hub", &groupHub{}, UseResumeStore(NewMemoryResumeStore(time.Minute)))
+			Expect(err).To(BeNil())
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			}()
+			conn, resumeToken := newResumingConnection(context.TODO(), server, "hinted", "")
+			Expect(server.CloseWithReconnectHint("hinted", ReconnectHint{URL: "http://green/hub", AccessToken: "token"})).To(BeTrue())
+			msg := (<-conn.ReceiveChan()).(closeMessage)
+			Expect(msg.AllowReconnect).To(BeTrue())
+			Expect(msg.Error).To(ContainSubstring("http://green/hub"))
+			// The connection id is no proof of the connection
+			negResp := negotiateWebSocketTestServerWithQuery(port, "?id=hinted")
+			Expect(negResp["url"]).To(BeNil())
+			Expect(negResp["accessToken"]).To(BeNil())
+			negResp = negotiateWebSocketTestServerWithQuery(port, "?resumeToken="+url.QueryEscape(resumeToken))
+			Expect(negResp["url"]).To(Equal("http://green/hub"))
+			Expect(negResp["accessToken"]).To(Equal("token"))
+			negResp = negotiateWebSocketTestServerWithQuery(port, "?resumeToken="+url.QueryEscape(resumeToken))
+			Expect(negResp["url"]).To(BeNil())
+			Expect(negResp["connectionId"]).NotTo(BeNil())
+		})
+	})
+
+	Context("A negotiation request is sent to a server with NegotiateRedirect", func() {
+		It("should redirect as the NegotiateRedirectFunc says", func() {
+			router := http.NewServeMux()
+			_, err := MapHub(router, "/hub", &webSocketHub{}, NegotiateRedirect(func(req *http.Request) *ReconnectHint {
+				return &ReconnectHint{URL: "http://eu/hub"}
+			}))
+			Expect(err).To(BeNil())
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			}()
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp["url"]).To(Equal("http://eu/hub"))
+		})
+	})
+
+	Context("A negotiation request is sent to a server with NegotiateMetadata", func() {
+		It("should add the metadata but not replace the protocol fields", func() {
+			router := http.NewServeMux()
+			_, err := MapHub(router, "/hub", &webSocketHub{}, NegotiateMetadata(func(req *http.Request) map[string]interface{} {
+				return map[string]interface{}{"region": "eu", "connectionId": "fake"}
+			}))
+			Expect(err).To(BeNil())
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			}()
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp["region"]).To(Equal("eu"))
+			Expect(negResp["connectionId"]).NotTo(Equal("fake"))
+			Expect(negResp["availableTransports"]).NotTo(BeNil())
+		})
+	})
+
+	Context("MapHub is called with an invalid option", func() {
+		It("should return an error", func() {
+			router := http.NewServeMux()
+			server, err := MapHub(router, "/hub", &webSocketHub{}, StreamBufferCapacity(0))
+			Expect(server).To(BeNil())
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
 	Context("A invalid negotiation request is sent", func() {
 		It("should send a correct negotiation response with support for Websockets with text and binary protocol", func() {
 			// Start server
@@ -97,8 +201,139 @@ var _ = Describe("Websocket server", func() {
 			handShakeAndCallWebSocketTestServer(port, fmt.Sprint(jsonMap["connectionId"]))
 		})
 	})
+
+	Context("When a server created with NewServer is mapped", func() {
+		It("should negotiate and serve websocket requests", func() {
+			server, err := NewServer(SimpleHubFactory(&webSocketHub{}))
+			Expect(err).To(BeNil())
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			port := freePort()
+			go func() {
+				_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			}()
+			jsonMap := negotiateWebSocketTestServer(port)
+			Expect(jsonMap["availableTransports"]).To(HaveLen(1))
+			handShakeAndCallWebSocketTestServer(port, fmt.Sprint(jsonMap["connectionId"]))
+		})
+	})
+})
+
+var _ = Describe("Websocket fragmentation", func() {
+
+	Context("When a message is sent in continuation frames", func() {
+		It("should reassemble the message", func() {
+			ws, tcpConn := dialRawWebSocketTestServer()
+			defer func() { _ = ws.Close() }()
+			Expect(writeWebSocketFrame(tcpConn, true, websocket.TextFrame, []byte(`{"protocol":"json","version":1}`+"\u001e"))).To(BeNil())
+			invocation := []byte(`{"type":1,"invocationId":"1","target":"add2","arguments":[1]}` + "\u001e")
+			Expect(writeWebSocketFrame(tcpConn, false, websocket.TextFrame, invocation[:20])).To(BeNil())
+			Expect(writeWebSocketFrame(tcpConn, false, websocket.ContinuationFrame, invocation[20:40])).To(BeNil())
+			Expect(writeWebSocketFrame(tcpConn, true, websocket.ContinuationFrame, invocation[40:])).To(BeNil())
+			var received string
+			for !strings.Contains(received, `"type":3`) {
+				var data string
+				Expect(websocket.Message.Receive(ws, &data)).To(BeNil())
+				received += data
+			}
+			Expect(received).To(ContainSubstring(`"result":3`))
+		})
+	})
+
+	Context("When a frame exceeds the MaximumReceiveMessageSize", func() {
+		It("should close the connection", func() {
+			ws, tcpConn := dialRawWebSocketTestServer(MaximumReceiveMessageSize(64))
+			defer func() { _ = ws.Close() }()
+			Expect(writeWebSocketFrame(tcpConn, true, websocket.TextFrame, []byte(`{"protocol":"json","version":1}`+"\u001e"))).To(BeNil())
+			Expect(writeWebSocketFrame(tcpConn, true, websocket.TextFrame, []byte(`{"type":1,"target":"`+strings.Repeat("x", 80)+`"}`+"\u001e"))).To(BeNil())
+			_ = ws.SetReadDeadline(time.Now().Add(time.Second))
+			for {
+				var data string
+				if err := websocket.Message.Receive(ws, &data); err != nil {
+					Expect(err).NotTo(BeAssignableToTypeOf(&net.OpError{}))
+					break
+				}
+			}
+		})
+	})
+})
+
+var _ = Describe("Websocket close", func() {
+
+	Context("When the user of the connection is disconnected", func() {
+		It("should close the websocket with policy violation", func() {
+			ws, tcpConn := dialRawWebSocketTestServer(UserIDProvider(func(Connection) string { return "troll" }))
+			defer func() { _ = ws.Close() }()
+			Expect(writeWebSocketFrame(tcpConn, true, websocket.TextFrame, []byte(`{"protocol":"json","version":1}`+"\u001e"))).To(BeNil())
+			Expect(writeWebSocketFrame(tcpConn, true, websocket.TextFrame, []byte(`{"type":1,"target":"ban","arguments":["troll"]}`+"\u001e"))).To(BeNil())
+			_ = tcpConn.SetReadDeadline(time.Now().Add(time.Second))
+			for {
+				opCode, payload, err := readWebSocketFrame(tcpConn)
+				Expect(err).To(BeNil())
+				if opCode == websocket.CloseFrame {
+					Expect(binary.BigEndian.Uint16(payload)).To(Equal(uint16(ClosePolicyViolation)))
+					Expect(string(payload[2:])).To(Equal("banned"))
+					break
+				}
+			}
+		})
+	})
 })
 
+// readWebSocketFrame reads an unmasked server frame with a payload shorter than 65536 bytes
+func readWebSocketFrame(r io.Reader) (opCode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return 0, nil, err
+	}
+	length := int(header[1] & 0x7f)
+	if length == 126 {
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return 0, nil, err
+		}
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload = make([]byte, length)
+	_, err = io.ReadFull(r, payload)
+	return header[0] & 0x0f, payload, err
+}
+
+// dialRawWebSocketTestServer starts a server and returns a websocket client connection to it and its underlying tcp connection,
+// which can be used to write raw websocket frames
+func dialRawWebSocketTestServer(options ...func(*Server) error) (*websocket.Conn, net.Conn) {
+	router := http.NewServeMux()
+	_, err := MapHub(router, "/hub", &webSocketHub{}, options...)
+	Expect(err).To(BeNil())
+	port := freePort()
+	go func() {
+		_ = http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+	}()
+	waitForPort(port)
+	config, err := websocket.NewConfig(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "http://127.0.0.1")
+	Expect(err).To(BeNil())
+	tcpConn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%v", port))
+	Expect(err).To(BeNil())
+	ws, err := websocket.NewClient(config, tcpConn)
+	Expect(err).To(BeNil())
+	return ws, tcpConn
+}
+
+// writeWebSocketFrame writes a masked client frame with a payload shorter than 126 bytes
+func writeWebSocketFrame(w io.Writer, fin bool, opCode byte, payload []byte) error {
+	header := []byte{opCode, 0x80 | byte(len(payload))}
+	if fin {
+		header[0] |= 0x80
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame := append(header, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
+
 var _ = Describe("Websocket connection", func() {
 
 	Context("The timeout is set with SetTimeout()", func() {
@@ -113,9 +348,13 @@ var _ = Describe("Websocket connection", func() {
 })
 
 func negotiateWebSocketTestServer(port int) map[string]interface{} {
+	return negotiateWebSocketTestServerWithQuery(port, "")
+}
+
+func negotiateWebSocketTestServerWithQuery(port int, query string) map[string]interface{} {
 	waitForPort(port)
 	buf := bytes.Buffer{}
-	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiate", port), "text/plain;charset=UTF-8", &buf)
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiate%v", port, query), "text/plain;charset=UTF-8", &buf)
 	Expect(err).To(BeNil())
 	Expect(resp).ToNot(BeNil())
 	defer func() {
@@ -131,6 +370,10 @@ func negotiateWebSocketTestServer(port int) map[string]interface{} {
 }
 
 func handShakeAndCallWebSocketTestServer(port int, connectionID string) {
+	handShakeAndCallWebSocketTestServerWithQuery(port, connectionID, "")
+}
+
+func handShakeAndCallWebSocketTestServerWithQuery(port int, connectionID string, query string) {
 	waitForPort(port)
 	logger := log.NewLogfmtLogger(os.Stderr)
 	protocol := JSONHubProtocol{}
@@ -139,13 +382,13 @@ func handShakeAndCallWebSocketTestServer(port int, connectionID string) {
 	if connectionID != "" {
 		urlParam = fmt.Sprintf("?id=%v", connectionID)
 	}
-	ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub%v", port, urlParam), "json", "http://127.0.0.1")
+	ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub%v%v", port, urlParam, query), "json", "http://127.0.0.1")
 	Expect(err).To(BeNil())
 	defer func() {
 		_ = ws.Close()
 	}()
-	wsConn := webSocketConnection{ws, connectionID, 0}
-	cliConn := newHubConnection(context.TODO(), &wsConn, &protocol, 1<<15)
+	wsConn := webSocketConnection{conn: ws, connectionID: connectionID}
+	cliConn := newHubConnection(context.TODO(), &wsConn, &protocol, 1<<15, "", nil)
 	_, _ = wsConn.Write(append([]byte(`{"protocol": "json","version": 1}`), 30))
 	_, _ = wsConn.Write(append([]byte(`{"type":1,"invocationId":"666","target":"add2","arguments":[1]}`), 30))
 	cliConn.Start()